@@ -0,0 +1,92 @@
+package dryrun
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// DryRun controls how the tools that rewrite files in place commit their
+// changes. When enabled, files are left untouched and the changes that would
+// have been made are printed as unified diffs.
+type DryRun struct {
+	// Print the diffs instead of writing the files
+	Enabled bool
+
+	// Print nothing but the diffs, and exit with a non zero status if any file
+	// would change
+	DiffOnly bool
+
+	// Where to print the diffs
+	Output io.Writer
+
+	// Number of files that would have changed
+	Changed int
+}
+
+func New() *DryRun {
+	return &DryRun{Output: os.Stdout}
+}
+
+// Register the -dry-run and -diff-only flags on the command line
+func (d *DryRun) Flags() {
+	flag.BoolVar(&d.Enabled, "dry-run", false, "Print the changes as unified diffs instead of writing the files")
+	flag.BoolVar(&d.DiffOnly, "diff-only", false, "Like -dry-run, print only the diffs and exit with status 1 if any file would change")
+}
+
+// Return true if the files must not be modified
+func (d *DryRun) Active() bool {
+	return d.Enabled || d.DiffOnly
+}
+
+// Rename the temporary file tmpname to target, or print the diff between
+// them in dry-run mode. In dry-run mode, the temporary file is removed.
+func (d *DryRun) Rename(tmpname, target string) error {
+	if !d.Active() {
+		return os.Rename(tmpname, target)
+	}
+
+	data, err := ioutil.ReadFile(tmpname)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(tmpname)
+	if err != nil {
+		return err
+	}
+	return d.diff(target, data)
+}
+
+// Write data to fname, or print the diff with the current content of fname in
+// dry-run mode.
+func (d *DryRun) WriteFile(fname string, data []byte, perm os.FileMode) error {
+	if !d.Active() {
+		return ioutil.WriteFile(fname, data, perm)
+	}
+	return d.diff(fname, data)
+}
+
+func (d *DryRun) diff(fname string, data []byte) error {
+	orig, err := ioutil.ReadFile(fname)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	diff := Unified(fname, fname, orig, data)
+	if diff == nil {
+		return nil
+	}
+
+	d.Changed += 1
+	_, err = d.Output.Write(diff)
+	return err
+}
+
+// Return the exit status the tool should use on success
+func (d *DryRun) ExitStatus() int {
+	if d.DiffOnly && d.Changed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+package dryrun
+
+import (
+	"bytes"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&DryRunSuite{})
+
+type DryRunSuite struct{}
+
+// Return the lines from first to last, one per line
+func lines(first, last int) string {
+	var res []string
+	for i := first; i <= last; i++ {
+		res = append(res, string(rune('a'+i%26))+strings.Repeat("x", i/26))
+	}
+	return strings.Join(res, "\n") + "\n"
+}
+
+func (s *DryRunSuite) TestUnifiedSame(c *C) {
+	c.Assert(Unified("a", "b", []byte("a\n"), []byte("a\n")), IsNil)
+}
+
+func (s *DryRunSuite) TestUnifiedContext(c *C) {
+	a := lines(0, 19)
+	b := strings.Replace(a, "k\n", "K\n", 1)
+	c.Assert(string(Unified("a", "b", []byte(a), []byte(b))), Equals, ""+
+		"--- a\n"+
+		"+++ b\n"+
+		"@@ -8,7 +8,7 @@\n"+
+		" h\n i\n j\n"+
+		"-k\n"+
+		"+K\n"+
+		" l\n m\n n\n")
+}
+
+func (s *DryRunSuite) TestUnifiedHunks(c *C) {
+	a := lines(0, 29)
+
+	// Changes separated by up to twice the context are in the same hunk
+	b := strings.Replace(a, "c\n", "C\n", 1)
+	b = strings.Replace(b, "j\n", "", 1)
+	b = strings.Replace(b, "z\n", "z\nZ\n", 1)
+	c.Assert(string(Unified("a", "b", []byte(a), []byte(b))), Equals, ""+
+		"--- a\n"+
+		"+++ b\n"+
+		"@@ -1,13 +1,12 @@\n"+
+		" a\n b\n"+
+		"-c\n"+
+		"+C\n"+
+		" d\n e\n f\n g\n h\n i\n"+
+		"-j\n"+
+		" k\n l\n m\n"+
+		"@@ -24,6 +23,7 @@\n"+
+		" x\n y\n z\n"+
+		"+Z\n"+
+		" ax\n bx\n cx\n")
+}
+
+func (s *DryRunSuite) TestUnifiedNoNewline(c *C) {
+	c.Assert(string(Unified("a", "b", []byte("a\nb"), []byte("a\nc"))), Equals, ""+
+		"--- a\n"+
+		"+++ b\n"+
+		"@@ -1,2 +1,2 @@\n"+
+		" a\n"+
+		"-b\n"+
+		"\\ No newline at end of file\n"+
+		"+c\n"+
+		"\\ No newline at end of file\n")
+	c.Assert(string(Unified("a", "b", []byte("a"), []byte("a\n"))), Equals, ""+
+		"--- a\n"+
+		"+++ b\n"+
+		"@@ -1 +1 @@\n"+
+		"-a\n"+
+		"\\ No newline at end of file\n"+
+		"+a\n")
+}
+
+func (s *DryRunSuite) TestUnifiedNewFile(c *C) {
+	c.Assert(string(Unified("a", "b", nil, []byte("a\nb\n"))), Equals, ""+
+		"--- a\n"+
+		"+++ b\n"+
+		"@@ -0,0 +1,2 @@\n"+
+		"+a\n"+
+		"+b\n")
+	c.Assert(string(Unified("a", "b", []byte("a\n"), nil)), Equals, ""+
+		"--- a\n"+
+		"+++ b\n"+
+		"@@ -1 +0,0 @@\n"+
+		"-a\n")
+}
+
+func (s *DryRunSuite) TestUnifiedLarge(c *C) {
+	// The script of thousands of edits is computed in linear space
+	a := lines(0, 19999)
+	b := strings.Replace(a, "\nk", "\nK", -1)
+	diff := Unified("a", "b", []byte(a), []byte(b))
+	c.Assert(bytes.Count(diff, []byte("\n@@ ")), Equals, 769)
+}
+
+func (s *DryRunSuite) TestWriteFile(c *C) {
+	dir, err := ioutil.TempDir("", "dryrun")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "file")
+	c.Assert(ioutil.WriteFile(fname, []byte("a\n"), 0644), IsNil)
+
+	var out bytes.Buffer
+	d := &DryRun{DiffOnly: true, Output: &out}
+	c.Assert(d.WriteFile(fname, []byte("a\n"), 0644), IsNil)
+	c.Assert(d.Changed, Equals, 0)
+	c.Assert(d.ExitStatus(), Equals, 0)
+	c.Assert(out.String(), Equals, "")
+
+	c.Assert(d.WriteFile(fname, []byte("b\n"), 0644), IsNil)
+	c.Assert(d.Changed, Equals, 1)
+	c.Assert(d.ExitStatus(), Equals, 1)
+	c.Assert(out.String(), Equals, "--- "+fname+"\n+++ "+fname+"\n@@ -1 +1 @@\n-a\n+b\n")
+	data, err := ioutil.ReadFile(fname)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "a\n")
+
+	// Without dry run, the file is written
+	d = &DryRun{Output: &out}
+	c.Assert(d.WriteFile(fname, []byte("b\n"), 0644), IsNil)
+	c.Assert(d.ExitStatus(), Equals, 0)
+	data, err = ioutil.ReadFile(fname)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "b\n")
+}
@@ -0,0 +1,240 @@
+package dryrun
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Number of unchanged lines shown around each change
+const contextLines = 3
+
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+type edit struct {
+	kind editKind
+	line string
+}
+
+// Unified returns the unified diff between a and b, labeled with the given
+// file names. It returns nil if both contents are the same.
+func Unified(aName, bName string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+
+	for start := 0; start < len(edits); {
+		// Find the next change
+		for start < len(edits) && edits[start].kind == editEqual {
+			start++
+		}
+		if start >= len(edits) {
+			break
+		}
+
+		// Extend the hunk until there is enough unchanged lines to stop it
+		end := start
+		for i := start; i < len(edits); i++ {
+			if edits[i].kind != editEqual {
+				end = i + 1
+			} else if i-end >= 2*contextLines {
+				break
+			}
+		}
+
+		first := start - contextLines
+		if first < 0 {
+			first = 0
+		}
+		last := end + contextLines
+		if last > len(edits) {
+			last = len(edits)
+		}
+
+		writeHunk(&out, edits, first, last)
+		start = last
+	}
+
+	return out.Bytes()
+}
+
+func writeHunk(out *bytes.Buffer, edits []edit, first, last int) {
+	// Line numbers of the hunk start in each file
+	aLine, bLine := 1, 1
+	for _, e := range edits[:first] {
+		if e.kind != editInsert {
+			aLine++
+		}
+		if e.kind != editDelete {
+			bLine++
+		}
+	}
+
+	var aCount, bCount int
+	for _, e := range edits[first:last] {
+		if e.kind != editInsert {
+			aCount++
+		}
+		if e.kind != editDelete {
+			bCount++
+		}
+	}
+	if aCount == 0 {
+		aLine--
+	}
+	if bCount == 0 {
+		bLine--
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+	for _, e := range edits[first:last] {
+		switch e.kind {
+		case editEqual:
+			out.WriteByte(' ')
+		case editDelete:
+			out.WriteByte('-')
+		case editInsert:
+			out.WriteByte('+')
+		}
+		out.WriteString(e.line)
+		if len(e.line) == 0 || e.line[len(e.line)-1] != '\n' {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(line, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// Split data in lines, keeping the line terminators
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// Compute the shortest edit script between a and b using the linear space
+// variant of the Myers algorithm, which splits the script at the middle snake
+// of the edit graph and computes both parts the same way
+func diffLines(a, b []string) []edit {
+	return appendDiff(nil, a, b)
+}
+
+// Append to edits the shortest edit script between a and b
+func appendDiff(edits []edit, a, b []string) []edit {
+	// Common prefix and suffix
+	pre, suf := 0, 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	for _, line := range a[:pre] {
+		edits = append(edits, edit{editEqual, line})
+	}
+	a1, b1 := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	switch {
+	case len(a1) == 0:
+		for _, line := range b1 {
+			edits = append(edits, edit{editInsert, line})
+		}
+	case len(b1) == 0:
+		for _, line := range a1 {
+			edits = append(edits, edit{editDelete, line})
+		}
+	default:
+		// Without a common prefix or suffix, there are at least two
+		// edits, and both sides of the snake are shorter scripts
+		x, y, u, v := middleSnake(a1, b1)
+		edits = appendDiff(edits, a1[:x], b1[:y])
+		for _, line := range a1[x:u] {
+			edits = append(edits, edit{editEqual, line})
+		}
+		edits = appendDiff(edits, a1[u:], b1[v:])
+	}
+
+	for _, line := range a[len(a)-suf:] {
+		edits = append(edits, edit{editEqual, line})
+	}
+	return edits
+}
+
+// Find the middle snake of the shortest edit script between a and b, by
+// searching for it from both ends of the edit graph until the paths overlap.
+// It returns the start (x, y) and the end (u, v) of the snake.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	offset := max + 1
+
+	// Furthest x on each diagonal k = x - y from the start, and from the
+	// end on the diagonals of the reversed edit graph
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+			forward[offset+k] = u
+			if r := delta - k; odd && r >= -(d-1) && r <= d-1 && u+backward[offset+r] >= n {
+				return x, y, u, v
+			}
+		}
+
+		for r := -d; r <= d; r += 2 {
+			var rx int
+			if r == -d || (r != d && backward[offset+r-1] < backward[offset+r+1]) {
+				rx = backward[offset+r+1]
+			} else {
+				rx = backward[offset+r-1] + 1
+			}
+			ry := rx - r
+			ru, rv := rx, ry
+			for ru < n && rv < m && a[n-1-ru] == b[m-1-rv] {
+				ru++
+				rv++
+			}
+			backward[offset+r] = ru
+			if k := delta - r; !odd && k >= -d && k <= d && forward[offset+k]+ru >= n {
+				return n - ru, m - rv, n - rx, m - ry
+			}
+		}
+	}
+	panic("dryrun: no middle snake")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Headers kept in the snapshots
+var snapshotHeaders = []string{"Content-Type", "Content-Length", "Location", "Last-Modified", "Etag"}
+
+// Snapshot of the response to an external URL
+type Snapshot struct {
+	URL        string            `json:"url"`
+	Status     int               `json:"status"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	BodySHA256 string            `json:"body_sha256,omitempty"`
+}
+
+// ExternalChecker checks external URLs. Responses can be recorded in a fixture
+// directory, and checks can be replayed offline from such a directory.
+type ExternalChecker struct {
+	Client *http.Client
+
+	// Fixture directory where to record the snapshots
+	Record string
+
+	// Fixture directory where to read the snapshots from instead of the
+	// network
+	Replay string
+
+	cache map[string]*Snapshot
+}
+
+func NewExternalChecker() *ExternalChecker {
+	return &ExternalChecker{
+		Client: http.DefaultClient,
+		cache:  map[string]*Snapshot{},
+	}
+}
+
+func fixtureName(dir, u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Return the snapshot for the given URL
+func (c *ExternalChecker) Check(u string) (*Snapshot, error) {
+	if s, ok := c.cache[u]; ok {
+		return s, nil
+	}
+
+	if c.Record != "" && c.Replay != "" {
+		return nil, fmt.Errorf("cannot both record and replay the external responses")
+	}
+
+	var s *Snapshot
+	var err error
+	if c.Replay != "" {
+		s, err = c.replay(u)
+	} else {
+		s, err = c.fetch(u)
+		if err == nil && c.Record != "" {
+			err = c.record(s)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache[u] = s
+	return s, nil
+}
+
+func (c *ExternalChecker) fetch(u string) (*Snapshot, error) {
+	res, err := c.Client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{
+		URL:        u,
+		Status:     res.StatusCode,
+		Headers:    map[string]string{},
+		BodySHA256: hex.EncodeToString(h.Sum(nil)),
+	}
+	for _, k := range snapshotHeaders {
+		if v := res.Header.Get(k); v != "" {
+			s.Headers[k] = v
+		}
+	}
+	return s, nil
+}
+
+func (c *ExternalChecker) record(s *Snapshot) error {
+	err := os.MkdirAll(c.Record, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fixtureName(c.Record, s.URL), append(data, '\n'), 0666)
+}
+
+func (c *ExternalChecker) replay(u string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(fixtureName(c.Replay, u))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no fixture recorded for %s", u)
+	} else if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
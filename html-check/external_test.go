@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&ExternalSuite{})
+
+type ExternalSuite struct{}
+
+func (s *ExternalSuite) TestRecordReplay(c *C) {
+	dir, err := ioutil.TempDir("", "html-check")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Other", "x")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not here"))
+	}))
+	u := server.URL + "/page"
+
+	rec := NewExternalChecker()
+	rec.Record = dir
+	recorded, err := rec.Check(u)
+	c.Assert(err, IsNil)
+	c.Assert(recorded.Status, Equals, http.StatusNotFound)
+	c.Assert(recorded.Headers, DeepEquals, map[string]string{"Content-Type": "text/plain", "Content-Length": "8"})
+	server.Close()
+
+	// The snapshot is replayed with the server down
+	rep := NewExternalChecker()
+	rep.Replay = dir
+	replayed, err := rep.Check(u)
+	c.Assert(err, IsNil)
+	c.Assert(replayed, DeepEquals, recorded)
+	_, err = rep.Check(server.URL + "/other")
+	c.Assert(err, ErrorMatches, "no fixture recorded for .*/other")
+	_, err = NewExternalChecker().Check(u)
+	c.Assert(err, NotNil)
+}
+
+func (s *ExternalSuite) TestRecordAndReplay(c *C) {
+	ext := NewExternalChecker()
+	ext.Record, ext.Replay = "a", "b"
+	_, err := ext.Check("http://example.com/")
+	c.Assert(err, ErrorMatches, "cannot both record and replay the external responses")
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/mildred/htmltools/xmlpath"
 	"golang.org/x/net/html"
 	"io"
 	"net/url"
@@ -11,10 +12,26 @@ import (
 	"strings"
 )
 
+var external *ExternalChecker
+
 func main() {
+	checkExternal := flag.Bool("external", false, "Check external http and https links")
+	record := flag.String("record", "", "Record the external responses in this fixture directory (implies -external)")
+	replay := flag.String("replay", "", "Check external links against the fixtures in this directory instead of the network (implies -external)")
 	flag.Parse()
 	infile := flag.Arg(0)
 
+	if *record != "" && *replay != "" {
+		fmt.Fprintln(os.Stderr, "-record and -replay cannot be used together")
+		os.Exit(2)
+	}
+
+	if *checkExternal || *record != "" || *replay != "" {
+		external = NewExternalChecker()
+		external.Record = *record
+		external.Replay = *replay
+	}
+
 	if infile == "-" {
 		infile = ""
 	}
@@ -58,6 +75,10 @@ func handleTags(curdir, infile string, f2 io.Writer) error {
 
 	errors := 0
 
+	// Doctype of the document, which must come before its elements
+	var doctype *xmlpath.Doctype
+	started := false
+
 	for {
 		tt := z.Next()
 		if tt == html.ErrorToken {
@@ -72,10 +93,32 @@ func handleTags(curdir, infile string, f2 io.Writer) error {
 		rawData := make([]byte, len(raw0))
 		copy(rawData, raw0)
 
+		if tt == html.DoctypeToken && !started {
+			doctype = xmlpath.ParseDoctype(string(z.Text()))
+		}
+
 		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			started = true
 			t := z.Token()
 			breadcrumb = append(breadcrumb, t.Data)
 			rawData = []byte(t.String())
+
+			if external != nil {
+				for _, a := range t.Attr {
+					u := getURL(t.Data, a.Key, a.Val)
+					if u == nil || (u.Scheme != "http" && u.Scheme != "https") {
+						continue
+					}
+					s, err := external.Check(u.String())
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "%s: %s: %v\n", strings.Join(breadcrumb, "/"), a.Val, err)
+						errors += 1
+					} else if s.Status >= 400 {
+						fmt.Fprintf(os.Stderr, "%s: %s: HTTP status %d\n", strings.Join(breadcrumb, "/"), a.Val, s.Status)
+						errors += 1
+					}
+				}
+			}
 		}
 
 		_, err := f2.Write(rawData)
@@ -103,6 +146,15 @@ func handleTags(curdir, infile string, f2 io.Writer) error {
 		errors += 1
 	}
 
+	// Legacy pages are displayed differently, which is not an error
+	if mode := doctype.QuirksMode(); mode != xmlpath.NoQuirks {
+		if doctype == nil {
+			fmt.Fprintf(os.Stderr, "Warning: no doctype, the document is displayed in %s mode\n", mode)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s: the document is displayed in %s mode\n", doctype, mode)
+		}
+	}
+
 	if errors > 0 {
 		if infile != "" {
 			return fmt.Errorf("%s: There are %d errors", infile, errors)
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"github.com/mildred/htmltools/parser"
 	_ "github.com/mildred/htmltools/relurl"
+	"github.com/mildred/htmltools/xmlpath"
 	"io"
 	"io/ioutil"
-	"launchpad.net/xmlpath"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -222,7 +222,7 @@ func handleTags(curdir, curfile string, r io.Reader, w io.Writer) error {
 			}
 			defer f.Close()
 
-			_, err = f.Write(in2.Node.XML())
+			_, err = f.Write(in2.Node.HTML())
 			return err
 		}()
 
@@ -240,7 +240,7 @@ func handleTags(curdir, curfile string, r io.Reader, w io.Writer) error {
 		template: pagination.FileName,
 		head:     pagination.MetaHead,
 	})
-	_, err = w.Write(inref.Node.XML())
+	_, err = w.Write(inref.Node.HTML())
 	return err
 }
 
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"github.com/mildred/htmltools/dryrun"
+	"github.com/mildred/htmltools/xmlpath"
+	"github.com/mildred/htmltools/xmlpath/css"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var verbose bool = false
+
+var dry = dryrun.New()
+
+func log(format string, args ...interface{}) {
+	if verbose {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] apply PATCHFILE FILE|DIR...\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	chdir := flag.String("C", "", "Change directory before operation")
+	verb := flag.Bool("v", false, "Be verbose")
+	dry.Flags()
+	flag.Usage = usage
+	flag.Parse()
+
+	verbose = *verb
+
+	if *chdir != "" {
+		err := os.Chdir(*chdir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if flag.Arg(0) != "apply" || flag.NArg() < 3 {
+		usage()
+		os.Exit(1)
+	}
+
+	patch, err := readPatch(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	for _, arg := range flag.Args()[2:] {
+		err = applyTree(patch, arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	os.Exit(dry.ExitStatus())
+}
+
+// An operation of a patch file, applied on all the nodes matched by Select
+type Operation struct {
+	Kind     string
+	Selector string
+	Select   *xmlpath.Path
+	Name     string
+	Value    string
+	Where    string
+	Content  []xmlpath.Node
+}
+
+var (
+	path_operations = xmlpath.MustCompile("/patch/*")
+	path_select     = xmlpath.MustCompile("@select")
+	path_css        = xmlpath.MustCompile("@css")
+	path_name       = xmlpath.MustCompile("@name")
+	path_value      = xmlpath.MustCompile("@value")
+	path_where      = xmlpath.MustCompile("@where")
+)
+
+func attrVal(n *xmlpath.Node, path *xmlpath.Path, defval string) string {
+	if val, ok := path.String(n); ok {
+		return val
+	}
+	return defval
+}
+
+func readPatch(fname string) ([]Operation, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	root, err := xmlpath.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+
+	var ops []Operation
+	it := path_operations.Iter(root)
+	for it.Next() {
+		n := it.Node()
+		op := Operation{
+			Kind:  n.Name().Local,
+			Name:  attrVal(n, path_name, ""),
+			Value: attrVal(n, path_value, ""),
+			Where: attrVal(n, path_where, "last-child"),
+		}
+
+		// The nodes are selected by an xpath, or by a CSS selector
+		// matching elements regardless of the case of their names
+		op.Selector = attrVal(n, path_select, "")
+		selector := attrVal(n, path_css, "")
+		switch {
+		case op.Selector != "" && selector != "":
+			return nil, fmt.Errorf("%s: <%s /> with both select and css attributes", fname, op.Kind)
+		case selector != "":
+			op.Selector = selector
+			op.Select, err = css.CompileHTML(selector)
+		case op.Selector != "":
+			op.Select, err = xmlpath.Compile(op.Selector)
+		default:
+			return nil, fmt.Errorf("%s: <%s /> with empty select attribute", fname, op.Kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fname, err)
+		}
+
+		switch op.Kind {
+		case "set-attr", "remove-attr":
+			if op.Name == "" {
+				return nil, fmt.Errorf("%s: <%s /> with empty name attribute", fname, op.Kind)
+			} else if !isQName(op.Name) {
+				return nil, fmt.Errorf("%s: <%s /> with invalid name attribute %#v", fname, op.Kind, op.Name)
+			}
+		case "set-text":
+			op.Value = n.String()
+		case "insert", "replace":
+			for _, c := range n.Children() {
+				op.Content = append(op.Content, *c)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%s: unknown operation <%s />", fname, op.Kind)
+		}
+
+		switch op.Where {
+		case "before", "after", "first-child", "last-child":
+		default:
+			return nil, fmt.Errorf("%s: <%s /> with invalid where attribute %#v", fname, op.Kind, op.Where)
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// Apply the patch on a file, or on all the HTML files in a directory
+func applyTree(patch []Operation, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if path != root && !isHTML(path) {
+			return nil
+		}
+		return applyFile(patch, path)
+	})
+}
+
+func isHTML(fname string) bool {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".html", ".htm", ".xhtml":
+		return true
+	default:
+		return false
+	}
+}
+
+func applyFile(patch []Operation, fname string) error {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+
+	in, err := xmlpath.ParseHTML(bytes.NewReader(data), xmlpath.KeepSource)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fname, err)
+	}
+	inref := in.Ref
+
+	matched := 0
+	for _, op := range patch {
+		nodes := op.Select.Iter(inref.Node).Nodes()
+		log("%s: <%s %#v /> matches %d nodes\n", fname, op.Kind, op.Selector, len(nodes))
+		for _, ref := range nodes {
+			// Nodes within a node removed or replaced are left alone
+			if ref.Node.Compare(inref.Node) == xmlpath.Disconnected {
+				continue
+			}
+			err = op.apply(ref)
+			if err != nil {
+				return fmt.Errorf("%s: %v", fname, err)
+			}
+		}
+		matched += len(nodes)
+	}
+
+	if matched == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(fname)
+	if err != nil {
+		return err
+	}
+	return dry.WriteFile(fname, inref.Node.HTML(), info.Mode())
+}
+
+func (op *Operation) apply(ref *xmlpath.NodeRef) error {
+	n := ref.Node
+	switch op.Kind {
+	case "set-attr", "remove-attr":
+		if n.Kind() != xmlpath.StartNode {
+			return fmt.Errorf("<%s /> on a node that is not an element", op.Kind)
+		}
+		name, err := attrName(n, op.Name)
+		if err != nil {
+			return err
+		}
+		if op.Kind == "set-attr" {
+			return ref.SetAttrNS(name.Space, name.Local, op.Value)
+		}
+		return ref.RemoveAttrNS(name.Space, name.Local)
+	case "set-text":
+		return ref.SetText(op.Value)
+	case "remove":
+		return ref.Remove()
+	}
+
+	content := make([]*xmlpath.Node, len(op.Content))
+	for i := range op.Content {
+		content[i] = &op.Content[i]
+	}
+	switch op.Kind {
+	case "replace":
+		return ref.ReplaceWith(content...)
+	case "insert":
+		if len(content) == 0 {
+			return nil
+		}
+		switch op.Where {
+		case "before":
+			return ref.InsertBefore(content...)
+		case "after":
+			return ref.InsertAfter(content...)
+		case "first-child":
+			return ref.PrependChild(content...)
+		case "last-child":
+			return ref.AppendChild(content...)
+		}
+	}
+	return nil
+}
+
+// Return the name of the attribute of the element n of the given qualified
+// name, of which the prefix is resolved in the scope of n. Unprefixed
+// attributes are in no namespace.
+func attrName(n *xmlpath.Node, qname string) (xml.Name, error) {
+	i := strings.IndexByte(qname, ':')
+	switch {
+	case i < 0:
+		return xml.Name{Local: qname}, nil
+	case qname[:i] == "xmlns":
+		// Namespace declarations are not in the scope of their prefix
+		return xml.Name{Space: "xmlns", Local: qname[i+1:]}, nil
+	}
+	return n.ResolveQName(qname)
+}
+
+// Return true if name is a qualified name, such as xlink:href
+func isQName(name string) bool {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return isNCName(name[:i]) && isNCName(name[i+1:])
+	}
+	return isNCName(name)
+}
+
+// Return true if name is a name without a colon
+func isNCName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || c >= 0x80 || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case i > 0 && (c == '-' || c == '.' || '0' <= c && c <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
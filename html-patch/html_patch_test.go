@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mildred/htmltools/xmlpath"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&PatchSuite{})
+
+type PatchSuite struct{}
+
+// applyPatch applies the patch to a file of the given content, and returns
+// its content afterwards
+func applyPatch(c *C, patch, html string) string {
+	dir, err := ioutil.TempDir("", "html-patch")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	pname := filepath.Join(dir, "patch.xml")
+	fname := filepath.Join(dir, "index.html")
+	c.Assert(ioutil.WriteFile(pname, []byte(patch), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(fname, []byte(html), 0644), IsNil)
+
+	ops, err := readPatch(pname)
+	c.Assert(err, IsNil)
+	c.Assert(applyTree(ops, fname), IsNil)
+	data, err := ioutil.ReadFile(fname)
+	c.Assert(err, IsNil)
+	return string(data)
+}
+
+var patchTests = []struct {
+	patch  string
+	result string
+}{
+	{`<set-attr select="//p" name="id" value="z"/>`, `<div class="x"><p id="z">a</p></div>`},
+	{`<set-attr select="//p" name="title" value="t"/>`, `<div class="x"><p id="y" title="t">a</p></div>`},
+	{`<set-attr select="//div" name="class" value="z"/>`, `<div class="z"><p id="y">a</p></div>`},
+	{`<remove-attr select="//div" name="class"/>`, `<div><p id="y">a</p></div>`},
+	{`<set-text select="//p">b &amp; c</set-text>`, `<div class="x"><p id="y">b &amp; c</p></div>`},
+	{`<insert select="//p" where="before"><em>e</em></insert>`, `<div class="x"><em>e</em><p id="y">a</p></div>`},
+	{`<insert select="//p" where="after"><em>e</em></insert>`, `<div class="x"><p id="y">a</p><em>e</em></div>`},
+	{`<insert select="//div" where="first-child"><em>e</em></insert>`, `<div class="x"><em>e</em><p id="y">a</p></div>`},
+	{`<insert select="//div"><em>e</em>f</insert>`, `<div class="x"><p id="y">a</p><em>e</em>f</div>`},
+	{`<replace select="//p"><em>e</em><em>f</em></replace>`, `<div class="x"><em>e</em><em>f</em></div>`},
+	{`<remove select="//p/text()"/>`, `<div class="x"><p id="y"></p></div>`},
+}
+
+func (s *PatchSuite) TestOperations(c *C) {
+	html := `<div class="x"><p id="y">a</p></div>`
+	for _, test := range patchTests {
+		result := applyPatch(c, "<patch>"+test.patch+"</patch>", html)
+		c.Assert(result, Equals, test.result, Commentf("%s", test.patch))
+	}
+}
+
+func (s *PatchSuite) TestReadPatch(c *C) {
+	dir, err := ioutil.TempDir("", "html-patch")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	pname := filepath.Join(dir, "patch.xml")
+
+	for _, test := range []struct {
+		patch string
+		err   string
+	}{
+		{`<set-attr name="a"/>`, ".*: <set-attr /> with empty select attribute"},
+		{`<set-attr select="//p"/>`, ".*: <set-attr /> with empty name attribute"},
+		{`<insert select="//p" where="inside"/>`, `.*: <insert /> with invalid where attribute "inside"`},
+		{`<rename select="//p"/>`, ".*: unknown operation <rename />"},
+		{`<remove select="//p["/>`, ".*"},
+	} {
+		c.Assert(ioutil.WriteFile(pname, []byte("<patch>"+test.patch+"</patch>"), 0644), IsNil)
+		_, err := readPatch(pname)
+		c.Assert(err, ErrorMatches, test.err)
+	}
+}
+
+func (s *PatchSuite) TestDryRun(c *C) {
+	var out bytes.Buffer
+	dry.DiffOnly, dry.Output = true, &out
+	defer func() { dry.DiffOnly, dry.Output, dry.Changed = false, os.Stdout, 0 }()
+
+	html := `<div class="x"><p id="y">a</p></div>`
+	c.Assert(applyPatch(c, `<patch><remove-attr select="//div" name="class"/></patch>`, html), Equals, html)
+	c.Assert(dry.ExitStatus(), Equals, 1)
+	c.Assert(out.String(), Matches, `(?s)--- .*index.html\n\+\+\+ .*index.html\n@@ -1 \+1 @@\n-<div class="x">.*\n\+<div><p id="y">a</p></div>\n.*`)
+}
+
+func (s *PatchSuite) TestInsert(c *C) {
+	html := `<div class="x"></div>`
+	c.Assert(applyPatch(c, `<patch><insert select="//div" where="first-child">hi</insert></patch>`, html), Equals, `<div class="x">hi</div>`)
+	c.Assert(applyPatch(c, `<patch><insert select="//div">hi</insert></patch>`, html), Equals, `<div class="x">hi</div>`)
+
+	html = `<div class="x"><p>a</p></div>`
+	c.Assert(applyPatch(c, `<patch><insert select="//div" where="first-child"><br/></insert></patch>`, html), Equals, `<div class="x"><br><p>a</p></div>`)
+	c.Assert(applyPatch(c, `<patch><insert select="//div" where="last-child"><br/></insert></patch>`, html), Equals, `<div class="x"><p>a</p><br></div>`)
+	c.Assert(applyPatch(c, `<patch><insert select="//p" where="before"><br/></insert></patch>`, html), Equals, `<div class="x"><br><p>a</p></div>`)
+	c.Assert(applyPatch(c, `<patch><insert select="//p" where="after"><br/></insert></patch>`, html), Equals, `<div class="x"><p>a</p><br></div>`)
+}
+
+func (s *PatchSuite) TestAttr(c *C) {
+	html := `<div class="x" xmlns:xlink="http://www.w3.org/1999/xlink"></div>`
+	c.Assert(applyPatch(c, `<patch><set-attr select="//div" name="id" value="a&quot;b"/></patch>`, html), Equals, `<div class="x" xmlns:xlink="http://www.w3.org/1999/xlink" id="a&quot;b"></div>`)
+	c.Assert(applyPatch(c, `<patch><set-attr select="//div" name="class" value="y"/></patch>`, html), Equals, `<div class="y" xmlns:xlink="http://www.w3.org/1999/xlink"></div>`)
+	c.Assert(applyPatch(c, `<patch><remove-attr select="//div" name="class"/></patch>`, html), Equals, `<div xmlns:xlink="http://www.w3.org/1999/xlink"></div>`)
+	c.Assert(applyPatch(c, `<patch><set-attr select="//div" name="xlink:href" value="#a"/></patch>`, html), Equals, `<div class="x" xmlns:xlink="http://www.w3.org/1999/xlink" xlink:href="#a"></div>`)
+}
+
+func (s *PatchSuite) TestInvalidName(c *C) {
+	dir, err := ioutil.TempDir("", "html-patch")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	pname := filepath.Join(dir, "patch.xml")
+
+	for _, name := range []string{`x=&quot;&quot; y`, `1x`, `a:b:c`, `:x`} {
+		patch := `<patch><set-attr select="//div" name="` + name + `" value="1"/></patch>`
+		c.Assert(ioutil.WriteFile(pname, []byte(patch), 0644), IsNil)
+		_, err := readPatch(pname)
+		c.Assert(err, ErrorMatches, ".*invalid name attribute.*")
+	}
+
+	html := `<div class="x"></div>`
+	c.Assert(ioutil.WriteFile(pname, []byte(`<patch><set-attr select="//div" name="svg:x" value="1"/></patch>`), 0644), IsNil)
+	ops, err := readPatch(pname)
+	c.Assert(err, IsNil)
+	root, err := xmlpath.ParseHTML(strings.NewReader(html))
+	c.Assert(err, IsNil)
+	div := ops[0].Select.Iter(root).Nodes()[0]
+	c.Assert(ops[0].apply(div), ErrorMatches, ".*undeclared prefix.*")
+}
+
+func (s *PatchSuite) TestCSS(c *C) {
+	html := `<ul><li class="a">1</li><LI class="b">2</LI></ul>`
+	c.Assert(applyPatch(c, `<patch><remove css="li.b"/></patch>`, html), Equals, `<ul><li class="a">1</li></ul>`)
+	c.Assert(applyPatch(c, `<patch><set-attr css="ul > li:first-child" name="id" value="x"/></patch>`, html), Equals, `<ul><li class="a" id="x">1</li><LI class="b">2</LI></ul>`)
+}
+
+func (s *PatchSuite) TestOverlapping(c *C) {
+	// The nodes within a node removed or replaced are left alone
+	html := `<div><ul><li>a</li><li>b</li></ul><p></p></div>`
+	c.Assert(applyPatch(c, `<patch><remove select="//ul|//li"/></patch>`, html), Equals, `<div><p></p></div>`)
+	c.Assert(applyPatch(c, `<patch><replace select="//ul|//li"><em>e</em></replace></patch>`, html), Equals, `<div><em>e</em><p></p></div>`)
+	c.Assert(applyPatch(c, `<patch><set-text select="//ul|//li">t</set-text></patch>`, html), Equals, `<div><ul>t</ul><p></p></div>`)
+	c.Assert(applyPatch(c, `<patch><remove select="//li"/><set-attr select="//li" name="id" value="x"/></patch>`, html), Equals, `<div><ul></ul><p></p></div>`)
+}
+
+func (s *PatchSuite) TestUnmatched(c *C) {
+	html := `<div class="x"></div>`
+	c.Assert(applyPatch(c, `<patch><remove select="//p"/></patch>`, html), Equals, html)
+}
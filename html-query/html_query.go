@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mildred/htmltools/xmlpath"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] FILE|DIR...\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	fold := flag.Bool("fold", false, "Match element and attribute names regardless of their case")
+	expr := flag.String("e", "", "Run this expression instead of reading expressions from the standard input")
+	jsonOut := flag.Bool("json", false, "Print the matching nodes and their content as JSON, one object per line")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	q := &Query{Fold: *fold, JSON: *jsonOut, Out: os.Stdout}
+	for _, arg := range flag.Args() {
+		err := q.LoadTree(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if *expr != "" {
+		err := q.Run(*expr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	prompt := ""
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		prompt = "xmlpath> "
+		fmt.Fprintf(os.Stderr, "%d documents loaded, enter path expressions (%s to quit)\n", q.Set.Len(), quitCommand)
+	}
+	q.Repl(os.Stdin, prompt)
+}
+
+// Query runs path expressions on a set of documents
+type Query struct {
+	// Match names regardless of their case
+	Fold bool
+
+	// Print the results as JSON
+	JSON bool
+
+	// Where to print the results
+	Out io.Writer
+
+	// Documents loaded, by file name
+	Set xmlpath.NodeSet
+}
+
+// Load a file, or all the HTML and XML files in a directory
+func (q *Query) LoadTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if path != root && !isHTML(path) && !isXML(path) {
+			return nil
+		}
+		return q.Load(path)
+	})
+}
+
+func isHTML(fname string) bool {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".html", ".htm", ".xhtml":
+		return true
+	default:
+		return false
+	}
+}
+
+func isXML(fname string) bool {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".xml", ".svg", ".xsl", ".xslt", ".rss", ".atom":
+		return true
+	default:
+		return false
+	}
+}
+
+// Load a file, parsed as XML if its extension says so, as HTML otherwise
+func (q *Query) Load(fname string) error {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+
+	if isXML(fname) {
+		_, err = q.Set.Parse(fname, bytes.NewReader(data), xmlpath.DecodeCharset)
+	} else {
+		_, err = q.Set.ParseHTML(fname, bytes.NewReader(data), xmlpath.DecodeCharset)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", fname, err)
+	}
+	return nil
+}
+
+// Command quitting the REPL, which is not a valid expression
+const quitCommand = ".quit"
+
+// Read expressions from r, one per line, and run them until the quit command
+// or the end of the input. Empty lines are skipped.
+func (q *Query) Repl(r io.Reader, prompt string) {
+	in := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(os.Stderr, prompt)
+		if !in.Scan() {
+			break
+		}
+		expr := strings.TrimSpace(in.Text())
+		if expr == quitCommand {
+			break
+		} else if expr == "" {
+			continue
+		}
+		err := q.Run(expr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}
+	if prompt != "" {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// Run an expression on all the documents and print its results
+func (q *Query) Run(expr string) error {
+	var path *xmlpath.Path
+	var err error
+	if q.Fold {
+		path, err = xmlpath.CompileHTML(expr)
+	} else {
+		path, err = xmlpath.Compile(expr)
+	}
+	if err != nil {
+		return err
+	}
+
+	matches := 0
+	for i := 0; i < q.Set.Len(); i++ {
+		root := q.Set.Root(i)
+		val, err := path.Eval(root)
+		if err != nil {
+			return err
+		}
+		nodes, ok := val.([]*xmlpath.Node)
+		if q.JSON {
+			// JSON has no numbers for NaN and the infinities
+			if f, isNum := val.(float64); isNum && (math.IsNaN(f) || math.IsInf(f, 0)) {
+				val = fmt.Sprint(f)
+			}
+			err := json.NewEncoder(q.Out).Encode(map[string]interface{}{"file": q.Set.Name(i), "value": val})
+			if err != nil {
+				return err
+			}
+			matches += len(nodes)
+			continue
+		}
+		if !ok {
+			fmt.Fprintf(q.Out, "%s: %v\n", q.Set.Name(i), val)
+			continue
+		}
+		for _, n := range nodes {
+			line, column := n.Position()
+			fmt.Fprintf(q.Out, "%s:%d:%d: %s\n", q.Set.Name(i), line, column, summary(n))
+		}
+		matches += len(nodes)
+	}
+	fmt.Fprintf(os.Stderr, "%d matches\n", matches)
+	return nil
+}
+
+const summaryMax = 120
+
+// Return a node on a single line, shortened if needed
+func summary(n *xmlpath.Node) string {
+	var s string
+	switch n.Kind() {
+	case xmlpath.AttrNode:
+		s = fmt.Sprintf("@%s=%q", n.Name().Local, n.String())
+	case xmlpath.StartNode:
+		s = string(n.XML())
+	default:
+		s = n.String()
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > summaryMax {
+		// Cut before the rune cut in the middle, if any
+		end := summaryMax
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		s = s[:end] + "..."
+	}
+	return s
+}
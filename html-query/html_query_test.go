@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&QuerySuite{})
+
+type QuerySuite struct{}
+
+// newQuery returns a query on files of the given names and contents, and the
+// output of its results
+func newQuery(c *C, files map[string]string) (*Query, *bytes.Buffer) {
+	dir, err := ioutil.TempDir("", "html-query")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	for name, content := range files {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), IsNil)
+	}
+	var out bytes.Buffer
+	q := &Query{Out: &out}
+	c.Assert(q.LoadTree(dir), IsNil)
+	return q, &out
+}
+
+func (s *QuerySuite) TestRun(c *C) {
+	q, out := newQuery(c, map[string]string{
+		"a.html":  "<ul>\n  <li id=\"x\">1</li>\n  <li>2</li>\n</ul>",
+		"b.xml":   `<r><li>3</li></r>`,
+		"c.txt":   `<li>4</li>`,
+		"d.xhtml": `<LI>5</LI>`,
+	})
+	c.Assert(q.Set.Len(), Equals, 3)
+	c.Assert(q.Run("//li"), IsNil)
+	c.Assert(strings.Replace(out.String(), filepath.Dir(q.Set.Name(0))+"/", "", -1), Equals, ""+
+		"a.html:2:3: <li id=\"x\">1</li>\n"+
+		"a.html:3:3: <li>2</li>\n"+
+		"b.xml:1:4: <li>3</li>\n")
+
+	out.Reset()
+	c.Assert(q.Run("//li/@id"), IsNil)
+	c.Assert(out.String(), Matches, `.*a.html:2:3: @id="x"\n`)
+
+	out.Reset()
+	c.Assert(q.Run("count(//li)"), IsNil)
+	c.Assert(out.String(), Matches, `.*a.html: 2\n.*b.xml: 1\n.*d.xhtml: 0\n`)
+
+	out.Reset()
+	q.Fold = true
+	c.Assert(q.Run("count(//li)"), IsNil)
+	c.Assert(out.String(), Matches, `(?s).*d.xhtml: 1\n`)
+
+	c.Assert(q.Run("//li["), NotNil)
+}
+
+func (s *QuerySuite) TestJSON(c *C) {
+	q, out := newQuery(c, map[string]string{"a.html": `<p>a</p>`})
+	q.JSON = true
+	c.Assert(q.Run("1 div 0"), IsNil)
+	c.Assert(q.Run("//p/text()"), IsNil)
+	c.Assert(out.String(), Matches, ``+
+		`{"file":".*a.html","value":"\+Inf"}\n`+
+		`{"file":".*a.html","value":\[{"kind":"text","text":"a",.*}\]}\n`)
+}
+
+func (s *QuerySuite) TestRepl(c *C) {
+	q, out := newQuery(c, map[string]string{"a.html": `<p>a</p><p>b</p>`})
+
+	// Empty lines are skipped until the quit command
+	q.Repl(strings.NewReader("count(//p)\n\n  \ncount(//p/text())\n.quit\ncount(//a)\n"), "")
+	c.Assert(out.String(), Matches, `.*a.html: 2\n.*a.html: 2\n`)
+
+	// or the end of the input
+	out.Reset()
+	q.Repl(strings.NewReader("\ncount(//a)"), "")
+	c.Assert(out.String(), Matches, `.*a.html: 0\n`)
+}
+
+func (s *QuerySuite) TestSummary(c *C) {
+	q, _ := newQuery(c, map[string]string{"a.html": "<p title=\"t\">a\n  b</p><pre>" + strings.Repeat("é", 100) + "</pre>"})
+	root := q.Set.Root(0)
+	p := root.Children()[0]
+	c.Assert(summary(p), Equals, `<p title="t">a b</p>`)
+	c.Assert(summary(p.Children()[0]), Equals, "a b")
+
+	// Long nodes are cut between runes
+	pre := root.Children()[1].Children()[0]
+	s1 := summary(pre)
+	c.Assert(utf8.ValidString(s1), Equals, true)
+	c.Assert(s1, Equals, strings.Repeat("é", summaryMax/2)+"...")
+	s2 := summary(root.Children()[1])
+	c.Assert(utf8.ValidString(s2), Equals, true)
+	c.Assert(s2, Equals, "<pre>"+strings.Repeat("é", (summaryMax-5)/2)+"...")
+}
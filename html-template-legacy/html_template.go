@@ -11,9 +11,9 @@ import (
 	"time"
 	//"golang.org/x/net/html"
 	"bytes"
+	"github.com/mildred/htmltools/xmlpath"
 	"io"
 	"io/ioutil"
-	"launchpad.net/xmlpath"
 	"os"
 	"path/filepath"
 )
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"github.com/mildred/htmltools/dryrun"
 	"golang.org/x/net/html"
 	"io/ioutil"
 	"os"
@@ -36,10 +37,13 @@ func replaceImports(fname string, imports map[string][]byte) error {
 		data = bytes.Replace(data, idata, []byte(import_tag), -1)
 	}
 
-	return ioutil.WriteFile(fname, data, os.ModePerm)
+	return dry.WriteFile(fname, data, os.ModePerm)
 }
 
+var dry = dryrun.New()
+
 func main() {
+	dry.Flags()
 	flag.Parse()
 
 	imports := flag.Args()
@@ -58,5 +62,5 @@ func main() {
 		os.Exit(1)
 	}
 
-	os.Exit(0)
+	os.Exit(dry.ExitStatus())
 }
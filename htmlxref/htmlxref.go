@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"github.com/mildred/htmltools/dryrun"
 	"golang.org/x/net/html"
 	"io"
 	"io/ioutil"
@@ -12,7 +13,10 @@ import (
 	"strings"
 )
 
+var dry = dryrun.New()
+
 func main() {
+	dry.Flags()
 	flag.Parse()
 	fname := flag.Arg(0)
 	err := xref(fname)
@@ -20,7 +24,14 @@ func main() {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
-	os.Exit(0)
+	os.Exit(dry.ExitStatus())
+}
+
+// Print informative messages, unless only the diffs are requested
+func info(format string, args ...interface{}) {
+	if !dry.DiffOnly {
+		fmt.Printf(format, args...)
+	}
 }
 
 func readAttributes(z *html.Tokenizer, attrs bool) (attributes [][]string, direction, kind string, href string) {
@@ -79,13 +90,13 @@ func xref(fname string) error {
 
 			if string(tagName) == "link" {
 				_, direction, kind, href := readAttributes(z, attrs)
-				fmt.Printf("Link: %v=%v %v\n", direction, kind, href)
+				info("Link: %v=%v %v\n", direction, kind, href)
 
 				if kind != "" {
 					target := filepath.Join(filepath.Dir(fname), href)
 					err := ensure_link(fname, target, reverse(direction), kind)
 					if err != nil && os.IsNotExist(err) {
-						fmt.Printf("      not modifiable\n")
+						info("      not modifiable\n")
 						err = nil
 					} else if err != nil {
 						return err
@@ -117,7 +128,7 @@ func ensure_link(target, source, direction, kind string) error {
 	if !strings.HasPrefix(line, "<!doctype") &&
 		!strings.HasPrefix(line, "<?xml") &&
 		!strings.HasPrefix(line, "<html") {
-		fmt.Printf("      not a HTML file\n")
+		info("      not a HTML file\n")
 		return nil
 	}
 
@@ -209,7 +220,7 @@ func ensure_link(target, source, direction, kind string) error {
 		}
 	}
 
-	return os.Rename(f2.Name(), f.Name())
+	return dry.Rename(f2.Name(), f.Name())
 }
 
 // FIXME: doesn't work with indent != 1
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/mildred/htmltools/dryrun"
 	"github.com/mildred/htmltools/parser"
 	"golang.org/x/net/html"
 	"io"
@@ -12,7 +13,10 @@ import (
 	"strings"
 )
 
+var dry = dryrun.New()
+
 func main() {
+	dry.Flags()
 	flag.Parse()
 	infile := flag.Arg(0)
 
@@ -26,7 +30,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	os.Exit(0)
+	os.Exit(dry.ExitStatus())
 }
 
 func main2(infile string) error {
@@ -73,7 +77,7 @@ func main2(infile string) error {
 	}
 
 	if infile != "" {
-		err := os.Rename(outfile, infile)
+		err := dry.Rename(outfile, infile)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,3089 @@
+package xmlpath_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/mildred/htmltools/xmlpath"
+	"github.com/mildred/htmltools/xmlpath/css"
+	"io"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"log"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"unsafe"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&BasicSuite{})
+
+type BasicSuite struct{}
+
+func (s *BasicSuite) TestInsertAndRemove(c *C) {
+	node_, err := xmlpath.Parse(bytes.NewBuffer([]byte(`<r><x>1</x><a><x>2</x></a><x>3</x></r>`)))
+	node := node_.Ref
+	c.Assert(err, IsNil)
+	pathA := xmlpath.MustCompile("//a")
+	pathX := xmlpath.MustCompile("//x")
+	a := pathA.Iter(node.Node).Nodes()[0]
+	xx := pathX.Iter(node.Node).Nodes()
+	log.Print(a.Node.XML())
+	c.Assert(string(a.Node.XML()), Equals, "<a><x>2</x></a>")
+	c.Assert(len(xx), Equals, 3)
+	c.Assert(string(xx[0].Node.XML()), Equals, "<x>1</x>")
+	c.Assert(string(xx[1].Node.XML()), Equals, "<x>2</x>")
+	c.Assert(string(xx[2].Node.XML()), Equals, "<x>3</x>")
+	xx[0].Node.Remove()
+	a.Node.InsertFirstChild(xx[0].Node)
+	log.Print(string(node.Node.XML()))
+	c.Assert(string(node.Node.XML()), Equals, "<r><a><x>1</x><x>2</x></a><x>3</x></r>")
+}
+
+var trivialXml = []byte(`<root>a<foo>b</foo>c<bar>d</bar>e<bar>f</bar>g</root>`)
+
+func (s *BasicSuite) TestRootText(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(trivialXml))
+	c.Assert(err, IsNil)
+	path := xmlpath.MustCompile("/")
+	result, ok := path.String(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(result, Equals, "abcdefg")
+}
+
+var trivialHtml = []byte(`<root><foo>&lt;a&gt;</root>`)
+
+func (s *BasicSuite) TestHTML(c *C) {
+	node, err := xmlpath.ParseHTML(bytes.NewBuffer(trivialHtml))
+	c.Assert(err, IsNil)
+	path := xmlpath.MustCompile("/root/foo")
+	result, ok := path.String(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(result, Equals, "<a>")
+}
+
+func (s *BasicSuite) TestIDIndex(c *C) {
+	node_, err := xmlpath.Parse(bytes.NewBuffer([]byte(`<r><x id="a">1</x><x xml:id="b">2</x></r>`)))
+	c.Assert(err, IsNil)
+	node := node_.Ref
+	path := xmlpath.MustCompile("r[id('a b')]/x")
+	c.Assert(len(path.Iter(node.Node).Nodes()), Equals, 2)
+	xmlpath.MustCompile("//x[@id='a']").Iter(node.Node).Nodes()[0].Node.Remove()
+	c.Assert(string(node.Node.XML()), Equals, `<r><x xml:id="b">2</x></r>`)
+	c.Assert(xmlpath.MustCompile("r[id('a')]").Exists(node.Node), Equals, false)
+	c.Assert(xmlpath.MustCompile("r[id('b')]").Exists(node.Node), Equals, true)
+}
+
+func (s *BasicSuite) TestIDIndexHTML(c *C) {
+	// The id attributes of HTML documents are found regardless of their case
+	data := `<div><p ID="a">1</p><p Id="b">2</p><p id="C">3</p></div>`
+	node, err := xmlpath.ParseHTML(bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	result, ok := xmlpath.MustCompile("id('a')").String(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(result, Equals, "1")
+	c.Assert(xmlpath.MustCompile("id('a b C')").Count(node), Equals, 3)
+	c.Assert(xmlpath.MustCompile("id('c')").Exists(node), Equals, false)
+
+	// but not in XML documents
+	node, err = xmlpath.Parse(bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	c.Assert(xmlpath.MustCompile("id('a b C')").Count(node), Equals, 1)
+}
+
+func (s *BasicSuite) TestLang(c *C) {
+	node, err := xmlpath.ParseHTML(bytes.NewBuffer([]byte(`<html lang="fr-CA"><p>a</p><p xml:lang="en">b</p></html>`)))
+	c.Assert(err, IsNil)
+	result, ok := xmlpath.MustCompile("//p[lang('fr')]").String(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(result, Equals, "a")
+	result, ok = xmlpath.MustCompile("//p[lang('en')]").String(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(result, Equals, "b")
+	c.Assert(xmlpath.MustCompile("//p[lang('fr-ca')]").Exists(node), Equals, true)
+	c.Assert(xmlpath.MustCompile("//p[lang('fr-FR')]").Exists(node), Equals, false)
+
+	// xml:lang comes first, wherever it is among the attributes
+	node, err = xmlpath.ParseHTML(bytes.NewBuffer([]byte(`<p lang="de" xml:lang="en">c</p>`)))
+	c.Assert(err, IsNil)
+	c.Assert(xmlpath.MustCompile("//p[lang('en')]").Exists(node), Equals, true)
+	c.Assert(xmlpath.MustCompile("//p[lang('de')]").Exists(node), Equals, false)
+	c.Assert(xmlpath.MustCompile("p[lang('en')]").Exists(node.Copy()), Equals, true)
+
+	// The lang attribute of HTML is not the language of XML documents
+	node, err = xmlpath.Parse(bytes.NewBuffer([]byte(`<r xml:lang="en-GB"><t lang="fr">a</t><t xml:lang="EN">b</t></r>`)))
+	c.Assert(err, IsNil)
+	c.Assert(xmlpath.MustCompile("//t[lang('fr')]").Count(node), Equals, 0)
+	c.Assert(xmlpath.MustCompile("//t[lang('en')]").Count(node), Equals, 2)
+	c.Assert(xmlpath.MustCompile("//t/text()[lang('en-gb')]").Count(node), Equals, 1)
+	c.Assert(xmlpath.MustCompile("//t[lang('e')]").Count(node), Equals, 0)
+}
+
+func (s *BasicSuite) TestNodeNames(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer([]byte(`<r xmlns:s="urn:s"><s:x s:a="1">2</s:x></r>`)))
+	c.Assert(err, IsNil)
+	for _, path := range []string{
+		"r/*[name()='s:x']",
+		"r/*[local-name()='x' and namespace-uri()='urn:s']",
+		"r/*[name(@*)='s:a']",
+		"r[namespace-uri(*)='urn:s']/*",
+	} {
+		result, ok := xmlpath.MustCompile(path).String(node)
+		c.Assert(ok, Equals, true, Commentf("xml path: %s", path))
+		c.Assert(result, Equals, "2", Commentf("xml path: %s", path))
+	}
+}
+
+func (s *BasicSuite) TestNamespaceWildcards(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer([]byte(`<r xmlns:s="urn:s" xmlns:t="urn:t"><s:x s:a="1">a</s:x><t:x t:a="2">b</t:x><s:y>c</s:y><x a="3">d</x>e</r>`)))
+	c.Assert(err, IsNil)
+	ns := map[string]string{"s": "urn:s", "t": "urn:t"}
+	for _, test := range []struct {
+		path   string
+		result []string
+	}{
+		{"r/s:*", []string{"a", "c"}},
+		{"r/t:*", []string{"b"}},
+		{"r/child::s:*", []string{"a", "c"}},
+		{"r/*:x", []string{"a", "b", "d"}},
+		{"r/*:y", []string{"c"}},
+		{"r/*:*", []string{"a", "b", "c", "d"}},
+		{"r/*", []string{"a", "b", "c", "d"}},
+		{"r/x", []string{"d"}},
+		{"r/*/@s:*", []string{"1"}},
+		{"r/*/@*:a", []string{"1", "2", "3"}},
+		{"r/*[@*:a > 1]", []string{"b", "d"}},
+		{"r/@*", nil},
+		{"r/@xmlns:s", nil},
+		{"r[count(@node()) = 0]/*:x", []string{"a", "b", "d"}},
+		{"r[count(s:*) = 2]/*:y", []string{"c"}},
+	} {
+		var result []string
+		iter := xmlpath.MustCompileNS(test.path, ns).Iter(node)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+}
+
+func (s *BasicSuite) TestNamespaceAxis(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer([]byte(`<r xmlns="urn:d" xmlns:s="urn:s" a="1"><x xmlns:s="urn:s2" xmlns:t="urn:t"><y xmlns=""/></x></r>`)))
+	c.Assert(err, IsNil)
+	for _, test := range []struct {
+		path   string
+		result []string
+	}{
+		{"*/namespace::*", []string{"urn:d", "urn:s"}},
+		{"*/*/namespace::*", []string{"urn:d", "urn:s2", "urn:t"}},
+		{"*/*/namespace::s", []string{"urn:s2"}},
+		{"*/*/*/namespace::node()", []string{"urn:s2", "urn:t"}},
+		{"*/namespace::t", nil},
+		{"*/@a/namespace::*", nil},
+		{"*/*[namespace::t = 'urn:t']/*/namespace::t", []string{"urn:t"}},
+		{"*[count(namespace::*) = 2]/@a", []string{"1"}},
+	} {
+		var result []string
+		iter := xmlpath.MustCompile(test.path).Iter(node)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+	_, err = xmlpath.Compile("*/namespace::text()")
+	c.Assert(err, ErrorMatches, `.*: text\(\) cannot succeed on axis "namespace"`)
+}
+
+func (s *BasicSuite) TestDefaultNamespace(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer([]byte(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:svg="http://www.w3.org/2000/svg"><body><a href="x">1</a><?a b?><svg:svg><svg:a href="y">2</svg:a></svg:svg><p xmlns=""><a href="z">3</a></p></body></html>`)))
+	c.Assert(err, IsNil)
+	ns := map[string]string{"": xmlpath.XHTMLNamespace, "svg": "http://www.w3.org/2000/svg"}
+	for _, test := range []struct {
+		path   string
+		result []string
+	}{
+		{"//a", []string{"1"}},
+		{"//a/@href", []string{"x"}},
+		{"//a[@href = 'x']", []string{"1"}},
+		{"/html/body/a", []string{"1"}},
+		{"//svg:a/@href", []string{"y"}},
+		{"//*:a", []string{"1", "2", "3"}},
+		{"//*[local-name() = 'a']", []string{"1", "2", "3"}},
+		{"//body/processing-instruction('a')", []string{"b"}},
+		{"//p", nil},
+		{"//*:p/*", []string{"3"}},
+		{"//*:p/a", nil},
+	} {
+		var result []string
+		iter := xmlpath.MustCompileNS(test.path, ns).Iter(node)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+	c.Assert(xmlpath.MustCompile("//a").Exists(node), Equals, true)
+	c.Assert(xmlpath.MustCompile("/html").Exists(node), Equals, false)
+}
+
+func (s *BasicSuite) TestCompileHTML(c *C) {
+	node, err := xmlpath.ParseHTML(bytes.NewBuffer([]byte(`<HTML><Body><A HREF="x">1</A><a href="y">2</a><p Class="c">3</p></Body></HTML>`)))
+	c.Assert(err, IsNil)
+	for _, test := range []struct {
+		path   string
+		result []string
+	}{
+		{"//a", []string{"1", "2"}},
+		{"//A/@href", []string{"x", "y"}},
+		{"/html/body/p[@class = 'c']", []string{"3"}},
+		{"//p[@CLASS = 'C']", nil},
+		{"//body/*[self::A]", []string{"1", "2"}},
+	} {
+		var result []string
+		iter := xmlpath.MustCompileHTML(test.path).Iter(node)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+	c.Assert(xmlpath.MustCompile("//a").Exists(node), Equals, true)
+	c.Assert(xmlpath.MustCompile("//A/@href").Exists(node), Equals, false)
+	value, err := xmlpath.MustCompileHTML("count(//A)").Eval(node)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 2.0)
+}
+
+func (s *BasicSuite) TestCompileWithOptions(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer([]byte(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:svg="http://www.w3.org/2000/svg"><body><A href="x">1</A><svg:svg id="s"/></body></html>`)))
+	c.Assert(err, IsNil)
+	opts := xmlpath.Options{
+		Namespaces:       map[string]string{"s": "http://www.w3.org/2000/svg"},
+		DefaultNamespace: xmlpath.XHTMLNamespace,
+		FoldCase:         true,
+		Functions: map[string]func(args ...xmlpath.Value) xmlpath.Value{
+			"twice":  func(args ...xmlpath.Value) xmlpath.Value { return args[0].(float64) * 2 },
+			"concat": func(args ...xmlpath.Value) xmlpath.Value { return "overridden" },
+		},
+	}
+	for _, test := range []struct {
+		path   string
+		result []string
+	}{
+		{"/html/body/a/@href", []string{"x"}},
+		{"//s:svg/@id", []string{"s"}},
+		{"//a[twice(number(.)) = 2]", []string{"1"}},
+		{"//a[concat('a', 'b') = 'overridden']", []string{"1"}},
+	} {
+		var result []string
+		iter := xmlpath.MustCompileWithOptions(test.path, opts).Iter(node)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+	c.Assert(opts.Namespaces, DeepEquals, map[string]string{"s": "http://www.w3.org/2000/svg"})
+
+	// The functions are only available to the paths compiled with them
+	_, err = xmlpath.Compile("//a[twice(.) = 2]")
+	c.Assert(err, NotNil)
+	c.Assert(xmlpath.MustCompile("//*[concat('a', 'b') = 'ab']").Exists(node), Equals, true)
+
+	// Namespaces take precedence over DefaultNamespace
+	opts = xmlpath.Options{Namespaces: map[string]string{"": ""}, DefaultNamespace: xmlpath.XHTMLNamespace}
+	c.Assert(xmlpath.MustCompileWithOptions("//body", opts).Exists(node), Equals, false)
+}
+
+func (s *BasicSuite) TestKeys(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	node.AddKey("born", xmlpath.MustCompile("//character"), xmlpath.MustCompile("born"))
+	node.AddKey("author", xmlpath.MustCompile("//book"), xmlpath.MustCompile("author/@id | isbn"))
+	for _, test := range []struct {
+		path   string
+		result []string
+	}{
+		{"library/book/character[. = key('born', '1922-07-17')]/@id", []string{"Spark"}},
+		{"library/book/character[key('born', born) = .]/@id", []string{"PP", "Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+		{"library/book/character[key('born', born)[2]]/@id", nil},
+		{"library/book[key('author', 'CMS')[1] = .]/isbn", []string{"0836217462"}},
+		{"library/book[count(key('author', 'CMS')) = 2][1]/isbn", []string{"0836217462"}},
+		{"library/book[count(key('author', ../book/isbn)) = 2][1]/isbn", []string{"0836217462"}},
+		{"library/book[key('author', 0883556316)]/isbn", nil},
+		{"library/book[key('author', '0883556316')/character = character]/isbn", []string{"0883556316"}},
+		{"library/book[key('missing', 'CMS')]/isbn", nil},
+	} {
+		var result []string
+		iter := xmlpath.MustCompile(test.path).Iter(node)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+
+	// The index follows changes to the document.
+	path := xmlpath.MustCompile("library/book[key('born', '1922-07-17')]/isbn")
+	c.Assert(path.Exists(node), Equals, true)
+	xmlpath.MustCompile("//character[@id='Spark']").Iter(node).Nodes()[0].Node.Remove()
+	c.Assert(path.Exists(node.Ref.Node), Equals, false)
+}
+
+func (s *BasicSuite) TestEval(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, test := range []struct {
+		path   string
+		result xmlpath.Value
+	}{
+		{"count(//character)", 7.0},
+		{"string(//title)", "Being a Dog Is a Full-Time Job"},
+		{"//book[2]/isbn = '0883556316'", true},
+		{"sum(//book/isbn) div 2", 859886889.0},
+		{"concat(//author/name, ' (', //author/born, ')')", "Charles M Schulz (1922-11-26)"},
+		{"//character[@id='Lucy']/@id", []string{"Lucy"}},
+		{"//book/isbn | //author/@id", []string{"0836217462", "CMS", "0883556316", "CMS"}},
+		{"id('Snoopy Lucy')/name", []string{"Snoopy", "Lucy"}},
+		{"id('Lucy Snoopy')/@id", []string{"Snoopy", "Lucy"}},
+		{"//nothing", []string(nil)},
+		{"string(-0)", "0"},
+		{"string(0 * -1)", "0"},
+		{"concat(-1 div (1 div 0), '')", "0"},
+	} {
+		value, err := xmlpath.MustCompile(test.path).Eval(node)
+		c.Assert(err, IsNil)
+		if nodes, ok := value.([]*xmlpath.Node); ok {
+			var result []string
+			for _, node := range nodes {
+				result = append(result, node.String())
+			}
+			value = result
+		}
+		c.Assert(value, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+
+	path := xmlpath.MustCompile("count(//book)")
+	c.Assert(path.Exists(node), Equals, false)
+	_, err = path.Eval(nil)
+	c.Assert(err, ErrorMatches, `xmlpath: cannot evaluate "count\(//book\)" without a context node`)
+}
+
+func (s *BasicSuite) TestCompileError(c *C) {
+	for _, test := range []struct {
+		path string
+		err  xmlpath.CompileError
+	}{
+		{"/foo)", xmlpath.CompileError{Offset: 4, Token: ")", Msg: "unexpected ')'"}},
+		{"a[b", xmlpath.CompileError{Offset: 3, Expected: "]", Msg: "expected ']'"}},
+		{"a[b = ]", xmlpath.CompileError{Offset: 6, Token: "]", Expected: "expression", Msg: "expected an expression"}},
+		{"a/@", xmlpath.CompileError{Offset: 3, Expected: "name", Msg: "missing name after @"}},
+		{"a[x = 'b]", xmlpath.CompileError{Offset: 7, Token: "b", Expected: "'", Msg: `missing "'"`}},
+		{"a[concat('a' 'b')]", xmlpath.CompileError{Offset: 13, Token: "'", Expected: ")", Msg: "missing )"}},
+		{"foo::node()", xmlpath.CompileError{Offset: 5, Token: "node", Msg: `unsupported axis: "foo"`}},
+	} {
+		_, err := xmlpath.Compile(test.path)
+		test.err.Path = test.path
+		c.Assert(err, DeepEquals, &test.err, Commentf("xml path: %s", test.path))
+	}
+}
+
+func (s *BasicSuite) TestCompileLimits(c *C) {
+	opts := xmlpath.Options{MaxLength: 20, MaxSteps: 3, MaxDepth: 2}
+	for _, test := range []struct {
+		path string
+		err  xmlpath.CompileError
+	}{
+		{"/a/b/c", xmlpath.CompileError{}},
+		{"a[b[c]]", xmlpath.CompileError{}},
+		{"count((a))", xmlpath.CompileError{}},
+		{"/a/b/c/d", xmlpath.CompileError{Offset: 8, Msg: "path has more than 3 steps"}},
+		{"a[b]/c[d]", xmlpath.CompileError{Offset: 9, Msg: "path has more than 3 steps"}},
+		{"a[b[c[d]]]", xmlpath.CompileError{Offset: 6, Token: "d", Msg: "expressions nested deeper than 2 levels"}},
+		{"a[((1))]", xmlpath.CompileError{Offset: 4, Token: "1", Msg: "expressions nested deeper than 2 levels"}},
+		{"a[concat(b, 'c')]", xmlpath.CompileError{}},
+		{"//a[@href = 'http://example.com/']", xmlpath.CompileError{Offset: 20, Token: "example.com", Msg: "path longer than 20 bytes"}},
+	} {
+		_, err := xmlpath.CompileWithOptions(test.path, opts)
+		if test.err.Msg == "" {
+			c.Assert(err, IsNil, Commentf("xml path: %s", test.path))
+			continue
+		}
+		test.err.Path = test.path
+		c.Assert(err, DeepEquals, &test.err, Commentf("xml path: %s", test.path))
+	}
+	_, err := xmlpath.Compile(strings.Repeat("a[", 100) + "b" + strings.Repeat("]", 100))
+	c.Assert(err, IsNil)
+}
+
+func (s *BasicSuite) TestExpr(c *C) {
+	for _, test := range []struct {
+		path string
+		expr string
+	}{
+		{"/library/book/isbn", "/child::library/child::book/child::isbn"},
+		{"library//@id", "child::library/descendant-or-self::node()/attribute::id"},
+		{"//book[@id = 'b0836217462'][2]", "/descendant-or-self::node()/child::book[@id = 'b0836217462'][2]"},
+		{"book/../.", "child::book/parent::node()/self::node()"},
+		{"a/text() | b/comment()", "child::a/child::text() | child::b/child::comment()"},
+		{"a except (b | c)", "child::a except (child::b | child::c)"},
+		{"a/processing-instruction('echo')", "child::a/child::processing-instruction('echo')"},
+		{"(//a)[1]//b", "(//a)[1]/descendant-or-self::node()/child::b"},
+		{"id('x')", "id('x')"},
+		{" count(//a) ", "count(//a)"},
+		{"/", "/"},
+		{"svg:*/*:x/@node()", "child::svg:*/child::*:x/attribute::*"},
+	} {
+		path := xmlpath.MustCompileNS(test.path, map[string]string{"svg": "http://www.w3.org/2000/svg"})
+		c.Assert(path.Expr(), Equals, test.expr, Commentf("xml path: %s", test.path))
+	}
+
+	path := xmlpath.MustCompile("/library/book[ isbn ][last()]//@id")
+	c.Assert(path.Absolute(), Equals, true)
+	c.Assert(path.Steps(), DeepEquals, []xmlpath.Step{
+		{Axis: "child", Test: "library"},
+		{Axis: "child", Test: "book", Predicates: []string{"isbn", "last()"}},
+		{Axis: "descendant-or-self", Test: "node()"},
+		{Axis: "attribute", Test: "id"},
+	})
+	c.Assert(xmlpath.MustCompile("a | b").Steps(), IsNil)
+	c.Assert(xmlpath.MustCompile("(a | b)/c").Absolute(), Equals, false)
+}
+
+func (s *BasicSuite) TestExprLibraryTable(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, test := range libraryTable {
+		path, err := xmlpath.Compile(test.path)
+		if err != nil {
+			continue
+		}
+		cmt := Commentf("xml path: %s, normalized: %s", test.path, path.Expr())
+		normalized, err := xmlpath.Compile(path.Expr())
+		c.Assert(err, IsNil, cmt)
+		c.Assert(normalized.Expr(), Equals, path.Expr(), cmt)
+		var want, got []string
+		for iter := path.Iter(node); iter.Next(); {
+			want = append(want, iter.Node().String())
+		}
+		for iter := normalized.Iter(node); iter.Next(); {
+			got = append(got, iter.Node().String())
+		}
+		c.Assert(got, DeepEquals, want, cmt)
+	}
+}
+
+func (s *BasicSuite) TestCache(c *C) {
+	cache := xmlpath.NewCache(2)
+	a, err := cache.Compile("a")
+	c.Assert(err, IsNil)
+	b, err := cache.Compile("b")
+	c.Assert(err, IsNil)
+	again, err := cache.Compile("a")
+	c.Assert(err, IsNil)
+	c.Assert(again, Equals, a)
+
+	// b is the least recently used path and is evicted.
+	_, err = cache.Compile("c")
+	c.Assert(err, IsNil)
+	again, _ = cache.Compile("a")
+	c.Assert(again, Equals, a)
+	again, _ = cache.Compile("b")
+	c.Assert(again == b, Equals, false)
+
+	_, err = cache.Compile("a[")
+	c.Assert(err, ErrorMatches, `.*: expected an expression`)
+
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				path, err := xmlpath.CompileCached(fmt.Sprintf("library/book[%d]/isbn", j%4+1))
+				c.Check(err, IsNil)
+				path.Exists(node)
+			}
+		}(i)
+	}
+	wg.Wait()
+	path, err := xmlpath.CompileCached("library/book[2]/isbn")
+	c.Assert(err, IsNil)
+	again, err = xmlpath.CompileCached("library/book[2]/isbn")
+	c.Assert(err, IsNil)
+	c.Assert(again, Equals, path)
+}
+
+func (s *BasicSuite) TestDocumentOrder(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer([]byte(`<r><a><a><b>1</b></a><b>2</b></a><b>3</b></r>`)))
+	c.Assert(err, IsNil)
+	for _, test := range []struct {
+		path   string
+		result []string
+	}{
+		{"//b", []string{"1", "2", "3"}},
+		{"//a/b", []string{"1", "2"}},
+		{"//a//b", []string{"1", "2"}},
+		{"//b[1]", []string{"1", "2", "3"}},
+		{"//b/ancestor::a", []string{"12", "1"}},
+		{"//b/ancestor-or-self::a/b", []string{"1", "2"}},
+		{"r/b/preceding::b", []string{"1", "2"}},
+		{"r/b/preceding::*/b", []string{"1", "2"}},
+		{"//b/..", []string{"123", "12", "1"}},
+		{"r/*/../*", []string{"12", "3"}},
+	} {
+		var result []string
+		iter := xmlpath.MustCompile(test.path).Iter(node)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+
+	// Positions in predicates remain proximity positions on reverse axes.
+	path := xmlpath.MustCompile("//b[. = '1']/ancestor::*[1]")
+	result, ok := path.String(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(result, Equals, "1")
+	path = xmlpath.MustCompile("string(//a//b)")
+	value, err := path.Eval(node)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "1")
+}
+
+func (s *BasicSuite) TestIterContext(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, path := range []string{"//character", "//name/..", "//book | //character", "(//character)[@id]"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		iter := xmlpath.MustCompile(path).IterContext(ctx, node)
+		c.Assert(iter.Next(), Equals, true, Commentf("xml path: %s", path))
+		c.Assert(iter.Next(), Equals, true, Commentf("xml path: %s", path))
+		cancel()
+		c.Assert(iter.Next(), Equals, false, Commentf("xml path: %s", path))
+		c.Assert(iter.Err(), Equals, context.Canceled, Commentf("xml path: %s", path))
+
+		iter = xmlpath.MustCompile(path).IterContext(ctx, node)
+		c.Assert(iter.Next(), Equals, false, Commentf("xml path: %s", path))
+		c.Assert(iter.Err(), Equals, context.Canceled, Commentf("xml path: %s", path))
+	}
+
+	iter := xmlpath.MustCompile("//character").IterContext(context.Background(), node)
+	count := 0
+	for iter.Next() {
+		count++
+	}
+	c.Assert(count, Equals, 7)
+	c.Assert(iter.Err(), IsNil)
+}
+
+func (s *BasicSuite) TestIterLimit(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, test := range []struct {
+		path   string
+		limit  int
+		result []string
+	}{
+		{"//character/@id", 3, []string{"PP", "Snoopy", "Schroeder"}},
+		{"//character/@id", 0, nil},
+		{"//book/@id", 10, []string{"b0836217462", "b0883556316"}},
+		{"//name/../@id", 2, []string{"CMS", "PP"}},
+		{"//book/@id | //character/@id", 2, []string{"b0836217462", "PP"}},
+	} {
+		var result []string
+		iter := xmlpath.MustCompile(test.path).Iter(node).Limit(test.limit)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("xml path: %s", test.path))
+	}
+}
+
+func (s *BasicSuite) TestCount(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, test := range []struct {
+		path  string
+		count int
+	}{
+		{"//character", 7},
+		{"//name/..", 9},
+		{"//book/ancestor::*", 1},
+		{"//book/ancestor::node()", 2},
+		{"//name/ancestor::*", 12},
+		{"/self::*", 0},
+		{"/descendant-or-self::*", 44},
+		{"//*", 44},
+		{"/descendant-or-self::*/library", 0},
+		{"/descendant-or-self::node()/library", 1},
+		{"//book | //character", 9},
+		{"(//character)[@id = 'PP']", 1},
+		{"//summary", 0},
+	} {
+		c.Assert(xmlpath.MustCompile(test.path).Count(node), Equals, test.count, Commentf("xml path: %s", test.path))
+		value, err := xmlpath.MustCompile("count(" + test.path + ")").Eval(node)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, float64(test.count), Commentf("xml path: %s", test.path))
+	}
+}
+
+func (s *BasicSuite) TestIndexNames(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	indexed, err := xmlpath.Parse(bytes.NewBuffer(libraryXml), xmlpath.IndexNames)
+	c.Assert(err, IsNil)
+	for _, test := range libraryTable {
+		path, err := xmlpath.Compile(test.path)
+		if err != nil {
+			continue
+		}
+		var want, got []string
+		iter := path.Iter(node)
+		for iter.Next() {
+			want = append(want, iter.Node().String())
+		}
+		iter = path.Iter(indexed)
+		for iter.Next() {
+			got = append(got, iter.Node().String())
+		}
+		c.Assert(got, DeepEquals, want, Commentf("xml path: %s", test.path))
+	}
+
+	// The index follows the modifications of the document.
+	path := xmlpath.MustCompile("//character[@id='Lucy']")
+	lucy, ok := path.First(indexed)
+	c.Assert(ok, Equals, true)
+	root := indexed.Ref
+	lucy.Remove()
+	c.Assert(path.Exists(root.Node), Equals, false)
+	c.Assert(xmlpath.MustCompile("//character").Count(root.Node), Equals, 6)
+	c.Assert(xmlpath.MustCompile("//book[2]//character").Count(root.Node), Equals, 3)
+}
+
+func (s *BasicSuite) TestNodeSet(c *C) {
+	var set xmlpath.NodeSet
+	_, err := set.Parse("library.xml", bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	_, err = set.ParseHTML("empty.html", bytes.NewBufferString(`<html><body><p>No books</body></html>`))
+	c.Assert(err, IsNil)
+	other, err := xmlpath.Parse(bytes.NewBufferString(`<library><book><title>Peanuts</title></book></library>`))
+	c.Assert(err, IsNil)
+	set.Add("other.xml", other)
+	c.Assert(set.Len(), Equals, 3)
+	c.Assert(set.Name(1), Equals, "empty.html")
+
+	var result []string
+	iter := xmlpath.MustCompile("/library/book/title").IterAll(&set)
+	for iter.Next() {
+		result = append(result, iter.Document()+": "+iter.Node().String())
+	}
+	c.Assert(result, DeepEquals, []string{
+		"library.xml: Being a Dog Is a Full-Time Job",
+		"library.xml: Barney Google and Snuffy Smith",
+		"other.xml: Peanuts",
+	})
+	c.Assert(iter.Next(), Equals, false)
+
+	iter = xmlpath.MustCompile("//summary").IterAll(&set)
+	c.Assert(iter.Next(), Equals, false)
+	iter = xmlpath.MustCompile("//title").IterAll(&xmlpath.NodeSet{})
+	c.Assert(iter.Next(), Equals, false)
+}
+
+func (s *BasicSuite) TestStats(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBufferString(`<a x="12"><b>345</b><b/></a>`))
+	c.Assert(err, IsNil)
+	stats := node.Stats()
+	c.Assert(stats.Nodes, Equals, 10)
+	c.Assert(stats.TextBytes, Equals, 5)
+	c.Assert(stats.Bytes > stats.Nodes*int(unsafe.Sizeof(xmlpath.Node{})), Equals, true)
+
+	indexed, err := xmlpath.Parse(bytes.NewBufferString(`<a x="12"><b>345</b><b/></a>`), xmlpath.IndexNames)
+	c.Assert(err, IsNil)
+	c.Assert(indexed.Stats().Bytes > stats.Bytes, Equals, true)
+
+	children := xmlpath.MustCompile("a").Iter(node)
+	c.Assert(children.Next(), Equals, true)
+	c.Assert(len(children.Node().Children()), Equals, 2)
+	c.Assert(children.Node().Children()[0].String(), Equals, "345")
+}
+
+func (s *BasicSuite) TestMatchAll(c *C) {
+	var set xmlpath.NodeSet
+	var want []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("doc%d.xml", i)
+		_, err := set.Parse(name, bytes.NewBufferString(fmt.Sprintf(`<r><a>%d</a><b/><a>%d</a></r>`, i, i+1)))
+		c.Assert(err, IsNil)
+		want = append(want, fmt.Sprintf("%s: %d", name, i), fmt.Sprintf("%s: %d", name, i+1))
+	}
+	path := xmlpath.MustCompile("//a")
+	for _, workers := range []int{0, 1, 3, 50} {
+		var got []string
+		for _, match := range path.MatchAll(&set, workers) {
+			got = append(got, match.Document+": "+match.Node.String())
+		}
+		c.Assert(got, DeepEquals, want, Commentf("workers: %d", workers))
+	}
+	c.Assert(path.MatchAll(&xmlpath.NodeSet{}, 4), HasLen, 0)
+}
+
+func (s *BasicSuite) TestCompileAll(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	paths := []string{
+		"//book/@id", "//@id", "//@*", "//name", "/library/book//born", "//character[@id='PP']/name",
+		"//character[2]/name", "//name/..", "//book//node()", "//character/.", "library/book/title/@lang",
+		"//character[born and @id]/@id", "//isbn | //quote", "//*[.//name][@id]", "/", "//nothing/@id",
+	}
+	m, err := xmlpath.CompileAll(paths)
+	c.Assert(err, IsNil)
+	book, _ := xmlpath.MustCompile("//book").First(node)
+	order := map[*xmlpath.Node]int{}
+	all := xmlpath.MustCompile("/ | //node() | //@*").Iter(node)
+	for all.Next() {
+		order[all.Node()] = len(order)
+	}
+	for _, context := range []*xmlpath.Node{node, book} {
+		type match struct {
+			node *xmlpath.Node
+			path int
+		}
+		var want, got []match
+		for i, path := range paths {
+			iter := xmlpath.MustCompile(path).Iter(context)
+			for iter.Next() {
+				want = append(want, match{iter.Node(), i})
+			}
+		}
+		iter := m.Iter(context)
+		for iter.Next() {
+			got = append(got, match{iter.Node(), iter.Path()})
+		}
+		sort.SliceStable(want, func(i, j int) bool { return order[want[i].node] < order[want[j].node] })
+		c.Assert(got, DeepEquals, want)
+	}
+
+	_, err = xmlpath.CompileAll([]string{"//a", "//b["})
+	c.Assert(err, ErrorMatches, `compiling xml path "//b\[":4: .*`)
+}
+
+func (s *BasicSuite) TestIterTrace(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	var stats []xmlpath.StepStats
+	tracer := xmlpath.TracerFunc(func(s xmlpath.StepStats) { stats = append(stats, s) })
+
+	path := "/library/book/character[@id='PP']/name"
+	iter := xmlpath.MustCompile(path).IterTrace(node, tracer)
+	c.Assert(iter.Next(), Equals, true)
+	c.Assert(iter.Node().String(), Equals, "Peppermint Patty")
+	c.Assert(stats, HasLen, 0)
+	c.Assert(iter.Next(), Equals, false)
+	c.Assert(iter.Next(), Equals, false)
+	c.Assert(stats, HasLen, 4)
+	c.Assert(stats[2].Step, DeepEquals, xmlpath.Step{Axis: "child", Test: "character", Predicates: []string{"@id='PP'"}})
+	c.Assert(stats[2].Visited > stats[2].Matched, Equals, true)
+	c.Assert(stats[2].Matched, Equals, 1)
+	c.Assert(stats[3].Visited, Equals, 7)
+	c.Assert(stats[3].Matched, Equals, 1)
+	for i, s := range stats {
+		c.Assert(s.Path, Equals, path)
+		c.Assert(s.Index, Equals, i)
+	}
+
+	// The steps are reported as evaluated
+	stats = nil
+	c.Assert(xmlpath.MustCompile("//name | //isbn").IterTrace(node, tracer).Nodes(), HasLen, 11)
+	c.Assert(stats, HasLen, 2)
+	c.Assert(stats[0].Path, Equals, "//name")
+	c.Assert(stats[0].Step.Axis, Equals, "descendant")
+	c.Assert(stats[0].Matched, Equals, 9)
+	c.Assert(stats[1].Path, Equals, "//isbn")
+	c.Assert(stats[1].Matched, Equals, 2)
+}
+
+func (s *BasicSuite) TestXPointer(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	book, _ := xmlpath.MustCompile("//book[2]").First(node)
+	for _, test := range []struct {
+		ptr    string
+		result []string
+	}{
+		{"PP", []string{"<character>"}},
+		{"nothing", nil},
+		{"element(PP)", []string{"<character>"}},
+		{"element(PP/1)", []string{"<name>"}},
+		{"element(/1/1/1)", []string{"<isbn>"}},
+		{"element(/1/2/4/2)", []string{"<born>"}},
+		{"element(/1/1/9)", nil},
+		{"xpointer(//character[@id='Snoopy']/name/text())", []string{"Snoopy"}},
+		{"xpath1(count(//book))", nil},
+		{"element(nothing) xpointer(//isbn)", []string{"<isbn>", "<isbn>"}},
+		{"element(CMS/1) xpointer(//isbn)", []string{"<name>"}},
+		{"unknown(foo) element(PP/1)", []string{"<name>"}},
+		{"xpointer(//character[substring-before(qualification, ' ') = 'bold,']/@id)", []string{"PP"}},
+		{"xpointer(//character[name = 'Snoopy' or name = '^(Snoopy^)']/@id)", []string{"Snoopy"}},
+		{"xmlns(x=urn:none) xpointer(//x:name) xpointer(//book[1]/@id)", []string{"b0836217462"}},
+	} {
+		var result []string
+		for _, context := range []*xmlpath.Node{node, book} {
+			result = nil
+			iter := xmlpath.MustCompileXPointer(test.ptr).Iter(context)
+			for iter.Next() {
+				if n := iter.Node(); n.Kind() == xmlpath.StartNode {
+					result = append(result, "<"+n.Name().Local+">")
+				} else {
+					result = append(result, n.String())
+				}
+			}
+			c.Assert(result, DeepEquals, test.result, Commentf("xpointer: %s", test.ptr))
+		}
+	}
+
+	for _, test := range []struct {
+		ptr string
+		err xmlpath.CompileError
+	}{
+		{"", xmlpath.CompileError{Msg: "empty pointer"}},
+		{"a:b", xmlpath.CompileError{Token: "a:b", Msg: `invalid id "a:b"`}},
+		{"foo(bar", xmlpath.CompileError{Offset: 7, Msg: "missing )"}},
+		{"element(/1/x)", xmlpath.CompileError{Offset: 8, Token: "/", Msg: `invalid child position "x"`}},
+		{"xpointer(//a[)", xmlpath.CompileError{Offset: 13, Token: ")", Expected: "expression", Msg: "expected an expression"}},
+		{"foo(bar)", xmlpath.CompileError{Token: "foo", Msg: "no supported pointer part"}},
+		{"xpointer(a^b)", xmlpath.CompileError{Offset: 10, Token: "^", Msg: "invalid escape in scheme data"}},
+	} {
+		_, err := xmlpath.CompileXPointer(test.ptr)
+		test.err.Path = test.ptr
+		c.Assert(err, DeepEquals, &test.err, Commentf("xpointer: %s", test.ptr))
+	}
+}
+
+func (s *BasicSuite) TestTransform(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	var t xmlpath.Transform
+	t.Rule(xmlpath.MustCompile("//book/@id"), func(node *xmlpath.Node) string {
+		return "book " + node.String() + "\n"
+	})
+	t.Rule(xmlpath.MustCompile("//book[1]/character/name"), func(node *xmlpath.Node) string {
+		return "- " + node.String() + "\n"
+	})
+	t.Rule(xmlpath.MustCompile("//name"), func(node *xmlpath.Node) string {
+		return "by " + node.String() + "\n"
+	})
+	var buf bytes.Buffer
+	c.Assert(t.Execute(&buf, node), IsNil)
+	c.Assert(buf.String(), Equals, `book b0836217462
+by Charles M Schulz
+- Peppermint Patty
+- Snoopy
+- Schroeder
+- Lucy
+book b0883556316
+by Charles M Schulz
+by Barney Google
+by Spark Plug
+by Snuffy Smith
+`)
+
+	// Rewriting the nodes once the templates are applied
+	t = xmlpath.Transform{}
+	t.Rule(xmlpath.MustCompile("//@id"), func(node *xmlpath.Node) string { return strings.ToLower(node.String()) })
+	t.Rule(xmlpath.MustCompile("//@id"), func(node *xmlpath.Node) string { return "unused" })
+	results := t.Apply(node)
+	c.Assert(results, HasLen, 11)
+	for _, result := range results {
+		result.Node.SetBytes([]byte(result.Output))
+	}
+	c.Assert(xmlpath.MustCompile("//character/@id").Iter(node).Nodes()[1].Node.String(), Equals, "snoopy")
+}
+
+func (s *BasicSuite) TestNodeRefMutation(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<r><a id="a"><x>1</x></a><b id="b"/></r>`))
+	c.Assert(err, IsNil)
+	doc := root.Ref
+	a := xmlpath.MustCompile("//a").Iter(root).Nodes()[0]
+	b := xmlpath.MustCompile("//b").Iter(root).Nodes()[0]
+	other, err := xmlpath.Parse(bytes.NewBufferString(`<o><y>2</y><z/></o>`))
+	c.Assert(err, IsNil)
+	y, _ := xmlpath.MustCompile("//y").First(other)
+	z, _ := xmlpath.MustCompile("//z").First(other)
+	text := xmlpath.CreateTextNode([]byte("t"))
+
+	c.Assert(b.InsertBefore(y, &text), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<r><a id="a"><x>1</x></a><y>2</y>t<b id="b"></b></r>`)
+	c.Assert(a.AppendChild(z), IsNil)
+	c.Assert(a.PrependChild(&text), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<r><a id="a">t<x>1</x><z></z></a><y>2</y>t<b id="b"></b></r>`)
+	c.Assert(b.InsertAfter(a.Node), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<r><a id="a">t<x>1</x><z></z></a><y>2</y>t<b id="b"></b><a id="a">t<x>1</x><z></z></a></r>`)
+	c.Assert(a.Remove(), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<r><y>2</y>t<b id="b"></b><a id="a">t<x>1</x><z></z></a></r>`)
+	x := xmlpath.MustCompile("//x").Iter(doc.Node).Nodes()[0]
+	c.Assert(x.ReplaceWith(y, z), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<r><y>2</y>t<b id="b"></b><a id="a">t<y>2</y><z></z><z></z></a></r>`)
+
+	// The references, the positions and the ends of the nodes are kept up to date
+	c.Assert(b.Node.String(), Equals, "")
+	c.Assert(b.Node.Parent(), Equals, doc.Node.Children()[0])
+	c.Assert(xmlpath.MustCompile("/r/a/z[2]/preceding-sibling::*[2]").Count(doc.Node), Equals, 1)
+	c.Assert(xmlpath.MustCompile("id('b')/following::z").Count(doc.Node), Equals, 2)
+	c.Assert(xmlpath.MustCompile("/r/node()").Count(doc.Node), Equals, 4)
+
+	// Attributes go next to attributes only
+	idB := xmlpath.MustCompile("//b/@id").Iter(doc.Node).Nodes()[0]
+	c.Assert(idB.InsertAfter(y), ErrorMatches, `xmlpath: cannot insert after: attributes only go next to attributes`)
+	c.Assert(b.AppendChild(idB.Node), ErrorMatches, `xmlpath: cannot append: attributes only go next to attributes`)
+	c.Assert(idB.AppendChild(y), ErrorMatches, `xmlpath: cannot append children to a node that is not an element`)
+	c.Assert(doc.InsertBefore(y), ErrorMatches, `xmlpath: cannot insert before the root node`)
+	c.Assert(doc.Remove(), ErrorMatches, `xmlpath: cannot replace the root node`)
+	c.Assert(b.AppendChild(doc.Node), ErrorMatches, `xmlpath: cannot append the end of an element or a root node`)
+	c.Assert(string(doc.Node.XML()), Equals, `<r><y>2</y>t<b id="b"></b><a id="a">t<y>2</y><z></z><z></z></a></r>`)
+}
+
+func (s *BasicSuite) TestNodeRefDetached(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<div><ul><li>a</li><li>b</li></ul><p/></div>`))
+	c.Assert(err, IsNil)
+	doc := root.Ref
+	ul := xmlpath.MustCompile("//ul").Iter(root).Nodes()[0]
+	li := xmlpath.MustCompile("//li").Iter(root).Nodes()
+
+	// The nodes removed are moved to a document of their own
+	c.Assert(ul.Remove(), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<div><p></p></div>`)
+	c.Assert(li[1].Remove(), IsNil)
+	c.Assert(li[0].SetText("c"), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<div><p></p></div>`)
+	c.Assert(string(ul.Node.XML()), Equals, `<ul><li>c</li></ul>`)
+	c.Assert(ul.Node.Parent(), IsNil)
+	c.Assert(ul.Remove(), ErrorMatches, `xmlpath: cannot replace the root node`)
+
+	// and may be inserted back
+	p := xmlpath.MustCompile("//p").Iter(doc.Node).Nodes()[0]
+	c.Assert(p.ReplaceWith(ul.Node), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<div><ul><li>c</li></ul></div>`)
+	c.Assert(string(p.Node.XML()), Equals, `<p></p>`)
+	c.Assert(li[0].SetText("d"), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<div><ul><li>c</li></ul></div>`)
+}
+
+func (s *BasicSuite) TestSetAttr(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<r xmlns:l="urn:l"><a href="x" id="a">1</a><b/></r>`))
+	c.Assert(err, IsNil)
+	doc := root.Ref
+	a := xmlpath.MustCompile("//a").Iter(root).Nodes()[0]
+	b := xmlpath.MustCompile("//b").Iter(root).Nodes()[0]
+	attrs := func(ref *xmlpath.NodeRef) string {
+		var res []string
+		iter := xmlpath.MustCompile("@* | namespace::*[not(parent::r)]").Iter(ref.Node)
+		for iter.Next() {
+			name := iter.Node().Name()
+			res = append(res, name.Space+" "+name.Local+"="+iter.Node().String())
+		}
+		return strings.Join(res, ", ")
+	}
+
+	c.Assert(a.SetAttr("href", "y"), IsNil)
+	c.Assert(a.SetAttr("title", "t"), IsNil)
+	c.Assert(b.SetAttr("id", "b"), IsNil)
+	c.Assert(string(a.Node.XML()), Equals, `<a href="y" id="a" title="t" xmlns:l="urn:l">1</a>`)
+	c.Assert(string(b.Node.XML()), Equals, `<b id="b" xmlns:l="urn:l"></b>`)
+	c.Assert(a.SetAttrNS("urn:l", "role", "link"), IsNil)
+	c.Assert(b.SetAttrNS("urn:other", "x", "1"), IsNil)
+	c.Assert(b.SetAttrNS("http://www.w3.org/XML/1998/namespace", "lang", "en"), IsNil)
+	c.Assert(attrs(a), Equals, " href=y,  id=a,  title=t, urn:l role=link")
+	c.Assert(attrs(b), Equals, " id=b, urn:other x=1, xmlns ns0=urn:other, http://www.w3.org/XML/1998/namespace lang=en")
+	c.Assert(xmlpath.MustCompileNS("//*[@o:x = '1']/@id", map[string]string{"o": "urn:other"}).Count(doc.Node), Equals, 1)
+	c.Assert(xmlpath.MustCompile("//*[lang('en')]/@id").Count(doc.Node), Equals, 1)
+	c.Assert(b.SetAttrNS("urn:other", "x", "2"), IsNil)
+	c.Assert(attrs(b), Equals, " id=b, urn:other x=2, xmlns ns0=urn:other, http://www.w3.org/XML/1998/namespace lang=en")
+
+	// The ids are kept up to date
+	c.Assert(a.SetAttr("id", "c"), IsNil)
+	href, _ := xmlpath.MustCompile("id('c')/@href").String(doc.Node)
+	c.Assert(href, Equals, "y")
+	c.Assert(xmlpath.MustCompile("id('b')").Count(doc.Node), Equals, 1)
+
+	c.Assert(a.RemoveAttr("href"), IsNil)
+	c.Assert(a.RemoveAttr("missing"), IsNil)
+	c.Assert(a.RemoveAttrNS("urn:l", "role"), IsNil)
+	c.Assert(b.RemoveAttrNS("urn:other", "x"), IsNil)
+	c.Assert(b.RemoveAttr("id"), IsNil)
+	c.Assert(attrs(a), Equals, " id=c,  title=t")
+	c.Assert(attrs(b), Equals, "xmlns ns0=urn:other, http://www.w3.org/XML/1998/namespace lang=en")
+	c.Assert(xmlpath.MustCompile("id('b')").Count(doc.Node), Equals, 0)
+
+	text := xmlpath.MustCompile("//a/text()").Iter(doc.Node).Nodes()[0]
+	c.Assert(text.SetAttr("x", "y"), ErrorMatches, "xmlpath: cannot set the attributes of a node that is not an element")
+	c.Assert(doc.RemoveAttr("x"), ErrorMatches, "xmlpath: cannot remove the attributes of a node that is not an element")
+	c.Assert(a.SetAttr("", "y"), ErrorMatches, "xmlpath: cannot set an attribute without a name")
+}
+
+func (s *BasicSuite) TestSetText(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<r><a href="x">old <b>bold</b></a><!--c--><p>héllo</p></r>`))
+	c.Assert(err, IsNil)
+	doc := root.Ref
+	a := xmlpath.MustCompile("//a").Iter(root).Nodes()[0]
+	text := xmlpath.MustCompile("//p/text()").Iter(root).Nodes()[0]
+	comment := xmlpath.MustCompile("//comment()").Iter(root).Nodes()[0]
+	href := xmlpath.MustCompile("//a/@href").Iter(root).Nodes()[0]
+
+	c.Assert(a.SetText("new"), IsNil)
+	c.Assert(comment.SetText("d"), IsNil)
+	c.Assert(href.SetText("y"), IsNil)
+	c.Assert(text.SetText("hello world"), IsNil)
+	c.Assert(string(doc.Node.XML()), Equals, `<r><a href="y">new</a><!--d--><p>hello world</p></r>`)
+	c.Assert(xmlpath.MustCompile("//a[. = 'new']").Count(doc.Node), Equals, 1)
+	c.Assert(a.SetText(""), IsNil)
+	c.Assert(string(a.Node.XML()), Equals, `<a href="y"></a>`)
+
+	second, err := text.SplitText(5)
+	c.Assert(err, IsNil)
+	third, err := second.SplitText(1)
+	c.Assert(err, IsNil)
+	c.Assert(text.Node.String(), Equals, "hello")
+	c.Assert(second.Node.String(), Equals, " ")
+	c.Assert(third.Node.String(), Equals, "world")
+	c.Assert(xmlpath.MustCompile("//p/text()").Count(doc.Node), Equals, 3)
+	c.Assert(string(doc.Node.XML()), Equals, `<r><a href="y"></a><!--d--><p>hello world</p></r>`)
+	c.Assert(third.SetText("there"), IsNil)
+	_, err = third.SplitText(6)
+	c.Assert(err, ErrorMatches, "xmlpath: cannot split text of 5 bytes at offset 6")
+	_, err = a.SplitText(0)
+	c.Assert(err, ErrorMatches, "xmlpath: cannot split a node that is not text")
+	c.Assert(second.SetText(""), IsNil)
+
+	doc.Normalize()
+	c.Assert(xmlpath.MustCompile("//p/text()").Count(doc.Node), Equals, 1)
+	c.Assert(text.Node.String(), Equals, "hellothere")
+	c.Assert(string(doc.Node.XML()), Equals, `<r><a href="y"></a><!--d--><p>hellothere</p></r>`)
+
+	root, err = xmlpath.Parse(bytes.NewBufferString(`<p>é</p>`))
+	c.Assert(err, IsNil)
+	_, err = xmlpath.MustCompile("//p/text()").Iter(root).Nodes()[0].SplitText(1)
+	c.Assert(err, ErrorMatches, "xmlpath: cannot split text of 2 bytes at offset 1")
+	c.Assert(root.Ref.SetText("x"), ErrorMatches, "xmlpath: cannot set the text of the root node")
+}
+
+func (s *BasicSuite) TestWriteXML(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<?xml-stylesheet href="s.xsl"?><r xmlns="urn:r" xmlns:l="urn:l"><a l:role="x" xml:lang="en">1 &amp; &lt;2&gt;</a>` +
+		"\n  <!--c-->\n  <l:b><c xmlns=\"\">t<d/></c></l:b></r>"))
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	c.Assert(root.WriteXML(&buf), IsNil)
+	c.Assert(buf.String(), Equals, `<?xml-stylesheet href="s.xsl"?><r xmlns="urn:r" xmlns:l="urn:l"><a l:role="x" xml:lang="en">1 &amp; &lt;2&gt;</a>`+
+		"\n  <!--c-->\n  <l:b><c xmlns=\"\">t<d></d></c></l:b></r>")
+
+	// The namespaces declared by the ancestors are declared again
+	b := xmlpath.MustCompile("//*[local-name() = 'b']").Iter(root).Nodes()[0]
+	c.Assert(string(b.Node.XML()), Equals, `<l:b xmlns="urn:r" xmlns:l="urn:l"><c xmlns="">t<d></d></c></l:b>`)
+	a := xmlpath.MustCompile("//*[local-name() = 'a']").Iter(root).Nodes()[0]
+	c.Assert(string(a.Node.XML()), Equals, `<a l:role="x" xml:lang="en" xmlns="urn:r" xmlns:l="urn:l">1 &amp; &lt;2&gt;</a>`)
+
+	buf.Reset()
+	c.Assert(root.WriteXMLIndent(&buf, "", "  "), IsNil)
+	c.Assert(buf.String(), Equals, `<?xml-stylesheet href="s.xsl"?>
+<r xmlns="urn:r" xmlns:l="urn:l">
+  <a l:role="x" xml:lang="en">1 &amp; &lt;2&gt;</a>
+  <!--c-->
+  <l:b>
+    <c xmlns="">t<d></d></c>
+  </l:b>
+</r>`)
+	buf.Reset()
+	c.Assert(b.Node.WriteXMLIndent(&buf, "> ", "\t"), IsNil)
+	c.Assert(buf.String(), Equals, "> <l:b xmlns=\"urn:r\" xmlns:l=\"urn:l\">\n> \t<c xmlns=\"\">t<d></d></c>\n> </l:b>")
+
+	// Names in namespaces not declared, as with SetNameNS or a lenient
+	// parser, are declared where used
+	b.Node.SetNameNS("urn:b", "b")
+	c.Assert(string(b.Node.XML()), Equals, `<ns0:b xmlns="urn:r" xmlns:l="urn:l" xmlns:ns0="urn:b"><c xmlns="">t<d></d></c></ns0:b>`)
+	root, err = xmlpath.ParseHTML(bytes.NewBufferString(`<p><svg:rect xlink:href="#a"/></p>`))
+	c.Assert(err, IsNil)
+	c.Assert(string(root.XML()), Equals, `<p><svg:rect xlink:href="#a" xmlns:svg="svg" xmlns:xlink="xlink"></svg:rect></p>`)
+}
+
+func (s *BasicSuite) TestWriteHTML(c *C) {
+	root, err := xmlpath.ParseHTML(bytes.NewBufferString(`<html><head><meta charset="utf-8"><script>if (a > b && c) {}</script>` +
+		`<style>p > a { color: red }</style></head><body><p title='"1" & 2'>a&nbsp;&lt;b&gt; &amp; c<br>` +
+		`<input type="checkbox" checked="checked" disabled=""><img src="a.png" alt=""></p>` +
+		`<svg:rect xlink:href="#a"/><!-- c --></body></html>`))
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	c.Assert(root.WriteHTML(&buf), IsNil)
+	c.Assert(buf.String(), Equals, `<html><head><meta charset="utf-8"><script>if (a > b && c) {}</script>`+
+		`<style>p > a { color: red }</style></head><body><p title="&quot;1&quot; &amp; 2">a&nbsp;&lt;b&gt; &amp; c<br>`+
+		`<input type="checkbox" checked disabled><img src="a.png" alt=""></p>`+
+		`<svg:rect xlink:href="#a"></svg:rect><!-- c --></body></html>`)
+
+	p := xmlpath.MustCompile("//p").Iter(root).Nodes()[0]
+	c.Assert(p.SetAttr("hidden", ""), IsNil)
+	c.Assert(p.SetText("x"), IsNil)
+	c.Assert(string(p.Node.HTML()), Equals, `<p title="&quot;1&quot; &amp; 2" hidden>x</p>`)
+
+	root, err = xmlpath.Parse(bytes.NewBufferString(`<html xmlns="http://www.w3.org/1999/xhtml"><body><br/><svg xmlns="http://www.w3.org/2000/svg"><a xmlns:xl="http://www.w3.org/1999/xlink" xl:href="#a"/></svg></body></html>`))
+	c.Assert(err, IsNil)
+	c.Assert(string(root.HTML()), Equals, `<html xmlns="http://www.w3.org/1999/xhtml"><body><br><svg xmlns="http://www.w3.org/2000/svg"><a xmlns:xl="http://www.w3.org/1999/xlink" xlink:href="#a"></a></svg></body></html>`)
+}
+
+func (s *BasicSuite) TestKeepSource(c *C) {
+	src := "<?xml version='1.0'?>\n<!DOCTYPE r>\n<r xmlns:l = 'urn:l'>\n  <a  href='x'   id=\"a\"/>\n  <b>t &#x41;<![CDATA[<>]]></b><!--c--><c><d/></c>\n</r>\n"
+	root, err := xmlpath.Parse(bytes.NewBufferString(src), xmlpath.KeepSource)
+	c.Assert(err, IsNil)
+	doc := root.Ref
+	c.Assert(string(doc.Node.XML()), Equals, src)
+	b := xmlpath.MustCompile("//b").Iter(doc.Node).Nodes()[0]
+	c.Assert(string(b.Node.XML()), Equals, `<b xmlns:l="urn:l">t &#x41;<![CDATA[<>]]></b>`)
+
+	// Only the modified nodes are written again
+	a := xmlpath.MustCompile("//a").Iter(doc.Node).Nodes()[0]
+	c.Assert(a.SetAttr("href", "y"), IsNil)
+	cd := xmlpath.MustCompile("//c").Iter(doc.Node).Nodes()[0]
+	cd.Node.SetName("e")
+	c.Assert(string(doc.Node.XML()), Equals, strings.NewReplacer(
+		`<a  href='x'   id="a"/>`, `<a href="y" id="a"></a>`,
+		`<c><d/></c>`, `<e><d/></e>`).Replace(src))
+	c.Assert(xmlpath.MustCompile("//d").Iter(doc.Node).Nodes()[0].AppendChild(b.Node), IsNil)
+	c.Assert(b.SetText("u"), IsNil)
+	c.Assert(string(xmlpath.MustCompile("/r").Iter(doc.Node).Nodes()[0].Node.XML()), Equals,
+		"<r xmlns:l = 'urn:l'>\n  <a href=\"y\" id=\"a\"></a>\n  <b>u</b><!--c--><e><d><b>t &#x41;<![CDATA[<>]]></b></d></e>\n</r>")
+	c.Assert(string(xmlpath.MustCompile("//e").Iter(doc.Node).Nodes()[0].Node.HTML()), Equals,
+		"<e><d><b>t &#x41;<![CDATA[<>]]></b></d></e>")
+
+	// The source is not used when indenting, nor without KeepSource
+	var buf bytes.Buffer
+	c.Assert(a.Node.WriteXMLIndent(&buf, "", " "), IsNil)
+	c.Assert(buf.String(), Equals, `<a href="y" id="a" xmlns:l="urn:l"></a>`)
+	root, err = xmlpath.Parse(bytes.NewBufferString(src))
+	c.Assert(err, IsNil)
+	c.Assert(string(root.XML()), Equals, "<?xml version='1.0'?>\n\n<r xmlns:l=\"urn:l\">\n  <a href=\"x\" id=\"a\"></a>\n  <b>t A<![CDATA[<>]]></b><!--c--><c><d></d></c>\n</r>\n")
+
+	html := "<p class=foo>a&nbsp;b<br>c<img src='a.png'></p>"
+	root, err = xmlpath.ParseHTML(bytes.NewBufferString(html), xmlpath.KeepSource)
+	c.Assert(err, IsNil)
+	c.Assert(string(root.HTML()), Equals, html)
+	img := xmlpath.MustCompile("//img").Iter(root).Nodes()[0]
+	c.Assert(img.SetAttr("alt", ""), IsNil)
+	c.Assert(string(img.Node.Parent().HTML()), Equals, `<p class=foo>a&nbsp;b<br>c<img src="a.png" alt=""></p>`)
+}
+
+func (s *BasicSuite) TestPosition(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString("<r>\n  <a id=\"a\">t</a><!--c-->\n\t<b/>é<c/></r>"))
+	c.Assert(err, IsNil)
+	positions := func(root *xmlpath.Node, path string) string {
+		var res []string
+		iter := xmlpath.MustCompile(path).Iter(root)
+		for iter.Next() {
+			line, column := iter.Node().Position()
+			res = append(res, fmt.Sprintf("%d:%d", line, column))
+		}
+		return strings.Join(res, " ")
+	}
+	c.Assert(positions(root, "//node() | //@*"), Equals, "1:1 1:4 2:3 2:3 2:13 2:18 2:26 3:2 3:6 3:8")
+	line, column := root.Position()
+	c.Assert(line, Equals, 0)
+	c.Assert(column, Equals, 0)
+
+	// The tokens which the decoder reads ahead to close elements
+	root, err = xmlpath.ParseHTML(bytes.NewBufferString("<p>a<br>b<br><img src='a'>\n</p>"))
+	c.Assert(err, IsNil)
+	c.Assert(positions(root, "//node()"), Equals, "1:1 1:4 1:5 1:9 1:10 1:14 1:27")
+}
+
+func (s *BasicSuite) TestOffsets(c *C) {
+	src := "<r>\n  <a id=\"a\">t<b/></a><!--c--></r>"
+	root, err := xmlpath.Parse(bytes.NewBufferString(src))
+	c.Assert(err, IsNil)
+	var res []string
+	iter := xmlpath.MustCompile("//node() | //@*").Iter(root)
+	for iter.Next() {
+		start, end := iter.Node().Offsets()
+		res = append(res, src[start:end])
+	}
+	c.Assert(res, DeepEquals, []string{"<r>\n  <a id=\"a\">t<b/></a><!--c--></r>", "\n  ", `<a id="a">t<b/></a>`, `<a id="a">`, "t", "<b/>", "<!--c-->"})
+	start, end := root.Offsets()
+	c.Assert(start, Equals, 0)
+	c.Assert(end, Equals, 0)
+
+	html := "<p>a<br>b<img src='a'></p>"
+	root, err = xmlpath.ParseHTML(bytes.NewBufferString(html))
+	c.Assert(err, IsNil)
+	res = nil
+	iter = xmlpath.MustCompile("//node()").Iter(root)
+	for iter.Next() {
+		start, end := iter.Node().Offsets()
+		res = append(res, html[start:end])
+	}
+	c.Assert(res, DeepEquals, []string{html, "a", "<br>", "b", "<img src='a'>"})
+}
+
+func (s *BasicSuite) TestDecodeCharset(c *C) {
+	parse := func(data []byte, html bool) string {
+		var root *xmlpath.Node
+		var err error
+		if html {
+			root, err = xmlpath.ParseHTML(bytes.NewReader(data), xmlpath.DecodeCharset)
+		} else {
+			root, err = xmlpath.Parse(bytes.NewReader(data), xmlpath.DecodeCharset)
+		}
+		if err != nil {
+			return err.Error()
+		}
+		value, _ := xmlpath.MustCompile("//p").String(root)
+		return value
+	}
+	c.Assert(parse([]byte("<?xml version='1.0' encoding='ISO-8859-1'?><p>caf\xe9 \x80</p>"), false), Equals, "café €")
+	c.Assert(parse([]byte("<html><head><meta charset=\"windows-1252\"/></head><p>\x93q\x94</p></html>"), true), Equals, "“q”")
+	c.Assert(parse([]byte("<html><head><meta http-equiv='Content-Type' content='text/html; charset=latin1'/></head><p>\xe9</p></html>"), true), Equals, "é")
+	c.Assert(parse([]byte("\xef\xbb\xbf<p>\xc3\xa9</p>"), false), Equals, "é")
+	c.Assert(parse([]byte("\xff\xfe<\x00p\x00>\x00\xe9\x00=\xd8\x00\xde<\x00/\x00p\x00>\x00"), false), Equals, "é\U0001f600")
+	c.Assert(parse([]byte("\xfe\xff\x00<\x00p\x00>\x00\xe9\x00<\x00/\x00p\x00>"), false), Equals, "é")
+	c.Assert(parse([]byte("<?xml version='1.0' encoding='EBCDIC'?><p/>"), false), Equals, `xmlpath: unsupported charset "ebcdic"`)
+
+	// Long documents are read in chunks
+	long := bytes.Repeat([]byte("\xe9\x00"), 5000)
+	value := parse(append(append([]byte("\xff\xfe<\x00p\x00>\x00"), long...), "<\x00/\x00p\x00>\x00"...), false)
+	c.Assert(value, Equals, strings.Repeat("é", 5000))
+
+	c.Assert(xmlpath.DetectCharset([]byte("<meta charset=utf-8>"), "text/html; charset=Shift_JIS"), Equals, "shift_jis")
+	c.Assert(xmlpath.DetectCharset([]byte("<p>"), ""), Equals, "utf-8")
+	xmlpath.RegisterCharset("x-upper", func(r io.Reader) io.Reader {
+		data, _ := ioutil.ReadAll(r)
+		return bytes.NewReader(bytes.ToUpper(data))
+	})
+	r, err := xmlpath.NewReader(bytes.NewBufferString("<p>a</p>"), "text/xml; charset=X-Upper")
+	c.Assert(err, IsNil)
+	data, _ := ioutil.ReadAll(r)
+	c.Assert(string(data), Equals, "<P>A</P>")
+}
+
+func (s *BasicSuite) TestHTMLEntity(c *C) {
+	root, err := xmlpath.ParseHTML(bytes.NewBufferString(`<p title="a&nbsp;b">Wait&hellip; &mdash; &NotEqualTilde; &lbrace;&#x2014;&#8230;&amp;lt;</p>`))
+	c.Assert(err, IsNil)
+	c.Assert(xmlpath.MustCompile("//p[. = 'Wait… — ≂̸ {—…&lt;']").Exists(root), Equals, true)
+	c.Assert(xmlpath.MustCompile("//p[@title = 'a\u00a0b']").Exists(root), Equals, true)
+
+	// Entities of XHTML documents
+	d := xml.NewDecoder(bytes.NewBufferString(`<p>&copy;&nbsp;&Aopf;</p>`))
+	d.Entity = xmlpath.HTMLEntity
+	root, err = xmlpath.ParseDecoder(d)
+	c.Assert(err, IsNil)
+	value, _ := xmlpath.MustCompile("//p").String(root)
+	c.Assert(value, Equals, "©\u00a0\U0001d538")
+}
+
+func (s *BasicSuite) TestParseFragment(c *C) {
+	root, err := xmlpath.ParseFragment(bytes.NewBufferString(`text <l:a href="x"/><b>1</b>`), `<g xmlns:l="urn:l" class="c">`, xmlpath.KeepSource)
+	c.Assert(err, IsNil)
+	c.Assert(xmlpath.MustCompile("/node()").Count(root), Equals, 3)
+	c.Assert(xmlpath.MustCompileNS("/l:a/@href", map[string]string{"l": "urn:l"}).Count(root), Equals, 1)
+	c.Assert(xmlpath.MustCompile("/b").Count(root), Equals, 1)
+	c.Assert(string(root.XML()), Equals, `text <l:a href="x"/><b>1</b>`)
+	a := xmlpath.MustCompile("/*[1]").Iter(root).Nodes()[0]
+	line, column := a.Node.Position()
+	start, end := a.Node.Offsets()
+	c.Assert([]int{line, column, start, end}, DeepEquals, []int{1, 6, 5, 20})
+	c.Assert(a.SetAttr("href", "y"), IsNil)
+	c.Assert(string(root.XML()), Equals, `text <l:a href="y" xmlns:l="urn:l"></l:a><b>1</b>`)
+
+	root, err = xmlpath.ParseFragment(bytes.NewBufferString("<li>1</li>\n<li>2</li>"), "", xmlpath.KeepSource)
+	c.Assert(err, IsNil)
+	c.Assert(xmlpath.MustCompile("/li").Count(root), Equals, 2)
+	li := xmlpath.MustCompile("/li[2]").Iter(root).Nodes()[0]
+	line, column = li.Node.Position()
+	c.Assert([]int{line, column}, DeepEquals, []int{2, 1})
+
+	root, err = xmlpath.ParseHTMLFragment(bytes.NewBufferString(`<td>a&nbsp;b<td><br>c`), "tr")
+	c.Assert(err, IsNil)
+	c.Assert(string(root.HTML()), Equals, `<td>a&nbsp;b<td><br>c</td></td>`)
+
+	_, err = xmlpath.ParseFragment(bytes.NewBufferString(`<a>`), "")
+	c.Assert(err, NotNil)
+}
+
+func (s *BasicSuite) TestCDATA(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<code>if a <![CDATA[< b && c]]]]><![CDATA[>]]></code>`))
+	c.Assert(err, IsNil)
+	code, ok := xmlpath.MustCompile("/code").String(root)
+	c.Assert(ok, Equals, true)
+	c.Assert(code, Equals, "if a < b && c]]>")
+	c.Assert(xmlpath.MustCompile("/code[contains(., '&& c')]").Count(root), Equals, 1)
+	texts := xmlpath.MustCompile("/code/text()").Iter(root).Nodes()
+	c.Assert(len(texts), Equals, 3)
+	c.Assert([]bool{texts[0].Node.CDATA(), texts[1].Node.CDATA(), texts[2].Node.CDATA()}, DeepEquals, []bool{false, true, true})
+	c.Assert(string(root.XML()), Equals, `<code>if a <![CDATA[< b && c]]]]><![CDATA[>]]></code>`)
+	c.Assert(string(root.HTML()), Equals, `<code>if a &lt; b &amp;&amp; c]]&gt;</code>`)
+
+	c.Assert(texts[1].SetText("x]]>y"), IsNil)
+	c.Assert(string(root.XML()), Equals, `<code>if a <![CDATA[x]]]]><![CDATA[>y]]><![CDATA[>]]></code>`)
+	code, _ = xmlpath.MustCompile("/code").String(root)
+	c.Assert(code, Equals, "if a x]]>y>")
+
+	root, err = xmlpath.Parse(bytes.NewBufferString(`<code><![CDATA[a]]><![CDATA[b]]></code>`))
+	c.Assert(err, IsNil)
+	code0 := xmlpath.MustCompile("/code").Iter(root).Nodes()[0]
+	code0.Normalize()
+	c.Assert(string(code0.Node.XML()), Equals, `<code><![CDATA[ab]]></code>`)
+}
+
+func (s *BasicSuite) TestDoctype(c *C) {
+	root, err := xmlpath.ParseHTML(bytes.NewBufferString("<!DOCTYPE html>\n<html><p>a</p></html>"))
+	c.Assert(err, IsNil)
+	c.Assert(root.Doctype(), DeepEquals, &xmlpath.Doctype{Name: "html"})
+	c.Assert(root.Doctype().QuirksMode(), Equals, xmlpath.NoQuirks)
+	p, _ := xmlpath.MustCompile("//p").First(root)
+	c.Assert(p.Doctype(), Equals, root.Doctype())
+
+	root, err = xmlpath.Parse(bytes.NewBufferString(`<!DOCTYPE html PUBLIC '-//W3C//DTD XHTML 1.0 Transitional//EN' "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd" [<!ENTITY a "b">]><html/>`))
+	c.Assert(err, IsNil)
+	dt := root.Doctype()
+	c.Assert(dt, DeepEquals, &xmlpath.Doctype{"html", "-//W3C//DTD XHTML 1.0 Transitional//EN", "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd"})
+	c.Assert(dt.String(), Equals, `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">`)
+	c.Assert(dt.QuirksMode(), Equals, xmlpath.LimitedQuirks)
+
+	root, err = xmlpath.Parse(bytes.NewBufferString(`<html/>`))
+	c.Assert(err, IsNil)
+	c.Assert(root.Doctype(), IsNil)
+	c.Assert(root.Doctype().QuirksMode(), Equals, xmlpath.Quirks)
+
+	for doctype, mode := range map[string]xmlpath.Mode{
+		`html SYSTEM "about:legacy-compat"`:                                                           xmlpath.NoQuirks,
+		`HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd"`:             xmlpath.NoQuirks,
+		`html PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN"`:                                        xmlpath.Quirks,
+		`html PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd"`: xmlpath.LimitedQuirks,
+		`html PUBLIC "-//IETF//DTD HTML 2.0//EN"`:                                                     xmlpath.Quirks,
+		`svg`: xmlpath.Quirks,
+	} {
+		c.Assert(xmlpath.ParseDoctype(doctype).QuirksMode(), Equals, mode, Commentf("%s", doctype))
+	}
+}
+
+func (s *BasicSuite) TestInnerOuter(c *C) {
+	root, err := xmlpath.ParseHTML(bytes.NewBufferString(`<div xmlns:l="urn:l" id="d">a<br><l:b>&lt;c</l:b></div>`), xmlpath.KeepSource)
+	c.Assert(err, IsNil)
+	div, _ := xmlpath.MustCompile("/div").First(root)
+	c.Assert(div.OuterHTML(), Equals, `<div xmlns:l="urn:l" id="d">a<br><l:b>&lt;c</l:b></div>`)
+	c.Assert(div.InnerHTML(), Equals, `a<br><l:b>&lt;c</l:b>`)
+	c.Assert(div.InnerXML(), Equals, `a<br xmlns:l="urn:l"></br><l:b xmlns:l="urn:l">&lt;c</l:b>`)
+	c.Assert(div.OuterXML(), Equals, `<div xmlns:l="urn:l" id="d">a<br><l:b>&lt;c</l:b></div>`)
+	c.Assert(root.InnerHTML(), Equals, root.OuterHTML())
+
+	text, _ := xmlpath.MustCompile("/div/text()").First(root)
+	c.Assert(text.OuterXML(), Equals, "a")
+	c.Assert(text.InnerXML(), Equals, "")
+	id, _ := xmlpath.MustCompile("/div/@id").First(root)
+	c.Assert(id.InnerHTML(), Equals, "")
+}
+
+func (s *BasicSuite) TestClone(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<r xmlns:l="urn:l"><l:a id="a">x<b/></l:a><c/></r>`))
+	c.Assert(err, IsNil)
+	a, _ := xmlpath.MustCompile("/r/*[1]").First(root)
+	clone := a.Clone()
+	c.Assert(string(clone.XML()), Equals, `<l:a id="a" xmlns:l="urn:l">x<b></b></l:a>`)
+	c.Assert(xmlpath.MustCompileNS("/l:a/b", map[string]string{"l": "urn:l"}).Count(clone), Equals, 1)
+	c.Assert(xmlpath.MustCompile("id('a')").Count(clone), Equals, 1)
+
+	c.Assert(clone.Ref.SetAttr("id", "z"), IsNil)
+	text, _ := xmlpath.MustCompile("/*/text()").First(clone.Ref.Node)
+	c.Assert(text.Ref.SetText("y"), IsNil)
+	c.Assert(string(root.XML()), Equals, `<r xmlns:l="urn:l"><l:a id="a">x<b></b></l:a><c></c></r>`)
+	c.Assert(string(clone.Ref.Node.XML()), Equals, `<l:a id="z" xmlns:l="urn:l">y<b></b></l:a>`)
+
+	cnode, _ := xmlpath.MustCompile("/r/c").First(root)
+	c.Assert(cnode.Ref.AppendChild(clone.Ref.Node), IsNil)
+	root = root.Ref.Node
+	c.Assert(string(root.XML()), Equals, `<r xmlns:l="urn:l"><l:a id="a">x<b></b></l:a><c><l:a id="z">y<b></b></l:a></c></r>`)
+
+	c.Assert(string(root.Clone().XML()), Equals, string(root.XML()))
+}
+
+func (s *BasicSuite) TestNavigation(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<r><a id="x" xml:lang="en">t<b/><!--c--></a><d/></r>`))
+	c.Assert(err, IsNil)
+	r := root.FirstChild()
+	c.Assert(r.Name().Local, Equals, "r")
+	c.Assert(root.LastChild(), Equals, r)
+	c.Assert(r.Parent(), Equals, root)
+	c.Assert(root.Parent(), IsNil)
+	c.Assert(root.NextSibling(), IsNil)
+
+	a := r.FirstChild()
+	c.Assert(a.Name().Local, Equals, "a")
+	c.Assert(a.PreviousSibling(), IsNil)
+	d := a.NextSibling()
+	c.Assert(d.Name().Local, Equals, "d")
+	c.Assert(d.PreviousSibling(), Equals, a)
+	c.Assert(d.NextSibling(), IsNil)
+	c.Assert(r.LastChild(), Equals, d)
+	c.Assert(d.FirstChild(), IsNil)
+
+	children := a.Children()
+	c.Assert(len(children), Equals, 3)
+	c.Assert(children[0].String(), Equals, "t")
+	c.Assert(children[0].PreviousSibling(), IsNil)
+	c.Assert(children[0].NextSibling(), Equals, children[1])
+	c.Assert(children[1].NextSibling(), Equals, children[2])
+	c.Assert(children[2].PreviousSibling(), Equals, children[1])
+	c.Assert(children[2].NextSibling(), IsNil)
+	c.Assert(children[1].Parent(), Equals, a)
+
+	id, ok := a.Attr("id")
+	c.Assert([]interface{}{id, ok}, DeepEquals, []interface{}{"x", true})
+	_, ok = a.Attr("lang")
+	c.Assert(ok, Equals, false)
+	_, ok = children[0].Attr("id")
+	c.Assert(ok, Equals, false)
+	attr, _ := xmlpath.MustCompile("/r/a/@id").First(root)
+	c.Assert(attr.Parent(), Equals, a)
+	c.Assert(attr.NextSibling(), IsNil)
+	c.Assert(attr.PreviousSibling(), IsNil)
+}
+
+var cssHTML = `<html lang="en"><body>
+<nav id="top"><a href="http://x" class="external link">X</a><a href="/y" class="link">Y</a><a href="https://z" class="external" hreflang="en-US">Z</a></nav>
+<ul><li>1</li><li class="it's">2</li><li>3</li><li>4</li><li>5</li></ul>
+<p></p><div><p>a</p><span>b</span><p>c</p></div>
+<svg xmlns="http://www.w3.org/2000/svg"><circle r="1"/></svg>
+</body></html>`
+
+var cssTests = []struct {
+	selector string
+	result   []string
+}{
+	{"nav a.external[href^='http']", []string{"X", "Z"}},
+	{"#top > a:not(.external)", []string{"Y"}},
+	{"a[hreflang|=en]", []string{"Z"}},
+	{"a[class~=link]", []string{"X", "Y"}},
+	{"a[href$=z], a[href*='y']", []string{"Y", "Z"}},
+	{"A[HREF='HTTP://X' i]", []string{"X"}},
+	{"li:first-child, li:last-child", []string{"1", "5"}},
+	{"li:nth-child(2n+1)", []string{"1", "3", "5"}},
+	{"li:nth-child(even)", []string{"2", "4"}},
+	{"li:nth-child(-n+2)", []string{"1", "2"}},
+	{"li:nth-last-child(2)", []string{"4"}},
+	{`li.it\27 s`, []string{"2"}},
+	{"li + li + li", []string{"3", "4", "5"}},
+	{"div p:first-of-type", []string{"a"}},
+	{"div p:last-of-type, div span:only-of-type", []string{"b", "c"}},
+	{"div p ~ *", []string{"b", "c"}},
+	{"p:empty", []string{""}},
+	{"body > :is(ul, div) > :where(li:nth-child(3), span)", []string{"3", "b"}},
+	{":root > body > p:lang(en)", []string{""}},
+	{"svg|circle", []string{""}},
+}
+
+func (s *BasicSuite) TestCSS(c *C) {
+	root, err := xmlpath.ParseHTML(bytes.NewBufferString(cssHTML))
+	c.Assert(err, IsNil)
+	opts := xmlpath.Options{FoldCase: true, Namespaces: map[string]string{"svg": "http://www.w3.org/2000/svg"}}
+	for _, test := range cssTests {
+		path, err := css.CompileWithOptions(test.selector, opts)
+		c.Assert(err, IsNil, Commentf("%s", test.selector))
+		result := []string{}
+		for iter := path.Iter(root); iter.Next(); {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, Commentf("%s", test.selector))
+	}
+
+	_, err = css.Compile("li:nth-of-type(2)")
+	c.Assert(err, IsNil)
+	_, err = css.Compile("a::before")
+	c.Assert(err, ErrorMatches, `compiling css selector "a::before":2: pseudo-elements are not supported`)
+	for _, selector := range []string{"", "a,", "a >", ":nth-of-type(1)", "li:hover", "a[href", ":not(a b)", "a[x~]"} {
+		_, err = css.Compile(selector)
+		c.Assert(err, NotNil, Commentf("%s", selector))
+	}
+	path, err := css.Translate("ul > li.x")
+	c.Assert(err, IsNil)
+	c.Assert(path, Equals, "descendant::ul/child::li[contains(concat(' ', normalize-space(@class), ' '), ' x ')]")
+}
+
+func (s *BasicSuite) TestAttrs(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xl="http://www.w3.org/1999/xlink"><use xl:href="#a" xml:lang="fr" x="1"/></svg>`))
+	c.Assert(err, IsNil)
+	use, _ := xmlpath.MustCompile("//*[@x]").First(root)
+	href, ok := use.AttrNS("http://www.w3.org/1999/xlink", "href")
+	c.Assert([]interface{}{href, ok}, DeepEquals, []interface{}{"#a", true})
+	lang, ok := use.AttrNS("http://www.w3.org/XML/1998/namespace", "lang")
+	c.Assert([]interface{}{lang, ok}, DeepEquals, []interface{}{"fr", true})
+	lang, ok = use.AttrNS("xml", "lang")
+	c.Assert([]interface{}{lang, ok}, DeepEquals, []interface{}{"fr", true})
+	_, ok = use.AttrNS("", "href")
+	c.Assert(ok, Equals, false)
+	c.Assert(use.Attrs(), DeepEquals, []xml.Attr{
+		{Name: xml.Name{Space: "http://www.w3.org/1999/xlink", Local: "href"}, Value: "#a"},
+		{Name: xml.Name{Space: "http://www.w3.org/XML/1998/namespace", Local: "lang"}, Value: "fr"},
+		{Name: xml.Name{Local: "x"}, Value: "1"},
+	})
+	c.Assert(use.Parent().Attrs(), HasLen, 0)
+	c.Assert(root.Attrs(), HasLen, 0)
+}
+
+func (s *BasicSuite) TestLookupPrefix(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<schema xmlns="urn:d" xmlns:xs="urn:xs"><e type="xs:string" xmlns:t="urn:t"><f xmlns="" xmlns:u="urn:xs">t</f></e></schema>`))
+	c.Assert(err, IsNil)
+	e, _ := xmlpath.MustCompile("//*[@type]").First(root)
+	f := e.FirstChild()
+	text := f.FirstChild()
+
+	lookup := func(n *xmlpath.Node, prefix string) []interface{} {
+		space, ok := n.LookupPrefix(prefix)
+		return []interface{}{space, ok}
+	}
+	c.Assert(lookup(e, "xs"), DeepEquals, []interface{}{"urn:xs", true})
+	c.Assert(lookup(e, "t"), DeepEquals, []interface{}{"urn:t", true})
+	c.Assert(lookup(e, ""), DeepEquals, []interface{}{"urn:d", true})
+	c.Assert(lookup(e.Parent(), "t"), DeepEquals, []interface{}{"", false})
+	c.Assert(lookup(text, "t"), DeepEquals, []interface{}{"urn:t", true})
+	c.Assert(lookup(text, ""), DeepEquals, []interface{}{"", false})
+	c.Assert(lookup(root, "xml"), DeepEquals, []interface{}{"http://www.w3.org/XML/1998/namespace", true})
+	c.Assert(e.FindNamespaces()["t"], Equals, "urn:t")
+
+	namespace := func(n *xmlpath.Node, space string) []interface{} {
+		prefix, ok := n.LookupNamespace(space)
+		return []interface{}{prefix, ok}
+	}
+	c.Assert(namespace(e, "urn:xs"), DeepEquals, []interface{}{"xs", true})
+	c.Assert(namespace(text, "urn:xs"), DeepEquals, []interface{}{"u", true})
+	c.Assert(namespace(e, "urn:d"), DeepEquals, []interface{}{"", true})
+	c.Assert(namespace(f, "urn:d"), DeepEquals, []interface{}{"", false})
+	c.Assert(namespace(e, "urn:x"), DeepEquals, []interface{}{"", false})
+
+	typ, _ := e.Attr("type")
+	name, err := e.ResolveQName(typ)
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, xml.Name{Space: "urn:xs", Local: "string"})
+	name, err = e.ResolveQName("string")
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, xml.Name{Space: "urn:d", Local: "string"})
+	name, err = f.ResolveQName("string")
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, xml.Name{Local: "string"})
+	_, err = f.ResolveQName("v:string")
+	c.Assert(err, ErrorMatches, `xmlpath: undeclared prefix "v" in "v:string"`)
+	_, err = f.ResolveQName("u:")
+	c.Assert(err, NotNil)
+}
+
+func (s *BasicSuite) TestStripSpace(c *C) {
+	const doc = "<r>\n  <a> x </a>\n  <pre xml:space='preserve'> <b> </b><c xml:space='default'> </c></pre>\n  <d><![CDATA[ ]]></d>\n</r>"
+	count := func(opts ...xmlpath.ParseOption) []int {
+		root, err := xmlpath.Parse(bytes.NewBufferString(doc), opts...)
+		c.Assert(err, IsNil)
+		return []int{
+			xmlpath.MustCompile("//text()").Count(root),
+			xmlpath.MustCompile("/r/node()").Count(root),
+			xmlpath.MustCompile("//pre//text()").Count(root),
+		}
+	}
+	c.Assert(count(), DeepEquals, []int{9, 7, 3})
+	c.Assert(count(xmlpath.StripSpace), DeepEquals, []int{2, 3, 0})
+	c.Assert(count(xmlpath.StripDefaultSpace), DeepEquals, []int{4, 3, 2})
+
+	root, err := xmlpath.Parse(bytes.NewBufferString(doc), xmlpath.StripSpace)
+	c.Assert(err, IsNil)
+	c.Assert(xmlpath.MustCompile("/r/node()[2]/self::pre").Exists(root), Equals, true)
+	c.Assert(string(root.XML()), Equals, "<r><a> x </a><pre xml:space=\"preserve\"><b></b><c xml:space=\"default\"></c></pre><d><![CDATA[ ]]></d></r>")
+
+	root, err = xmlpath.Parse(bytes.NewBufferString(doc), xmlpath.StripSpace, xmlpath.KeepSource)
+	c.Assert(err, IsNil)
+	c.Assert(string(root.XML()), Equals, doc)
+}
+
+func (s *BasicSuite) TestStringValue(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString("<r><h1>\n  The <em>quick</em><!-- c --><?pi x?>\n  <![CDATA[brown]]>\tfox\n</h1><p>ab</p></r>"))
+	c.Assert(err, IsNil)
+	h1, _ := xmlpath.MustCompile("//h1").First(root)
+	c.Assert(h1.String(), Equals, "\n  The quick\n  brown\tfox\n")
+	c.Assert(h1.NormalizedText(), Equals, "The quick brown fox")
+	c.Assert(root.String(), Equals, "\n  The quick\n  brown\tfox\nab")
+	c.Assert(xmlpath.MustCompile("//em").Iter(root).Nodes()[0].Node.NormalizedText(), Equals, "quick")
+	c.Assert(xmlpath.MustCompile("//p[. = 'a']").Exists(root), Equals, false)
+	c.Assert(xmlpath.MustCompile("//p[. = 'ab']").Exists(root), Equals, true)
+	c.Assert(xmlpath.MustCompile("//p[. = 'abc']").Exists(root), Equals, false)
+}
+
+func (s *BasicSuite) TestWriteJSON(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString("<?pi x?><r xmlns:l=\"urn:l\" l:a=\"1\">t<![CDATA[<]]><!--c--><e/></r>"))
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	c.Assert(root.WriteJSON(&buf), IsNil)
+	c.Assert(buf.String(), Equals, `{"kind":"root","children":[`+
+		`{"kind":"processing-instruction","name":"pi","text":"x","line":1,"column":1},`+
+		`{"kind":"element","name":"r","line":1,"column":9,`+
+		`"attrs":[{"kind":"attribute","name":"l","space":"xmlns","value":"urn:l","line":1,"column":9},{"kind":"attribute","name":"a","space":"urn:l","value":"1","line":1,"column":9}],`+
+		`"children":[{"kind":"text","text":"t","line":1,"column":36},{"kind":"text","text":"\u003c","cdata":true,"line":1,"column":37},`+
+		`{"kind":"comment","text":"c","line":1,"column":50},{"kind":"element","name":"e","line":1,"column":58}]}]}`+"\n")
+
+	e, _ := xmlpath.MustCompile("//e").First(root)
+	data, err := json.Marshal(map[string]*xmlpath.Node{"e": e})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"e":{"kind":"element","name":"e","line":1,"column":58}}`)
+}
+
+func (s *BasicSuite) TestLimits(c *C) {
+	limits := &xmlpath.Limits{ForbidDTD: true}
+	for _, doc := range []string{
+		`<!DOCTYPE r [<!ENTITY a "b">]><r/>`,
+		`<!DOCTYPE r SYSTEM "file:///etc/passwd"><r/>`,
+		`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"><html/>`,
+		`<!ENTITY a "b"><r/>`,
+	} {
+		_, err := limits.Parse(bytes.NewBufferString(doc))
+		c.Assert(err, ErrorMatches, "xmlpath: line 1: document type definitions are forbidden", Commentf("%s", doc))
+		_, err = xmlpath.Parse(bytes.NewBufferString(doc))
+		c.Assert(err, IsNil)
+	}
+	for _, doc := range []string{`<!DOCTYPE html><html/>`, `<!doctype html SYSTEM "about:legacy-compat"><html/>`} {
+		_, err := limits.ParseHTML(bytes.NewBufferString(doc))
+		c.Assert(err, IsNil, Commentf("%s", doc))
+	}
+
+	const doc = "<r a=\"&amp;&lt;\">\n&amp;&#10;<![CDATA[&&]]></r>"
+	_, err := (&xmlpath.Limits{MaxEntities: 4}).Parse(bytes.NewBufferString(doc))
+	c.Assert(err, IsNil)
+	_, err = (&xmlpath.Limits{MaxEntities: 3}).Parse(bytes.NewBufferString(doc))
+	c.Assert(err, ErrorMatches, "xmlpath: line 1: more than 3 entity references")
+	_, err = (&xmlpath.Limits{MaxEntities: 1}).ParseFragment(bytes.NewBufferString("&amp;&amp;"), "")
+	c.Assert(err, NotNil)
+
+	decoder := func() *xml.Decoder {
+		d := xml.NewDecoder(bytes.NewBufferString("<r>&big;&big;</r>"))
+		d.Entity = map[string]string{"big": strings.Repeat("x", 1000)}
+		return d
+	}
+	root, err := xmlpath.ParseDecoder(decoder())
+	c.Assert(err, IsNil)
+	c.Assert(root.String(), HasLen, 2000)
+	_, err = (&xmlpath.Limits{MaxExpansion: 100}).ParseDecoder(decoder())
+	c.Assert(err, ErrorMatches, "xmlpath: line 1: text expanded more than 100 times")
+	_, err = (&xmlpath.Limits{MaxExpansion: 200}).ParseDecoder(decoder())
+	c.Assert(err, IsNil)
+
+	const nested = "<a x='1'><b>t<c/><!--d--></b><b><c/></b></a>"
+	_, err = (&xmlpath.Limits{MaxDepth: 3, MaxNodes: 8}).Parse(bytes.NewBufferString(nested))
+	c.Assert(err, IsNil)
+	_, err = (&xmlpath.Limits{MaxDepth: 2}).Parse(bytes.NewBufferString(nested))
+	c.Assert(err, ErrorMatches, "xmlpath: line 1: elements nested more than 2 deep")
+	_, err = (&xmlpath.Limits{MaxNodes: 7}).Parse(bytes.NewBufferString(nested))
+	c.Assert(err, ErrorMatches, "xmlpath: line 1: more than 7 nodes")
+	_, err = (&xmlpath.Limits{MaxDepth: 1}).ParseHTMLFragment(bytes.NewBufferString("<p><br>x</p>"), "")
+	c.Assert(err, NotNil)
+	_, err = (&xmlpath.Limits{MaxDepth: 2}).ParseHTMLFragment(bytes.NewBufferString("<p><br>x</p>"), "")
+	c.Assert(err, IsNil)
+}
+
+func (s *BasicSuite) TestStore(c *C) {
+	store := xmlpath.NewStore()
+	a, err := store.Parse("a.xml", bytes.NewBufferString(`<a>1</a>`))
+	c.Assert(err, IsNil)
+	again, err := store.Parse("a.xml", bytes.NewBufferString(`<a>1</a>`))
+	c.Assert(err, IsNil)
+	c.Assert(again == a, Equals, true)
+
+	changed, err := store.Parse("a.xml", bytes.NewBufferString(`<a>2</a>`))
+	c.Assert(err, IsNil)
+	c.Assert(changed == a, Equals, false)
+	c.Assert(changed.String(), Equals, "2")
+	other, err := store.Parse("b.xml", bytes.NewBufferString(`<a>2</a>`))
+	c.Assert(err, IsNil)
+	c.Assert(other == changed, Equals, false)
+	html, err := store.ParseHTML("a.xml", bytes.NewBufferString(`<a>2</a>`))
+	c.Assert(err, IsNil)
+	c.Assert(html == changed, Equals, false)
+	kept, err := store.ParseHTML("a.xml", bytes.NewBufferString(`<a>2</a>`), xmlpath.KeepSource)
+	c.Assert(err, IsNil)
+	c.Assert(kept == html, Equals, false)
+	c.Assert(store.Len(), Equals, 2)
+
+	_, err = store.Parse("b.xml", bytes.NewBufferString(`<a>`))
+	c.Assert(err, NotNil)
+	c.Assert(store.Len(), Equals, 1)
+	store.Forget("a.xml")
+	c.Assert(store.Len(), Equals, 0)
+}
+
+func (s *BasicSuite) TestCompare(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBufferString(`<a x="1"><b>t</b><c/></a>`))
+	c.Assert(err, IsNil)
+	a := root.FirstChild()
+	attr := xmlpath.MustCompile("/a/@x").Iter(root).Nodes()[0].Node
+	b, cc := a.FirstChild(), a.LastChild()
+	t := b.FirstChild()
+
+	c.Assert(a.Compare(a), Equals, xmlpath.Same)
+	c.Assert(root.Compare(t), Equals, xmlpath.Contains)
+	c.Assert(t.Compare(a), Equals, xmlpath.ContainedBy)
+	c.Assert(a.Compare(attr), Equals, xmlpath.Contains)
+	c.Assert(attr.Compare(b), Equals, xmlpath.Before)
+	c.Assert(cc.Compare(t), Equals, xmlpath.After)
+	c.Assert(b.Compare(cc), Equals, xmlpath.Before)
+	c.Assert(b.Compare(b.Copy()), Equals, xmlpath.Disconnected)
+	c.Assert(xmlpath.ContainedBy.String(), Equals, "contained by")
+
+	other, err := xmlpath.Parse(bytes.NewBufferString(`<z/>`))
+	c.Assert(err, IsNil)
+	sorted := xmlpath.SortNodes([]*xmlpath.Node{cc, other.FirstChild(), t, a, cc, attr, root.FirstChild()})
+	var names []string
+	for _, n := range sorted {
+		names = append(names, n.Name().Local+":"+n.String())
+	}
+	c.Assert(names, DeepEquals, []string{"a:t", "x:1", ":t", "c:", "z:"})
+
+	seen := map[*xmlpath.NodeRef]bool{cc.Ref: true}
+	ref := cc.Ref
+	c.Assert(b.Ref.Remove(), IsNil)
+	c.Assert(seen[xmlpath.MustCompile("/a/c").Iter(ref.Node).Nodes()[0]], Equals, true)
+	c.Assert(ref.Node.Compare(ref.Node.Parent().FirstChild()), Equals, xmlpath.Same)
+}
+
+var c14nXml = []byte(`<?xml version="1.0"?>
+<?pi data?>
+<!--before-->
+<doc b='2' a="1" xmlns:z="urn:z" xmlns="urn:d" xml:lang="en">
+  <e/><z:f z:x="&lt;&#9;>" ><![CDATA[a<b]]>&#13;</z:f>
+  <g xmlns="" xmlns:y="urn:y"><y:h>t<!--inside--></y:h></g>
+</doc>
+<!--after-->
+`)
+
+func (s *BasicSuite) TestC14N(c *C) {
+	root, err := xmlpath.Parse(bytes.NewBuffer(c14nXml))
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	c.Assert(root.WriteC14N(&buf), IsNil)
+	c.Assert(buf.String(), Equals, `<?pi data?>
+<doc xmlns="urn:d" xmlns:z="urn:z" a="1" b="2" xml:lang="en">
+  <e></e><z:f z:x="&lt;&#x9;>">a&lt;b&#xD;</z:f>
+  <g xmlns="" xmlns:y="urn:y"><y:h>t</y:h></g>
+</doc>`)
+	c.Assert(string((&xmlpath.C14N{WithComments: true}).Bytes(root)), Equals, `<?pi data?>
+<!--before-->
+<doc xmlns="urn:d" xmlns:z="urn:z" a="1" b="2" xml:lang="en">
+  <e></e><z:f z:x="&lt;&#x9;>">a&lt;b&#xD;</z:f>
+  <g xmlns="" xmlns:y="urn:y"><y:h>t<!--inside--></y:h></g>
+</doc>
+<!--after-->`)
+
+	h := xmlpath.MustCompile("//*[local-name()='h']").Iter(root).Nodes()[0].Node
+	f := xmlpath.MustCompile("//*[local-name()='f']").Iter(root).Nodes()[0].Node
+	c.Assert(string((&xmlpath.C14N{}).Bytes(h)), Equals, `<y:h xmlns:y="urn:y" xmlns:z="urn:z" xml:lang="en">t</y:h>`)
+	c.Assert(string((&xmlpath.C14N{Exclusive: true}).Bytes(h)), Equals, `<y:h xmlns:y="urn:y">t</y:h>`)
+	c.Assert(string((&xmlpath.C14N{Exclusive: true}).Bytes(f)), Equals, `<z:f xmlns:z="urn:z" z:x="&lt;&#x9;>">a&lt;b&#xD;</z:f>`)
+	c.Assert(string((&xmlpath.C14N{Exclusive: true, InclusivePrefixes: []string{"#default"}}).Bytes(f)), Equals, `<z:f xmlns="urn:d" xmlns:z="urn:z" z:x="&lt;&#x9;>">a&lt;b&#xD;</z:f>`)
+
+	g := h.Parent()
+	c.Assert(string((&xmlpath.C14N{Exclusive: true}).Bytes(g)), Equals, `<g><y:h xmlns:y="urn:y">t</y:h></g>`)
+	c.Assert(string((&xmlpath.C14N{Exclusive: true}).Bytes(g.Parent())), Equals, `<doc xmlns="urn:d" a="1" b="2" xml:lang="en">
+  <e></e><z:f xmlns:z="urn:z" z:x="&lt;&#x9;>">a&lt;b&#xD;</z:f>
+  <g xmlns=""><y:h xmlns:y="urn:y">t</y:h></g>
+</doc>`)
+
+	other, err := xmlpath.Parse(bytes.NewBufferString(`<doc xml:lang='en' a='1' xmlns:z='urn:z' xmlns='urn:d' b='2'>
+  <e></e><z:f z:x='&lt;&#x9;&gt;'>a&lt;b&#xD;</z:f>
+  <g xmlns:y='urn:y' xmlns=''><y:h>t</y:h></g>
+</doc>`))
+	c.Assert(err, IsNil)
+	c.Assert(string((&xmlpath.C14N{}).Bytes(other.FirstChild())), Equals, string((&xmlpath.C14N{}).Bytes(g.Parent())))
+}
+
+func (s *BasicSuite) TestCallbacks(c *C) {
+	var events []string
+	cb := &xmlpath.Callbacks{
+		StartElement: func(name xml.Name, attrs []xml.Attr, pos xmlpath.Position) error {
+			events = append(events, fmt.Sprintf("<%s %d> %d:%d %d-%d", name.Local, len(attrs), pos.Line, pos.Column, pos.Offset, pos.EndOffset))
+			return nil
+		},
+		EndElement: func(name xml.Name, pos xmlpath.Position) error {
+			events = append(events, fmt.Sprintf("</%s> %d:%d", name.Local, pos.Line, pos.Column))
+			return nil
+		},
+		Text: func(text []byte, cdata bool, pos xmlpath.Position) error {
+			events = append(events, fmt.Sprintf("%q %v %d:%d", text, cdata, pos.Line, pos.Column))
+			return nil
+		},
+	}
+	root, err := cb.Parse(bytes.NewBufferString("<a id='x'>\n<b/><!--c--><![CDATA[d]]></a>"), xmlpath.KeepSource)
+	c.Assert(err, IsNil)
+	c.Assert(root.String(), Equals, "\nd")
+	c.Assert(events, DeepEquals, []string{
+		"<a 1> 1:1 0-10",
+		`"\n" false 1:11`,
+		"<b 0> 2:1 11-15",
+		"</b> 2:5",
+		`"d" true 2:13`,
+		"</a> 2:26",
+	})
+
+	events = nil
+	_, err = cb.ParseHTMLFragment(bytes.NewBufferString("<p>x<br></p>"), "<div class='c'>")
+	c.Assert(err, IsNil)
+	c.Assert(events, DeepEquals, []string{
+		"<p 0> 1:1 0-3",
+		`"x" false 1:4`,
+		"<br 0> 1:5 4-8",
+		"</br> 1:9",
+		"</p> 1:9",
+	})
+
+	var ids []string
+	stop := fmt.Errorf("stop")
+	cb = &xmlpath.Callbacks{
+		StartElement: func(name xml.Name, attrs []xml.Attr, pos xmlpath.Position) error {
+			for _, attr := range attrs {
+				if attr.Name.Local == "id" {
+					ids = append(ids, attr.Value)
+				}
+			}
+			if name.Local == "stop" {
+				return stop
+			}
+			return nil
+		},
+		Limits: &xmlpath.Limits{MaxDepth: 3},
+	}
+	_, err = cb.Parse(bytes.NewBufferString(`<a id="1"><b id="2"/><stop id="3"/><c id="4"/></a>`))
+	c.Assert(err, Equals, stop)
+	c.Assert(ids, DeepEquals, []string{"1", "2", "3"})
+	_, err = cb.Parse(bytes.NewBufferString(`<a><b><c><d/></c></b></a>`))
+	c.Assert(err, ErrorMatches, ".*nested more than 3 deep")
+}
+
+func (s *BasicSuite) TestProgress(c *C) {
+	var reads, totals []int64
+	cb := &xmlpath.Callbacks{Progress: func(read, total int64) {
+		reads = append(reads, read)
+		totals = append(totals, total)
+	}}
+	data := "<a>" + strings.Repeat("<b>text</b>", 1000) + "</a>"
+	_, err := cb.Parse(bytes.NewReader([]byte(data)))
+	c.Assert(err, IsNil)
+	c.Assert(len(reads) > 1, Equals, true)
+	c.Assert(reads[len(reads)-1], Equals, int64(len(data)))
+	for i := range reads {
+		c.Assert(totals[i], Equals, int64(len(data)))
+		if i > 0 {
+			c.Assert(reads[i] > reads[i-1], Equals, true)
+		}
+	}
+
+	reads, totals = nil, nil
+	_, err = cb.ParseFragment(io.MultiReader(strings.NewReader("<b>"), strings.NewReader("text</b>")), "")
+	c.Assert(err, IsNil)
+	c.Assert(reads[len(reads)-1], Equals, int64(11))
+	c.Assert(totals[0], Equals, int64(-1))
+}
+
+var rawTextHtml = `<html><head><title>a < b &amp; c</title>
+<script type="text/javascript">if (a<b && c>d) x = "</p>";</script><STYLE>p > a { }</STYLE >
+</head><body><!-- <script> --><textarea name='t'><b>&lt;</b></textarea><p>x</p><script/><p>y</p></body></html>`
+
+func (s *BasicSuite) TestRawText(c *C) {
+	for _, r := range []io.Reader{strings.NewReader(rawTextHtml), iotest.OneByteReader(strings.NewReader(rawTextHtml))} {
+		root, err := xmlpath.ParseHTML(r, xmlpath.KeepSource)
+		c.Assert(err, IsNil)
+		for path, value := range map[string]string{
+			"/html/head/title":    "a < b & c",
+			"/html/head/script":   `if (a<b && c>d) x = "</p>";`,
+			"/html/head/STYLE":    "p > a { }",
+			"/html/body/textarea": "<b><</b>",
+		} {
+			result, ok := xmlpath.MustCompile(path).String(root)
+			c.Assert(ok, Equals, true)
+			c.Assert(result, Equals, value, Commentf("%s", path))
+		}
+		c.Assert(xmlpath.MustCompile("//p").Count(root), Equals, 2)
+		c.Assert(xmlpath.MustCompile("//b").Count(root), Equals, 0)
+		c.Assert(xmlpath.MustCompile("/html/body/script/node()").Exists(root), Equals, false)
+		c.Assert(string(root.HTML()), Equals, rawTextHtml)
+	}
+
+	root, err := xmlpath.ParseHTMLFragment(strings.NewReader("if (a<b) {}\r\n"), "script")
+	c.Assert(err, IsNil)
+	c.Assert(root.String(), Equals, "if (a<b) {}\n")
+	root, err = xmlpath.ParseHTML(strings.NewReader("<title>a\r\nb &lt;\rc&#13;</title><textarea>\r\n</textarea>"))
+	c.Assert(err, IsNil)
+	result, _ := xmlpath.MustCompile("//title").String(root)
+	c.Assert(result, Equals, "a\nb <\nc\r")
+	result, _ = xmlpath.MustCompile("//textarea").String(root)
+	c.Assert(result, Equals, "\n")
+	root, err = xmlpath.Parse(strings.NewReader("<script>a&amp;b</script>"))
+	c.Assert(err, IsNil)
+	c.Assert(root.String(), Equals, "a&b")
+}
+
+func (s *BasicSuite) TestNormalizeSpaceNBSP(c *C) {
+	root, err := xmlpath.ParseHTML(bytes.NewBufferString("<p> a&nbsp;\u3000b\r\n\tc </p>"))
+	c.Assert(err, IsNil)
+	value, err := xmlpath.MustCompile("normalize-space(//p)").Eval(root)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "a\u00a0\u3000b c")
+	p, _ := xmlpath.MustCompile("//p").First(root)
+	c.Assert(p.NormalizedText(), Equals, "a\u00a0\u3000b c")
+	c.Assert(xmlpath.MustCompile("//p[normalize-space() = 'a\u00a0\u3000b c']").Exists(root), Equals, true)
+}
+
+func (s *BasicSuite) TestMatchesCache(c *C) {
+	var buf bytes.Buffer
+	buf.WriteString("<r>")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&buf, "<a p='^%d$'>%d</a>", i, i%150)
+	}
+	buf.WriteString("</r>")
+	root, err := xmlpath.Parse(&buf)
+	c.Assert(err, IsNil)
+
+	// There are more patterns than the cached ones, which are compiled
+	// again once evicted, and the invalid ones are cached as well
+	path := xmlpath.MustCompile("r/a[matches(., @p)]")
+	for i := 0; i < 2; i++ {
+		c.Assert(path.Count(root), Equals, 150)
+	}
+	c.Assert(xmlpath.MustCompile("r/a[matches(., concat(@p, '('))]").Exists(root), Equals, false)
+}
+
+func (s *BasicSuite) TestCopy(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	book, ok := xmlpath.MustCompile("library/book[2]").First(node)
+	c.Assert(ok, Equals, true)
+	sub := book.Copy()
+
+	title, ok := xmlpath.MustCompile("/title").String(sub)
+	c.Assert(ok, Equals, true)
+	c.Assert(title, Equals, "Barney Google and Snuffy Smith")
+	character, ok := xmlpath.MustCompile("character[1]").First(sub)
+	c.Assert(ok, Equals, true)
+	c.Assert(xmlpath.MustCompile("//name").Count(character), Equals, 4)
+	c.Assert(xmlpath.MustCompile("/@id").Exists(character), Equals, true)
+	c.Assert(xmlpath.MustCompile("/..").Exists(character), Equals, false)
+	c.Assert(xmlpath.MustCompile("id('Snoopy')").Exists(sub), Equals, false)
+	c.Assert(xmlpath.MustCompile("id('Snuffy')").Exists(sub), Equals, true)
+
+	// The original document is left alone.
+	c.Assert(xmlpath.MustCompile("//name").Count(book), Equals, 9)
+
+	// The nodes of the copy are not the ones of book
+	c.Assert(sub.Compare(book), Equals, xmlpath.Disconnected)
+	c.Assert(sub.Ref == book.Ref, Equals, false)
+	c.Assert(sub.Ref.SetAttr("id", "x"), IsNil)
+	c.Assert(xmlpath.MustCompile("@id[. = 'b0883556316']").Exists(book), Equals, true)
+}
+
+func (s *BasicSuite) TestIterClone(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, path := range []string{"//character/@id", "//book/character[last()]/@id", "//book/@id | //character/@id", "//name/../@id"} {
+		var want []string
+		iter := xmlpath.MustCompile(path).Iter(node)
+		for iter.Next() {
+			want = append(want, iter.Node().String())
+		}
+		for skip := 0; skip <= len(want); skip++ {
+			iter := xmlpath.MustCompile(path).Iter(node)
+			for i := 0; i < skip; i++ {
+				iter.Next()
+			}
+			clone := iter.Clone()
+			var rest, cloneRest []string
+			for iter.Next() {
+				rest = append(rest, iter.Node().String())
+			}
+			for clone.Next() {
+				cloneRest = append(cloneRest, clone.Node().String())
+			}
+			cmt := Commentf("xml path: %s, skip: %d", path, skip)
+			c.Assert(rest, DeepEquals, append([]string(nil), want[skip:]...), cmt)
+			c.Assert(cloneRest, DeepEquals, rest, cmt)
+		}
+	}
+}
+
+func (s *BasicSuite) TestOptimize(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, test := range []struct {
+		path   string
+		preds  []string
+		result []string
+	}{
+		{"//character[true()]/@id", nil, []string{"PP", "Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+		{"//character[1 = 2]/@id", []string{"1 = 2"}, nil},
+		{"//character[1 + 1]/@id", []string{"1 + 1"}, []string{"Snoopy", "Spark"}},
+		{"//character[name = 'Lucy' or @id = 'PP']/@id", []string{"name = 'Lucy' or @id = 'PP'"}, []string{"PP", "Lucy"}},
+		{"//character[.//name][@id != 'PP']/@id", []string{"@id != 'PP'", ".//name"}, []string{"Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+		{"//character[position() = 1][@id != 'PP']/@id", []string{"position() = 1", "@id != 'PP'"}, []string{"Barney"}},
+		{"//character[@id != 'PP'][position() = 1]/@id", []string{"@id != 'PP'", "position() = 1"}, []string{"Snoopy", "Barney"}},
+		{"//character[name][2]/@id", []string{"name", "2"}, []string{"Snoopy", "Spark"}},
+	} {
+		path := xmlpath.MustCompile(test.path)
+		cmt := Commentf("xml path: %s", test.path)
+		steps := path.Steps()
+		c.Assert(steps[1].Predicates, DeepEquals, test.preds, cmt)
+		var result []string
+		iter := path.Iter(node)
+		for iter.Next() {
+			result = append(result, iter.Node().String())
+		}
+		c.Assert(result, DeepEquals, test.result, cmt)
+	}
+}
+
+func (s *BasicSuite) TestFirst(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	first, ok := xmlpath.MustCompile("//book/title").First(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(first.String(), Equals, "Being a Dog Is a Full-Time Job")
+	_, ok = xmlpath.MustCompile("//book/summary").First(node)
+	c.Assert(ok, Equals, false)
+
+	// Looking for the first match does not allocate according to the
+	// document size.
+	path := xmlpath.MustCompile("//book")
+	big, err := xmlpath.Parse(bytes.NewBufferString("<a><book/>" + strings.Repeat("<b/>", 10000) + "</a>"))
+	c.Assert(err, IsNil)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 10; i++ {
+		path.Exists(big)
+	}
+	runtime.ReadMemStats(&after)
+	c.Assert(after.TotalAlloc-before.TotalAlloc < 10*1024, Equals, true)
+}
+
+var streamTable = []string{
+	"library",
+	"/library/book/title",
+	"//title",
+	"//book//name",
+	"library/book/@id",
+	"//@*",
+	"//character/name/text()",
+	"//comment()",
+	"//book/descendant-or-self::*",
+	"//book/self::book/isbn",
+	".//born",
+	"//*:born",
+}
+
+func (s *BasicSuite) TestStream(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, test := range streamTable {
+		path := xmlpath.MustCompile(test)
+		var want []string
+		iter := path.Iter(node)
+		for iter.Next() {
+			want = append(want, iter.Node().String())
+		}
+		var got []string
+		err := path.Stream(xml.NewDecoder(bytes.NewBuffer(libraryXml)), func(node *xmlpath.Node) error {
+			got = append(got, node.String())
+			return nil
+		})
+		c.Assert(err, IsNil)
+		c.Assert(got, DeepEquals, want, Commentf("path: %s", test))
+	}
+
+	stop := fmt.Errorf("stop")
+	count := 0
+	path := xmlpath.MustCompile("//book/isbn")
+	err = path.Stream(xml.NewDecoder(bytes.NewBuffer(libraryXml)), func(node *xmlpath.Node) error {
+		count++
+		return stop
+	})
+	c.Assert(err, Equals, stop)
+	c.Assert(count, Equals, 1)
+
+	path = xmlpath.MustCompile("//book[1]/isbn")
+	err = path.Stream(xml.NewDecoder(bytes.NewBuffer(libraryXml)), nil)
+	c.Assert(err, ErrorMatches, `xmlpath: cannot stream "//book\[1\]/isbn": predicates are not supported`)
+	path = xmlpath.MustCompile("//isbn/..")
+	err = path.Stream(xml.NewDecoder(bytes.NewBuffer(libraryXml)), nil)
+	c.Assert(err, ErrorMatches, `xmlpath: cannot stream "//isbn/..": unsupported axis "parent"`)
+}
+
+func (s *BasicSuite) TestStreamNamespaces(c *C) {
+	data := `<r xmlns="urn:d" xmlns:q="urn:q"><a q:z="1" xmlns:p="urn:p"><b p:y="2"/></a><q:c xmlns:q="urn:q2" q:x="3"/></r>`
+	node, err := xmlpath.Parse(bytes.NewBufferString(data))
+	c.Assert(err, IsNil)
+	ns := map[string]string{"d": "urn:d", "q": "urn:q", "q2": "urn:q2"}
+	for _, test := range []string{"//d:a/@q:z", "//d:b", "//d:b/@*", "//q2:c", "//q2:c/@*", "//d:a"} {
+		path := xmlpath.MustCompileNS(test, ns)
+		var want []string
+		iter := path.Iter(node)
+		for iter.Next() {
+			want = append(want, string(iter.Node().XML()))
+		}
+		var got []string
+		err := path.Stream(xml.NewDecoder(bytes.NewBufferString(data)), func(node *xmlpath.Node) error {
+			got = append(got, string(node.XML()))
+			return nil
+		})
+		c.Assert(err, IsNil)
+		c.Assert(got, DeepEquals, want, Commentf("path: %s", test))
+	}
+
+	// The root node is not streamed
+	for _, test := range []string{"/", ".", "/descendant-or-self::node()", "/self::node()"} {
+		err := xmlpath.MustCompile(test).Stream(xml.NewDecoder(bytes.NewBufferString(data)), nil)
+		c.Assert(err, ErrorMatches, `xmlpath: cannot stream ".*": the root node is selected`)
+	}
+}
+
+func (s *BasicSuite) TestRegisterFunc(c *C) {
+	xmlpath.RegisterFunc("test-reverse", func(args ...xmlpath.Value) xmlpath.Value {
+		var res []rune
+		for _, arg := range args {
+			if nodes, ok := arg.([]*xmlpath.Node); ok && len(nodes) > 0 {
+				arg = nodes[0].String()
+			}
+			for _, r := range arg.(string) {
+				res = append([]rune{r}, res...)
+			}
+		}
+		return string(res)
+	})
+	c.Assert(func() { xmlpath.RegisterFunc("test-reverse", nil) }, PanicMatches, `xmlpath: function test-reverse\(\) is already registered`)
+	c.Assert(func() { xmlpath.RegisterFunc("concat", nil) }, PanicMatches, `xmlpath: function concat\(\) is already registered`)
+
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	path := xmlpath.MustCompile("library/book/character[test-reverse(@id) = 'ycuL']/name")
+	result, ok := path.String(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(result, Equals, "Lucy")
+	path = xmlpath.MustCompile("library/book/character[test-reverse(' ', name, @id) = 'ypoonSypoonS ']/born")
+	result, ok = path.String(node)
+	c.Assert(ok, Equals, true)
+	c.Assert(result, Equals, "1950-10-04")
+}
+
+func (s *BasicSuite) TestIterWithVars(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	isbn := xmlpath.MustCompile("library/book[isbn]/isbn")
+	path := xmlpath.MustCompile("library/book[character[@id = $id] and count(character) > $n]/character[@id = $id]/name")
+	tests := []struct {
+		vars map[string]xmlpath.Value
+		want []string
+	}{
+		{map[string]xmlpath.Value{"id": "Lucy", "n": 3}, []string{"Lucy"}},
+		{map[string]xmlpath.Value{"id": "Lucy", "n": 4.0}, nil},
+		{map[string]xmlpath.Value{"id": []byte("Spark"), "n": int64(0)}, []string{"Spark Plug"}},
+		{map[string]xmlpath.Value{"id": isbn.Iter(node).Nodes()[0].Node, "n": 0}, nil},
+		{map[string]xmlpath.Value{"n": 0}, nil},
+	}
+	for _, test := range tests {
+		var got []string
+		iter := path.IterWithVars(node, test.vars)
+		for iter.Next() {
+			got = append(got, iter.Node().String())
+		}
+		c.Assert(got, DeepEquals, test.want, Commentf("vars: %#v", test.vars))
+	}
+}
+
+func (s *BasicSuite) TestLibraryTable(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	for _, test := range libraryTable {
+		cmt := Commentf("xml path: %s", test.path)
+		path, err := xmlpath.Compile(test.path)
+		if want, ok := test.result.(cerror); ok {
+			c.Assert(err, ErrorMatches, string(want), cmt)
+			c.Assert(path, IsNil, cmt)
+			continue
+		}
+		c.Assert(err, IsNil)
+		switch want := test.result.(type) {
+		case string:
+			got, ok := path.String(node)
+			c.Assert(ok, Equals, true, cmt)
+			c.Assert(got, Equals, want, cmt)
+			c.Assert(path.Exists(node), Equals, true, cmt)
+			iter := path.Iter(node)
+			iter.Next()
+			node := iter.Node()
+			c.Assert(node.String(), Equals, want, cmt)
+			c.Assert(string(node.Bytes()), Equals, want, cmt)
+		case []string:
+			var alls []string
+			var allb []string
+			iter := path.Iter(node)
+			for iter.Next() {
+				alls = append(alls, iter.Node().String())
+				allb = append(allb, string(iter.Node().Bytes()))
+			}
+			c.Assert(alls, DeepEquals, want, cmt)
+			c.Assert(allb, DeepEquals, want, cmt)
+			s, sok := path.String(node)
+			b, bok := path.Bytes(node)
+			if len(want) == 0 {
+				c.Assert(sok, Equals, false, cmt)
+				c.Assert(bok, Equals, false, cmt)
+				c.Assert(s, Equals, "")
+				c.Assert(b, IsNil)
+			} else {
+				c.Assert(sok, Equals, true, cmt)
+				c.Assert(bok, Equals, true, cmt)
+				c.Assert(s, Equals, alls[0], cmt)
+				c.Assert(string(b), Equals, alls[0], cmt)
+				c.Assert(path.Exists(node), Equals, true, cmt)
+			}
+		case exists:
+			wantb := bool(want)
+			ok := path.Exists(node)
+			c.Assert(ok, Equals, wantb, cmt)
+			_, ok = path.String(node)
+			c.Assert(ok, Equals, wantb, cmt)
+		}
+	}
+}
+
+type unmarshalCharacter struct {
+	ID   string `xpath:"@id"`
+	Name string `xpath:"name"`
+}
+
+type unmarshalBook struct {
+	ISBN       int64                 `xpath:"isbn"`
+	Title      []byte                `xpath:"title"`
+	Available  bool                  `xpath:"@available"`
+	Missing    string                `xpath:"missing"`
+	Ignored    string                `xpath:"-"`
+	Author     *unmarshalCharacter   `xpath:"author"`
+	Characters []unmarshalCharacter  `xpath:"character"`
+	Names      []string              `xpath:"character/name"`
+	Quote      *xmlpath.Node         `xpath:"quote"`
+	Refs       []*unmarshalCharacter `xpath:"character[@id='Lucy']"`
+}
+
+func (s *BasicSuite) TestUnmarshal(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(libraryXml))
+	c.Assert(err, IsNil)
+	var book unmarshalBook
+	book.Ignored = "kept"
+	err = xmlpath.Unmarshal(xmlpath.MustCompile("/library/book").Iter(node).Nodes()[0].Node, &book)
+	c.Assert(err, IsNil)
+	c.Assert(book.ISBN, Equals, int64(836217462))
+	c.Assert(string(book.Title), Equals, "Being a Dog Is a Full-Time Job")
+	c.Assert(book.Available, Equals, true)
+	c.Assert(book.Missing, Equals, "")
+	c.Assert(book.Ignored, Equals, "kept")
+	c.Assert(book.Author, DeepEquals, &unmarshalCharacter{"CMS", "Charles M Schulz"})
+	c.Assert(book.Characters, HasLen, 4)
+	c.Assert(book.Characters[1], DeepEquals, unmarshalCharacter{"Snoopy", "Snoopy"})
+	c.Assert(book.Names, DeepEquals, []string{"Peppermint Patty", "Snoopy", "Schroeder", "Lucy"})
+	c.Assert(book.Quote.String(), Equals, "I'd dog paddle the deepest ocean.")
+	c.Assert(book.Refs, DeepEquals, []*unmarshalCharacter{{"Lucy", "Lucy"}})
+
+	var bad struct {
+		Count int `xpath:"library/book/title"`
+	}
+	err = xmlpath.Unmarshal(node, &bad)
+	c.Assert(err, ErrorMatches, `xmlpath: field Count: .*invalid syntax`)
+	c.Assert(xmlpath.Unmarshal(node, book), ErrorMatches, `xmlpath: Unmarshal expects .*`)
+}
+
+type cerror string
+type exists bool
+
+var libraryTable = []struct {
+	path   string
+	result interface{}
+}{
+	// These are the examples in the package documentation:
+	{"/library/book/isbn", "0836217462"},
+	{"library/*/isbn", "0836217462"},
+	{"/library/book/../book/./isbn", "0836217462"},
+	{"/library/book/character[2]/name", "Snoopy"},
+	{"/library/book/character[born='1950-10-04']/name", "Snoopy"},
+	{"/library/book//node()[@id='PP']/name", "Peppermint Patty"},
+	{"//book[author/@id='CMS']/title", "Being a Dog Is a Full-Time Job"},
+	{"/library/book/preceding::comment()", " Great book. "},
+
+	// A few simple
+	{"/library/book/isbn", exists(true)},
+	{"/library/isbn", exists(false)},
+	{"/library/book/isbn/bad", exists(false)},
+	{"/library/book/bad", exists(false)},
+	{"/library/bad/isbn", exists(false)},
+	{"/bad/book/isbn", exists(false)},
+
+	// Simple paths.
+	{"/library/book/isbn", "0836217462"},
+	{"/library/book/author/name", "Charles M Schulz"},
+	{"/library/book/author/born", "1922-11-26"},
+	{"/library/book/character/name", "Peppermint Patty"},
+	{"/library/book/character/qualification", "bold, brash and tomboyish"},
+
+	// Unrooted path with root node as context.
+	{"library/book/isbn", "0836217462"},
+
+	// Multiple entries from simple paths.
+	{"/library/book/isbn", []string{"0836217462", "0883556316"}},
+	{"/library/book/character/name", []string{"Peppermint Patty", "Snoopy", "Schroeder", "Lucy", "Barney Google", "Spark Plug", "Snuffy Smith"}},
+
+	// Handling of wildcards.
+	{"/library/book/author/*", []string{"Charles M Schulz", "1922-11-26", "2000-02-12", "Charles M Schulz", "1922-11-26", "2000-02-12"}},
+
+	// Unsupported axis and note test.
+	{"/foo()", cerror(`compiling xml path "/foo\(\)":5: unsupported expression: foo\(\)`)},
+	{"/foo::node()", cerror(`compiling xml path "/foo::node\(\)":6: unsupported axis: "foo"`)},
+	{"/library/x:book", cerror(`compiling xml path "/library/x:book":.*: undeclared prefix "x"`)},
+	{"/library/book/@x:lang", cerror(`.*: undeclared prefix "x"`)},
+	{"/library/x:*", cerror(`.*: undeclared prefix "x"`)},
+
+	// The attribute axis.
+	{"/library/book/title/attribute::lang", "en"},
+	{"/library/book/title/@lang", "en"},
+	{"/library/book/@available/parent::node()/@id", "b0836217462"},
+	{"/library/book/attribute::*", []string{"b0836217462", "true", "b0883556316", "true"}},
+	{"/library/book/attribute::text()", cerror(`.*: text\(\) cannot succeed on axis "attribute"`)},
+	{"/library/book/attribute::node()", []string{"b0836217462", "true", "b0883556316", "true"}},
+	{"/library/book/@*", []string{"b0836217462", "true", "b0883556316", "true"}},
+	{"/library/book/@node()", []string{"b0836217462", "true", "b0883556316", "true"}},
+	{"/library/book/@*[. = 'true']/../isbn", []string{"0836217462", "0883556316"}},
+	{"/library/book/@*[1]", []string{"b0836217462", "b0883556316"}},
+	{"/library/book/@*[last()]", []string{"true", "true"}},
+	{"/library/book/@*[name() = 'id']", []string{"b0836217462", "b0883556316"}},
+	{"/library/book/attribute::*[2]", []string{"true", "true"}},
+	{"/library/book/character[@*[starts-with(., 'S')]]/name", []string{"Snoopy", "Schroeder", "Spark Plug", "Snuffy Smith"}},
+	{"/library/book/*[count(@*) = 0]", []string{"0836217462", "I'd dog paddle the deepest ocean.", "0883556316"}},
+	{"//@*[contains(., 'Sn')]", []string{"Snoopy", "Snuffy"}},
+	{"/library/book/@node(", cerror(".*: missing \\)")},
+
+	// The self axis.
+	{"/library/book/isbn/./self::node()", "0836217462"},
+
+	// The descendant axis.
+	{"/library/book/isbn/descendant::isbn", exists(false)},
+	{"/library/descendant::isbn", []string{"0836217462", "0883556316"}},
+	{"/descendant::*/isbn", []string{"0836217462", "0883556316"}},
+	{"/descendant::isbn", []string{"0836217462", "0883556316"}},
+
+	// The descendant-or-self axis.
+	{"/library/book/isbn/descendant-or-self::isbn", "0836217462"},
+	{"/library//isbn", []string{"0836217462", "0883556316"}},
+	{"//isbn", []string{"0836217462", "0883556316"}},
+	{"/descendant-or-self::node()/child::book/child::*", "0836217462"},
+
+	// The parent axis.
+	{"/library/book/isbn/../isbn/parent::node()//title", "Being a Dog Is a Full-Time Job"},
+
+	// The ancestor axis.
+	{"/library/book/isbn/ancestor::book/title", "Being a Dog Is a Full-Time Job"},
+	{"/library/book/ancestor::book/title", exists(false)},
+
+	// The ancestor-or-self axis.
+	{"/library/book/isbn/ancestor-or-self::book/title", "Being a Dog Is a Full-Time Job"},
+	{"/library/book/ancestor-or-self::book/title", "Being a Dog Is a Full-Time Job"},
+
+	// The following axis.
+	// The first author name must not be included, as it's within the context
+	// node (author) rather than following it. These queries exercise de-duping
+	// of nodes, since the following axis runs to the end multiple times.
+	{"/library/book/author/following::name", []string{"Peppermint Patty", "Snoopy", "Schroeder", "Lucy", "Charles M Schulz", "Barney Google", "Spark Plug", "Snuffy Smith"}},
+	{"//following::book/author/name", []string{"Charles M Schulz", "Charles M Schulz"}},
+
+	// The following-sibling axis.
+	{"/library/book/quote/following-sibling::node()/name", []string{"Charles M Schulz", "Peppermint Patty", "Snoopy", "Schroeder", "Lucy"}},
+
+	// The preceding axis.
+	{"/library/book/author/born/preceding::name", []string{"Charles M Schulz", "Peppermint Patty", "Snoopy", "Schroeder", "Lucy", "Charles M Schulz"}},
+	{"/library/book/author/born/preceding::author/name", []string{"Charles M Schulz"}},
+	{"/library/book/author/born/preceding::library", exists(false)},
+
+	// The preceding-sibling axis.
+	{"/library/book/author/born/preceding-sibling::name", []string{"Charles M Schulz", "Charles M Schulz"}},
+	{"/library/book/author/born/preceding::author/name", []string{"Charles M Schulz"}},
+	{"/library/book/author/name/preceding-sibling::*", exists(false)},
+	{"/library/book/author/name/preceding-sibling::processing-instruction()[1]", []string{`"go rocks"`}},
+	{"/library/book[1]/character[@id='Lucy']/preceding-sibling::character/@id", []string{"PP", "Snoopy", "Schroeder"}},
+	{"/library/preceding-sibling::*", exists(false)},
+	{"/preceding-sibling::node()", exists(false)},
+
+	// Positions on reverse axes are counted from the context node.
+	{"/library/book/character[last()]/preceding-sibling::character[1]/@id", []string{"Schroeder", "Spark"}},
+	{"/library/book/character[last()]/preceding-sibling::character[2]/@id", []string{"Snoopy", "Barney"}},
+	{"/library/book/character[last()]/preceding-sibling::character[last()]/@id", []string{"PP", "Barney"}},
+	{"/library/book/character[last()]/preceding-sibling::*[1]", exists(true)},
+	{"/library/book/character[1]/preceding-sibling::*[1]/name", []string{"Charles M Schulz", "Charles M Schulz"}},
+	{"/library/book/character[@id='Snoopy']/preceding-sibling::character[1]/@id", []string{"PP"}},
+	{"/library/book/character/name/ancestor::*[1]/@id", []string{"PP", "Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+	{"/library/book/character[1]/name/ancestor::*[2]/@id", []string{"b0836217462", "b0883556316"}},
+	{"/library/book/character[1]/name/ancestor-or-self::*[last()]", exists(true)},
+	{"/library/book/character[1]/name/ancestor::*[last()]/book[1]/isbn", "0836217462"},
+	{"//character[@id='Lucy']/preceding::name[1]", []string{"Schroeder"}},
+	{"//character[@id='Spark']/preceding::character[2]/@id", []string{"Lucy"}},
+	{"//character[@id='Spark']/preceding::character[last()]/@id", []string{"PP"}},
+	{"library/book[author/name = preceding-sibling::book/author/name]/@id", []string{"b0883556316"}},
+	{"library/book/character[born = preceding-sibling::character/born]/@id", exists(false)},
+
+	// Comments.
+	{"/library/comment()", []string{" Great book. ", " Another great book. "}},
+	{"//self::comment()", []string{" Great book. ", " Another great book. "}},
+	{`comment("")`, cerror(`.*: comment\(\) has no arguments`)},
+
+	// Processing instructions.
+	{`/library/book/author/processing-instruction()`, `"go rocks"`},
+	{`/library/book/author/processing-instruction("echo")`, `"go rocks"`},
+	{`/library//processing-instruction("echo")`, `"go rocks"`},
+	{`/library/book/author/processing-instruction("foo")`, exists(false)},
+	{`/library/book/author/processing-instruction(")`, cerror(`.*: missing '"'`)},
+	{`/library/book/author[processing-instruction('echo')]/name`, []string{"Charles M Schulz"}},
+	{`/library/book/author[processing-instruction("echo") = '"go rocks"']/@id`, []string{"CMS"}},
+	{`/library/book/author[processing-instruction('echo') != '"go rocks"']/@id`, exists(false)},
+	{`/library/book/author[processing-instruction('foo')]/@id`, exists(false)},
+	{`/library/book[author/processing-instruction()]/isbn`, []string{"0836217462"}},
+	{`/library/book[contains(.//processing-instruction('echo'), 'go')]/isbn`, []string{"0836217462"}},
+	{`/library/book/author/node()[self::processing-instruction('echo')]`, []string{`"go rocks"`}},
+	{`/library/book/author/processing-instruction('echo')[. = '"go rocks"']/../@id`, []string{"CMS"}},
+	{`/library/book/author[processing-instruction('echo') and name]/@id`, []string{"CMS"}},
+	{`/library/book/author[processing-instruction('a:echo')]/@id`, exists(false)},
+	{`/library/book/author[processing-instruction('echo', 'x')]/@id`, cerror(`.*: missing \)`)},
+
+	// Predicates.
+	{"library/book[@id='b0883556316']/isbn", []string{"0883556316"}},
+	{"library/book[isbn='0836217462']/character[born='1950-10-04']/name", []string{"Snoopy"}},
+	{"library/book[quote]/@id", []string{"b0836217462"}},
+	{"library/book[./character/born='1922-07-17']/@id", []string{"b0883556316"}},
+	{"library/book[2]/isbn", []string{"0883556316"}},
+	{"library/book[0]/isbn", cerror(".*: positions start at 1")},
+	{"library/book[-1]/isbn", cerror(".*: positions must be positive")},
+	{"library/book[.//quote]/@id", []string{"b0836217462"}},
+	{"library/book[.//born = '1922-07-17']/isbn", []string{"0883556316"}},
+	{"//book[.//@id = 'Spark']/isbn", []string{"0883556316"}},
+	{"//book[not(.//quote)]/isbn", []string{"0883556316"}},
+	{"//*[.//character[@id='Lucy']]", exists(true)},
+	{"//character[.//name][3]/@id", []string{"Schroeder", "Snuffy"}},
+	{"library/book[author//name = character//name]/@id", exists(false)},
+	{"library/book[character//born = '1950-10-04']/@id", []string{"b0836217462"}},
+	{"library/book[count(.//name) = 4]/@id", []string{"b0883556316"}},
+	{"library/book[.//]", cerror(".*: missing name")},
+	{"library/book[isbn = /library/book[2]/isbn]/@id", []string{"b0883556316"}},
+	{"library/book/character[born = //author/born]/@id", exists(false)},
+	{"library/book[@id = /library/book/@id]/isbn", []string{"0836217462", "0883556316"}},
+	{"//character[@id = /library/book[1]/character[last()]/@id]/name", []string{"Lucy"}},
+	{"//character[count(/library/book) = 2][1]/@id", []string{"PP", "Barney"}},
+	{"//character[/library/book/quote]/@id", exists(true)},
+	{"//character[/library/nothing]/@id", exists(false)},
+	{"//character[/ = /][1]/@id", []string{"PP", "Barney"}},
+	{"//character[//book[2]/character[1]/@id = 'PP']/@id", exists(false)},
+	{"library/book[/library/book[1] = .]/isbn", []string{"0836217462"}},
+	{"library/book[/]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[/ | isbn][last()]/isbn", []string{"0883556316"}},
+	{"library/book[count(/) = 1][/ * 0 = 0]/isbn", exists(false)},
+
+	// Positional functions.
+	{"library/book[last()]/isbn", []string{"0883556316"}},
+	{"library/book/character[last()]/name", []string{"Lucy", "Snuffy Smith"}},
+	{"library/book/character[position() = last()]/name", []string{"Lucy", "Snuffy Smith"}},
+	{"library/book/character[position() > 1]/name", []string{"Snoopy", "Schroeder", "Lucy", "Spark Plug", "Snuffy Smith"}},
+	{"library/book/character[position()<=2]/name", []string{"Peppermint Patty", "Snoopy", "Barney Google", "Spark Plug"}},
+	{"library/book/character[position() >= last()]/name", []string{"Lucy", "Snuffy Smith"}},
+	{"library/book/character[position() < 2]/name", []string{"Peppermint Patty", "Barney Google"}},
+	{"library/book/character[@id='Lucy'][1]/name", []string{"Lucy"}},
+	{"library/book/character[starts-with(@id, 'S')][2]/name", []string{"Schroeder", "Snuffy Smith"}},
+	{"library/book/character[2][starts-with(@id, 'S')]/name", []string{"Snoopy", "Spark Plug"}},
+	{"library/book/character[position() > 1][1]/name", []string{"Snoopy", "Spark Plug"}},
+	{"library/book/character[position() > 1][last()]/name", []string{"Lucy", "Snuffy Smith"}},
+	{"library/book/character[last()][1]/name", []string{"Lucy", "Snuffy Smith"}},
+	{"library/book/character[position() < last()][last()]/name", []string{"Schroeder", "Spark Plug"}},
+	{"library/book/character[contains(name, 'S')][contains(name, 'o')][1]/@id", []string{"Snoopy"}},
+	{"library/book/character[3][1][1]/@id", []string{"Schroeder", "Snuffy"}},
+	{"library/book/character[1][2]/@id", exists(false)},
+	{"library/book/character[1][0]", cerror(".*: positions start at 1")},
+	{"(//character)[position() > 2][2]/@id", []string{"Lucy"}},
+	{"(//character)[@id != 'PP'][last()][1]/@id", []string{"Snuffy"}},
+	{"library/book[character[last()]/@id='Lucy']/isbn", []string{"0836217462"}},
+	{"library/book/isbn/ancestor::*[1]/@id", []string{"b0836217462", "b0883556316"}},
+	{"/library/book/author/born/preceding::name[1]", []string{"Charles M Schulz", "Charles M Schulz"}},
+	{"/library/book/author/born/preceding::name[2]", []string{"Lucy"}},
+	{"/library/book/author/born/preceding::name[last()]", []string{"Charles M Schulz"}},
+	{"library/book[last(1)]", cerror(".*: last\\(\\) has no arguments")},
+	{"library/book[position() > foo]", exists(false)},
+	{"library/book[position() > ]", cerror(".*: expected an expression")},
+	{"library/book[@id = $]", cerror(".*: expected a variable name")},
+	{"library/book[@id = $*]", cerror(".*: expected a variable name")},
+	{"library/book[$unbound]/isbn", exists(false)},
+	{"library/book[string($unbound) = '']/isbn", []string{"0836217462", "0883556316"}},
+
+	// Boolean functions.
+	{"library/book/*[not(@id)]/@lang", []string{"en", "en"}},
+	{"library/book/character[not(@id='Snoopy') and not(position() > 2)]/name", []string{"Peppermint Patty", "Barney Google", "Spark Plug"}},
+	{"library/book[not(quote)]/isbn", []string{"0883556316"}},
+	{"library/book[not(not(quote))]/isbn", []string{"0836217462"}},
+	{"library/book/character[not(position() = last())]/@id", []string{"PP", "Snoopy", "Schroeder", "Barney", "Spark"}},
+	{"library/book[true()]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[false()]/isbn", exists(false)},
+	{"library/book[not(false()) and true()]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[quote = true()]/isbn", []string{"0836217462"}},
+	{"library/book[quote = false()]/isbn", []string{"0883556316"}},
+	{"library/book[true() = 'x' and false() = '']/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[true() = 2 and false() = 0]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[string(true()) = 'true']/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[true(1)]", cerror(".*: true\\(\\) has no arguments")},
+	{"library/book[not()]", cerror(".*: wrong number of arguments for not\\(\\)")},
+	{"library/book[not(quote, isbn)]", cerror(".*: wrong number of arguments for not\\(\\)")},
+	{"library/book[not(quote]", cerror(".*: missing \\)")},
+
+	// Literals.
+	{`library/book[quote = 'I''d dog paddle the deepest ocean.']/@id`, []string{"b0836217462"}},
+	{`library/book[contains(quote, "I'd")]/@id`, []string{"b0836217462"}},
+	{`library/book[contains(quote, 'I''d dog')]/@id`, []string{"b0836217462"}},
+	{`library/book[starts-with(quote, '''')]/@id`, exists(false)},
+	{`library/book[concat('''', "x") = "'x"]/@id`, []string{"b0836217462", "b0883556316"}},
+	{`library/book[concat("say ""hi""", '') = 'say "hi"']/@id`, []string{"b0836217462", "b0883556316"}},
+	{`library/book[string-length('''''') = 2]/@id`, []string{"b0836217462", "b0883556316"}},
+	{`library/book[string-length("""") = 1 and string-length('') = 0]/@id`, []string{"b0836217462", "b0883556316"}},
+	{`library/book[quote = 'I'd']`, cerror(`.*: expected ']'`)},
+	{`library/book[quote = 'I''d]`, cerror(`.*: missing "'"`)},
+	{`library/book[quote = "say ""hi]`, cerror(`.*: missing '"'`)},
+
+	// String functions.
+	{"//book[contains(title, 'Dog')]/isbn", []string{"0836217462"}},
+	{"library/book/character[contains(name, 'S')]/@id", []string{"Snoopy", "Schroeder", "Spark", "Snuffy"}},
+	{"library/book/character[contains(@id, qualification)]/@id", exists(false)},
+	{"library/book/character[contains('Peppermint Patty Lucy', name)]/@id", []string{"PP", "Lucy"}},
+	{"library/book[contains(@missing, '')]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[contains(title, 'dog')]/isbn", exists(false)},
+	{"library/book[contains(title)]/isbn", cerror(".*: wrong number of arguments for contains\\(\\)")},
+	{"library/book[contains(title, 'Dog)]/isbn", cerror(".*: missing \"'\"")},
+	{"library/book/character[starts-with(name, 'S')]/@id", []string{"Snoopy", "Schroeder", "Spark", "Snuffy"}},
+	{"library/book/character[starts-with(@id, 'Sn')]/name", []string{"Snoopy", "Snuffy Smith"}},
+	{"library/book/character[starts-with(name, 'Smith')]/@id", exists(false)},
+	{"library/book/character[ends-with(name, 'Smith')]/@id", []string{"Snuffy"}},
+	{"library/book/character[ends-with(born, '-01')]/@id", []string{"Barney", "Snuffy"}},
+	{"library/book[starts-with(@id, 'b')][ends-with(@id, '6')]/isbn", []string{"0883556316"}},
+	{"library/book[starts-with(@id, 'b') and ends-with(@id, '6')]/isbn", []string{"0883556316"}},
+	{"library/book[ends-with(@id)]/isbn", cerror(".*: wrong number of arguments for ends-with\\(\\)")},
+	{"library/book/author[normalize-space()='Charles M Schulz 1922-11-26 2000-02-12']/@id", []string{"CMS", "CMS"}},
+	{"library/book/character[normalize-space(name)='Lucy']/@id", []string{"Lucy"}},
+	{"library/book/character[normalize-space('  Snuffy \t Smith ')=normalize-space(name)]/@id", []string{"Snuffy"}},
+	{"library/book/character[normalize-space(@missing)]/@id", exists(false)},
+	{"library/book/character[starts-with(normalize-space(qualification), 'bossy, crabby')]/@id", []string{"Lucy"}},
+	{"library/book/character[string-length(name) > 12]/@id", []string{"PP", "Barney"}},
+	{"library/book/character[string-length(@id) = 4]/name", []string{"Lucy"}},
+	{"library/book/character/name[string-length() < 6]", []string{"Lucy"}},
+	{"library/book[string-length(@missing) > 0]/isbn", exists(false)},
+	{"library/book[string-length('éèà') = 3]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[3 <= string-length(isbn)]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book/character[substring(name, 2, 3)='epp']/@id", []string{"PP"}},
+	{"library/book/character[substring(name, 12)='Patty']/@id", []string{"PP"}},
+	{"library/book/character[substring(name, 1.5, 2.6)='epp']/@id", []string{"PP"}},
+	{"library/book/character[substring(name, 0, 3)='Pe']/@id", []string{"PP"}},
+	{"library/book/character[substring(name, .5, 2.)='Pe']/@id", []string{"PP"}},
+	{"library/book/character[substring(born, 1, 4) = '1922']/name", []string{"Spark Plug"}},
+	{"library/book/character[substring-before(born, '-') = '1934']/name", []string{"Snuffy Smith"}},
+	{"library/book/character[substring-after(name, ' ') = 'Smith']/@id", []string{"Snuffy"}},
+	{"library/book/character[substring-after(born, '-08-') = '22']/@id", []string{"PP"}},
+	{"library/book/character[substring-before(name, ' ')]/@id", []string{"PP", "Barney", "Spark", "Snuffy"}},
+	{"library/book/character[substring-after(name, 'x')]/@id", exists(false)},
+	{"library/book[substring(isbn)]", cerror(".*: wrong number of arguments for substring\\(\\)")},
+	{"library/book/character[translate(@id, 'PSNOY', 'psnoy')='snoopy']/name", []string{"Snoopy"}},
+	{"library/book/character[translate(born, '-', '')='19520303']/@id", []string{"Lucy"}},
+	{"library/book/character[translate(name, 'aeiou ', '')='SnffySmth']/@id", []string{"Snuffy"}},
+	{"library/book/character[translate(name, 'ooL', 'aeX')='Xucy']/@id", []string{"Lucy"}},
+	{"library/book/character[translate(name, 'o', 'ae')='Snaapy']/@id", []string{"Snoopy"}},
+	{"library/book[translate(isbn, '0')]", cerror(".*: wrong number of arguments for translate\\(\\)")},
+	{"library/book/character[concat(@id, '/', born)='Lucy/1952-03-03']/name", []string{"Lucy"}},
+	{"library/book/character[name=concat(@id, ' Smith')]/born", []string{"1934-01-01"}},
+	{"library/book/character[name = concat('Sn', 'oo', 'py')]/@id", []string{"Snoopy"}},
+	{"library/book/character[contains(concat(name, born), 'y1950')]/@id", []string{"Snoopy"}},
+	{"library/book[concat(@missing, '')]/isbn", exists(false)},
+	{"library/book[concat(isbn)]", cerror(".*: wrong number of arguments for concat\\(\\)")},
+	{"library/book[count(character) > 3]/isbn", []string{"0836217462"}},
+	{"library/book[count(character) = 3]/isbn", []string{"0883556316"}},
+	{"library/book[count(quote) = 0]/isbn", []string{"0883556316"}},
+	{"library/book[count(character[contains(name, 'S')]) = 2]/isbn", []string{"0836217462", "0883556316"}},
+	{"library[count(book/author) = 2]/book[1]/isbn", []string{"0836217462"}},
+	{"library/book[count('foo') = count('foo')]/isbn", exists(false)},
+	{"library/book[count(character, quote)]", cerror(".*: wrong number of arguments for count\\(\\)")},
+	{"library/book/character[floor(substring(born, 1, 4)) = 1950]/@id", []string{"Snoopy"}},
+	{"library/book/character[floor(substring(born, 6, 2)) = 8]/@id", []string{"PP"}},
+	{"library/book/character[ceiling(1.2) = 2]/@id", []string{"PP", "Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+	{"library/book/character[round(2.5) = 3 and round(2.4) = 2]/@id", []string{"PP", "Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+	{"library/book/character[round(@id) = round(@id)]/@id", exists(false)},
+	{"library/book/character[floor(position()) = 2]/@id", []string{"Snoopy", "Spark"}},
+	{"library/book[sum(character/born) > 1]/isbn", exists(false)},
+	{"library/book[sum(isbn) = 836217462]/@id", []string{"b0836217462"}},
+	{"library[sum(book/isbn) = 1719773778]/book[1]/@id", []string{"b0836217462"}},
+	{"library/book[sum(quote) = 0]/isbn", []string{"0883556316"}},
+	{"library/book[round(isbn, title)]", cerror(".*: wrong number of arguments for round\\(\\)")},
+	{"library/book[number(isbn) = 883556316]/@id", []string{"b0883556316"}},
+	{"library/book/isbn[number() > 883556315]", []string{"0883556316"}},
+	{"library/book[number(title) = number(title)]/isbn", exists(false)},
+	{"library/book[number(@missing) = number(@missing)]/isbn", exists(false)},
+	{"library/book[number(' 12.50 ') = 12.5]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[number('1e3') = number('1e3')]/isbn", exists(false)},
+	{"library/book/character[string(@id) = 'PP']/name", []string{"Peppermint Patty"}},
+	{"library/book/character/@id[string() = 'Lucy']", []string{"Lucy"}},
+	{"library/book[string(12.50) = '12.5' and string(number('x')) = 'NaN']/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[string(character/name) = 'Barney Google']/@id", []string{"b0883556316"}},
+	{"library/book[string(count(character)) = '4']/@id", []string{"b0836217462"}},
+	{"library/book[boolean(quote)]/isbn", []string{"0836217462"}},
+	{"library/book[boolean('')]/isbn", exists(false)},
+	{"library/book[boolean(count(quote))]/isbn", []string{"0836217462"}},
+	{"library/book[boolean(number('x'))]/isbn", exists(false)},
+	{"library/book[boolean()]", cerror(".*: wrong number of arguments for boolean\\(\\)")},
+	{"library/book[count(id('PP Lucy missing')) = 2]/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book/character[id(name)]/@id", []string{"Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+	{"library/book/character[id(name)/born = born]/@id", []string{"Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+	{"library/book/character[starts-with(normalize-space(id(' Lucy\tPP ')), 'Peppermint')]/@id", []string{"PP", "Snoopy", "Schroeder", "Lucy", "Barney", "Spark", "Snuffy"}},
+	{"library/book/author[id(@id)]/name", []string{"Charles M Schulz", "Charles M Schulz"}},
+	{"library/book[author = id('CMS')]/isbn", []string{"0836217462"}},
+	{"library/book[id('missing')]/isbn", exists(false)},
+	{"library/book[id()]", cerror(".*: wrong number of arguments for id\\(\\)")},
+	{"library/book/title[lang('en')]", exists(false)},
+	{"library/book[lang()]", cerror(".*: wrong number of arguments for lang\\(\\)")},
+	{"library/book/*[name()='quote']", []string{"I'd dog paddle the deepest ocean."}},
+	{"library/book/*[local-name()='quote']", []string{"I'd dog paddle the deepest ocean."}},
+	{"library/book/*[namespace-uri()='']/@id", []string{"CMS", "PP", "Snoopy", "Schroeder", "Lucy", "CMS", "Barney", "Spark", "Snuffy"}},
+	{"library/book/character[name(@*)='id']/name", []string{"Peppermint Patty", "Snoopy", "Schroeder", "Lucy", "Barney Google", "Spark Plug", "Snuffy Smith"}},
+	{"library/book[name(*)='isbn' and name(*[last()]) = 'character']/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book/author/processing-instruction()[name()='echo']", []string{`"go rocks"`}},
+	{"library/book/title[name(text())='']", []string{"Being a Dog Is a Full-Time Job", "Barney Google and Snuffy Smith"}},
+	{"library/book[local-name(missing)='']/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[name(isbn, title)]", cerror(".*: wrong number of arguments for name\\(\\)")},
+	{"library/book/character[matches(born, '^19[0-4]')]/@id", []string{"Barney", "Spark", "Snuffy"}},
+	{"library/book/character[matches(name, 'o.*y$')]/@id", []string{"Snoopy"}},
+	{"library/book/character[matches(name, '^s', 'i')]/@id", []string{"Snoopy", "Schroeder", "Spark", "Snuffy"}},
+	{"library/book/character[matches(name, concat('^', @id, '$'))]/@id", []string{"Snoopy", "Schroeder", "Lucy"}},
+	{"library/book/character[matches(name, concat('(', @id))]/@id", exists(false)},
+	{"library/book[matches(isbn, '(')]", cerror(".*: error parsing regexp: missing closing \\): `\\(`")},
+	{"library/book[matches(isbn, 'a', 'z')]", cerror(".*: error parsing regexp: invalid or unsupported Perl syntax: `\\(\\?z`")},
+	{"library/book[matches(isbn)]", cerror(".*: wrong number of arguments for matches\\(\\)")},
+	{"library/book/character[lower-case(@id)='snoopy']/name", []string{"Snoopy"}},
+	{"library/book/character[upper-case(name)='LUCY']/@id", []string{"Lucy"}},
+	{"library/book/character[contains(lower-case(qualification), 'peanuts')]/@id", []string{"Schroeder"}},
+	{"library/book[lower-case('ÉTÉ') = 'été']/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[lower-case()]", cerror(".*: wrong number of arguments for lower-case\\(\\)")},
+	{"library/book[string-length(isbn, title)]", cerror(".*: wrong number of arguments for string-length\\(\\)")},
+	{"library/book[normalize-space(isbn, title)]", cerror(".*: wrong number of arguments for normalize-space\\(\\)")},
+
+	// Comparisons.
+	{"library/book/character[@id!='Snoopy' and position() < 3]/@id", []string{"PP", "Barney", "Spark"}},
+	{"library/book[character/@id != 'Lucy']/isbn", []string{"0836217462", "0883556316"}},
+	{"library/book[isbn != 'x']/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[quote != 'x']/@id", []string{"b0836217462"}},
+	{"library/book[not(quote = 'x')]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library[comment() = ' Great book. ']/book[1]/@id", []string{"b0836217462"}},
+	{"library[comment() != ' Great book. ']/book[1]/@id", []string{"b0836217462"}},
+	{"library[comment() = 'Great book.']/book/@id", exists(false)},
+	{"library/book[preceding-sibling::comment()[1] = ' Another great book. ']/@id", []string{"b0883556316"}},
+	{"library/book/*/*[text() = 'Snoopy']/../@id", []string{"Snoopy"}},
+	{"library/book/*[text() != '']/../@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book/character[name/text() = born/text()]/@id", exists(false)},
+	{"library/book/title[text() = 'Being a Dog Is a Full-Time Job']/@lang", []string{"en"}},
+	{"library/book/*[normalize-space(text()) != ''][1]", []string{"0836217462", "0883556316"}},
+	{"library/book/isbn[text() > 836217462]", []string{"0883556316"}},
+	{"library/book/author[processing-instruction() = node()]/@id", []string{"CMS"}},
+	{"library/book/character[text() = '']/@id", exists(false)},
+	{"library/book[quote != true()]/@id", []string{"b0883556316"}},
+	{"library/book[quote != false()]/@id", []string{"b0836217462"}},
+	{"library/book[isbn != 836217462]/@id", []string{"b0883556316"}},
+	{"library/book[number(title) != number(title)]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[string-length(isbn) != 10]/@id", exists(false)},
+	{"library/book['a' != 'b' and not('a' != 'a')]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[isbn > 836217462]/@id", []string{"b0883556316"}},
+	{"library/book[isbn >= 836217462]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[isbn<883556316]/@id", []string{"b0836217462"}},
+	{"library/book[isbn <= '0836217462']/@id", []string{"b0836217462"}},
+	{"library/book[character/born < 1922]/@id", exists(false)},
+	{"library/book[character/born <= 1922]/@id", exists(false)},
+	{"library/book[author/@id > 0]/@id", exists(false)},
+	{"library/book[@available > false()]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book/character[string-length(name) > string-length(@id)]/@id", []string{"PP", "Barney", "Spark", "Snuffy"}},
+	{"library/book[count(character) >= 4]/@id", []string{"b0836217462"}},
+	{"library/book['2' > 1 and 1 < '2' and not('a' < 1) and not('a' >= 1)]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book/character[name = @id]/born", []string{"1950-10-04", "1951-05-30", "1952-03-03"}},
+	{"library/book/character[@id = name]/born", []string{"1950-10-04", "1951-05-30", "1952-03-03"}},
+	{"library/book/character[name != @id]/@id", []string{"PP", "Barney", "Spark", "Snuffy"}},
+	{"library/book/character[born = ../author/born]/@id", exists(false)},
+	{"library/book/character[substring(born, 1, 4) = substring(../author/born, 1, 4)]/@id", []string{"Spark"}},
+	{"library/book/character[born < ../author/born]/@id", exists(false)},
+	{"library/book/character[substring(born, 1, 4) < substring(../author/born, 1, 4)]/@id", []string{"Barney"}},
+	{"library/book/character[substring(born, 1, 4) >= ../character[1]/born]/@id", exists(false)},
+	{"library/book[character/@id = ../book/author/@id]/@id", exists(false)},
+	{"library/book[author/@id = ../book/author/@id]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[character/@id != character/@id]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[quote != quote]/@id", exists(false)},
+	{"library/book[quote = missing or missing = quote or missing = missing]/@id", exists(false)},
+	{"library/book[isbn >= ../book/isbn]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[isbn > ../book/isbn]/@id", []string{"b0883556316"}},
+	{"library/book[isbn !== 'x']", cerror(".*: expected an expression")},
+
+	// Arithmetic.
+	{"library/book/character[last() - 1]/@id", []string{"Schroeder", "Spark"}},
+	{"library/book/character[last()-1]/@id", []string{"Schroeder", "Spark"}},
+	{"library/book/character[position() = last() - 2]/@id", []string{"Snoopy", "Barney"}},
+	{"library/book/character[1 + 1]/@id", []string{"Snoopy", "Spark"}},
+	{"library/book/character[(1 + 1) * 2]/@id", []string{"Lucy"}},
+	{"library/book/character[1 + 1 * 2]/@id", []string{"Schroeder", "Snuffy"}},
+	{"library/book/character[position() mod 2 = 0]/@id", []string{"Snoopy", "Lucy", "Spark"}},
+	{"library/book/character[position() div 2 = 1]/@id", []string{"Snoopy", "Spark"}},
+	{"library/book/character[-position() = -1]/@id", []string{"PP", "Barney"}},
+	{"library/book/character[--1]/@id", []string{"PP", "Barney"}},
+	{"library/book/character[5 - 2 - 1]/@id", []string{"Snoopy", "Spark"}},
+	{"library/book/character[8 div 2 div 2]/@id", []string{"Snoopy", "Spark"}},
+	{"library/book[isbn - 1 = 883556315]/@id", []string{"b0883556316"}},
+	{"library/book[isbn*2 > 1700000000]/@id", []string{"b0883556316"}},
+	{"library/book[isbn mod 2 = 0]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[substring(born, 1, 4) - 1900 > 0]/@id", exists(false)},
+	{"library/book/character[substring(born, 1, 4) - 1950 >= 0]/@id", []string{"PP", "Snoopy", "Schroeder", "Lucy"}},
+	{"library/book[1 div 0 > 1000 and -1 div 0 < -1000 and string(0 div 0) = 'NaN']/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[5 mod 2 = 1 and -5 mod 2 = -1 and 5 mod -2 = 1]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[(quote or isbn = 'x') and not(quote)]/@id", exists(false)},
+	{"library/book[(1 + 1]", cerror(".*: missing \\)")},
+	{"library/book[1 +]", cerror(".*: expected an expression")},
+	{"library/book[1 div]", cerror(".*: expected an expression")},
+	{"library/book[1 - 1]", exists(false)},
+	{"library/book[-(1)]", cerror(".*: positions must be positive")},
+
+	// Unions.
+	{"library/book/isbn | library/book/title", []string{"0836217462", "Being a Dog Is a Full-Time Job", "0883556316", "Barney Google and Snuffy Smith"}},
+	{"//title|//isbn|//@lang", []string{"0836217462", "Being a Dog Is a Full-Time Job", "en", "0883556316", "Barney Google and Snuffy Smith", "en"}},
+	{"//character[@id='Lucy']/name | /library/book/character[1]/name | //character[1]/name", []string{"Peppermint Patty", "Lucy", "Barney Google"}},
+	{"library/book[quote | author]/@id", []string{"b0836217462", "b0883556316"}},
+	{"library/book[count(quote | author | isbn) = 3]/@id", []string{"b0836217462"}},
+	{"library/book[count(character | character/name) = 8]/@id", []string{"b0836217462"}},
+	{"library/book[(quote | isbn) = '0883556316']/@id", []string{"b0883556316"}},
+	{"library/book[string(quote | isbn) = '0836217462']/@id", []string{"b0836217462"}},
+	{"library/book | ", cerror(".*: expected an expression")},
+	{"library/book | //", cerror(".*: missing name")},
+	{"library/book[isbn | 'x']", cerror(".*: union operands must be paths")},
+	{"count(library)", exists(false)},
+
+	// Intersections and differences.
+	{"library/book/character/@id intersect //character[contains(name, 'S')]/@id", []string{"Snoopy", "Schroeder", "Spark", "Snuffy"}},
+	{"//character/@id intersect //@id[starts-with(., 'S')] intersect //@id[contains(., 'o')]", []string{"Snoopy", "Schroeder"}},
+	{"//character/@id except //@id[starts-with(., 'S')] except //@id[. = 'PP']", []string{"Lucy", "Barney"}},
+	{"//character/@id except (//@id[starts-with(., 'S')] except //@id[. = 'Spark'])", []string{"PP", "Lucy", "Barney", "Spark"}},
+	{"//book/isbn | //character/@id except //character/@id", []string{"0836217462", "0883556316"}},
+	{"(//book/isbn | //character/@id) except //character/@id", []string{"0836217462", "0883556316"}},
+	{"//character/@id except //character/@id[1] | //book/@id", []string{"b0836217462", "b0883556316"}},
+	{"//character/@id intersect //name", exists(false)},
+	{"library/book[count(character except character[1]) = 3]/@id", []string{"b0836217462"}},
+	{"library/book/character[not(. intersect ../character[last()])]/@id", []string{"PP", "Snoopy", "Schroeder", "Barney", "Spark"}},
+	{"library/book[isbn except 'x']", cerror(".*: except operands must be paths")},
+	{"library/book[isbn intersect]", cerror(".*: expected an expression")},
+
+	// Filter expressions.
+	{"(library/book/character/name)[1]", []string{"Peppermint Patty"}},
+	{"(//character/name)[last()]", []string{"Snuffy Smith"}},
+	{"(//character)[last()]/name", []string{"Snuffy Smith"}},
+	{"(//character)[position() > 5]/@id", []string{"Spark", "Snuffy"}},
+	{"(//title | //isbn)[1]", []string{"0836217462"}},
+	{"(//title | //isbn)[2]", []string{"Being a Dog Is a Full-Time Job"}},
+	{"(//book)[2]//name", []string{"Charles M Schulz", "Barney Google", "Spark Plug", "Snuffy Smith"}},
+	{"(//character)[@id='Lucy' or @id='Spark'][2]/born", []string{"1922-07-17"}},
+	{"(//character)[starts-with(@id, 'S')][last()]/name", []string{"Snuffy Smith"}},
+	{"(//book/character[1])/name", []string{"Peppermint Patty", "Barney Google"}},
+	{"(//character/name)[1] | (//character/name)[last()]", []string{"Peppermint Patty", "Snuffy Smith"}},
+	{"library/book[(character/name)[2] = 'Snoopy']/@id", []string{"b0836217462"}},
+	{"library/book[id('Lucy')/born = character/born]/@id", []string{"b0836217462"}},
+	{"library/book[count((character | author)[name]) = 4]/@id", []string{"b0883556316"}},
+	{"library/book/character[(../character)[last()] = .]/@id", []string{"Lucy", "Snuffy"}},
+	{"('a')[1]", cerror(".*: predicates and paths only apply to node-sets")},
+	{"library/book[(1 + 1)/isbn]", cerror(".*: predicates and paths only apply to node-sets")},
+	{"library/book[concat('a', 'b')[1]]/@id", exists(false)},
+	{"(//character)[0]", cerror(".*: positions start at 1")},
+	{"(//character)[1", cerror(".*: expected ']'")},
+	{"(//character)/", cerror(".*: missing name")},
+
+	// Bogus expressions.
+	{"/foo)", cerror(`compiling xml path "/foo\)":4: unexpected '\)'`)},
+}
+
+var libraryXml = []byte(
+	`<?xml version="1.0"?> 
+<library>
+  <!-- Great book. -->
+  <book id="b0836217462" available="true">
+    <isbn>0836217462</isbn>
+    <title lang="en">Being a Dog Is a Full-Time Job</title>
+    <quote>I'd dog paddle the deepest ocean.</quote>
+    <author id="CMS">
+      <?echo "go rocks"?>
+      <name>Charles M Schulz</name>
+      <born>1922-11-26</born>
+      <dead>2000-02-12</dead>
+    </author>
+    <character id="PP">
+      <name>Peppermint Patty</name>
+      <born>1966-08-22</born>
+      <qualification>bold, brash and tomboyish</qualification>
+    </character>
+    <character id="Snoopy">
+      <name>Snoopy</name>
+      <born>1950-10-04</born>
+      <qualification>extroverted beagle</qualification>
+    </character>
+    <character id="Schroeder">
+      <name>Schroeder</name>
+      <born>1951-05-30</born>
+      <qualification>brought classical music to the Peanuts strip</qualification>
+    </character>
+    <character id="Lucy">
+      <name>Lucy</name>
+      <born>1952-03-03</born>
+      <qualification>bossy, crabby and selfish</qualification>
+    </character>
+  </book>
+  <!-- Another great book. -->
+  <book id="b0883556316" available="true">
+    <isbn>0883556316</isbn>
+    <title lang="en">Barney Google and Snuffy Smith</title>
+    <author id="CMS">
+      <name>Charles M Schulz</name>
+      <born>1922-11-26</born>
+      <dead>2000-02-12</dead>
+    </author>
+    <character id="Barney">
+      <name>Barney Google</name>
+      <born>1919-01-01</born>
+      <qualification>goggle-eyed, moustached, gloved and top-hatted, bulbous-nosed, cigar-chomping shrimp</qualification>
+    </character>
+    <character id="Spark">
+      <name>Spark Plug</name>
+      <born>1922-07-17</born>
+      <qualification>brown-eyed, bow-legged nag, seldom races, patched blanket</qualification>
+    </character>
+    <character id="Snuffy">
+      <name>Snuffy Smith</name>
+      <born>1934-01-01</born>
+      <qualification>volatile and diminutive moonshiner, ornery little cuss, sawed-off and shiftless</qualification>
+    </character>
+  </book>
+</library>
+`)
+
+func (s *BasicSuite) BenchmarkParse(c *C) {
+	for i := 0; i < c.N; i++ {
+		_, err := xmlpath.Parse(bytes.NewBuffer(instancesXml))
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *BasicSuite) BenchmarkSimplePathCompile(c *C) {
+	var err error
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		_, err = xmlpath.Compile("/DescribeInstancesResponse/reservationSet/item/groupSet/item/groupId")
+	}
+	c.StopTimer()
+	c.Assert(err, IsNil)
+}
+
+func (s *BasicSuite) BenchmarkSimplePathString(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(instancesXml))
+	c.Assert(err, IsNil)
+	path := xmlpath.MustCompile("/DescribeInstancesResponse/reservationSet/item/instancesSet/item/instanceType")
+	var str string
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		str, _ = path.String(node)
+	}
+	c.StopTimer()
+	c.Assert(str, Equals, "m1.small")
+}
+
+func (s *BasicSuite) BenchmarkSimplePathStringUnmarshal(c *C) {
+	// For a vague comparison.
+	var result struct {
+		Str string `xml:"reservationSet>item>instancesSet>item>instanceType"`
+	}
+	for i := 0; i < c.N; i++ {
+		xml.Unmarshal(instancesXml, &result)
+	}
+	c.StopTimer()
+	c.Assert(result.Str, Equals, "m1.large")
+}
+
+func (s *BasicSuite) BenchmarkSimplePathExists(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(instancesXml))
+	c.Assert(err, IsNil)
+	path := xmlpath.MustCompile("/DescribeInstancesResponse/reservationSet/item/instancesSet/item/instanceType")
+	var exists bool
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		exists = path.Exists(node)
+	}
+	c.StopTimer()
+	c.Assert(exists, Equals, true)
+}
+
+var instancesXml = []byte(
+	`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2011-12-15/">
+  <requestId>98e3c9a4-848c-4d6d-8e8a-b1bdEXAMPLE</requestId>
+  <reservationSet>
+    <item>
+      <reservationId>r-b27e30d9</reservationId>
+      <ownerId>999988887777</ownerId>
+      <groupSet>
+        <item>
+          <groupId>sg-67ad940e</groupId>
+          <groupName>default</groupName>
+        </item>
+      </groupSet>
+      <instancesSet>
+        <item>
+          <instanceId>i-c5cd56af</instanceId>
+          <imageId>ami-1a2b3c4d</imageId>
+          <instanceState>
+            <code>16</code>
+            <name>running</name>
+          </instanceState>
+          <privateDnsName>domU-12-31-39-10-56-34.compute-1.internal</privateDnsName>
+          <dnsName>ec2-174-129-165-232.compute-1.amazonaws.com</dnsName>
+          <reason/>
+          <keyName>GSG_Keypair</keyName>
+          <amiLaunchIndex>0</amiLaunchIndex>
+          <productCodes/>
+          <instanceType>m1.small</instanceType>
+          <launchTime>2010-08-17T01:15:18.000Z</launchTime>
+          <placement>
+            <availabilityZone>us-east-1b</availabilityZone>
+            <groupName/>
+          </placement>
+          <kernelId>aki-94c527fd</kernelId>
+          <ramdiskId>ari-96c527ff</ramdiskId>
+          <monitoring>
+            <state>disabled</state>
+          </monitoring>
+          <privateIpAddress>10.198.85.190</privateIpAddress>
+          <ipAddress>174.129.165.232</ipAddress>
+          <architecture>i386</architecture>
+          <rootDeviceType>ebs</rootDeviceType>
+          <rootDeviceName>/dev/sda1</rootDeviceName>
+          <blockDeviceMapping>
+            <item>
+              <deviceName>/dev/sda1</deviceName>
+              <ebs>
+                <volumeId>vol-a082c1c9</volumeId>
+                <status>attached</status>
+                <attachTime>2010-08-17T01:15:21.000Z</attachTime>
+                <deleteOnTermination>false</deleteOnTermination>
+              </ebs>
+            </item>
+          </blockDeviceMapping>
+          <instanceLifecycle>spot</instanceLifecycle>
+          <spotInstanceRequestId>sir-7a688402</spotInstanceRequestId>
+          <virtualizationType>paravirtual</virtualizationType>
+          <clientToken/>
+          <tagSet/>
+          <hypervisor>xen</hypervisor>
+       </item>
+      </instancesSet>
+      <requesterId>854251627541</requesterId>
+    </item>
+    <item>
+      <reservationId>r-b67e30dd</reservationId>
+      <ownerId>999988887777</ownerId>
+      <groupSet>
+        <item>
+          <groupId>sg-67ad940e</groupId>
+          <groupName>default</groupName>
+        </item>
+      </groupSet>
+      <instancesSet>
+        <item>
+          <instanceId>i-d9cd56b3</instanceId>
+          <imageId>ami-1a2b3c4d</imageId>
+          <instanceState>
+            <code>16</code>
+            <name>running</name>
+          </instanceState>
+          <privateDnsName>domU-12-31-39-10-54-E5.compute-1.internal</privateDnsName>
+          <dnsName>ec2-184-73-58-78.compute-1.amazonaws.com</dnsName>
+          <reason/>
+          <keyName>GSG_Keypair</keyName>
+          <amiLaunchIndex>0</amiLaunchIndex>
+          <productCodes/>
+          <instanceType>m1.large</instanceType>
+          <launchTime>2010-08-17T01:15:19.000Z</launchTime>
+          <placement>
+            <availabilityZone>us-east-1b</availabilityZone>
+            <groupName/>
+          </placement>
+          <kernelId>aki-94c527fd</kernelId>
+          <ramdiskId>ari-96c527ff</ramdiskId>
+          <monitoring>
+            <state>disabled</state>
+          </monitoring>
+          <privateIpAddress>10.198.87.19</privateIpAddress>
+          <ipAddress>184.73.58.78</ipAddress>
+          <architecture>i386</architecture>
+          <rootDeviceType>ebs</rootDeviceType>
+          <rootDeviceName>/dev/sda1</rootDeviceName>
+          <blockDeviceMapping>
+            <item>
+              <deviceName>/dev/sda1</deviceName>
+              <ebs>
+                <volumeId>vol-a282c1cb</volumeId>
+                <status>attached</status>
+                <attachTime>2010-08-17T01:15:23.000Z</attachTime>
+                <deleteOnTermination>false</deleteOnTermination>
+              </ebs>
+            </item>
+          </blockDeviceMapping>
+          <instanceLifecycle>spot</instanceLifecycle>
+          <spotInstanceRequestId>sir-55a3aa02</spotInstanceRequestId>
+          <virtualizationType>paravirtual</virtualizationType>
+          <clientToken/>
+          <tagSet/>
+          <hypervisor>xen</hypervisor>
+       </item>
+      </instancesSet>
+      <requesterId>854251627541</requesterId>
+    </item>
+  </reservationSet>
+</DescribeInstancesResponse>
+`)
+
+var nestedXml = []byte("<r>" + strings.Repeat("<a><b><name>x</name></b><name>y</name></a>", 1000) + "</r>")
+
+func (s *BasicSuite) BenchmarkDescendantChild(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(nestedXml))
+	c.Assert(err, IsNil)
+	path := xmlpath.MustCompile("/descendant-or-self::*/name")
+	var count int
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		count = 0
+		iter := path.Iter(node)
+		for iter.Next() {
+			count++
+		}
+	}
+	c.StopTimer()
+	c.Assert(count, Equals, 2000)
+}
+
+func (s *BasicSuite) BenchmarkAttributeTestFirst(c *C) {
+	node, err := xmlpath.Parse(bytes.NewBuffer(nestedXml))
+	c.Assert(err, IsNil)
+	path := xmlpath.MustCompile("//a[.//name = 'z'][@missing]")
+	var exists bool
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		exists = path.Exists(node)
+	}
+	c.StopTimer()
+	c.Assert(exists, Equals, false)
+}
@@ -0,0 +1,303 @@
+package xmlpath
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// C14N writes nodes as canonical XML, which is the same for the documents
+// that only differ in their formatting, such as the order of attributes, the
+// quotes around their values or the CDATA sections, so that it may be hashed
+// or signed. It follows Canonical XML 1.0, or Exclusive XML Canonicalization
+// 1.0 if Exclusive. The zero value writes canonical XML without comments.
+type C14N struct {
+	// Exclusive declares on each element only the namespaces used by its
+	// name and the names of its attributes, and does not bring the xml:
+	// attributes of the ancestors of the node written, so that it is
+	// written the same whatever the document it is part of.
+	Exclusive bool
+
+	// InclusivePrefixes are the prefixes declared as without Exclusive
+	// whenever in scope, "#default" being the default namespace.
+	InclusivePrefixes []string
+
+	// WithComments writes the comments, which are left out otherwise.
+	WithComments bool
+}
+
+// WriteC14N writes n and its content to w as canonical XML without
+// comments, as the zero value of C14N does.
+func (n *Node) WriteC14N(w io.Writer) error {
+	return (&C14N{}).Write(w, n)
+}
+
+// Write writes n and its content to w as canonical XML. For the root node,
+// the comments and processing instructions around the document element are
+// separated from it by line breaks, and its text, which is only white space
+// unless n is the root of a fragment, is left out if only white space. The
+// nodes of documents parsed with KeepSource are never written as they were
+// in the source.
+func (c *C14N) Write(w io.Writer, n *Node) error {
+	_, err := w.Write(c.Bytes(n))
+	return err
+}
+
+// Bytes returns n and its content as canonical XML, as written by Write.
+func (c *C14N) Bytes(n *Node) []byte {
+	cw := &c14nWriter{C14N: c, inclusive: map[string]bool{}}
+	for _, prefix := range c.InclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		cw.inclusive[prefix] = true
+	}
+	n = n.element()
+	var ns map[string]string
+	if n.up != nil {
+		ns = n.up.FindNamespaces()
+	}
+	cw.node(n, ns, map[string]string{"": ""}, true)
+	return cw.buf
+}
+
+// c14nWriter formats nodes to canonical XML
+type c14nWriter struct {
+	*C14N
+	buf       []byte
+	inclusive map[string]bool
+}
+
+// c14nAttr is an attribute of an element written as canonical XML
+type c14nAttr struct {
+	space, local string
+	name, value  string
+}
+
+// node writes n, within the namespaces ns, of which the ones in rendered are
+// declared by the elements written around n. The apex is the node given to
+// Write.
+func (cw *c14nWriter) node(n *Node, ns, rendered map[string]string, apex bool) {
+	switch n.kind {
+	case StartNode:
+		if n.name.Local == "" {
+			cw.root(n, rendered)
+		} else {
+			cw.element(n, ns, rendered, apex)
+		}
+	case AttrNode:
+		name, _ := attrName(n, ns)
+		cw.attrValue(name, n.attr)
+	case TextNode:
+		cw.buf = appendC14NEscaped(cw.buf, n.text, false)
+	case CommentNode:
+		if cw.WithComments {
+			cw.buf = append(cw.buf, "<!--"...)
+			cw.buf = append(cw.buf, n.text...)
+			cw.buf = append(cw.buf, "-->"...)
+		}
+	case ProcInstNode:
+		cw.buf = append(cw.buf, "<?"...)
+		cw.buf = append(cw.buf, n.name.Local...)
+		if len(n.text) > 0 {
+			cw.buf = append(cw.buf, ' ')
+			cw.buf = append(cw.buf, n.text...)
+		}
+		cw.buf = append(cw.buf, "?>"...)
+	}
+}
+
+// root writes the content of the root node n
+func (cw *c14nWriter) root(n *Node, rendered map[string]string) {
+	ns := n.namespaces(nil)
+	before := true
+	for _, c := range n.content() {
+		switch c.kind {
+		case StartNode:
+			cw.node(c, ns, rendered, false)
+			before = false
+		case TextNode:
+			if strings.TrimSpace(string(c.text)) != "" {
+				cw.node(c, ns, rendered, false)
+			}
+		case CommentNode, ProcInstNode:
+			// The XML declaration is left out as well
+			if c.kind == CommentNode && !cw.WithComments || c.kind == ProcInstNode && c.name.Local == "xml" {
+				continue
+			}
+			if !before {
+				cw.buf = append(cw.buf, '\n')
+			}
+			cw.node(c, ns, rendered, false)
+			if before {
+				cw.buf = append(cw.buf, '\n')
+			}
+		}
+	}
+}
+
+// element writes the element n and its content
+func (cw *c14nWriter) element(n *Node, ns, rendered map[string]string, apex bool) {
+	ns = n.namespaces(ns)
+	name, ok := elemName(n, ns)
+	if !ok {
+		if n.name.Space == "" {
+			ns[""] = ""
+		} else {
+			prefix := declPrefix(ns, n.name.Space)
+			ns[prefix] = n.name.Space
+			name = prefix + ":" + n.name.Local
+		}
+	}
+
+	var attrs []c14nAttr
+	for i := n.pos + 1; i < n.end && n.doc.nodes[i].kind == AttrNode; i++ {
+		attr := &n.doc.nodes[i]
+		if isNamespaceDecl(attr.name) {
+			continue
+		}
+		name, ok := attrName(attr, ns)
+		if !ok {
+			prefix := declPrefix(ns, attr.name.Space)
+			ns[prefix] = attr.name.Space
+			name = prefix + ":" + attr.name.Local
+		}
+		attrs = append(attrs, c14nAttr{attr.name.Space, attr.name.Local, name, attr.attr})
+	}
+	if apex && !cw.Exclusive {
+		attrs = inheritXMLAttrs(n, attrs)
+	}
+	for i := range attrs {
+		if isXMLNamespace(attrs[i].space) {
+			attrs[i].space = "http://www.w3.org/XML/1998/namespace"
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	var prefixes []string
+	if cw.Exclusive {
+		used := map[string]bool{qnamePrefix(name): true}
+		for _, attr := range attrs {
+			if attr.space != "" && !isXMLNamespace(attr.space) {
+				used[qnamePrefix(attr.name)] = true
+			}
+		}
+		for prefix := range cw.inclusive {
+			if _, ok := ns[prefix]; ok {
+				used[prefix] = true
+			}
+		}
+		for prefix := range used {
+			prefixes = append(prefixes, prefix)
+		}
+	} else {
+		for prefix := range ns {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+
+	cw.buf = append(cw.buf, '<')
+	cw.buf = append(cw.buf, name...)
+	inner, copied := rendered, false
+	for _, prefix := range prefixes {
+		space := ns[prefix]
+		if old, ok := rendered[prefix]; prefix == "xml" || ok && old == space || !ok && space == "" {
+			continue
+		}
+		if !copied {
+			inner, copied = make(map[string]string, len(rendered)+1), true
+			for k, v := range rendered {
+				inner[k] = v
+			}
+		}
+		inner[prefix] = space
+		cw.buf = append(cw.buf, ' ')
+		if prefix == "" {
+			cw.attrValue("xmlns", space)
+		} else {
+			cw.attrValue("xmlns:"+prefix, space)
+		}
+	}
+	for _, attr := range attrs {
+		cw.buf = append(cw.buf, ' ')
+		cw.attrValue(attr.name, attr.value)
+	}
+	cw.buf = append(cw.buf, '>')
+
+	for _, c := range n.content() {
+		cw.node(c, ns, inner, false)
+	}
+	cw.buf = append(cw.buf, "</"...)
+	cw.buf = append(cw.buf, name...)
+	cw.buf = append(cw.buf, '>')
+}
+
+// inheritXMLAttrs appends to attrs the xml: attributes of the ancestors of
+// n which n does not have, the nearest ones first
+func inheritXMLAttrs(n *Node, attrs []c14nAttr) []c14nAttr {
+	for e := n.up; e != nil; e = e.up {
+		for i := e.pos + 1; i < e.end && e.doc.nodes[i].kind == AttrNode; i++ {
+			attr := &e.doc.nodes[i]
+			if !isXMLNamespace(attr.name.Space) {
+				continue
+			}
+			found := false
+			for _, a := range attrs {
+				found = found || isXMLNamespace(a.space) && a.local == attr.name.Local
+			}
+			if !found {
+				attrs = append(attrs, c14nAttr{attr.name.Space, attr.name.Local, "xml:" + attr.name.Local, attr.attr})
+			}
+		}
+	}
+	return attrs
+}
+
+// qnamePrefix returns the prefix of the qualified name qname, or the empty
+// string if it has none
+func qnamePrefix(qname string) string {
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		return qname[:i]
+	}
+	return ""
+}
+
+// attrValue writes an attribute of the given name and value
+func (cw *c14nWriter) attrValue(name, value string) {
+	cw.buf = append(cw.buf, name...)
+	cw.buf = append(cw.buf, '=', '"')
+	cw.buf = appendC14NEscaped(cw.buf, []byte(value), true)
+	cw.buf = append(cw.buf, '"')
+}
+
+// appendC14NEscaped appends data to result, escaped as text in canonical
+// XML, or as the value of an attribute if attr
+func appendC14NEscaped(result []byte, data []byte, attr bool) []byte {
+	for _, c := range data {
+		switch {
+		case c == '&':
+			result = append(result, "&amp;"...)
+		case c == '<':
+			result = append(result, "&lt;"...)
+		case c == '>' && !attr:
+			result = append(result, "&gt;"...)
+		case c == '"' && attr:
+			result = append(result, "&quot;"...)
+		case c == '\t' && attr:
+			result = append(result, "&#x9;"...)
+		case c == '\n' && attr:
+			result = append(result, "&#xA;"...)
+		case c == '\r':
+			result = append(result, "&#xD;"...)
+		default:
+			result = append(result, c)
+		}
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package xmlpath
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// Cache memoizes compiled paths, keeping the most recently used ones. It is
+// safe for concurrent use.
+type Cache struct {
+	size int
+
+	mu    sync.Mutex
+	paths map[string]*list.Element
+	lru   list.List
+}
+
+type cacheEntry struct {
+	path     string
+	compiled *Path
+}
+
+// NewCache returns a cache keeping up to size compiled paths.
+func NewCache(size int) *Cache {
+	return &Cache{size: size, paths: make(map[string]*list.Element)}
+}
+
+// Compile returns the compiled path, taken from the cache if it was compiled
+// before. Paths failing to compile are not cached.
+func (c *Cache) Compile(path string) (*Path, error) {
+	c.mu.Lock()
+	if elem, ok := c.paths[path]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*cacheEntry).compiled, nil
+	}
+	c.mu.Unlock()
+
+	compiled, err := Compile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.paths[path]; ok {
+		// Compiled concurrently
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).compiled, nil
+	}
+	c.paths[path] = c.lru.PushFront(&cacheEntry{path, compiled})
+	for c.lru.Len() > c.size {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.paths, elem.Value.(*cacheEntry).path)
+	}
+	return compiled, nil
+}
+
+var defaultCache = NewCache(256)
+
+// CompileCached is like Compile, but memoizes the compiled paths in a cache
+// shared by the package, keeping the 256 most recently used ones.
+func CompileCached(path string) (*Path, error) {
+	return defaultCache.Compile(path)
+}
+
+// regexpCache memoizes compiled regular expressions, keeping the most
+// recently used ones as Cache does. The patterns failing to compile are
+// cached as well, with their error.
+type regexpCache struct {
+	size int
+
+	mu  sync.Mutex
+	res map[string]*list.Element
+	lru list.List
+}
+
+type regexpEntry struct {
+	pattern string
+	re      *regexp.Regexp
+	err     error
+}
+
+func (c *regexpCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	if elem, ok := c.res[pattern]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		e := elem.Value.(*regexpEntry)
+		return e.re, e.err
+	}
+	c.mu.Unlock()
+
+	re, err := regexp.Compile(pattern)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.res[pattern]; !ok {
+		c.res[pattern] = c.lru.PushFront(&regexpEntry{pattern, re, err})
+		for c.lru.Len() > c.size {
+			elem := c.lru.Back()
+			c.lru.Remove(elem)
+			delete(c.res, elem.Value.(*regexpEntry).pattern)
+		}
+	}
+	return re, err
+}
+
+// regexps are the regular expressions of the patterns given to matches()
+// which are not literals, and are otherwise compiled for each node
+var regexps = &regexpCache{size: 256, res: make(map[string]*list.Element)}
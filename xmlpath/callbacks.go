@@ -0,0 +1,186 @@
+package xmlpath
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+)
+
+// Position is where a node is in the source of its document.
+type Position struct {
+	Line, Column      int // Line and column, as given by Node.Position
+	Offset, EndOffset int // Offsets of its markup, as given by Node.Offsets
+}
+
+// Callbacks are called while documents are parsed with its methods, which
+// otherwise parse them as the functions of the same names do, so that
+// programs may gather what they need of the documents, such as their ids or
+// anchors, in the same pass. They are called in document order for each
+// element, end of element and text node, as soon as the node is parsed, the
+// white space left out by StripSpace and StripDefaultSpace excepted, and not
+// for the context element of fragments. A callback returning an error stops
+// the parsing, which returns the error. The callbacks left nil are not
+// called.
+type Callbacks struct {
+	StartElement func(name xml.Name, attrs []xml.Attr, pos Position) error
+	EndElement   func(name xml.Name, pos Position) error
+
+	// Text is given the text of text nodes, which must not be modified,
+	// and whether it comes from a CDATA section
+	Text func(text []byte, cdata bool, pos Position) error
+
+	// Progress is called as the source of documents is read, with the
+	// number of bytes read so far and their total number, or -1 if
+	// unknown. The total is known for files and for the readers with a Len
+	// method, such as *bytes.Reader. ParseDecoder, which does not read the
+	// source, does not call it.
+	Progress func(read, total int64)
+
+	// Limits restrict the documents parsed if not nil, as its methods do
+	Limits *Limits
+}
+
+// Parse is like the Parse function, but calls the callbacks of cb.
+func (cb *Callbacks) Parse(r io.Reader, opts ...ParseOption) (*Node, error) {
+	d, src, err := newDecoder(cb.progress(r), opts, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return parseDecoder(d, src, cb.Limits, cb, opts)
+}
+
+// ParseHTML is like the ParseHTML function, but calls the callbacks of cb.
+func (cb *Callbacks) ParseHTML(r io.Reader, opts ...ParseOption) (*Node, error) {
+	d, src, err := newDecoder(cb.progress(r), opts, "", "")
+	if err != nil {
+		return nil, err
+	}
+	htmlDecoder(d, src)
+	return parseDecoder(d, src, cb.Limits, cb, opts)
+}
+
+// ParseFragment is like the ParseFragment function, but calls the callbacks
+// of cb.
+func (cb *Callbacks) ParseFragment(r io.Reader, context string, opts ...ParseOption) (*Node, error) {
+	return parseFragment(cb.progress(r), context, false, cb.Limits, cb, opts)
+}
+
+// ParseHTMLFragment is like the ParseHTMLFragment function, but calls the
+// callbacks of cb.
+func (cb *Callbacks) ParseHTMLFragment(r io.Reader, context string, opts ...ParseOption) (*Node, error) {
+	return parseFragment(cb.progress(r), context, true, cb.Limits, cb, opts)
+}
+
+// ParseDecoder is like the ParseDecoder function, but calls the callbacks of
+// cb.
+func (cb *Callbacks) ParseDecoder(d *xml.Decoder, opts ...ParseOption) (*Node, error) {
+	return parseDecoder(d, nil, cb.Limits, cb, opts)
+}
+
+// progress returns r, reporting what is read from it to cb.Progress if not
+// nil
+func (cb *Callbacks) progress(r io.Reader) io.Reader {
+	if cb.Progress == nil {
+		return r
+	}
+	pr := &progressReader{r: r, fn: cb.Progress, total: -1}
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		pr.total = int64(r.Len())
+	case *os.File:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			pr.total = info.Size()
+		}
+	}
+	return pr
+}
+
+// progressReader reports to fn the number of bytes read from r
+type progressReader struct {
+	r     io.Reader
+	fn    func(read, total int64)
+	read  int64
+	total int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.read += int64(n)
+		pr.fn(pr.read, pr.total)
+	}
+	return n, err
+}
+
+// call calls the callback for the nodes parsed from the token t, an element
+// and its attributes or a single other node
+func (cb *Callbacks) call(t xml.Token, nodes []Node) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	n := &nodes[0]
+	pos := Position{int(n.line), int(n.column), int(n.offset), int(n.endOffset)}
+	switch n.kind {
+	case StartNode:
+		if cb.StartElement != nil {
+			attrs := make([]xml.Attr, 0, len(nodes)-1)
+			for _, attr := range nodes[1:] {
+				attrs = append(attrs, xml.Attr{Name: attr.name, Value: attr.attr})
+			}
+			return cb.StartElement(n.name, attrs, pos)
+		}
+	case EndNode:
+		if cb.EndElement != nil {
+			return cb.EndElement(t.(xml.EndElement).Name, pos)
+		}
+	case TextNode:
+		if cb.Text != nil {
+			return cb.Text(n.text, n.cdata, pos)
+		}
+	}
+	return nil
+}
+
+// fragment returns the callbacks to call for the content of a fragment
+// parsed within the start tag start, which leave out its element and give
+// the positions within the content
+func (cb *Callbacks) fragment(start string) *Callbacks {
+	if cb == nil {
+		return nil
+	}
+	lines := strings.Count(start, "\n")
+	columns := len(start) - strings.LastIndex(start, "\n") - 1
+	within := func(pos Position) Position {
+		if pos.Line == lines+1 {
+			pos.Column -= columns
+		}
+		pos.Line -= lines
+		pos.Offset -= len(start)
+		pos.EndOffset -= len(start)
+		return pos
+	}
+	depth := 0
+	return &Callbacks{
+		StartElement: func(name xml.Name, attrs []xml.Attr, pos Position) error {
+			depth++
+			if depth == 1 || cb.StartElement == nil {
+				return nil
+			}
+			return cb.StartElement(name, attrs, within(pos))
+		},
+		EndElement: func(name xml.Name, pos Position) error {
+			depth--
+			if depth == 0 || cb.EndElement == nil {
+				return nil
+			}
+			return cb.EndElement(name, within(pos))
+		},
+		Text: func(text []byte, cdata bool, pos Position) error {
+			if cb.Text == nil {
+				return nil
+			}
+			return cb.Text(text, cdata, within(pos))
+		},
+	}
+}
@@ -0,0 +1,224 @@
+package xmlpath
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	charsets   = map[string]func(io.Reader) io.Reader{}
+	charsetsMu sync.RWMutex
+)
+
+// RegisterCharset makes NewReader, and the parsing with DecodeCharset,
+// support the encoding of the given name, of which decode returns a reader of
+// the text read from its argument converted to UTF-8. It makes available the
+// encodings of golang.org/x/text, as in:
+//
+//     xmlpath.RegisterCharset("shift_jis", japanese.ShiftJIS.NewDecoder().Reader)
+//
+// RegisterCharset panics if the name is already used by another encoding.
+func RegisterCharset(name string, decode func(io.Reader) io.Reader) {
+	name = strings.ToLower(name)
+	charsetsMu.Lock()
+	defer charsetsMu.Unlock()
+	if _, dup := charsets[name]; dup || builtinCharsets[name] != "" {
+		panic("xmlpath: charset " + name + " is already registered")
+	}
+	charsets[name] = decode
+}
+
+// builtinCharsets are the names of the encodings supported without
+// RegisterCharset. As in web browsers, the ones of ISO-8859-1 and ASCII stand
+// for windows-1252, which only differs from them in characters left unused.
+var builtinCharsets = map[string]string{
+	"utf-8":             "utf-8",
+	"utf8":              "utf-8",
+	"unicode-1-1-utf-8": "utf-8",
+	"utf-16":            "utf-16le",
+	"utf-16le":          "utf-16le",
+	"utf-16be":          "utf-16be",
+	"windows-1252":      "windows-1252",
+	"cp1252":            "windows-1252",
+	"x-cp1252":          "windows-1252",
+	"iso-8859-1":        "windows-1252",
+	"iso8859-1":         "windows-1252",
+	"iso_8859-1":        "windows-1252",
+	"latin1":            "windows-1252",
+	"l1":                "windows-1252",
+	"us-ascii":          "windows-1252",
+	"ascii":             "windows-1252",
+}
+
+// charsetPrescan is the length of the start of documents in which NewReader
+// looks for their encoding
+const charsetPrescan = 1024
+
+var (
+	xmlEncoding = regexp.MustCompile(`^<\?xml[^>]*\sencoding\s*=\s*["']([^"']+)["']`)
+	metaCharset = regexp.MustCompile(`(?i)<meta\s[^>]*charset\s*=\s*["']?([-\w.:+]+)`)
+)
+
+// DetectCharset returns the name of the encoding of the document starting
+// with prefix, given by its byte order mark, or else by the charset parameter
+// of contentType if not empty, or else by its xml declaration or by a meta
+// element declaring its charset. It returns "utf-8" if the document declares
+// no encoding.
+func DetectCharset(prefix []byte, contentType string) string {
+	switch {
+	case bytes.HasPrefix(prefix, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8"
+	case bytes.HasPrefix(prefix, []byte{0xfe, 0xff}):
+		return "utf-16be"
+	case bytes.HasPrefix(prefix, []byte{0xff, 0xfe}):
+		return "utf-16le"
+	}
+	if contentType != "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+			return strings.ToLower(params["charset"])
+		}
+	}
+	if len(prefix) > charsetPrescan {
+		prefix = prefix[:charsetPrescan]
+	}
+	if m := xmlEncoding.FindSubmatch(prefix); m != nil {
+		return strings.ToLower(string(m[1]))
+	} else if m := metaCharset.FindSubmatch(prefix); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	return "utf-8"
+}
+
+// NewReader returns a reader of the document read from r converted to UTF-8,
+// from the encoding found by DetectCharset in its first bytes, its byte order
+// mark being left out. Besides the encodings registered with RegisterCharset,
+// UTF-8, UTF-16, ISO-8859-1 and windows-1252 are supported. NewReader returns
+// an error if the encoding of the document is not.
+func NewReader(r io.Reader, contentType string) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, charsetPrescan)
+	prefix, err := br.Peek(charsetPrescan)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	name := DetectCharset(prefix, contentType)
+	switch {
+	case bytes.HasPrefix(prefix, []byte{0xef, 0xbb, 0xbf}):
+		br.Discard(3)
+	case bytes.HasPrefix(prefix, []byte{0xfe, 0xff}), bytes.HasPrefix(prefix, []byte{0xff, 0xfe}):
+		br.Discard(2)
+	}
+	switch builtinCharsets[name] {
+	case "utf-8":
+		return br, nil
+	case "utf-16le":
+		return &decodeReader{r: br, decode: decodeUTF16(false)}, nil
+	case "utf-16be":
+		return &decodeReader{r: br, decode: decodeUTF16(true)}, nil
+	case "windows-1252":
+		return &decodeReader{r: br, decode: decodeWindows1252}, nil
+	}
+	charsetsMu.RLock()
+	decode := charsets[name]
+	charsetsMu.RUnlock()
+	if decode == nil {
+		return nil, fmt.Errorf("xmlpath: unsupported charset %q", name)
+	}
+	return decode(br), nil
+}
+
+// decodeReader reads the text of r converted to UTF-8 with decode, which
+// returns the conversion of the longest start of its input that it may
+// convert, and the length of that start. At the end of the input, decode
+// converts all of it.
+type decodeReader struct {
+	r       io.Reader
+	decode  func(src []byte, eof bool) (dst []byte, n int)
+	in, out []byte
+	err     error
+}
+
+func (d *decodeReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		var buf [4096]byte
+		n, err := d.r.Read(buf[:])
+		d.in = append(d.in, buf[:n]...)
+		d.err = err
+		out, used := d.decode(d.in, err != nil)
+		d.out = out
+		d.in = append(d.in[:0], d.in[used:]...)
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// windows1252 are the characters of the bytes from 0x80 to 0x9f in
+// windows-1252, the other bytes being the characters of the same code
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// decodeWindows1252 converts text in windows-1252 to UTF-8
+func decodeWindows1252(src []byte, eof bool) ([]byte, int) {
+	dst := make([]byte, 0, len(src))
+	for _, c := range src {
+		switch {
+		case c < 0x80:
+			dst = append(dst, c)
+		case c < 0xa0:
+			dst = append(dst, string(windows1252[c-0x80])...)
+		default:
+			dst = append(dst, string(rune(c))...)
+		}
+	}
+	return dst, len(src)
+}
+
+// decodeUTF16 returns the function converting text in UTF-16 to UTF-8
+func decodeUTF16(bigEndian bool) func(src []byte, eof bool) ([]byte, int) {
+	unit := func(b []byte) rune {
+		if bigEndian {
+			return rune(b[0])<<8 | rune(b[1])
+		}
+		return rune(b[1])<<8 | rune(b[0])
+	}
+	return func(src []byte, eof bool) ([]byte, int) {
+		dst := make([]byte, 0, len(src))
+		i := 0
+		for ; i+1 < len(src); i += 2 {
+			r := unit(src[i:])
+			if utf16.IsSurrogate(r) {
+				if i+3 < len(src) {
+					if r2 := utf16.DecodeRune(r, unit(src[i+2:])); r2 != utf8.RuneError {
+						r = r2
+						i += 2
+					} else {
+						r = utf8.RuneError
+					}
+				} else if !eof {
+					break
+				} else {
+					r = utf8.RuneError
+				}
+			}
+			dst = append(dst, string(r)...)
+		}
+		if eof && i < len(src) {
+			dst = append(dst, string(utf8.RuneError)...)
+			i = len(src)
+		}
+		return dst, i
+	}
+}
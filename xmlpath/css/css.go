@@ -0,0 +1,584 @@
+// Package css compiles CSS selectors, such as nav a.external[href^='http'],
+// to xmlpath paths matching the same elements.
+//
+// Selectors are translated to XPath expressions, which are compiled with
+// xmlpath. The elements matched are the descendants of the node the path is
+// applied to, as are all the elements matched by the compound selectors of
+// the selector. The following selectors are supported:
+//
+//     - Type and universal selectors, with an optional namespace prefix, as
+//       in svg|circle or *|*, the prefixes being resolved with the
+//       namespaces of the options given to CompileWithOptions
+//     - Class and id selectors
+//     - Attribute selectors, with the =, ~=, |=, ^=, $= and *= operators and
+//       the i flag for comparing values regardless of their case
+//     - The descendant, child (>), next-sibling (+) and subsequent-sibling
+//       (~) combinators, and lists of selectors separated by commas
+//     - The :root, :empty, :first-child, :last-child, :only-child,
+//       :nth-child(), :nth-last-child(), :lang(), :not(), :is() and
+//       :where() pseudo-classes, the last three taking lists of compound
+//       selectors
+//     - The :first-of-type, :last-of-type, :only-of-type, :nth-of-type()
+//       and :nth-last-of-type() pseudo-classes, in compound selectors with
+//       a type selector, as in p:first-of-type
+//
+// Pseudo-elements, and the pseudo-classes depending on the state of user
+// interfaces, such as :hover, are not supported.
+package css // import "github.com/mildred/htmltools/xmlpath/css"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mildred/htmltools/xmlpath"
+)
+
+// MustCompile returns the selector compiled with Compile, and panics if
+// there are any errors.
+func MustCompile(selector string) *xmlpath.Path {
+	return MustCompileWithOptions(selector, xmlpath.Options{})
+}
+
+// Compile returns the path matching the elements matched by selector, in
+// which names are case-sensitive, as in XML documents.
+func Compile(selector string) (*xmlpath.Path, error) {
+	return CompileWithOptions(selector, xmlpath.Options{})
+}
+
+// MustCompileHTML returns the selector compiled with CompileHTML, and panics
+// if there are any errors.
+func MustCompileHTML(selector string) *xmlpath.Path {
+	return MustCompileWithOptions(selector, xmlpath.Options{FoldCase: true})
+}
+
+// CompileHTML returns the path matching the elements matched by selector, in
+// which element and attribute names match regardless of their case, as in
+// HTML documents. The values of attributes, classes and ids remain
+// case-sensitive.
+func CompileHTML(selector string) (*xmlpath.Path, error) {
+	return CompileWithOptions(selector, xmlpath.Options{FoldCase: true})
+}
+
+// CompileWithOptions returns the path matching the elements matched by
+// selector, compiled by xmlpath.CompileWithOptions with the given options.
+func CompileWithOptions(selector string, opts xmlpath.Options) (*xmlpath.Path, error) {
+	path, err := Translate(selector)
+	if err != nil {
+		return nil, err
+	}
+	return xmlpath.CompileWithOptions(path, opts)
+}
+
+// MustCompileWithOptions returns the selector compiled with
+// CompileWithOptions, and panics if there are any errors.
+func MustCompileWithOptions(selector string, opts xmlpath.Options) *xmlpath.Path {
+	path, err := CompileWithOptions(selector, opts)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// Translate returns the XPath expression matching the elements matched by
+// selector, as compiled by Compile.
+func Translate(selector string) (string, error) {
+	t := translator{selector: selector}
+	path, err := t.selectorList(false)
+	if err != nil {
+		return "", err
+	}
+	if t.i < len(t.selector) {
+		return "", t.errorf("unexpected %q", t.selector[t.i:t.i+1])
+	}
+	return path, nil
+}
+
+// SyntaxError is the error returned when a selector fails to compile.
+type SyntaxError struct {
+	Selector string // Selector being compiled
+	Offset   int    // Byte offset in Selector at which the error was detected
+	Msg      string // Description of the error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("compiling css selector %q:%d: %s", e.Selector, e.Offset, e.Msg)
+}
+
+// translator translates a selector to XPath, reading it from the offset i
+type translator struct {
+	selector string
+	i        int
+}
+
+func (t *translator) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Selector: t.selector, Offset: t.i, Msg: fmt.Sprintf(format, args...)}
+}
+
+// selectorList translates the selectors separated by commas at the current
+// position, as a union of paths, or as boolean expressions testing the
+// context node if compound, in which case they may not have combinators
+func (t *translator) selectorList(compound bool) (string, error) {
+	var res []string
+	for {
+		t.space()
+		var s string
+		var err error
+		if compound {
+			s, err = t.compound("self::")
+		} else {
+			s, err = t.complex()
+		}
+		if err != nil {
+			return "", err
+		}
+		res = append(res, s)
+		t.space()
+		if !t.skip(",") {
+			break
+		}
+	}
+	if compound {
+		return strings.Join(res, " or "), nil
+	}
+	return strings.Join(res, " | "), nil
+}
+
+// complex translates a selector made of compound selectors and combinators
+func (t *translator) complex() (string, error) {
+	path, err := t.compound("descendant::")
+	if err != nil {
+		return "", err
+	}
+	for {
+		space := t.space()
+		var axis string
+		switch {
+		case t.skip(">"):
+			axis = "child::"
+		case t.skip("+"):
+			axis = "following-sibling::*[1]/self::"
+		case t.skip("~"):
+			axis = "following-sibling::"
+		case space && t.i < len(t.selector) && t.selector[t.i] != ',' && t.selector[t.i] != ')':
+			axis = "descendant::"
+		default:
+			return path, nil
+		}
+		t.space()
+		step, err := t.compound(axis)
+		if err != nil {
+			return "", err
+		}
+		path += "/" + step
+	}
+}
+
+// compound translates a compound selector, such as a.external[href], to a
+// step of the given axis
+func (t *translator) compound(axis string) (string, error) {
+	start := t.i
+	name, err := t.typeSelector()
+	if err != nil {
+		return "", err
+	}
+	var preds []string
+	for t.i < len(t.selector) {
+		var pred string
+		switch t.selector[t.i] {
+		case '#':
+			t.i++
+			id, err := t.ident()
+			if err != nil {
+				return "", err
+			}
+			pred = "@id = " + literal(id)
+		case '.':
+			t.i++
+			class, err := t.ident()
+			if err != nil {
+				return "", err
+			}
+			pred = "contains(concat(' ', normalize-space(@class), ' '), " + literal(" "+class+" ") + ")"
+		case '[':
+			t.i++
+			if pred, err = t.attribute(); err != nil {
+				return "", err
+			}
+		case ':':
+			t.i++
+			if pred, err = t.pseudoClass(name); err != nil {
+				return "", err
+			}
+		default:
+			if t.i == start {
+				return "", t.errorf("expected a selector")
+			}
+			return step(axis, name, preds), nil
+		}
+		preds = append(preds, pred)
+	}
+	if t.i == start {
+		return "", t.errorf("expected a selector")
+	}
+	return step(axis, name, preds), nil
+}
+
+// step returns the step of the given axis, name test and predicates
+func step(axis, name string, preds []string) string {
+	s := axis + name
+	for _, pred := range preds {
+		s += "[" + pred + "]"
+	}
+	return s
+}
+
+// typeSelector translates the type or universal selector at the current
+// position, if any, to a name test
+func (t *translator) typeSelector() (string, error) {
+	if t.i >= len(t.selector) || !t.peekIdent() && t.selector[t.i] != '*' {
+		return "*", nil
+	}
+	name, err := t.qualifiedName(true)
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// qualifiedName reads a name, with an optional namespace prefix, and returns
+// it as in XPath, as svg:circle, the name being possibly * if wildcard
+func (t *translator) qualifiedName(wildcard bool) (string, error) {
+	part := func() (string, error) {
+		if wildcard && t.skip("*") {
+			return "*", nil
+		}
+		return t.ident()
+	}
+	name, err := part()
+	if err != nil {
+		return "", err
+	}
+	// | followed by = is the |= operator of attribute selectors
+	if strings.HasPrefix(t.selector[t.i:], "|") && !strings.HasPrefix(t.selector[t.i:], "|=") {
+		t.i++
+		wildcard = true
+		local, err := part()
+		if err != nil {
+			return "", err
+		}
+		name += ":" + local
+	}
+	return name, nil
+}
+
+// attribute translates the attribute selector following [
+func (t *translator) attribute() (string, error) {
+	t.space()
+	name, err := t.qualifiedName(false)
+	if err != nil {
+		return "", err
+	}
+	attr := "@" + name
+	t.space()
+	if t.skip("]") {
+		return attr, nil
+	}
+	var op string
+	for _, o := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+		if t.skip(o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return "", t.errorf("expected an attribute operator")
+	}
+	t.space()
+	var value string
+	if t.i < len(t.selector) && (t.selector[t.i] == '"' || t.selector[t.i] == '\'') {
+		value, err = t.str()
+	} else {
+		value, err = t.ident()
+	}
+	if err != nil {
+		return "", err
+	}
+	t.space()
+	val := literal(value)
+	if t.i < len(t.selector) && (t.selector[t.i] == 'i' || t.selector[t.i] == 'I') {
+		t.i++
+		t.space()
+		attr, val = "lower-case("+attr+")", literal(strings.ToLower(value))
+	} else if t.skip("s") || t.skip("S") {
+		t.space()
+	}
+	if !t.skip("]") {
+		return "", t.errorf("expected ]")
+	}
+	switch op {
+	case "=":
+		return attr + " = " + val, nil
+	case "~=":
+		if value == "" || strings.ContainsAny(value, " \t\r\n\f") {
+			return "false()", nil
+		}
+		return "contains(concat(' ', normalize-space(" + attr + "), ' '), concat(' ', " + val + ", ' '))", nil
+	case "|=":
+		return attr + " = " + val + " or starts-with(" + attr + ", concat(" + val + ", '-'))", nil
+	}
+	if value == "" {
+		return "false()", nil
+	}
+	switch op {
+	case "^=":
+		return "starts-with(" + attr + ", " + val + ")", nil
+	case "$=":
+		return "ends-with(" + attr + ", " + val + ")", nil
+	default:
+		return "contains(" + attr + ", " + val + ")", nil
+	}
+}
+
+// pseudoClass translates the pseudo-class following :, in a compound
+// selector with the name test name
+func (t *translator) pseudoClass(name string) (string, error) {
+	if strings.HasPrefix(t.selector[t.i:], ":") {
+		return "", t.errorf("pseudo-elements are not supported")
+	}
+	start := t.i
+	class, err := t.ident()
+	if err != nil {
+		return "", err
+	}
+	class = strings.ToLower(class)
+	ofType := strings.HasSuffix(class, "-of-type")
+	if ofType && (name == "*" || strings.HasSuffix(name, ":*")) {
+		t.i = start
+		return "", t.errorf(":%s needs a type selector", class)
+	}
+	preceding, following := "preceding-sibling::*", "following-sibling::*"
+	if ofType {
+		preceding, following = "preceding-sibling::"+name, "following-sibling::"+name
+	}
+	switch class {
+	case "root":
+		return "not(../..)", nil
+	case "empty":
+		return "not(*) and not(text())", nil
+	case "first-child", "first-of-type":
+		return "not(" + preceding + ")", nil
+	case "last-child", "last-of-type":
+		return "not(" + following + ")", nil
+	case "only-child", "only-of-type":
+		return "not(" + preceding + ") and not(" + following + ")", nil
+	}
+	if !t.skip("(") {
+		t.i = start
+		return "", t.errorf("unsupported pseudo-class :%s", class)
+	}
+	t.space()
+	var pred string
+	switch class {
+	case "nth-child", "nth-of-type":
+		pred, err = t.nth(preceding)
+	case "nth-last-child", "nth-last-of-type":
+		pred, err = t.nth(following)
+	case "lang":
+		var lang string
+		if lang, err = t.ident(); err == nil {
+			pred = "lang(" + literal(lang) + ")"
+		}
+	case "not":
+		if pred, err = t.selectorList(true); err == nil {
+			pred = "not(" + pred + ")"
+		}
+	case "is", "where":
+		pred, err = t.selectorList(true)
+	default:
+		t.i = start
+		return "", t.errorf("unsupported pseudo-class :%s()", class)
+	}
+	if err != nil {
+		return "", err
+	}
+	t.space()
+	if !t.skip(")") {
+		return "", t.errorf("expected )")
+	}
+	return pred, nil
+}
+
+// nth translates the an+b argument of the :nth- pseudo-classes, the
+// position of the elements being counted among the given siblings
+func (t *translator) nth(siblings string) (string, error) {
+	end := strings.IndexByte(t.selector[t.i:], ')')
+	if end < 0 {
+		return "", t.errorf("expected )")
+	}
+	arg := strings.ToLower(strings.Join(strings.Fields(t.selector[t.i:t.i+end]), ""))
+	a, b, ok := parseNth(arg)
+	if !ok {
+		return "", t.errorf("invalid argument %q", arg)
+	}
+	t.i += end
+	pos := "(count(" + siblings + ") + 1)"
+	switch {
+	case a == 0:
+		return pos + " = " + strconv.Itoa(b), nil
+	case a > 0 && b <= 1:
+		return "(" + pos + " - " + strconv.Itoa(b) + ") mod " + strconv.Itoa(a) + " = 0", nil
+	case a > 0:
+		return pos + " >= " + strconv.Itoa(b) + " and (" + pos + " - " + strconv.Itoa(b) + ") mod " + strconv.Itoa(a) + " = 0", nil
+	default:
+		return pos + " <= " + strconv.Itoa(b) + " and (" + strconv.Itoa(b) + " - " + pos + ") mod " + strconv.Itoa(-a) + " = 0", nil
+	}
+}
+
+// parseNth parses an+b, without spaces, as in 2n+1, -n+3, odd or 5
+func parseNth(arg string) (a, b int, ok bool) {
+	switch arg {
+	case "odd":
+		return 2, 1, true
+	case "even":
+		return 2, 0, true
+	}
+	n := strings.IndexByte(arg, 'n')
+	if n < 0 {
+		b, err := strconv.Atoi(arg)
+		return 0, b, err == nil
+	}
+	switch coef := arg[:n]; coef {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		var err error
+		if a, err = strconv.Atoi(coef); err != nil {
+			return 0, 0, false
+		}
+	}
+	if rest := arg[n+1:]; rest != "" {
+		if rest[0] != '+' && rest[0] != '-' {
+			return 0, 0, false
+		}
+		var err error
+		if b, err = strconv.Atoi(rest); err != nil {
+			return 0, 0, false
+		}
+	}
+	return a, b, true
+}
+
+// space skips white space, and returns whether there was any
+func (t *translator) space() bool {
+	start := t.i
+	for t.i < len(t.selector) && strings.IndexByte(" \t\r\n\f", t.selector[t.i]) >= 0 {
+		t.i++
+	}
+	return t.i > start
+}
+
+// skip skips s if at the current position, and returns whether it was
+func (t *translator) skip(s string) bool {
+	if strings.HasPrefix(t.selector[t.i:], s) {
+		t.i += len(s)
+		return true
+	}
+	return false
+}
+
+// peekIdent returns whether an identifier starts at the current position
+func (t *translator) peekIdent() bool {
+	s := t.selector[t.i:]
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	return s != "" && (isNameStart(s[0]) || s[0] == '-' || s[0] == '\\')
+}
+
+// ident reads an identifier, resolving its escapes
+func (t *translator) ident() (string, error) {
+	if !t.peekIdent() {
+		return "", t.errorf("expected an identifier")
+	}
+	var res []byte
+	for t.i < len(t.selector) {
+		c := t.selector[t.i]
+		switch {
+		case c == '\\':
+			t.i++
+			res = t.escape(res)
+		case isNameStart(c) || c == '-' || c >= '0' && c <= '9':
+			res = append(res, c)
+			t.i++
+		default:
+			return string(res), nil
+		}
+	}
+	return string(res), nil
+}
+
+// str reads a quoted string, resolving its escapes
+func (t *translator) str() (string, error) {
+	quote := t.selector[t.i]
+	t.i++
+	var res []byte
+	for t.i < len(t.selector) {
+		c := t.selector[t.i]
+		t.i++
+		switch c {
+		case quote:
+			return string(res), nil
+		case '\\':
+			if strings.HasPrefix(t.selector[t.i:], "\n") {
+				t.i++
+			} else {
+				res = t.escape(res)
+			}
+		default:
+			res = append(res, c)
+		}
+	}
+	return "", t.errorf("unterminated string")
+}
+
+// escape appends to res the character escaped by the backslash before the
+// current position, given by up to 6 hexadecimal digits or as is
+func (t *translator) escape(res []byte) []byte {
+	hex := 0
+	for hex < 6 && t.i+hex < len(t.selector) && strings.IndexByte("0123456789abcdefABCDEF", t.selector[t.i+hex]) >= 0 {
+		hex++
+	}
+	if hex > 0 {
+		r, _ := strconv.ParseUint(t.selector[t.i:t.i+hex], 16, 32)
+		t.i += hex
+		if t.i < len(t.selector) && strings.IndexByte(" \t\r\n\f", t.selector[t.i]) >= 0 {
+			t.i++
+		}
+		if r == 0 || r > utf8.MaxRune {
+			r = utf8.RuneError
+		}
+		return append(res, string(rune(r))...)
+	}
+	if t.i >= len(t.selector) {
+		return append(res, string(utf8.RuneError)...)
+	}
+	_, size := utf8.DecodeRuneInString(t.selector[t.i:])
+	res = append(res, t.selector[t.i:t.i+size]...)
+	t.i += size
+	return res
+}
+
+// isNameStart returns whether c may start an identifier, all the bytes of
+// non-ASCII characters being allowed
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+// literal returns s as an XPath literal, in which quotes are doubled
+func literal(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
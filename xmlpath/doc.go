@@ -0,0 +1,214 @@
+// Package xmlpath implements a strict subset of the XPath specification for the Go language.
+//
+// The XPath specification is available at:
+//
+//     http://www.w3.org/TR/xpath
+//
+// Path expressions supported by this package are in the following format,
+// with all components being optional:
+//
+//     /axis-name::node-test[predicate]/axis-name::node-test[predicate]
+//
+// At the moment, xmlpath is compatible with the XPath specification
+// to the following extent:
+//
+//     - All axes are supported ("child", "following-sibling", etc)
+//     - All abbreviated forms are supported (".", "//", etc)
+//     - All node types except for namespace are supported; the namespace
+//       axis selects instead the xmlns attributes declaring the namespaces
+//       in scope, the string value of which is the namespace URI
+//     - Name tests may use a wildcard for the local name or the namespace,
+//       as in svg:* or *:section
+//     - Attribute wildcards (@* and @node()) select all the attributes of
+//       an element except for namespace declarations
+//     - Predicates support paths (including descendant shortcuts, as in
+//       div[.//a], and absolute paths evaluated from the root node, as in
+//       a[@href = /html/head/base/@href]), literals (in which a quote is
+//       escaped by doubling it, as in 'it''s'), numbers, variables, the
+//       functions listed below, comparisons (=, !=, <, <=, > and >=,
+//       including between two node-sets),
+//       arithmetic (+, -, *, div and mod) and boolean operators
+//     - Positions on reverse axes count from the context node, so that
+//       preceding-sibling::p[1] is the nearest preceding p, but the nodes
+//       matched by a path are always returned in document order
+//     - Node tests may be used as predicate operands, as in
+//       [processing-instruction('xml-stylesheet') = 'href="a.xsl"'],
+//       [comment() = ' generated '] or [text() != '']
+//     - Several predicates may follow a step, each one filtering the nodes
+//       selected by the previous one, as in li[@class='x'][2]
+//     - Paths may be combined with the union (|), intersect and except
+//       operators
+//     - Predicates and relative paths may follow parenthesized expressions
+//       and function calls, as in (//h1 | //h2)[1] or id('foo')/a
+//     - Prefixes are resolved with the namespaces given to CompileNS, the
+//       empty prefix giving the default namespace of unprefixed element
+//       names (such as XHTMLNamespace)
+//     - Paths compiled with CompileHTML match element and attribute names
+//       regardless of their case
+//     - CompileWithOptions gathers these options, along with functions
+//       only available to the path being compiled
+//     - Richer expressions are not supported
+//
+// The following functions are supported in predicates:
+//
+//     - position() and last()
+//     - not(expr), true() and false()
+//     - string(), string(expr), number(), number(expr) and boolean(expr)
+//     - id(ids) and lang(lang)
+//     - key(name, value), for keys declared with Node.AddKey
+//     - name(), local-name() and namespace-uri(), with an optional node-set
+//       argument
+//     - count(nodes) and sum(nodes)
+//     - floor(num), ceiling(num) and round(num)
+//     - concat(str, str, ...)
+//     - contains(str, substr), starts-with(str, prefix) and
+//       ends-with(str, suffix)
+//     - substring(str, start) and substring(str, start, length)
+//     - substring-before(str, sep) and substring-after(str, sep)
+//     - translate(str, from, to), lower-case(str) and upper-case(str)
+//     - matches(str, pattern) and matches(str, pattern, flags), using the
+//       syntax and flags of the regexp package
+//     - string-length() and string-length(str)
+//     - normalize-space() and normalize-space(str)
+//
+// Other functions may be made available with RegisterFunc. Predicates may also
+// refer to $name variables, bound with Path.IterWithVars.
+//
+// Expressions that do not evaluate to node-sets, such as count(//a) or
+// string(//title), may be compiled as well. Their value is obtained with
+// Path.Eval, the other methods of Path only going over node-sets.
+//
+// Compiled paths may be applied concurrently. Programs compiling the same
+// paths repeatedly may memoize them with CompileCached or a Cache.
+//
+// Documents parsed or added into a NodeSet may be queried at once with
+// Path.IterAll, which also tells the document of each matching node, or
+// concurrently with Path.MatchAll. Programs parsing the same files
+// repeatedly, such as a documentation tree being edited, may keep them in a
+// Store, which only parses again the files whose content changed.
+//
+// Documents may also be walked without paths, with Node.Parent,
+// Node.Children, Node.FirstChild, Node.NextSibling and their likes, and the
+// attributes of elements read with Node.Attr, Node.AttrNS and Node.Attrs.
+// The namespaces in scope for a node are given by Node.FindNamespaces,
+// Node.LookupPrefix and Node.LookupNamespace, and the qualified names found
+// in attribute values are resolved with Node.ResolveQName. Node.Compare
+// tells whether a node comes before, after, inside or around another one,
+// and SortNodes sorts nodes in document order without duplicates.
+//
+// Documents may be modified through the NodeRef of their nodes, obtained
+// with Iter.Nodes, which keep referring to the same nodes as the documents
+// change, so that they also identify nodes, as keys of maps. Node.WriteXML and Node.WriteXMLIndent write them back as XML, and
+// Node.WriteHTML as HTML. Node.OuterHTML and Node.InnerHTML, and their XML
+// equivalents, return the markup of a node or of its content as a string.
+// Documents parsed with the KeepSource option are written as they were
+// parsed, except where they were modified. Node.WriteJSON dumps the tree of
+// nodes as JSON, for other programs to read, and Node.WriteC14N and C14N
+// write canonical XML, to hash or sign documents regardless of their
+// formatting. The text
+// of CDATA sections is text as any other, but Node.CDATA tells where it comes
+// from, and Node.WriteXML writes it back as CDATA sections.
+//
+// The document type declaration of documents is given by Node.Doctype, which
+// tells with Doctype.QuirksMode how web browsers display HTML documents.
+//
+// The nodes which were parsed tell where they come from in the document with
+// Node.Position, as a line and a column, and with Node.Offsets, as byte
+// offsets.
+//
+// Many paths may be compiled together with CompileAll, so that a MultiPath
+// finds the nodes they match in a single pass over the document, telling the
+// path matching each node, rather than going over the document for each path.
+//
+// The fragments of URIs pointing into XML documents, such as the ones of
+// XInclude, may be resolved with CompileXPointer, which supports shorthand
+// ids and the element(), xpointer(), xpath1() and xmlns() schemes.
+//
+// A Transform applies templates to the nodes matched by the paths of its
+// rules, in document order, to produce reports or the new values of the
+// nodes declaratively.
+//
+// The work done by each step of a path, in nodes visited and selected, may be
+// traced with Path.IterTrace to understand why a path is slow.
+//
+// Paths made of forward steps without predicates, such as //book/title or
+// //book/@id, may also be applied to large documents without parsing them
+// entirely with Path.Stream, which reports the matching nodes as they are
+// decoded.
+//
+// For example, assuming the following document:
+//
+//     <library>
+//       <!-- Great book. -->
+//       <book id="b0836217462" available="true">
+//         <isbn>0836217462</isbn>
+//         <title lang="en">Being a Dog Is a Full-Time Job</title>
+//         <quote>I'd dog paddle the deepest ocean.</quote>
+//         <author id="CMS">
+//           <?echo "go rocks"?>
+//           <name>Charles M Schulz</name>
+//           <born>1922-11-26</born>
+//           <dead>2000-02-12</dead>
+//         </author>
+//         <character id="PP">
+//           <name>Peppermint Patty</name>
+//           <born>1966-08-22</born>
+//           <qualification>bold, brash and tomboyish</qualification>
+//         </character>
+//         <character id="Snoopy">
+//           <name>Snoopy</name>
+//           <born>1950-10-04</born>
+//           <qualification>extroverted beagle</qualification>
+//         </character>
+//       </book>
+//     </library>
+//
+// The following examples are valid path expressions, and the first
+// match has the indicated value:
+//
+//     /library/book/isbn                               =>  "0836217462"
+//     library/*/isbn                                   =>  "0836217462"
+//     /library/book/../book/./isbn                     =>  "0836217462"
+//     /library/book/character[2]/name                  =>  "Snoopy"
+//     /library/book/character[born='1950-10-04']/name  =>  "Snoopy"
+//     /library/book//node()[@id='PP']/name             =>  "Peppermint Patty"
+//     //book[author/@id='CMS']/title                   =>  "Being a Dog Is a Full-Time Job"},
+//     /library/book/preceding::comment()               =>  " Great book. "
+//
+// To run an expression, compile it, and then apply the compiled path to any
+// number of context nodes, from one or more parsed xml documents:
+//
+//     path := xmlpath.MustCompile("/library/book/isbn")
+//     root, err := xmlpath.Parse(file)
+//     if err != nil {
+//             log.Fatal(err)
+//     }
+//     if value, ok := path.String(root); ok {
+//             fmt.Println("Found:", value)
+//     }
+//
+// HTML documents may be parsed with ParseHTML when they are close to being
+// well-formed, which does not include the markup in the text of scripts and
+// styles, and as web browsers do with the html5 subpackage otherwise.
+// Markup without a single root element, such as template partials, is parsed
+// with ParseFragment and ParseHTMLFragment. Documents in other encodings than
+// UTF-8 are converted when parsed with the DecodeCharset option, or read
+// through NewReader. The text nodes made only of white space, which depend on
+// the formatting of documents, are left out with the StripSpace and
+// StripDefaultSpace options, the latter respecting xml:space. Untrusted
+// documents may be parsed with the methods of Limits, which reject the ones
+// with document type definitions, too many entity references, or elements
+// nested too deep. The methods of Callbacks call functions for the elements
+// and the text as they are parsed, to gather information on documents, such
+// as their ids, without going over them again, and report the progress of
+// the parsing of large documents.
+//
+// The css subpackage compiles CSS selectors, as in
+// css.MustCompileHTML("nav a.external"), to paths matching the same elements.
+//
+// This package started as a fork of launchpad.net/xmlpath, which it extends
+// with the features above and with the modification of the parsed documents.
+// It is imported as github.com/mildred/htmltools/xmlpath, and its exported
+// API only changes in backward compatible ways.
+//
+package xmlpath // import "github.com/mildred/htmltools/xmlpath"
@@ -0,0 +1,186 @@
+package xmlpath
+
+import (
+	"strings"
+)
+
+// Doctype is the document type declaration of a document, such as
+// <!DOCTYPE html>.
+type Doctype struct {
+	Name     string // Name of the root element, such as html
+	PublicID string // Public identifier, empty if missing
+	SystemID string // System identifier, empty if missing
+}
+
+// Doctype returns the document type declaration which comes before the root
+// element of the document of n, or nil if it has none.
+func (n *Node) Doctype() *Doctype {
+	if n.doc == nil {
+		return nil
+	}
+	return n.doc.doctype
+}
+
+// ParseDoctype parses the content of a document type declaration following
+// the DOCTYPE keyword, as in html PUBLIC "-//W3C//DTD HTML 4.01//EN". The
+// internal subset of XML documents is left out.
+func ParseDoctype(s string) *Doctype {
+	dt := &Doctype{}
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " \t\r\n[")
+	if i < 0 {
+		i = len(s)
+	}
+	dt.Name, s = s[:i], strings.TrimSpace(s[i:])
+	switch {
+	case len(s) >= 6 && strings.EqualFold(s[:6], "PUBLIC"):
+		dt.PublicID, s = doctypeLiteral(s[6:])
+		dt.SystemID, _ = doctypeLiteral(s)
+	case len(s) >= 6 && strings.EqualFold(s[:6], "SYSTEM"):
+		dt.SystemID, _ = doctypeLiteral(s[6:])
+	}
+	return dt
+}
+
+// doctypeLiteral returns the quoted literal at the start of s, and what
+// follows it
+func doctypeLiteral(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if s == "" || s[0] != '"' && s[0] != '\'' {
+		return "", s
+	}
+	end := strings.IndexByte(s[1:], s[0])
+	if end < 0 {
+		return s[1:], ""
+	}
+	return s[1 : end+1], s[end+2:]
+}
+
+// String returns the declaration of dt, as in <!DOCTYPE html>.
+func (dt *Doctype) String() string {
+	s := "<!DOCTYPE " + dt.Name
+	if dt.PublicID != "" {
+		s += ` PUBLIC "` + dt.PublicID + `"`
+		if dt.SystemID != "" {
+			s += ` "` + dt.SystemID + `"`
+		}
+	} else if dt.SystemID != "" {
+		s += ` SYSTEM "` + dt.SystemID + `"`
+	}
+	return s + ">"
+}
+
+// Mode is the rendering mode in which web browsers display HTML documents,
+// which depends on their document type declaration.
+type Mode int
+
+const (
+	NoQuirks      Mode = iota // Standards mode
+	LimitedQuirks             // Almost standards mode
+	Quirks                    // Emulation of legacy browsers
+)
+
+func (m Mode) String() string {
+	switch m {
+	case NoQuirks:
+		return "no-quirks"
+	case LimitedQuirks:
+		return "limited-quirks"
+	case Quirks:
+		return "quirks"
+	}
+	return "invalid mode"
+}
+
+// QuirksMode returns the mode in which web browsers display the HTML
+// documents declared with dt, as specified by HTML5. Documents without a
+// declaration, for which dt is nil, are displayed in quirks mode. An empty
+// identifier is taken as missing.
+func (dt *Doctype) QuirksMode() Mode {
+	if dt == nil || !strings.EqualFold(dt.Name, "html") {
+		return Quirks
+	}
+	public, system := strings.ToLower(dt.PublicID), strings.ToLower(dt.SystemID)
+	switch public {
+	case "-//w3o//dtd w3 html strict 3.0//en//", "-/w3c/dtd html 4.0 transitional/en", "html":
+		return Quirks
+	}
+	if system == "http://www.ibm.com/data/dtd/v11/ibmxhtml1-transitional.dtd" {
+		return Quirks
+	}
+	for _, prefix := range quirksPublicPrefixes {
+		if strings.HasPrefix(public, prefix) {
+			return Quirks
+		}
+	}
+	if strings.HasPrefix(public, "-//w3c//dtd html 4.01 frameset//") || strings.HasPrefix(public, "-//w3c//dtd html 4.01 transitional//") {
+		if system == "" {
+			return Quirks
+		}
+		return LimitedQuirks
+	}
+	if strings.HasPrefix(public, "-//w3c//dtd xhtml 1.0 frameset//") || strings.HasPrefix(public, "-//w3c//dtd xhtml 1.0 transitional//") {
+		return LimitedQuirks
+	}
+	return NoQuirks
+}
+
+// quirksPublicPrefixes are the starts of the public identifiers, in lower
+// case, of the documents displayed in quirks mode
+var quirksPublicPrefixes = []string{
+	"+//silmaril//dtd html pro v0r11 19970101//",
+	"-//as//dtd html 3.0 aswedit + extensions//",
+	"-//advasoft ltd//dtd html 3.0 aswedit + extensions//",
+	"-//ietf//dtd html 2.0 level 1//",
+	"-//ietf//dtd html 2.0 level 2//",
+	"-//ietf//dtd html 2.0 strict level 1//",
+	"-//ietf//dtd html 2.0 strict level 2//",
+	"-//ietf//dtd html 2.0 strict//",
+	"-//ietf//dtd html 2.0//",
+	"-//ietf//dtd html 2.1e//",
+	"-//ietf//dtd html 3.0//",
+	"-//ietf//dtd html 3.2 final//",
+	"-//ietf//dtd html 3.2//",
+	"-//ietf//dtd html 3//",
+	"-//ietf//dtd html level 0//",
+	"-//ietf//dtd html level 1//",
+	"-//ietf//dtd html level 2//",
+	"-//ietf//dtd html level 3//",
+	"-//ietf//dtd html strict level 0//",
+	"-//ietf//dtd html strict level 1//",
+	"-//ietf//dtd html strict level 2//",
+	"-//ietf//dtd html strict level 3//",
+	"-//ietf//dtd html strict//",
+	"-//ietf//dtd html//",
+	"-//metrius//dtd metrius presentational//",
+	"-//microsoft//dtd internet explorer 2.0 html strict//",
+	"-//microsoft//dtd internet explorer 2.0 html//",
+	"-//microsoft//dtd internet explorer 2.0 tables//",
+	"-//microsoft//dtd internet explorer 3.0 html strict//",
+	"-//microsoft//dtd internet explorer 3.0 html//",
+	"-//microsoft//dtd internet explorer 3.0 tables//",
+	"-//netscape comm. corp.//dtd html//",
+	"-//netscape comm. corp.//dtd strict html//",
+	"-//o'reilly and associates//dtd html 2.0//",
+	"-//o'reilly and associates//dtd html extended 1.0//",
+	"-//o'reilly and associates//dtd html extended relaxed 1.0//",
+	"-//sq//dtd html 2.0 hotmetal + extensions//",
+	"-//softquad software//dtd hotmetal pro 6.0::19990601::extensions to html 4.0//",
+	"-//softquad//dtd hotmetal pro 4.0::19971010::extensions to html 4.0//",
+	"-//spyglass//dtd html 2.0 extended//",
+	"-//sun microsystems corp.//dtd hotjava html//",
+	"-//sun microsystems corp.//dtd hotjava strict html//",
+	"-//w3c//dtd html 3 1995-03-24//",
+	"-//w3c//dtd html 3.2 draft//",
+	"-//w3c//dtd html 3.2 final//",
+	"-//w3c//dtd html 3.2//",
+	"-//w3c//dtd html 3.2s draft//",
+	"-//w3c//dtd html 4.0 frameset//",
+	"-//w3c//dtd html 4.0 transitional//",
+	"-//w3c//dtd html experimental 19960712//",
+	"-//w3c//dtd html experimental 970421//",
+	"-//w3c//dtd w3 html//",
+	"-//w3o//dtd w3 html 3.0//",
+	"-//webtechs//dtd mozilla html 2.0//",
+	"-//webtechs//dtd mozilla html//",
+}
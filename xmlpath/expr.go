@@ -0,0 +1,453 @@
+package xmlpath
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value is the result of the evaluation of an expression. It is one of bool,
+// float64, string or []*Node (a node-set, in document order).
+type Value interface{}
+
+// exprContext is the context in which an expression is evaluated.
+type exprContext struct {
+	// Context node
+	node *Node
+
+	// Context position, starting at 1
+	pos int
+
+	// Context size. It is only computed when the expression uses last()
+	size int
+
+	// Variable bindings
+	vars map[string]Value
+}
+
+type expr interface {
+	eval(ctx *exprContext) Value
+}
+
+// boolExpr is implemented by expressions that can compute their boolean
+// value without computing their full value first.
+type boolExpr interface {
+	evalBool(ctx *exprContext) bool
+}
+
+func evalBool(e expr, ctx *exprContext) bool {
+	if b, ok := e.(boolExpr); ok {
+		return b.evalBool(ctx)
+	}
+	return toBool(e.eval(ctx))
+}
+
+// evalPredicate returns whether the predicate e holds in ctx. A number is a
+// shorthand for a test on the context position.
+func evalPredicate(e expr, ctx *exprContext) bool {
+	if b, ok := e.(boolExpr); ok {
+		return b.evalBool(ctx)
+	}
+	v := e.eval(ctx)
+	if n, ok := v.(float64); ok {
+		return n == float64(ctx.pos)
+	}
+	return toBool(v)
+}
+
+// stringExpr is implemented by expressions that can compute their string
+// value without computing their full value first.
+type stringExpr interface {
+	evalString(ctx *exprContext) string
+}
+
+func evalString(e expr, ctx *exprContext) string {
+	if s, ok := e.(stringExpr); ok {
+		return s.evalString(ctx)
+	}
+	return toString(e.eval(ctx))
+}
+
+// normalizeValue converts the Go types close to the ones of Value
+func normalizeValue(v Value) Value {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return string(v)
+	case *Node:
+		return []*Node{v}
+	case nil:
+		return []*Node(nil)
+	}
+	return v
+}
+
+func toBool(v Value) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return len(v) > 0
+	case []*Node:
+		return len(v) > 0
+	}
+	return false
+}
+
+func toNumber(v Value) float64 {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case float64:
+		return v
+	case string:
+		return stringToNumber(v)
+	case []*Node:
+		return stringToNumber(toString(v))
+	}
+	return math.NaN()
+}
+
+// stringToNumber converts a string to a number. Only decimal numbers
+// surrounded by whitespace are valid, anything else is NaN.
+func stringToNumber(s string) float64 {
+	s = strings.Trim(s, " \t\r\n")
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || digits == "." || strings.Trim(digits, "0123456789.") != "" || strings.Count(digits, ".") > 1 {
+		return math.NaN()
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return n
+}
+
+func toString(v Value) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return formatNumber(v)
+	case string:
+		return v
+	case []*Node:
+		if len(v) > 0 {
+			return v[0].String()
+		}
+	}
+	return ""
+}
+
+// roundNumber rounds n to the closest integer, rounding halves towards
+// positive infinity
+func roundNumber(n float64) float64 {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return n
+	}
+	return math.Floor(n + 0.5)
+}
+
+func formatNumber(n float64) string {
+	switch {
+	case math.IsNaN(n):
+		return "NaN"
+	case math.IsInf(n, 1):
+		return "Infinity"
+	case math.IsInf(n, -1):
+		return "-Infinity"
+	case n == 0:
+		// Negative zero too
+		return "0"
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+type exprOpEq struct {
+	lval *Path
+	rval string
+}
+
+func (e *exprOpEq) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprOpEq) evalBool(ctx *exprContext) bool {
+	iter := e.lval.iterAny(ctx.node, ctx.vars)
+	for iter.Next() {
+		if iter.Node().equals(e.rval) {
+			return true
+		}
+	}
+	return false
+}
+
+type exprOpOr struct {
+	vals []expr
+}
+
+func (e *exprOpOr) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprOpOr) evalBool(ctx *exprContext) bool {
+	for _, e := range e.vals {
+		if evalBool(e, ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+type exprOpAnd struct {
+	vals []expr
+}
+
+func (e *exprOpAnd) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprOpAnd) evalBool(ctx *exprContext) bool {
+	for _, e := range e.vals {
+		if !evalBool(e, ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// exprCompare compares two values
+type exprCompare struct {
+	op   string
+	lval expr
+	rval expr
+}
+
+func (e *exprCompare) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprCompare) evalBool(ctx *exprContext) bool {
+	return compareValues(e.op, e.lval.eval(ctx), e.rval.eval(ctx))
+}
+
+// compareValues compares two values according to the XPath rules. When a
+// node-set is involved, the comparison is true if it is true for any of its
+// nodes.
+func compareValues(op string, l, r Value) bool {
+	ln, lset := l.([]*Node)
+	rn, rset := r.([]*Node)
+	switch {
+	case lset && rset:
+		return compareNodeSets(op, ln, rn)
+	case rset:
+		return compareValues(reverseOp(op), r, l)
+	case lset:
+		if b, ok := r.(bool); ok {
+			return compareScalars(op, len(ln) > 0, b)
+		}
+		for _, a := range ln {
+			var v Value = a.String()
+			if _, ok := r.(float64); ok {
+				v = toNumber(v)
+			}
+			if compareScalars(op, v, r) {
+				return true
+			}
+		}
+		return false
+	}
+	return compareScalars(op, l, r)
+}
+
+// compareNodeSets compares two node-sets, which is true if the comparison is
+// true for the string values of a node of each
+func compareNodeSets(op string, l, r []*Node) bool {
+	rs := make([]Value, len(r))
+	for i, b := range r {
+		rs[i] = b.String()
+	}
+	if op == "=" {
+		set := make(map[Value]bool, len(rs))
+		for _, b := range rs {
+			set[b] = true
+		}
+		for _, a := range l {
+			if set[a.String()] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, a := range l {
+		var as Value = a.String()
+		if op != "!=" {
+			// Relational operators compare numbers
+			as = toNumber(as)
+		}
+		for _, b := range rs {
+			if compareScalars(op, as, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reverseOp returns the operator to use when swapping the operands
+func reverseOp(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return op
+}
+
+func compareScalars(op string, l, r Value) bool {
+	if op == "=" || op == "!=" {
+		_, lbool := l.(bool)
+		_, rbool := r.(bool)
+		_, lnum := l.(float64)
+		_, rnum := r.(float64)
+		switch {
+		case lbool || rbool:
+			return (toBool(l) == toBool(r)) == (op == "=")
+		case lnum || rnum:
+			// NaN is different from everything, including itself
+			if op == "!=" {
+				return toNumber(l) != toNumber(r)
+			}
+			return toNumber(l) == toNumber(r)
+		default:
+			return (toString(l) == toString(r)) == (op == "=")
+		}
+	}
+	ln, rn := toNumber(l), toNumber(r)
+	switch op {
+	case "<":
+		return ln < rn
+	case "<=":
+		return ln <= rn
+	case ">":
+		return ln > rn
+	case ">=":
+		return ln >= rn
+	}
+	return false
+}
+
+// exprArith is an arithmetic operation on two numbers
+type exprArith struct {
+	op   string
+	lval expr
+	rval expr
+}
+
+func (e *exprArith) eval(ctx *exprContext) Value {
+	l, r := toNumber(e.lval.eval(ctx)), toNumber(e.rval.eval(ctx))
+	switch e.op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "div":
+		return l / r
+	case "mod":
+		return math.Mod(l, r)
+	}
+	return math.NaN()
+}
+
+// exprNegate is the unary minus
+type exprNegate struct {
+	val expr
+}
+
+func (e *exprNegate) eval(ctx *exprContext) Value {
+	return -toNumber(e.val.eval(ctx))
+}
+
+type exprString struct {
+	val string
+}
+
+func (e *exprString) eval(ctx *exprContext) Value {
+	return e.val
+}
+
+type exprNumber struct {
+	val float64
+}
+
+func (e *exprNumber) eval(ctx *exprContext) Value {
+	return e.val
+}
+
+type exprBool struct {
+	val bool
+}
+
+func (e *exprBool) eval(ctx *exprContext) Value {
+	return e.val
+}
+
+func (e *exprBool) evalBool(ctx *exprContext) bool {
+	return e.val
+}
+
+type exprPath struct {
+	path *Path
+}
+
+func (e *exprPath) eval(ctx *exprContext) Value {
+	return e.path.iter(ctx.node, ctx.vars).nodes()
+}
+
+func (e *exprPath) evalBool(ctx *exprContext) bool {
+	return e.path.iterAny(ctx.node, ctx.vars).Next()
+}
+
+func (e *exprPath) evalString(ctx *exprContext) string {
+	iter := e.path.iter(ctx.node, ctx.vars)
+	if iter.Next() {
+		return iter.Node().String()
+	}
+	return ""
+}
+
+// exprVar is a $name variable reference
+type exprVar struct {
+	name string
+}
+
+func (e *exprVar) eval(ctx *exprContext) Value {
+	if v, ok := ctx.vars[e.name]; ok {
+		return v
+	}
+	return []*Node(nil)
+}
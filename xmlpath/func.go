@@ -0,0 +1,683 @@
+package xmlpath
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// function describes a function usable in expressions
+type function struct {
+	// Minimum and maximum number of arguments, max is -1 for variadic
+	// functions
+	min, max int
+
+	// Build the expression evaluating the function
+	build func(c *pathCompiler, args []expr) (expr, error)
+}
+
+var (
+	functions   map[string]function
+	functionsMu sync.RWMutex
+)
+
+// RegisterFunc makes fn available to the paths compiled afterwards under the
+// given name. The function receives the values of its arguments and must
+// return one of the types documented for Value. The function may be called
+// concurrently if the paths using it are.
+//
+// RegisterFunc panics if the name is already used by another function.
+func RegisterFunc(name string, fn func(args ...Value) Value) {
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	if _, dup := functions[name]; dup {
+		panic("xmlpath: function " + name + "() is already registered")
+	}
+	functions[name] = customFunction(fn)
+}
+
+// customFunction returns fn as a function taking any number of arguments
+func customFunction(fn func(args ...Value) Value) function {
+	return function{0, -1, func(c *pathCompiler, args []expr) (expr, error) {
+		return &exprCustom{fn, args}, nil
+	}}
+}
+
+// lookupFunction returns the function registered under name
+func lookupFunction(name string) (f function, ok bool) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	f, ok = functions[name]
+	return
+}
+
+// lookupFunction returns the function given to the compiler under name, or
+// else the one registered under name
+func (c *pathCompiler) lookupFunction(name string) (f function, ok bool) {
+	if fn, ok := c.funcs[name]; ok {
+		return customFunction(fn), true
+	}
+	return lookupFunction(name)
+}
+
+func init() {
+	functions = map[string]function{
+		"position": {0, 0, func(c *pathCompiler, args []expr) (expr, error) {
+			c.position = true
+			return &exprPosition{}, nil
+		}},
+		"last": {0, 0, func(c *pathCompiler, args []expr) (expr, error) {
+			c.last = true
+			return &exprLast{}, nil
+		}},
+		"string": {0, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprToString{argOrContext(args)}, nil
+		}},
+		"number": {0, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprToNumber{argOrContext(args)}, nil
+		}},
+		"boolean": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprToBool{args[0]}, nil
+		}},
+		"id": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprID{args[0]}, nil
+		}},
+		"key": {2, 2, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprKey{args[0], args[1]}, nil
+		}},
+		"lang": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprLang{args[0]}, nil
+		}},
+		"name": {0, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprNodeName{qualifiedName, argOrContext(args)}, nil
+		}},
+		"local-name": {0, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprNodeName{func(n *Node) string { return n.name.Local }, argOrContext(args)}, nil
+		}},
+		"namespace-uri": {0, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprNodeName{func(n *Node) string { return n.name.Space }, argOrContext(args)}, nil
+		}},
+		"count": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprCount{args[0]}, nil
+		}},
+		"sum": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprSum{args[0]}, nil
+		}},
+		"floor": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprNumberFunc{math.Floor, args[0]}, nil
+		}},
+		"ceiling": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprNumberFunc{math.Ceil, args[0]}, nil
+		}},
+		"round": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprNumberFunc{roundNumber, args[0]}, nil
+		}},
+		"true": {0, 0, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprBool{true}, nil
+		}},
+		"false": {0, 0, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprBool{false}, nil
+		}},
+		"not": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprNot{args[0]}, nil
+		}},
+		"concat": {2, -1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprConcat{args}, nil
+		}},
+		"contains": {2, 2, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprStringTest{strings.Contains, args[0], args[1]}, nil
+		}},
+		"starts-with": {2, 2, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprStringTest{strings.HasPrefix, args[0], args[1]}, nil
+		}},
+		"ends-with": {2, 2, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprStringTest{strings.HasSuffix, args[0], args[1]}, nil
+		}},
+		"matches": {2, 3, func(c *pathCompiler, args []expr) (expr, error) {
+			e := &exprMatches{str: args[0], pattern: args[1]}
+			if len(args) > 2 {
+				e.flags = args[2]
+			}
+			return e, e.compile(c)
+		}},
+		"substring": {2, 3, func(c *pathCompiler, args []expr) (expr, error) {
+			e := &exprSubstring{str: args[0], start: args[1]}
+			if len(args) > 2 {
+				e.length = args[2]
+			}
+			return e, nil
+		}},
+		"substring-before": {2, 2, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprSubstringSplit{false, args[0], args[1]}, nil
+		}},
+		"substring-after": {2, 2, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprSubstringSplit{true, args[0], args[1]}, nil
+		}},
+		"translate": {3, 3, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprTranslate{args[0], args[1], args[2]}, nil
+		}},
+		"lower-case": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprStringFunc{strings.ToLower, args[0]}, nil
+		}},
+		"upper-case": {1, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprStringFunc{strings.ToUpper, args[0]}, nil
+		}},
+		"string-length": {0, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprStringLength{argOrContext(args)}, nil
+		}},
+		"normalize-space": {0, 1, func(c *pathCompiler, args []expr) (expr, error) {
+			return &exprNormalizeSpace{argOrContext(args)}, nil
+		}},
+	}
+}
+
+// exprCustom is a function registered with RegisterFunc
+type exprCustom struct {
+	fn   func(args ...Value) Value
+	args []expr
+}
+
+func (e *exprCustom) eval(ctx *exprContext) Value {
+	args := make([]Value, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.eval(ctx)
+	}
+	return e.fn(args...)
+}
+
+// argOrContext returns the first argument, or the context node if there is
+// none
+func argOrContext(args []expr) expr {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return &exprContextNode{}
+}
+
+// exprContextNode evaluates to the context node
+type exprContextNode struct{}
+
+func (e *exprContextNode) eval(ctx *exprContext) Value {
+	return []*Node{ctx.node}
+}
+
+func (e *exprContextNode) evalString(ctx *exprContext) string {
+	return ctx.node.String()
+}
+
+// exprPosition is the position() function
+type exprPosition struct{}
+
+func (e *exprPosition) eval(ctx *exprContext) Value {
+	return float64(ctx.pos)
+}
+
+// exprLast is the last() function
+type exprLast struct{}
+
+func (e *exprLast) eval(ctx *exprContext) Value {
+	return float64(ctx.size)
+}
+
+// exprToString is the string() function
+type exprToString struct {
+	val expr
+}
+
+func (e *exprToString) eval(ctx *exprContext) Value {
+	return e.evalString(ctx)
+}
+
+func (e *exprToString) evalString(ctx *exprContext) string {
+	return evalString(e.val, ctx)
+}
+
+// exprToNumber is the number() function
+type exprToNumber struct {
+	val expr
+}
+
+func (e *exprToNumber) eval(ctx *exprContext) Value {
+	return toNumber(e.val.eval(ctx))
+}
+
+// exprToBool is the boolean() function
+type exprToBool struct {
+	val expr
+}
+
+func (e *exprToBool) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprToBool) evalBool(ctx *exprContext) bool {
+	return evalBool(e.val, ctx)
+}
+
+// exprID is the id() function. The elements are looked up in the id index
+// of the document.
+type exprID struct {
+	val expr
+}
+
+func (e *exprID) eval(ctx *exprContext) Value {
+	var ids []string
+	switch v := e.val.eval(ctx).(type) {
+	case []*Node:
+		for _, node := range v {
+			ids = append(ids, strings.Fields(node.String())...)
+		}
+	default:
+		ids = strings.Fields(toString(v))
+	}
+	var res []*Node
+	seen := map[*Node]bool{}
+	for _, id := range ids {
+		if node := ctx.node.doc.ids[id]; node != nil && !seen[node] {
+			seen[node] = true
+			res = append(res, node)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].pos < res[j].pos })
+	return res
+}
+
+// exprKey is the key() function. The nodes are looked up in the index of a
+// key declared with Node.AddKey.
+type exprKey struct {
+	name expr
+	val  expr
+}
+
+func (e *exprKey) eval(ctx *exprContext) Value {
+	key := ctx.node.doc.keys[evalString(e.name, ctx)]
+	if key == nil {
+		return []*Node(nil)
+	}
+	var values []string
+	switch v := e.val.eval(ctx).(type) {
+	case []*Node:
+		for _, node := range v {
+			values = append(values, node.String())
+		}
+	default:
+		values = []string{toString(v)}
+	}
+	var res []*Node
+	seen := map[*Node]bool{}
+	for _, value := range values {
+		for _, node := range key.lookup(ctx.node, value) {
+			if !seen[node] {
+				seen[node] = true
+				res = append(res, node)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].pos < res[j].pos })
+	return res
+}
+
+// exprLang is the lang() function. The language is taken from the closest
+// xml:lang attribute, or lang attribute in documents parsed as HTML, of
+// which xml:lang takes precedence on the same element.
+type exprLang struct {
+	val expr
+}
+
+func (e *exprLang) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprLang) evalBool(ctx *exprContext) bool {
+	want := strings.ToLower(evalString(e.val, ctx))
+	for n := ctx.node; n != nil; n = n.up {
+		if n.kind != StartNode {
+			continue
+		}
+		// The lang attribute of HTML is only taken in documents parsed
+		// as HTML, and when there is no xml:lang
+		var lang *Node
+		for i := n.pos + 1; i < n.end && n.doc.nodes[i].kind == AttrNode; i++ {
+			attr := &n.doc.nodes[i]
+			if attr.name.Local != "lang" {
+				continue
+			} else if isXMLNamespace(attr.name.Space) {
+				lang = attr
+				break
+			} else if attr.name.Space == "" && n.doc.html {
+				lang = attr
+			}
+		}
+		if lang != nil {
+			value := strings.ToLower(lang.attr)
+			return value == want || strings.HasPrefix(value, want+"-")
+		}
+	}
+	return false
+}
+
+// exprNodeName is a function returning a part of the name of the first node
+// of a node-set: name(), local-name() and namespace-uri()
+type exprNodeName struct {
+	part func(n *Node) string
+	val  expr
+}
+
+func (e *exprNodeName) eval(ctx *exprContext) Value {
+	return e.evalString(ctx)
+}
+
+func (e *exprNodeName) evalString(ctx *exprContext) string {
+	nodes, ok := e.val.eval(ctx).([]*Node)
+	if !ok || len(nodes) == 0 {
+		return ""
+	}
+	return e.part(nodes[0])
+}
+
+// qualifiedName returns the name of n with the prefix in scope for its
+// namespace
+func qualifiedName(n *Node) string {
+	if n.name.Space == "" {
+		return n.name.Local
+	}
+	var ns map[string]string
+	if n.kind == StartNode {
+		ns = n.FindNamespaces()
+	} else if n.up != nil {
+		ns = n.up.FindNamespaces()
+	}
+	if prefix := findNS(ns, n.name.Space); prefix != "" {
+		return prefix + ":" + n.name.Local
+	}
+	return n.name.Local
+}
+
+// exprCount is the count() function. It is NaN when its argument is not a
+// node-set.
+type exprCount struct {
+	val expr
+}
+
+func (e *exprCount) eval(ctx *exprContext) Value {
+	if path, ok := e.val.(*exprPath); ok {
+		return float64(path.path.count(ctx.node, ctx.vars))
+	}
+	nodes, ok := e.val.eval(ctx).([]*Node)
+	if !ok {
+		return math.NaN()
+	}
+	return float64(len(nodes))
+}
+
+// exprSum is the sum() function. It is NaN when its argument is not a
+// node-set.
+type exprSum struct {
+	val expr
+}
+
+func (e *exprSum) eval(ctx *exprContext) Value {
+	nodes, ok := e.val.eval(ctx).([]*Node)
+	if !ok {
+		return math.NaN()
+	}
+	var sum float64
+	for _, node := range nodes {
+		sum += stringToNumber(node.String())
+	}
+	return sum
+}
+
+// exprNumberFunc is a function of one number: floor(), ceiling() and round()
+type exprNumberFunc struct {
+	fn  func(n float64) float64
+	val expr
+}
+
+func (e *exprNumberFunc) eval(ctx *exprContext) Value {
+	return e.fn(toNumber(e.val.eval(ctx)))
+}
+
+// exprNot is the not() function
+type exprNot struct {
+	val expr
+}
+
+func (e *exprNot) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprNot) evalBool(ctx *exprContext) bool {
+	return !evalBool(e.val, ctx)
+}
+
+// exprConcat is the concat() function
+type exprConcat struct {
+	vals []expr
+}
+
+func (e *exprConcat) eval(ctx *exprContext) Value {
+	return e.evalString(ctx)
+}
+
+func (e *exprConcat) evalString(ctx *exprContext) string {
+	var s []string
+	for _, v := range e.vals {
+		s = append(s, evalString(v, ctx))
+	}
+	return strings.Join(s, "")
+}
+
+// exprStringTest is a boolean function of two strings: contains(),
+// starts-with() and ends-with()
+type exprStringTest struct {
+	test     func(s, substr string) bool
+	haystack expr
+	needle   expr
+}
+
+func (e *exprStringTest) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprStringTest) evalBool(ctx *exprContext) bool {
+	return e.test(evalString(e.haystack, ctx), evalString(e.needle, ctx))
+}
+
+// exprMatches is the matches() extension function, using the syntax of the
+// regexp package. The flags are the ones of the regexp package as well.
+type exprMatches struct {
+	str     expr
+	pattern expr
+	flags   expr
+
+	// Regular expression compiled in advance for a literal pattern
+	re *regexp.Regexp
+}
+
+// compile compiles the pattern in advance when it is known
+func (e *exprMatches) compile(c *pathCompiler) error {
+	pattern, ok := e.pattern.(*exprString)
+	if !ok {
+		return nil
+	}
+	flags := ""
+	if e.flags != nil {
+		f, ok := e.flags.(*exprString)
+		if !ok {
+			return nil
+		}
+		flags = f.val
+	}
+	re, err := regexp.Compile(regexpPattern(pattern.val, flags))
+	if err != nil {
+		return c.errorf("%v", err)
+	}
+	e.re = re
+	return nil
+}
+
+// regexpPattern returns the pattern with the given flags set
+func regexpPattern(pattern, flags string) string {
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return pattern
+}
+
+func (e *exprMatches) eval(ctx *exprContext) Value {
+	return e.evalBool(ctx)
+}
+
+func (e *exprMatches) evalBool(ctx *exprContext) bool {
+	re := e.re
+	if re == nil {
+		flags := ""
+		if e.flags != nil {
+			flags = evalString(e.flags, ctx)
+		}
+		var err error
+		re, err = regexps.compile(regexpPattern(evalString(e.pattern, ctx), flags))
+		if err != nil {
+			return false
+		}
+	}
+	return re.MatchString(evalString(e.str, ctx))
+}
+
+// exprSubstring is the substring() function. The length is nil when not
+// given.
+type exprSubstring struct {
+	str    expr
+	start  expr
+	length expr
+}
+
+func (e *exprSubstring) eval(ctx *exprContext) Value {
+	return e.evalString(ctx)
+}
+
+func (e *exprSubstring) evalString(ctx *exprContext) string {
+	s := []rune(evalString(e.str, ctx))
+	// Characters are counted from 1 and are kept when their position p
+	// verifies first <= p < last
+	first := roundNumber(toNumber(e.start.eval(ctx)))
+	last := math.Inf(1)
+	if e.length != nil {
+		last = first + roundNumber(toNumber(e.length.eval(ctx)))
+	}
+	if math.IsNaN(first) || math.IsNaN(last) {
+		return ""
+	}
+	var res []rune
+	for i, r := range s {
+		if p := float64(i + 1); first <= p && p < last {
+			res = append(res, r)
+		}
+	}
+	return string(res)
+}
+
+// exprSubstringSplit is the substring-before() or substring-after() function
+type exprSubstringSplit struct {
+	after bool
+	str   expr
+	sep   expr
+}
+
+func (e *exprSubstringSplit) eval(ctx *exprContext) Value {
+	return e.evalString(ctx)
+}
+
+func (e *exprSubstringSplit) evalString(ctx *exprContext) string {
+	s := evalString(e.str, ctx)
+	sep := evalString(e.sep, ctx)
+	i := strings.Index(s, sep)
+	switch {
+	case i < 0:
+		return ""
+	case e.after:
+		return s[i+len(sep):]
+	default:
+		return s[:i]
+	}
+}
+
+// exprTranslate is the translate() function
+type exprTranslate struct {
+	str  expr
+	from expr
+	to   expr
+}
+
+func (e *exprTranslate) eval(ctx *exprContext) Value {
+	return e.evalString(ctx)
+}
+
+func (e *exprTranslate) evalString(ctx *exprContext) string {
+	from := []rune(evalString(e.from, ctx))
+	to := []rune(evalString(e.to, ctx))
+	return strings.Map(func(r rune) rune {
+		for i, f := range from {
+			if f != r {
+				continue
+			} else if i < len(to) {
+				return to[i]
+			} else {
+				return -1
+			}
+		}
+		return r
+	}, evalString(e.str, ctx))
+}
+
+// exprStringFunc is a function transforming a string: lower-case() and
+// upper-case()
+type exprStringFunc struct {
+	fn  func(s string) string
+	val expr
+}
+
+func (e *exprStringFunc) eval(ctx *exprContext) Value {
+	return e.evalString(ctx)
+}
+
+func (e *exprStringFunc) evalString(ctx *exprContext) string {
+	return e.fn(evalString(e.val, ctx))
+}
+
+// exprStringLength is the string-length() function
+type exprStringLength struct {
+	val expr
+}
+
+func (e *exprStringLength) eval(ctx *exprContext) Value {
+	return float64(utf8.RuneCountInString(evalString(e.val, ctx)))
+}
+
+// exprNormalizeSpace is the normalize-space() function
+type exprNormalizeSpace struct {
+	val expr
+}
+
+func (e *exprNormalizeSpace) eval(ctx *exprContext) Value {
+	return e.evalString(ctx)
+}
+
+func (e *exprNormalizeSpace) evalString(ctx *exprContext) string {
+	return normalizeSpace(evalString(e.val, ctx))
+}
+
+// normalizeSpace strips the leading and trailing white space of s and
+// replaces the other sequences of white space with single spaces. White space
+// is the one of XML, which does not include no-break spaces.
+func normalizeSpace(s string) string {
+	return strings.Join(strings.FieldsFunc(s, isXMLSpace), " ")
+}
+
+func isXMLSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+}
@@ -0,0 +1,142 @@
+// Package html5 parses HTML documents as web browsers do, with the parser of
+// golang.org/x/net/html, into xmlpath documents.
+//
+// Unlike xmlpath.ParseHTML, which relies on the lenient mode of the xml
+// decoder, it accepts any document: elements left open are closed, stray end
+// tags are ignored, attribute values may be left unquoted, and the html, head
+// and body elements are added when missing. The documents are made of the
+// same nodes as the ones of xmlpath.ParseHTML, so that the same paths apply
+// to them. The elements of SVG and MathML are in their namespaces, and the
+// other ones in no namespace.
+package html5 // import "github.com/mildred/htmltools/xmlpath/html5"
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/mildred/htmltools/xmlpath"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Namespaces of the foreign elements and attributes of HTML documents
+const (
+	svgSpace   = "http://www.w3.org/2000/svg"
+	mathSpace  = "http://www.w3.org/1998/Math/MathML"
+	xlinkSpace = "http://www.w3.org/1999/xlink"
+)
+
+// Parse reads an HTML document from r, parses it, and returns its root node.
+// The documents parsed are never known by their source, so that the
+// xmlpath.KeepSource option is ignored, and the nodes have no position.
+func Parse(r io.Reader, opts ...xmlpath.ParseOption) (*xmlpath.Node, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return Convert(doc, opts...)
+}
+
+// ParseFragment reads the HTML content of an element from r, parses it as
+// web browsers do, and returns the root node of a document of which it is the
+// content. The element is given by context, as its name such as "tr", or as
+// the empty string for a body element.
+func ParseFragment(r io.Reader, context string, opts ...xmlpath.ParseOption) (*xmlpath.Node, error) {
+	if context == "" {
+		context = "body"
+	}
+	nodes, err := html.ParseFragment(r, &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Lookup([]byte(context)),
+		Data:     context,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []xml.Token
+	for _, n := range nodes {
+		res = tokens(n, res)
+	}
+	return xmlpath.ParseDecoder(xml.NewTokenDecoder(&tokenReader{tokens: res}), opts...)
+}
+
+// Convert returns the root node of a document made of the nodes of n and its
+// content. The doctype of documents is given by Node.Doctype.
+func Convert(n *html.Node, opts ...xmlpath.ParseOption) (*xmlpath.Node, error) {
+	return xmlpath.ParseDecoder(xml.NewTokenDecoder(&tokenReader{tokens: tokens(n, nil)}), opts...)
+}
+
+// tokenReader reads a list of tokens as an xml.TokenReader
+type tokenReader struct {
+	tokens []xml.Token
+}
+
+func (r *tokenReader) Token() (xml.Token, error) {
+	if len(r.tokens) == 0 {
+		return nil, io.EOF
+	}
+	t := r.tokens[0]
+	r.tokens = r.tokens[1:]
+	return t, nil
+}
+
+// tokens appends to res the xml tokens of n and its content
+func tokens(n *html.Node, res []xml.Token) []xml.Token {
+	switch n.Type {
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			res = tokens(c, res)
+		}
+	case html.ElementNode:
+		start := xml.StartElement{Name: elemName(n)}
+		for _, attr := range n.Attr {
+			start.Attr = append(start.Attr, xml.Attr{Name: attrName(attr), Value: attr.Val})
+		}
+		res = append(res, start)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			res = tokens(c, res)
+		}
+		res = append(res, start.End())
+	case html.TextNode:
+		res = append(res, xml.CharData(n.Data))
+	case html.CommentNode:
+		res = append(res, xml.Comment(n.Data))
+	case html.DoctypeNode:
+		dt := xmlpath.Doctype{Name: n.Data}
+		for _, attr := range n.Attr {
+			switch attr.Key {
+			case "public":
+				dt.PublicID = attr.Val
+			case "system":
+				dt.SystemID = attr.Val
+			}
+		}
+		// Without the <! and > of the declaration
+		s := dt.String()
+		res = append(res, xml.Directive(s[2:len(s)-1]))
+	}
+	return res
+}
+
+// elemName returns the name of the element n
+func elemName(n *html.Node) xml.Name {
+	switch n.Namespace {
+	case "svg":
+		return xml.Name{Space: svgSpace, Local: n.Data}
+	case "math":
+		return xml.Name{Space: mathSpace, Local: n.Data}
+	}
+	return xml.Name{Local: n.Data}
+}
+
+// attrName returns the name of attr, the xml decoder resolving the xml and
+// xmlns prefixes
+func attrName(attr html.Attribute) xml.Name {
+	switch attr.Namespace {
+	case "xlink":
+		return xml.Name{Space: xlinkSpace, Local: attr.Key}
+	case "xml", "xmlns":
+		return xml.Name{Space: attr.Namespace, Local: attr.Key}
+	}
+	return xml.Name{Local: attr.Key}
+}
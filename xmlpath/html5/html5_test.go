@@ -0,0 +1,110 @@
+package html5_test
+
+import (
+	"github.com/mildred/htmltools/xmlpath"
+	"github.com/mildred/htmltools/xmlpath/html5"
+	"golang.org/x/net/html"
+	. "launchpad.net/gocheck"
+	"strings"
+	"testing"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&HTML5Suite{})
+
+type HTML5Suite struct{}
+
+var ns = map[string]string{
+	"svg":   "http://www.w3.org/2000/svg",
+	"math":  "http://www.w3.org/1998/Math/MathML",
+	"xlink": "http://www.w3.org/1999/xlink",
+}
+
+// Return the strings of the nodes matched by path in root
+func matches(root *xmlpath.Node, path string) []string {
+	var res []string
+	iter := xmlpath.MustCompileNS(path, ns).Iter(root)
+	for iter.Next() {
+		res = append(res, iter.Node().String())
+	}
+	return res
+}
+
+func (s *HTML5Suite) TestImpliedTags(c *C) {
+	root, err := html5.Parse(strings.NewReader(`<title>t</title><p>a<p>b`))
+	c.Assert(err, IsNil)
+	c.Assert(matches(root, "/html/head/title"), DeepEquals, []string{"t"})
+	c.Assert(matches(root, "/html/body/p"), DeepEquals, []string{"a", "b"})
+	c.Assert(matches(root, "/*"), DeepEquals, []string{"tab"})
+}
+
+func (s *HTML5Suite) TestVoidElements(c *C) {
+	root, err := html5.Parse(strings.NewReader(`<p>a<br>b<img src="i.png">c<input></p>`))
+	c.Assert(err, IsNil)
+	c.Assert(matches(root, "//p/node()"), DeepEquals, []string{"a", "", "b", "", "c", ""})
+	c.Assert(xmlpath.MustCompile("//br/node() | //img/node() | //input/node()").Count(root), Equals, 0)
+	c.Assert(matches(root, "//img/@src"), DeepEquals, []string{"i.png"})
+}
+
+func (s *HTML5Suite) TestAttributes(c *C) {
+	root, err := html5.Parse(strings.NewReader(`<div id=a class="x y" DATA-N='1' hidden>t</div>`))
+	c.Assert(err, IsNil)
+	c.Assert(matches(root, "//div/@*"), DeepEquals, []string{"a", "x y", "1", ""})
+	c.Assert(matches(root, "//div/@data-n"), DeepEquals, []string{"1"})
+	c.Assert(matches(root, "id('a')"), DeepEquals, []string{"t"})
+}
+
+func (s *HTML5Suite) TestForeignNamespaces(c *C) {
+	root, err := html5.Parse(strings.NewReader(`<p><svg viewBox="0 0 1 1"><a xlink:href="#c">l</a><circle r="1"/></svg><math><mi>x</mi></math></p>`))
+	c.Assert(err, IsNil)
+	c.Assert(matches(root, "//svg:svg/svg:a"), DeepEquals, []string{"l"})
+	c.Assert(matches(root, "//svg:svg/@viewBox"), DeepEquals, []string{"0 0 1 1"})
+	c.Assert(matches(root, "//svg:a/@xlink:href"), DeepEquals, []string{"#c"})
+	c.Assert(xmlpath.MustCompileNS("//svg:circle", ns).Count(root), Equals, 1)
+	c.Assert(matches(root, "//math:math/math:mi"), DeepEquals, []string{"x"})
+	c.Assert(xmlpath.MustCompile("//svg | //circle | //math | //mi").Count(root), Equals, 0)
+	c.Assert(matches(root, "//p[not(namespace-uri())]"), DeepEquals, []string{"lx"})
+}
+
+func (s *HTML5Suite) TestParseHTML(c *C) {
+	// Both parsers give the same document for HTML they both accept
+	for _, test := range []string{
+		`<html><head><title>t</title></head><body><p class="a">x <em>y</em></p><!--c--></body></html>`,
+		`<html><head></head><body><ul><li id="a">1</li><li>2</li></ul></body></html>`,
+	} {
+		root, err := html5.Parse(strings.NewReader(test))
+		c.Assert(err, IsNil)
+		want, err := xmlpath.ParseHTML(strings.NewReader(test))
+		c.Assert(err, IsNil)
+		c.Assert(string(root.XML()), Equals, string(want.XML()), Commentf("%s", test))
+		c.Assert(string(root.HTML()), Equals, test)
+	}
+}
+
+func (s *HTML5Suite) TestConvert(c *C) {
+	// Build the tree of <svg xlink:href="#a" xml:lang="en"><desc>d</desc></svg>
+	svg := &html.Node{
+		Type:      html.ElementNode,
+		Data:      "svg",
+		Namespace: "svg",
+		Attr: []html.Attribute{
+			{Namespace: "xlink", Key: "href", Val: "#a"},
+			{Namespace: "xml", Key: "lang", Val: "en"},
+		},
+	}
+	desc := &html.Node{Type: html.ElementNode, Data: "desc", Namespace: "svg", Parent: svg}
+	text := &html.Node{Type: html.TextNode, Data: "d", Parent: desc}
+	svg.FirstChild, svg.LastChild = desc, desc
+	desc.FirstChild, desc.LastChild = text, text
+	doc := &html.Node{Type: html.DocumentNode, FirstChild: svg, LastChild: svg}
+	svg.Parent = doc
+
+	root, err := html5.Convert(doc)
+	c.Assert(err, IsNil)
+	c.Assert(matches(root, "/svg:svg/svg:desc"), DeepEquals, []string{"d"})
+	c.Assert(matches(root, "/svg:svg/@xlink:href"), DeepEquals, []string{"#a"})
+	c.Assert(matches(root, "/svg:svg[lang('en')]"), DeepEquals, []string{"d"})
+}
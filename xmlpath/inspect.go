@@ -0,0 +1,115 @@
+package xmlpath
+
+import (
+	"strings"
+)
+
+// Step describes a location step of a compiled path.
+type Step struct {
+	Axis       string   // Name of the axis, such as "child" or "attribute"
+	Test       string   // Node test, such as "a", "svg:*" or "text()"
+	Predicates []string // Source of the predicates, in order
+}
+
+// Steps returns the location steps of p. For a path starting with a
+// parenthesized expression or a function call, these are the steps applied to
+// the nodes it selects. Unions and other expressions have no steps.
+func (p *Path) Steps() []Step {
+	if p.operands != nil || len(p.steps) == 0 {
+		return nil
+	}
+	steps := make([]Step, len(p.steps))
+	for i := range p.steps {
+		steps[i] = p.steps[i].describe()
+	}
+	return steps
+}
+
+// describe returns the description of the step
+func (step *pathStep) describe() Step {
+	res := Step{Axis: step.axis, Test: step.test()}
+	for _, pred := range step.preds {
+		res.Predicates = append(res.Predicates, pred.src)
+	}
+	return res
+}
+
+// Absolute returns whether p is a location path starting from the root node.
+func (p *Path) Absolute() bool {
+	return p.operands == nil && p.base == nil && len(p.steps) > 0 && p.steps[0].root
+}
+
+// Expr returns p in a normalized form, in which steps are written with
+// explicit axes and node tests. Compiling it again gives a path equivalent to
+// p, except for the case-insensitivity of paths compiled by CompileHTML.
+func (p *Path) Expr() string {
+	var buf []byte
+	switch {
+	case p.operands != nil:
+		sep := " " + p.setOp + " "
+		for i, operand := range p.operands {
+			if i > 0 {
+				buf = append(buf, sep...)
+			}
+			if operand.operands != nil {
+				buf = append(buf, '(')
+				buf = append(buf, operand.Expr()...)
+				buf = append(buf, ')')
+			} else {
+				buf = append(buf, operand.Expr()...)
+			}
+		}
+		return string(buf)
+	case p.base != nil:
+		buf = append(buf, p.baseSrc...)
+		buf = appendPreds(buf, p.preds)
+	case p.steps[0].root && p.steps[0].axis == "self":
+		// The root node by itself
+		return "/"
+	}
+	for i := range p.steps {
+		step := &p.steps[i]
+		if i > 0 || step.root || p.base != nil {
+			buf = append(buf, '/')
+		}
+		buf = append(buf, step.axis...)
+		buf = append(buf, "::"...)
+		buf = append(buf, step.test()...)
+		buf = appendPreds(buf, step.preds)
+	}
+	return string(buf)
+}
+
+func appendPreds(buf []byte, preds []pathPred) []byte {
+	for _, pred := range preds {
+		buf = append(buf, '[')
+		buf = append(buf, pred.src...)
+		buf = append(buf, ']')
+	}
+	return buf
+}
+
+// test returns the node test of the step as written in a path
+func (step *pathStep) test() string {
+	switch step.kind {
+	case AnyNode:
+		if step.name == "*" {
+			return "node()"
+		}
+	case TextNode:
+		return "text()"
+	case CommentNode:
+		return "comment()"
+	case ProcInstNode:
+		if step.name == "*" {
+			return "processing-instruction()"
+		} else if strings.IndexByte(step.name, '\'') >= 0 {
+			return `processing-instruction("` + step.name + `")`
+		}
+		return "processing-instruction('" + step.name + "')"
+	}
+	if step.prefix != "" {
+		return step.prefix + ":" + step.name
+	}
+	return step.name
+}
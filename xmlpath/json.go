@@ -0,0 +1,82 @@
+package xmlpath
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonNode is the JSON representation of a node
+type jsonNode struct {
+	Kind     string      `json:"kind"`
+	Name     string      `json:"name,omitempty"`
+	Space    string      `json:"space,omitempty"`
+	Value    string      `json:"value,omitempty"`
+	Text     string      `json:"text,omitempty"`
+	CDATA    bool        `json:"cdata,omitempty"`
+	Line     int         `json:"line,omitempty"`
+	Column   int         `json:"column,omitempty"`
+	Attrs    []*jsonNode `json:"attrs,omitempty"`
+	Children []*jsonNode `json:"children,omitempty"`
+}
+
+// WriteJSON writes n and its content to w as JSON, for programs in other
+// languages or tests to read the parsed tree. Each node is an object with:
+//
+//     - kind: "root", "element", "attribute", "text", "comment" or
+//       "processing-instruction"
+//     - name and space: the local name and the namespace of elements and
+//       attributes, and the target of processing instructions
+//     - value: the value of attributes
+//     - text: the text of text nodes, comments and processing instructions
+//     - cdata: true for text nodes parsed from CDATA sections
+//     - line and column: the position of the node in its source, if parsed
+//     - attrs: the attributes of elements, including the namespace
+//       declarations, as attribute nodes
+//     - children: the children of elements and of the root node
+//
+// The members which are empty, false or 0 are left out. The end of an
+// element is written as the element.
+func (n *Node) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(n.jsonNode())
+}
+
+// MarshalJSON returns n and its content as JSON, as written by WriteJSON,
+// so that nodes may be part of the values given to json.Marshal.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.jsonNode())
+}
+
+// jsonNode returns the JSON representation of n and its content
+func (n *Node) jsonNode() *jsonNode {
+	if n.kind == EndNode {
+		n = &n.doc.nodes[n.end]
+	}
+	res := &jsonNode{Line: int(n.line), Column: int(n.column)}
+	switch n.kind {
+	case StartNode:
+		res.Kind = "element"
+		if n.up == nil && n.name.Local == "" {
+			res.Kind = "root"
+		}
+		res.Name, res.Space = n.name.Local, n.name.Space
+		for i := n.pos + 1; i < n.end && n.doc.nodes[i].kind == AttrNode; i++ {
+			res.Attrs = append(res.Attrs, n.doc.nodes[i].jsonNode())
+		}
+		for _, c := range n.Children() {
+			res.Children = append(res.Children, c.jsonNode())
+		}
+	case AttrNode:
+		res.Kind = "attribute"
+		res.Name, res.Space, res.Value = n.name.Local, n.name.Space, n.attr
+	case TextNode:
+		res.Kind = "text"
+		res.Text, res.CDATA = string(n.text), n.cdata
+	case CommentNode:
+		res.Kind = "comment"
+		res.Text = string(n.text)
+	case ProcInstNode:
+		res.Kind = "processing-instruction"
+		res.Name, res.Text = n.name.Local, string(n.text)
+	}
+	return res
+}
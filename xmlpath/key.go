@@ -0,0 +1,58 @@
+package xmlpath
+
+import (
+	"sort"
+	"sync"
+)
+
+// nodeKey is a key declared on a document with Node.AddKey. Its index is
+// built on first use, and dropped whenever the document is modified.
+type nodeKey struct {
+	match *Path
+	use   *Path
+
+	mu    sync.Mutex
+	index map[string][]*Node
+}
+
+// AddKey declares on the document of node a key, to be looked up with the
+// key() function. The key indexes the nodes matched by match from the root of
+// the document by the string values of the nodes use matches on them. For
+// instance, to look up characters by name with key('character', 'Snoopy'):
+//
+//	doc.AddKey("character", xmlpath.MustCompile("//character"), xmlpath.MustCompile("name"))
+//
+// Declaring a key again with the same name replaces it.
+func (node *Node) AddKey(name string, match, use *Path) {
+	node.doc.keys[name] = &nodeKey{match: match, use: use}
+}
+
+// lookup returns the nodes of the document of root indexed by the key with
+// value, in document order.
+func (k *nodeKey) lookup(root *Node, value string) []*Node {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.index == nil {
+		for root.up != nil {
+			root = root.up
+		}
+		k.index = make(map[string][]*Node)
+		iter := k.match.Iter(root)
+		for iter.Next() {
+			node := iter.Node()
+			seen := make(map[string]bool)
+			uses := k.use.Iter(node)
+			for uses.Next() {
+				value := uses.Node().String()
+				if !seen[value] {
+					seen[value] = true
+					k.index[value] = append(k.index[value], node)
+				}
+			}
+		}
+		for _, nodes := range k.index {
+			sort.Slice(nodes, func(i, j int) bool { return nodes[i].pos < nodes[j].pos })
+		}
+	}
+	return k.index[value]
+}
@@ -0,0 +1,184 @@
+package xmlpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Limits restrict the documents parsed with its methods, which otherwise
+// parse them as the functions of the same names do, so that documents
+// fetched off the network may be parsed safely. The xml decoder never loads
+// external entities, nor expands the entities declared by document type
+// definitions, so that documents may neither read local files nor expand to
+// billions of characters, whatever the limits. The zero value has no limits.
+type Limits struct {
+	// ForbidDTD rejects the documents with a document type definition: a
+	// doctype with an internal subset or an external identifier, or
+	// another declaration such as <!ENTITY>. The doctypes of HTML5,
+	// <!DOCTYPE html> and <!DOCTYPE html SYSTEM "about:legacy-compat">,
+	// are accepted.
+	ForbidDTD bool
+
+	// MaxEntities limits the number of entity and character references,
+	// such as &amp; or &#10;, in the text and in the attribute values of
+	// documents. It is ignored by ParseDecoder, which does not know the
+	// source of the document.
+	MaxEntities int
+
+	// MaxExpansion limits the ratio of the size of the text and of the
+	// attribute values of documents, with their references expanded, to
+	// the size of their source, once the text is longer than 1 KB. It
+	// protects from the expansion of the entities given by the Entity map
+	// of the decoders passed to ParseDecoder.
+	MaxExpansion float64
+
+	// MaxDepth limits the nesting depth of the elements of documents, the
+	// document element being of depth 1, and MaxNodes the number of their
+	// nodes, counting elements, attributes, text, comments and processing
+	// instructions. The context element of fragments is not counted.
+	// Documents within these limits may be parsed without exhausting the
+	// memory or the stack of programs going over them.
+	MaxDepth int
+	MaxNodes int
+}
+
+// expansionMin is the size of the text from which MaxExpansion applies
+const expansionMin = 1024
+
+// Parse is like the Parse function, but returns an error if the document
+// exceeds the limits.
+func (l *Limits) Parse(r io.Reader, opts ...ParseOption) (*Node, error) {
+	d, src, err := newDecoder(r, opts, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return parseDecoder(d, src, l, nil, opts)
+}
+
+// ParseHTML is like the ParseHTML function, but returns an error if the
+// document exceeds the limits.
+func (l *Limits) ParseHTML(r io.Reader, opts ...ParseOption) (*Node, error) {
+	d, src, err := newDecoder(r, opts, "", "")
+	if err != nil {
+		return nil, err
+	}
+	htmlDecoder(d, src)
+	return parseDecoder(d, src, l, nil, opts)
+}
+
+// ParseFragment is like the ParseFragment function, but returns an error if
+// the content exceeds the limits.
+func (l *Limits) ParseFragment(r io.Reader, context string, opts ...ParseOption) (*Node, error) {
+	return parseFragment(r, context, false, l, nil, opts)
+}
+
+// ParseHTMLFragment is like the ParseHTMLFragment function, but returns an
+// error if the content exceeds the limits.
+func (l *Limits) ParseHTMLFragment(r io.Reader, context string, opts ...ParseOption) (*Node, error) {
+	return parseFragment(r, context, true, l, nil, opts)
+}
+
+// ParseDecoder is like the ParseDecoder function, but returns an error if
+// the document exceeds the limits.
+func (l *Limits) ParseDecoder(d *xml.Decoder, opts ...ParseOption) (*Node, error) {
+	return parseDecoder(d, nil, l, nil, opts)
+}
+
+// fragment returns the limits of the content of a fragment parsed within the
+// start tag start, which does not count towards them
+func (l *Limits) fragment(start string) *Limits {
+	if l == nil {
+		return nil
+	}
+	res := *l
+	attrs := 0
+	d := xml.NewDecoder(strings.NewReader(start))
+	d.Strict = false
+	if t, err := d.Token(); err == nil {
+		if t, ok := t.(xml.StartElement); ok {
+			attrs = len(t.Attr)
+		}
+	}
+	if res.MaxDepth > 0 {
+		res.MaxDepth++
+	}
+	if res.MaxNodes > 0 {
+		res.MaxNodes += 1 + attrs
+	}
+	if res.MaxEntities > 0 {
+		res.MaxEntities += strings.Count(start, "&")
+	}
+	return &res
+}
+
+// limitsUsage is what counts towards the limits in a document being parsed
+type limitsUsage struct {
+	entities int
+	expanded int64
+	depth    int
+	nodes    int
+}
+
+// check returns an error if the token t, of which the markup read by src
+// goes from start to end, makes the document exceed the limits
+func (l *Limits) check(t xml.Token, src *sourceReader, start, end int64, usage *limitsUsage) error {
+	var markup []byte
+	if src != nil {
+		markup = src.at(start)[:end-start]
+	}
+	switch t := t.(type) {
+	case xml.Directive:
+		if l.ForbidDTD && !isHTMLDoctype(t) {
+			return fmt.Errorf("document type definitions are forbidden")
+		}
+	case xml.CharData:
+		if !bytes.HasPrefix(markup, []byte("<![CDATA[")) {
+			usage.entities += bytes.Count(markup, []byte("&"))
+		}
+		usage.expanded += int64(len(t))
+		usage.nodes++
+	case xml.StartElement:
+		usage.entities += bytes.Count(markup, []byte("&"))
+		for _, attr := range t.Attr {
+			usage.expanded += int64(len(attr.Value))
+		}
+		usage.depth++
+		usage.nodes += 1 + len(t.Attr)
+	case xml.EndElement:
+		usage.depth--
+	case xml.Comment, xml.ProcInst:
+		usage.nodes++
+	}
+	if l.MaxDepth > 0 && usage.depth > l.MaxDepth {
+		return fmt.Errorf("elements nested more than %d deep", l.MaxDepth)
+	}
+	if l.MaxNodes > 0 && usage.nodes > l.MaxNodes {
+		return fmt.Errorf("more than %d nodes", l.MaxNodes)
+	}
+	if l.MaxEntities > 0 && usage.entities > l.MaxEntities {
+		return fmt.Errorf("more than %d entity references", l.MaxEntities)
+	}
+	if l.MaxExpansion > 0 && usage.expanded > expansionMin && float64(usage.expanded) > l.MaxExpansion*float64(end) {
+		return fmt.Errorf("text expanded more than %g times", l.MaxExpansion)
+	}
+	return nil
+}
+
+// isHTMLDoctype returns whether the directive d is a doctype declaring no
+// document type definition, as <!DOCTYPE html> or its legacy form
+// <!DOCTYPE html SYSTEM "about:legacy-compat">
+func isHTMLDoctype(d xml.Directive) bool {
+	if len(d) <= 7 || !bytes.EqualFold(d[:7], []byte("DOCTYPE")) || bytes.IndexByte(d, '[') >= 0 {
+		return false
+	}
+	dt := ParseDoctype(string(d[7:]))
+	if dt.PublicID != "" {
+		return false
+	} else if dt.SystemID == "" {
+		return len(bytes.Fields(d)) == 2
+	}
+	return dt.SystemID == "about:legacy-compat"
+}
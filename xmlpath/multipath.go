@@ -0,0 +1,190 @@
+package xmlpath
+
+import (
+	"sort"
+)
+
+// MultiPath is a set of paths applied together to a context node. The paths
+// made of child, descendant, descendant-or-self, self and attribute steps,
+// whose predicates do not depend on positions, are all evaluated in a
+// single pass over the document, checking each node against them. The
+// other paths are evaluated on their own.
+type MultiPath struct {
+	paths []*Path
+
+	// Whether each path is checked against each node
+	matched []bool
+
+	// Whether some matched path starts from the root node
+	absolute bool
+}
+
+// CompileAll returns the paths compiled as a MultiPath, or the error of the
+// first path failing to compile.
+func CompileAll(paths []string) (*MultiPath, error) {
+	compiled := make([]*Path, len(paths))
+	for i, path := range paths {
+		p, err := Compile(path)
+		if err != nil {
+			return nil, err
+		}
+		compiled[i] = p
+	}
+	return newMultiPath(compiled), nil
+}
+
+// newMultiPath returns the MultiPath of the compiled paths
+func newMultiPath(paths []*Path) *MultiPath {
+	m := &MultiPath{paths: paths}
+	for _, p := range paths {
+		matched := p.matchable()
+		m.matched = append(m.matched, matched)
+		m.absolute = m.absolute || matched && p.steps[0].root
+	}
+	return m
+}
+
+// matchable returns whether the nodes matched by p may be found by checking
+// each node against the steps of p, from the last one
+func (p *Path) matchable() bool {
+	if p.operands != nil || p.base != nil || len(p.steps) == 0 {
+		return false
+	}
+	for i := range p.steps {
+		switch p.steps[i].axis {
+		case "child", "descendant", "descendant-or-self", "self", "attribute":
+		default:
+			return false
+		}
+		for _, pred := range p.steps[i].preds {
+			if _, ok := pred.pred.(boolExpr); !ok || pred.pos || pred.last {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Iter returns an iterator over the nodes matched by the paths of m on the
+// given context. The nodes come in document order, once for each path
+// matching them, in the order of the paths.
+func (m *MultiPath) Iter(context *Node) *MultiIter {
+	iter := &MultiIter{}
+	for i, p := range m.paths {
+		if m.matched[i] {
+			continue
+		}
+		pathIter := p.Iter(context)
+		for pathIter.Next() {
+			iter.matches = append(iter.matches, multiMatch{pathIter.Node(), i})
+		}
+	}
+
+	start, end := context.pos, context.end
+	if m.absolute {
+		start, end = 0, len(context.doc.nodes)
+	}
+	for pos := start; pos < end; pos++ {
+		node := &context.doc.nodes[pos]
+		if node.kind == EndNode {
+			continue
+		}
+		for i, p := range m.paths {
+			if m.matched[i] && p.matchStep(len(p.steps)-1, node, context) {
+				iter.matches = append(iter.matches, multiMatch{node, i})
+			}
+		}
+	}
+	sort.SliceStable(iter.matches, func(i, j int) bool {
+		a, b := iter.matches[i], iter.matches[j]
+		return a.node.pos < b.node.pos || a.node.pos == b.node.pos && a.path < b.path
+	})
+	return iter
+}
+
+// matchStep returns whether node is selected by the k-th step of p, from a
+// node selected by the previous steps on context
+func (p *Path) matchStep(k int, node, context *Node) bool {
+	if k < 0 {
+		if p.steps[0].root {
+			for context.up != nil {
+				context = context.up
+			}
+		}
+		return node == context
+	}
+	step := &p.steps[k]
+	if !step.match(node) || (node.kind == AttrNode) != (step.axis == "attribute") && step.axis != "self" && step.axis != "descendant-or-self" {
+		return false
+	}
+	if step.axis == "attribute" && step.name == "*" && isNamespaceDecl(node.name) {
+		return false
+	}
+	for _, pred := range step.preds {
+		if !evalPredicate(pred.pred, &exprContext{node: node, pos: 1, size: 1}) {
+			return false
+		}
+	}
+	switch step.axis {
+	case "self":
+		return p.matchStep(k-1, node, context)
+	case "child", "attribute":
+		return node.up != nil && p.matchStep(k-1, node.up, context)
+	case "descendant-or-self":
+		if p.matchStep(k-1, node, context) {
+			return true
+		} else if node.kind == AttrNode {
+			// Attributes are only selected as the context node itself
+			return false
+		}
+	}
+	for up := node.up; up != nil; up = up.up {
+		if p.matchStep(k-1, up, context) {
+			return true
+		}
+	}
+	return false
+}
+
+// MultiIter iterates over the nodes matched by the paths of a MultiPath.
+type MultiIter struct {
+	matches []multiMatch
+	pos     int
+}
+
+type multiMatch struct {
+	node *Node
+	path int
+}
+
+// Next iterates to the next node matched by a path, if any, and returns
+// whether there is a node available.
+func (iter *MultiIter) Next() bool {
+	if iter.pos <= len(iter.matches) {
+		iter.pos++
+	}
+	return iter.pos <= len(iter.matches)
+}
+
+// Node returns the current node.
+// Must only be called after MultiIter.Next returns true.
+func (iter *MultiIter) Node() *Node {
+	return iter.current().node
+}
+
+// Path returns the index of the path matching the current node, in the
+// paths given to CompileAll.
+// Must only be called after MultiIter.Next returns true.
+func (iter *MultiIter) Path() int {
+	return iter.current().path
+}
+
+func (iter *MultiIter) current() multiMatch {
+	if iter.pos == 0 {
+		panic("MultiIter called before MultiIter.Next")
+	}
+	if iter.pos > len(iter.matches) {
+		panic("MultiIter called after MultiIter.Next false")
+	}
+	return iter.matches[iter.pos-1]
+}
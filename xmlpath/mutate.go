@@ -0,0 +1,298 @@
+package xmlpath
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// The methods of NodeRef modify the document of the node it refers to. They
+// insert copies of the nodes given, with their content, which may come from
+// any document. Since the nodes of a document are stored together, the
+// modifications invalidate all the *Node of the document, but not the
+// references to them: the NodeRef obtained with Node.Ref or Iter.Nodes keep
+// referring to the same nodes. The methods return an error, leaving the
+// document unmodified, when the modification would not give a well-formed
+// document.
+
+// InsertBefore inserts copies of nodes before the node of ref.
+func (ref *NodeRef) InsertBefore(nodes ...*Node) error {
+	n := ref.Node
+	if err := n.checkSibling("insert before", nodes); err != nil {
+		return err
+	}
+	n.splice(n.pos, n.pos, values(nodes))
+	return nil
+}
+
+// InsertAfter inserts copies of nodes after the node of ref and its content.
+func (ref *NodeRef) InsertAfter(nodes ...*Node) error {
+	n := ref.Node
+	if err := n.checkSibling("insert after", nodes); err != nil {
+		return err
+	}
+	after := n.after()
+	n.splice(after, after, values(nodes))
+	return nil
+}
+
+// PrependChild inserts copies of nodes as the first children of the element
+// of ref, after its attributes.
+func (ref *NodeRef) PrependChild(nodes ...*Node) error {
+	n := ref.Node
+	if err := n.checkParent("prepend", nodes); err != nil {
+		return err
+	}
+	first := n.pos + n.numattributes() + 1
+	n.splice(first, first, values(nodes))
+	return nil
+}
+
+// AppendChild inserts copies of nodes as the last children of the element of
+// ref.
+func (ref *NodeRef) AppendChild(nodes ...*Node) error {
+	n := ref.Node
+	if err := n.checkParent("append", nodes); err != nil {
+		return err
+	}
+	n.splice(n.end, n.end, values(nodes))
+	return nil
+}
+
+// ReplaceWith replaces the node of ref and its content with copies of
+// nodes. Afterwards, ref keeps referring to the node replaced, which is
+// moved with its content to a document of its own, of which it is the root:
+// modifying it, or the nodes within it, leaves the document unchanged.
+func (ref *NodeRef) ReplaceWith(nodes ...*Node) error {
+	n := ref.Node
+	if err := n.checkSibling("replace", nodes); err != nil {
+		return err
+	}
+	n.splice(n.pos, n.after(), values(nodes))
+	return nil
+}
+
+// Remove removes the node of ref and its content from its document.
+// Afterwards, ref keeps referring to the node removed, which is moved with its
+// content to a document of its own, and may be inserted elsewhere.
+func (ref *NodeRef) Remove() error {
+	return ref.ReplaceWith()
+}
+
+// SetAttr sets the attribute of the given name, in no namespace, of the
+// element of ref to value, creating the attribute if missing.
+func (ref *NodeRef) SetAttr(name, value string) error {
+	return ref.SetAttrNS("", name, value)
+}
+
+// SetAttrNS sets the attribute of the element of ref in the namespace space
+// with the local name local to value, creating the attribute if missing. When
+// no prefix is declared for space on the element or its ancestors, the
+// attribute is created along with the declaration of a new prefix.
+func (ref *NodeRef) SetAttrNS(space, local, value string) error {
+	n := ref.Node
+	if err := n.checkElement("set"); err != nil {
+		return err
+	} else if local == "" {
+		return fmt.Errorf("xmlpath: cannot set an attribute without a name")
+	}
+	if attr := n.attrNS(space, local); attr != nil {
+		attr.attr = value
+		attr.modified()
+		// Refresh the ids and the keys
+		refresh(n.doc.nodes)
+		return nil
+	}
+	attrs := []Node{{kind: AttrNode, name: xml.Name{Space: space, Local: local}, attr: value}}
+	if space != "" && space != "xmlns" && !isXMLNamespace(space) && attrPrefix(n.FindNamespaces(), space) == "" {
+		attrs = append(attrs, Node{kind: AttrNode, name: xml.Name{Space: "xmlns", Local: newPrefix(n.FindNamespaces())}, attr: space})
+	}
+	last := n.pos + n.numattributes() + 1
+	n.splice(last, last, attrs)
+	return nil
+}
+
+// RemoveAttr removes the attribute of the given name, in no namespace, from
+// the element of ref, if it has one.
+func (ref *NodeRef) RemoveAttr(name string) error {
+	return ref.RemoveAttrNS("", name)
+}
+
+// RemoveAttrNS removes the attribute in the namespace space with the local
+// name local from the element of ref, if it has one.
+func (ref *NodeRef) RemoveAttrNS(space, local string) error {
+	n := ref.Node
+	if err := n.checkElement("remove"); err != nil {
+		return err
+	}
+	if attr := n.attrNS(space, local); attr != nil {
+		n.splice(attr.pos, attr.pos+1, nil)
+	}
+	return nil
+}
+
+// attrNS returns the attribute of the element n with the given name, or nil
+func (n *Node) attrNS(space, local string) *Node {
+	for i := n.pos + 1; i < n.end && n.doc.nodes[i].kind == AttrNode; i++ {
+		attr := &n.doc.nodes[i]
+		if attr.name.Local == local && (attr.name.Space == space || isXMLNamespace(space) && isXMLNamespace(attr.name.Space)) {
+			return attr
+		}
+	}
+	return nil
+}
+
+// attrPrefix returns the first prefix bound to space in ns, not the default
+// one
+func attrPrefix(ns map[string]string, space string) string {
+	res := ""
+	for prefix, uri := range ns {
+		if uri == space && prefix != "" && (res == "" || prefix < res) {
+			res = prefix
+		}
+	}
+	return res
+}
+
+// newPrefix returns a prefix not bound in ns
+func newPrefix(ns map[string]string) string {
+	for i := 0; ; i++ {
+		prefix := "ns" + strconv.Itoa(i)
+		if _, ok := ns[prefix]; !ok {
+			return prefix
+		}
+	}
+}
+
+// checkElement returns an error if the attributes of n may not be modified
+func (n *Node) checkElement(op string) error {
+	if n.kind != StartNode || n.up == nil && n.name.Local == "" {
+		return fmt.Errorf("xmlpath: cannot %s the attributes of a node that is not an element", op)
+	}
+	return nil
+}
+
+// SetText sets the text of the text node, comment or processing
+// instruction of ref, or the value of its attribute. The content of an
+// element is replaced with a single text node, if text is not empty.
+func (ref *NodeRef) SetText(text string) error {
+	n := ref.Node
+	switch n.kind {
+	case TextNode, CommentNode, ProcInstNode:
+		n.text = []byte(text)
+		n.modified()
+	case AttrNode:
+		n.attr = text
+		n.modified()
+	case StartNode:
+		if n.up == nil && n.name.Local == "" {
+			return fmt.Errorf("xmlpath: cannot set the text of the root node")
+		}
+		var nodes []Node
+		if text != "" {
+			nodes = append(nodes, Node{kind: TextNode, text: []byte(text)})
+		}
+		n.splice(n.pos+n.numattributes()+1, n.end, nodes)
+		return nil
+	default:
+		return fmt.Errorf("xmlpath: cannot set the text of the end of an element")
+	}
+	// Refresh the ids and the keys
+	refresh(n.doc.nodes)
+	return nil
+}
+
+// SplitText splits the text node of ref in two at the byte offset, ref
+// keeping the text before offset, and returns the reference to the new text
+// node following it, with the text after offset.
+func (ref *NodeRef) SplitText(offset int) (*NodeRef, error) {
+	n := ref.Node
+	if n.kind != TextNode {
+		return nil, fmt.Errorf("xmlpath: cannot split a node that is not text")
+	} else if offset < 0 || offset > len(n.text) || offset < len(n.text) && !utf8.RuneStart(n.text[offset]) {
+		return nil, fmt.Errorf("xmlpath: cannot split text of %d bytes at offset %d", len(n.text), offset)
+	}
+	text := n.text
+	n.text = text[:offset:offset]
+	n.modified()
+	n.splice(n.pos+1, n.pos+1, []Node{{kind: TextNode, text: text[offset:], cdata: n.cdata}})
+	n = ref.Node
+	return n.doc.nodes[n.pos+1].Ref, nil
+}
+
+// Normalize merges the adjacent text nodes within the node of ref, and
+// removes the empty ones, as after parsing. The references to the nodes
+// removed, merged or empty, keep referring to them, each moved to a document
+// of its own.
+func (ref *NodeRef) Normalize() {
+	n := ref.Node
+	nodes := n.doc.nodes
+	start, end := n.pos, n.after()
+	nodelist := append([]Node(nil), nodes[:start]...)
+	var removed []Node
+	for i := start; i < end; i++ {
+		node := nodes[i]
+		if node.kind != TextNode {
+			nodelist = append(nodelist, node)
+			continue
+		}
+		first := i
+		node.text = append([]byte(nil), node.text...)
+		for i+1 < end && nodes[i+1].kind == TextNode {
+			i++
+			node.text = append(node.text, nodes[i].text...)
+			node.cdata = node.cdata && nodes[i].cdata
+			node.src = nil
+			removed = append(removed, nodes[i])
+		}
+		if len(node.text) > 0 {
+			nodelist = append(nodelist, node)
+		} else {
+			removed = append(removed, nodes[first])
+		}
+	}
+	nodelist = append(nodelist, nodes[end:]...)
+	detach(removed)
+	refresh(nodelist)
+}
+
+// checkSibling returns an error if nodes may not be siblings of n
+func (n *Node) checkSibling(op string, nodes []*Node) error {
+	if n.up == nil {
+		return fmt.Errorf("xmlpath: cannot %s the root node", op)
+	} else if n.kind == EndNode {
+		return fmt.Errorf("xmlpath: cannot %s the end of an element", op)
+	}
+	return checkInserted(op, nodes, n.kind == AttrNode)
+}
+
+// checkParent returns an error if nodes may not be children of n
+func (n *Node) checkParent(op string, nodes []*Node) error {
+	if n.kind != StartNode {
+		return fmt.Errorf("xmlpath: cannot %s children to a node that is not an element", op)
+	}
+	return checkInserted(op, nodes, false)
+}
+
+// checkInserted returns an error if nodes may not be inserted as attributes,
+// or as other nodes
+func checkInserted(op string, nodes []*Node, attrs bool) error {
+	for _, node := range nodes {
+		if node.kind == EndNode || node.kind == StartNode && node.up == nil && node.name.Local == "" {
+			return fmt.Errorf("xmlpath: cannot %s the end of an element or a root node", op)
+		} else if (node.kind == AttrNode) != attrs {
+			return fmt.Errorf("xmlpath: cannot %s: attributes only go next to attributes", op)
+		}
+	}
+	return nil
+}
+
+// values returns copies of the nodes
+func values(nodes []*Node) []Node {
+	res := make([]Node, len(nodes))
+	for i, node := range nodes {
+		res[i] = *node
+	}
+	return res
+}
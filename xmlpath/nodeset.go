@@ -0,0 +1,172 @@
+package xmlpath
+
+import (
+	"encoding/xml"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// NodeSet is a set of documents that a path may be applied to at once with
+// Path.IterAll, each document being known by a name such as its file name.
+type NodeSet struct {
+	names []string
+	roots []*NodeRef
+}
+
+// Add adds to set the document of root under the given name.
+func (set *NodeSet) Add(name string, root *Node) {
+	for root.up != nil {
+		root = root.up
+	}
+	set.names = append(set.names, name)
+	set.roots = append(set.roots, root.Ref)
+}
+
+// Parse is like the Parse function, and adds the parsed document to set
+// under the given name.
+func (set *NodeSet) Parse(name string, r io.Reader, opts ...ParseOption) (*Node, error) {
+	root, err := Parse(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	set.Add(name, root)
+	return root, nil
+}
+
+// ParseHTML is like the ParseHTML function, and adds the parsed document to
+// set under the given name.
+func (set *NodeSet) ParseHTML(name string, r io.Reader, opts ...ParseOption) (*Node, error) {
+	root, err := ParseHTML(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	set.Add(name, root)
+	return root, nil
+}
+
+// ParseDecoder is like the ParseDecoder function, and adds the parsed
+// document to set under the given name.
+func (set *NodeSet) ParseDecoder(name string, d *xml.Decoder, opts ...ParseOption) (*Node, error) {
+	root, err := ParseDecoder(d, opts...)
+	if err != nil {
+		return nil, err
+	}
+	set.Add(name, root)
+	return root, nil
+}
+
+// Len returns the number of documents in set.
+func (set *NodeSet) Len() int {
+	return len(set.roots)
+}
+
+// Name returns the name of the i-th document added to set.
+func (set *NodeSet) Name(i int) string {
+	return set.names[i]
+}
+
+// Root returns the root node of the i-th document added to set.
+func (set *NodeSet) Root(i int) *Node {
+	return set.roots[i].Node
+}
+
+// IterAll returns an iterator that goes over the nodes that p matches on
+// the root of each document of set, in turn, in the order the documents were
+// added.
+func (p *Path) IterAll(set *NodeSet) *SetIter {
+	return &SetIter{path: p, set: set, doc: -1}
+}
+
+// SetIter iterates over the nodes matched by a path in a NodeSet.
+// The documents must not be modified during the iteration.
+type SetIter struct {
+	path *Path
+	set  *NodeSet
+	doc  int
+	iter *Iter
+}
+
+// Next iterates to the next node, if any, and returns whether there is a
+// node available.
+func (iter *SetIter) Next() bool {
+	for iter.doc < len(iter.set.roots) {
+		if iter.iter != nil && iter.iter.Next() {
+			return true
+		}
+		iter.doc++
+		if iter.doc < len(iter.set.roots) {
+			iter.iter = iter.path.Iter(iter.set.roots[iter.doc].Node)
+		}
+	}
+	return false
+}
+
+// Node returns the current node.
+// Must only be called after SetIter.Next returns true.
+func (iter *SetIter) Node() *Node {
+	if iter.iter == nil {
+		panic("SetIter.Node called before SetIter.Next")
+	}
+	if iter.doc >= len(iter.set.roots) {
+		panic("SetIter.Node called after SetIter.Next false")
+	}
+	return iter.iter.Node()
+}
+
+// Document returns the name of the document of the current node.
+// Must only be called after SetIter.Next returns true.
+func (iter *SetIter) Document() string {
+	if iter.iter == nil {
+		panic("SetIter.Document called before SetIter.Next")
+	}
+	if iter.doc >= len(iter.set.roots) {
+		panic("SetIter.Document called after SetIter.Next false")
+	}
+	return iter.set.names[iter.doc]
+}
+
+// Match is a node matched in a document of a NodeSet.
+type Match struct {
+	Document string // Name of the document
+	Node     *Node
+}
+
+// MatchAll applies p to the documents of set concurrently, with up to
+// workers goroutines, or as many as GOMAXPROCS when workers is not
+// positive. The matching nodes are returned in the order of IterAll, by
+// document then in document order. The documents must not be modified
+// meanwhile.
+func (p *Path) MatchAll(set *NodeSet, workers int) []Match {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(set.roots) {
+		workers = len(set.roots)
+	}
+	results := make([][]*Node, len(set.roots))
+	docs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range docs {
+				results[doc] = p.Iter(set.roots[doc].Node).nodes()
+			}
+		}()
+	}
+	for doc := range set.roots {
+		docs <- doc
+	}
+	close(docs)
+	wg.Wait()
+
+	var matches []Match
+	for doc, nodes := range results {
+		for _, node := range nodes {
+			matches = append(matches, Match{set.names[doc], node})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,149 @@
+package xmlpath
+
+import (
+	"sort"
+)
+
+// optimize rewrites the predicates of p so that they are evaluated faster,
+// and plans the evaluation of its steps. It is called on compiled paths:
+//
+//   - predicates of constant value are folded, the true ones being dropped
+//   - tests on the attributes of the context node are evaluated before the
+//     other predicates of a step or operands of and and or, when their
+//     order does not matter
+//   - descendant-or-self::node()/child::x is evaluated as descendant::x
+func (p *Path) optimize() {
+	for i := range p.steps {
+		step := &p.steps[i]
+		step.preds = optimizePreds(step.preds)
+		step.last = false
+		for _, pred := range step.preds {
+			step.last = step.last || pred.last
+		}
+	}
+	p.preds = optimizePreds(p.preds)
+	if len(p.steps) > 0 {
+		p.plan, p.ordered = evalSteps(p.steps)
+	}
+}
+
+// optimizePreds returns the predicates preds, optimized
+func optimizePreds(preds []pathPred) []pathPred {
+	res := preds[:0]
+	for _, pred := range preds {
+		if isConst(pred.pred) {
+			switch v := pred.pred.eval(&exprContext{}).(type) {
+			case float64:
+				pred.pred = &exprNumber{v}
+			default:
+				if toBool(v) {
+					continue
+				}
+				pred.pred = &exprBool{false}
+			}
+		}
+		reorderOperands(pred.pred)
+		res = append(res, pred)
+	}
+	if len(res) == 0 {
+		return nil
+	}
+
+	// Positions depend on the predicates evaluated before, and numbers are
+	// tests on positions.
+	for _, pred := range res {
+		if _, ok := pred.pred.(boolExpr); !ok || pred.pos || pred.last {
+			return res
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return exprCost(res[i].pred) < exprCost(res[j].pred) })
+	return res
+}
+
+// isConst returns whether e has the same value in any context
+func isConst(e expr) bool {
+	switch e := e.(type) {
+	case *exprString, *exprNumber, *exprBool:
+		return true
+	case *exprOpOr:
+		return allConst(e.vals)
+	case *exprOpAnd:
+		return allConst(e.vals)
+	case *exprCompare:
+		return isConst(e.lval) && isConst(e.rval)
+	case *exprArith:
+		return isConst(e.lval) && isConst(e.rval)
+	case *exprNegate:
+		return isConst(e.val)
+	case *exprNot:
+		return isConst(e.val)
+	case *exprToBool:
+		return isConst(e.val)
+	case *exprToNumber:
+		return isConst(e.val)
+	case *exprToString:
+		return isConst(e.val)
+	}
+	return false
+}
+
+func allConst(vals []expr) bool {
+	for _, val := range vals {
+		if !isConst(val) {
+			return false
+		}
+	}
+	return true
+}
+
+// reorderOperands moves the cheapest operands of the and and or operators
+// first, within e
+func reorderOperands(e expr) {
+	var vals []expr
+	switch e := e.(type) {
+	case *exprOpOr:
+		vals = e.vals
+	case *exprOpAnd:
+		vals = e.vals
+	case *exprNot:
+		reorderOperands(e.val)
+		return
+	default:
+		return
+	}
+	for _, val := range vals {
+		reorderOperands(val)
+	}
+	sort.SliceStable(vals, func(i, j int) bool { return exprCost(vals[i]) < exprCost(vals[j]) })
+}
+
+// exprCost ranks the cost of evaluating e: constants cost nothing, tests on
+// the attributes of the context node little, and other expressions may go
+// over many nodes.
+func exprCost(e expr) int {
+	switch e := e.(type) {
+	case *exprString, *exprNumber, *exprBool:
+		return 0
+	case *exprPath:
+		if isAttrPath(e.path) {
+			return 1
+		}
+	case *exprOpEq:
+		if isAttrPath(e.lval) {
+			return 1
+		}
+	case *exprCompare:
+		if exprCost(e.lval) <= 1 && exprCost(e.rval) <= 1 {
+			return 1
+		}
+	case *exprNot:
+		return exprCost(e.val)
+	}
+	return 2
+}
+
+// isAttrPath returns whether p selects attributes of the context node by name
+func isAttrPath(p *Path) bool {
+	return p.operands == nil && p.base == nil && len(p.steps) == 1 &&
+		p.steps[0].axis == "attribute" && !p.steps[0].root && p.steps[0].preds == nil
+}
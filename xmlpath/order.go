@@ -0,0 +1,95 @@
+package xmlpath
+
+import (
+	"sort"
+)
+
+// Order is the position of a node relative to another, as returned by
+// Node.Compare.
+type Order int
+
+const (
+	Same         Order = iota // Same node
+	Before                    // Preceding the other node, outside of it
+	After                     // Following the other node, outside of it
+	Contains                  // Ancestor of the other node
+	ContainedBy               // Descendant of the other node
+	Disconnected              // Node of another document
+)
+
+func (o Order) String() string {
+	switch o {
+	case Same:
+		return "same"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	case Contains:
+		return "contains"
+	case ContainedBy:
+		return "contained by"
+	case Disconnected:
+		return "disconnected"
+	}
+	return "invalid order"
+}
+
+// Compare returns the position of n relative to other in their document.
+// Elements contain their attributes as well as their content, and the
+// attributes come before the content in document order. The end of an
+// element is taken as the element.
+func (n *Node) Compare(other *Node) Order {
+	n, other = n.element(), other.element()
+	switch {
+	case n.doc != other.doc:
+		return Disconnected
+	case n.pos == other.pos:
+		return Same
+	case n.pos < other.pos:
+		if n.kind == StartNode && other.pos < n.end {
+			return Contains
+		}
+		return Before
+	default:
+		if other.kind == StartNode && n.pos < other.end {
+			return ContainedBy
+		}
+		return After
+	}
+}
+
+// SortNodes sorts nodes in document order and removes the duplicates, in
+// place, and returns the nodes left. The nodes of different documents are
+// grouped by document, in the order of their first node in nodes. Nodes
+// obtained before modifying their document must be taken from their Ref.
+func SortNodes(nodes []*Node) []*Node {
+	docs := map[*document]int{}
+	for _, n := range nodes {
+		if _, ok := docs[n.doc]; !ok {
+			docs[n.doc] = len(docs)
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i].element(), nodes[j].element()
+		if a.doc != b.doc {
+			return docs[a.doc] < docs[b.doc]
+		}
+		return a.pos < b.pos
+	})
+	res := nodes[:0]
+	for _, n := range nodes {
+		if len(res) == 0 || n.Compare(res[len(res)-1]) != Same {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// element returns the element of n if it is the end of an element, or n
+func (n *Node) element() *Node {
+	if n.kind == EndNode {
+		return &n.doc.nodes[n.end]
+	}
+	return n
+}
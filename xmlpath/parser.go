@@ -0,0 +1,1308 @@
+package xmlpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+// Node is an item in an xml tree that was compiled to
+// be processed via xml paths. A node may represent:
+//
+//     - An element in the xml document (<body>)
+//     - An attribute of an element in the xml document (href="...")
+//     - A comment in the xml document (<!--...-->)
+//     - A processing instruction in the xml document (<?...?>)
+//     - Some text within the xml document
+//
+type Node struct {
+	// Node Kind
+	kind NodeKind
+
+	// Tag name for start nodes
+	// attribute name for attributes
+	// empty for others (including end node)
+	name xml.Name
+
+	// Attribute value for attributes
+	attr string
+
+	// Text content for text nodes, comments and processing instructions
+	text []byte
+
+	// Whether a text node was a CDATA section in the source
+	cdata bool
+
+	// Document of the node, shared by all its nodes
+	doc *document
+
+	// Index of the current node in the `nodes' list
+	pos int
+
+	// Index of the paired node (for begin and end tags)
+	end int
+
+	// Parent node
+	up *Node
+
+	// For start node, the direct children, as the range of their positions
+	// in the downs of the document starting at down
+	down  int32
+	ndown int32
+
+	// Source of the node if parsed with KeepSource and not modified since:
+	// the start or end tag of elements, the other markup as is. It starts
+	// with the markup of the source which has no node, such as directives.
+	src []byte
+
+	// Position of the node in the source if parsed, and the offsets of the
+	// start and of the end of its markup, attributes having the ones of the
+	// start tag of their element
+	line, column      int32
+	offset, endOffset int32
+
+	// Persistent pointer to the node itself, which keeps referring to it
+	// when its document is modified: two *Node are the same node if they
+	// have the same Ref, which may identify nodes in maps
+	Ref *NodeRef
+}
+
+// document holds what the nodes of a document share, so that it is not
+// repeated in each node.
+type document struct {
+	// List of all nodes in the document
+	nodes []Node
+
+	// Positions of the children of the elements, in one block
+	downs []int32
+
+	// Index of the elements by id
+	ids map[string]*Node
+
+	// Keys declared with AddKey
+	keys map[string]*nodeKey
+
+	// Positions of the elements by local name, in document order, if parsed
+	// with IndexNames
+	names map[string][]int
+
+	// Document type declaration, if parsed
+	doctype *Doctype
+
+	// Whether the document was parsed as HTML, by a decoder that is not
+	// strict
+	html bool
+}
+
+// DocumentStats describes the memory used by a document.
+type DocumentStats struct {
+	Nodes     int // Number of nodes, counting the end of elements as nodes
+	TextBytes int // Size of the text and attribute values
+	Bytes     int // Estimate of the memory used by the nodes and their text
+}
+
+// Stats returns statistics on the memory used by the document of n.
+func (n *Node) Stats() DocumentStats {
+	nodes := n.doc.nodes
+	stats := DocumentStats{Nodes: len(nodes)}
+	downs := 0
+	for i := range nodes {
+		stats.TextBytes += len(nodes[i].text) + len(nodes[i].attr)
+		downs += int(nodes[i].ndown)
+	}
+	stats.Bytes = len(nodes)*int(unsafe.Sizeof(Node{})+unsafe.Sizeof(NodeRef{})) +
+		downs*int(unsafe.Sizeof(int32(0))) +
+		len(n.doc.names)*int(unsafe.Sizeof([]int{})) +
+		stats.TextBytes
+	for _, positions := range n.doc.names {
+		stats.Bytes += len(positions) * int(unsafe.Sizeof(0))
+	}
+	return stats
+}
+
+type NodeRef struct {
+	Node *Node
+}
+
+type NodeKind int
+
+const (
+	AnyNode NodeKind = iota
+	StartNode
+	EndNode
+	AttrNode
+	TextNode
+	CommentNode
+	ProcInstNode
+)
+
+// Parent returns the element containing n, or the root node for the
+// top-level nodes, or nil for the root node. The parent of attributes is
+// their element.
+func (n *Node) Parent() *Node {
+	return n.up
+}
+
+// Children returns the children of n other than its attributes, in document
+// order: elements, text, comments and processing instructions.
+func (n *Node) Children() []*Node {
+	var children []*Node
+	for _, pos := range n.downs() {
+		children = append(children, &n.doc.nodes[pos])
+	}
+	return children
+}
+
+// downs returns the positions of the children of n in its document
+func (n *Node) downs() []int32 {
+	return n.doc.downs[n.down : n.down+n.ndown]
+}
+
+// FirstChild returns the first of the children of n, or nil if it has none.
+func (n *Node) FirstChild() *Node {
+	if downs := n.downs(); len(downs) > 0 {
+		return &n.doc.nodes[downs[0]]
+	}
+	return nil
+}
+
+// LastChild returns the last of the children of n, or nil if it has none.
+func (n *Node) LastChild() *Node {
+	if downs := n.downs(); len(downs) > 0 {
+		return &n.doc.nodes[downs[len(downs)-1]]
+	}
+	return nil
+}
+
+// PreviousSibling returns the child of the parent of n preceding it, or nil
+// if n is the first one. Attributes and the root node have no siblings. The
+// siblings of the end of an element are the ones of the element.
+func (n *Node) PreviousSibling() *Node {
+	if n.kind == EndNode {
+		n = &n.doc.nodes[n.end]
+	}
+	if n.up == nil || n.kind == AttrNode {
+		return nil
+	}
+	prev := &n.doc.nodes[n.pos-1]
+	if prev.kind == EndNode {
+		prev = &n.doc.nodes[prev.end]
+	}
+	if prev.kind == AttrNode || prev == n.up {
+		return nil
+	}
+	return prev
+}
+
+// NextSibling returns the child of the parent of n following it, or nil if
+// n is the last one. Attributes and the root node have no siblings. The
+// siblings of the end of an element are the ones of the element.
+func (n *Node) NextSibling() *Node {
+	if n.kind == EndNode {
+		n = &n.doc.nodes[n.end]
+	}
+	if n.up == nil || n.kind == AttrNode {
+		return nil
+	}
+	if next := &n.doc.nodes[n.after()]; next.kind != EndNode {
+		return next
+	}
+	return nil
+}
+
+// Attr returns the value of the attribute of the element n with the given
+// name, in no namespace, and whether it has one.
+func (n *Node) Attr(name string) (value string, ok bool) {
+	return n.AttrNS("", name)
+}
+
+// AttrNS returns the value of the attribute of the element n in the
+// namespace space with the local name local, and whether it has one, as in
+// n.AttrNS("http://www.w3.org/1999/xlink", "href"). The namespace of the xml:
+// prefix may be given as "xml" or as its URI.
+func (n *Node) AttrNS(space, local string) (value string, ok bool) {
+	if n.kind != StartNode || n.doc == nil {
+		return "", false
+	}
+	if attr := n.attrNS(space, local); attr != nil {
+		return attr.attr, true
+	}
+	return "", false
+}
+
+// Attrs returns the names and the values of the attributes of the element n,
+// in document order. As with @*, the namespace declarations are left out.
+func (n *Node) Attrs() []xml.Attr {
+	var attrs []xml.Attr
+	if n.kind != StartNode || n.doc == nil {
+		return attrs
+	}
+	for i := n.pos + 1; i < n.end && n.doc.nodes[i].kind == AttrNode; i++ {
+		if attr := &n.doc.nodes[i]; !isNamespaceDecl(attr.name) {
+			attrs = append(attrs, xml.Attr{Name: attr.name, Value: attr.attr})
+		}
+	}
+	return attrs
+}
+
+func (n *Node) InsertFirstChild(cn *Node) {
+	first := n.pos + n.numattributes() + 1
+	n.splice(first, first, []Node{*cn})
+}
+
+func (n *Node) InsertLastChild(cn *Node) {
+	n.splice(n.end, n.end, []Node{*cn})
+}
+
+// Copy returns a copy of n and its content as a document of its own, of
+// which the copy of n is the root node. Absolute paths applied within it, as
+// in /title or //name, resolve from the copy rather than from the root of the
+// original document, and id() only finds the elements within n. The nodes of
+// the copy have their own NodeRef, so that modifying either document leaves
+// the other unchanged.
+func (n *Node) Copy() *Node {
+	nodes := append([]Node{}, n.extract()...)
+	for i := range nodes {
+		nodes[i].Ref = nil
+		nodes[i].text = append([]byte{}, nodes[i].text...)
+	}
+	// The copy is indexed like the original, but has none of its keys
+	var doc *document
+	if old := nodes[0].doc; old != nil {
+		doc = &document{html: old.html}
+		if old.names != nil {
+			doc.names = map[string][]int{}
+		}
+	}
+	nodes[0].doc = doc
+	refresh(nodes)
+	return nodes[0].Ref.Node
+}
+
+// Clone returns a copy of n and its content which shares nothing with the
+// document of n, so that modifying either leaves the other unchanged. Unlike
+// Copy, the clone is the child of the root node of a new document, so that
+// paths such as /book find a clone of a book element. The namespaces in scope
+// for n are declared by attributes of that root node, as with ParseFragment.
+// The clone may be inserted elsewhere with the methods of NodeRef, and its
+// NodeRef keeps referring to it when its own document is modified. The clone
+// of the end of an element is the clone of the element, and the clone of a
+// root node is the one of its document.
+func (n *Node) Clone() *Node {
+	if n.kind == EndNode {
+		n = &n.doc.nodes[n.end]
+	}
+	if n.up == nil {
+		return n.Copy()
+	}
+	var doc *document
+	if n.doc != nil {
+		doc = &document{html: n.doc.html}
+		if n.doc.names != nil {
+			doc.names = map[string][]int{}
+		}
+	}
+	nodes := []Node{{kind: StartNode, doc: doc}}
+	for _, decl := range n.up.namespaceDecls(nil) {
+		nodes = append(nodes, Node{kind: AttrNode, name: decl.name, attr: decl.attr})
+	}
+	pos := len(nodes)
+	for _, node := range n.extract() {
+		node.Ref = nil
+		node.text = append([]byte(nil), node.text...)
+		nodes = append(nodes, node)
+	}
+	nodes = append(nodes, Node{kind: EndNode})
+	refresh(nodes)
+	return &nodes[pos]
+}
+
+func (n *Node) Kind() NodeKind {
+	return n.kind
+}
+
+// Position returns the line and the column, counted in bytes from 1, at which
+// n starts in the source it was parsed from. Attributes start where their
+// element does. The position is 0, 0 for nodes which were not parsed, such as
+// the root node and the nodes created with CreateTextNode.
+func (n *Node) Position() (line, column int) {
+	return int(n.line), int(n.column)
+}
+
+// Offsets returns the offsets in bytes of the start and of the end of n in
+// the source it was parsed from. Elements go from the start of their start
+// tag to the end of their end tag, and attributes span the start tag of their
+// element. As with Position, the offsets do not change when the document is
+// modified, and they are 0, 0 for nodes which were not parsed.
+func (n *Node) Offsets() (start, end int) {
+	if n.kind == StartNode && n.doc != nil {
+		return int(n.offset), int(n.doc.nodes[n.end].endOffset)
+	}
+	return int(n.offset), int(n.endOffset)
+}
+
+// CDATA returns whether the text node n was parsed from a CDATA section, or
+// made of text nodes which all were. WriteXML writes such nodes as CDATA
+// sections. The text of n is the content of the section, and takes part in
+// the string values of n and of its ancestors as any other text. ParseDecoder
+// does not know the source of the document, so that none of the nodes it
+// parses were CDATA sections.
+func (n *Node) CDATA() bool {
+	return n.cdata
+}
+
+func findNS(ns map[string]string, nsuri string) string {
+	res := ""
+	for k, v := range ns {
+		if v == nsuri {
+			return k
+		}
+		//res += "[" + k + "=" + v + "]?"
+	}
+	//res += nsuri
+	return res
+}
+
+// FindNamespaces returns the namespaces in scope for n, declared by the
+// attributes of n or of its ancestors, by prefix. The empty prefix gives the
+// default namespace, which is empty if there is none, and the xml prefix is
+// always bound. The scope of nodes other than elements is the one of their
+// parent.
+func (n *Node) FindNamespaces() map[string]string {
+	var ns map[string]string = nil
+	if n.up != nil {
+		ns = n.up.FindNamespaces()
+	}
+	return n.namespaces(ns)
+}
+
+// LookupPrefix returns the namespace bound to prefix in the scope of n, the
+// empty prefix giving the default namespace, and whether there is one. Unlike
+// FindNamespaces, it goes over the declarations of the ancestors of n without
+// gathering them.
+func (n *Node) LookupPrefix(prefix string) (space string, ok bool) {
+	if prefix == "xml" {
+		return "http://www.w3.org/XML/1998/namespace", true
+	}
+	for e := n; e != nil && e.doc != nil; e = e.up {
+		if e.kind != StartNode {
+			continue
+		}
+		for i := e.pos + 1; i < e.end && e.doc.nodes[i].kind == AttrNode; i++ {
+			attr := &e.doc.nodes[i]
+			if prefix == "" && attr.name.Space == "" && attr.name.Local == "xmlns" || prefix != "" && attr.name.Space == "xmlns" && attr.name.Local == prefix {
+				// xmlns="" removes the default namespace from the scope
+				return attr.attr, attr.attr != ""
+			}
+		}
+	}
+	return "", false
+}
+
+// LookupNamespace returns the prefix bound to space in the scope of n, and
+// whether there is one. The prefix declared nearest to n is preferred, and
+// the empty prefix is returned if space is the default namespace and no
+// other prefix is bound to it.
+func (n *Node) LookupNamespace(space string) (prefix string, ok bool) {
+	if isXMLNamespace(space) {
+		return "xml", true
+	} else if space == "" {
+		return "", false
+	}
+	e := n
+	if e.kind != StartNode {
+		e = e.up
+	}
+	if e == nil || e.doc == nil {
+		return "", false
+	}
+	def := false
+	for _, decl := range e.namespaceDecls(nil) {
+		if decl.attr != space {
+			continue
+		} else if decl.name.Space == "xmlns" {
+			return decl.name.Local, true
+		}
+		def = true
+	}
+	return "", def
+}
+
+// ResolveQName returns the name given by qname, such as xs:string, resolving
+// its prefix in the scope of n, as for the attributes of which the value is
+// a qualified name. Unprefixed names are in the default namespace. It
+// returns an error if qname is not a qualified name or if its prefix is not
+// bound.
+func (n *Node) ResolveQName(qname string) (xml.Name, error) {
+	prefix, local := "", qname
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		prefix, local = qname[:i], qname[i+1:]
+		if prefix == "" {
+			return xml.Name{}, fmt.Errorf("xmlpath: invalid qualified name %q", qname)
+		}
+	}
+	if local == "" || strings.ContainsAny(local, ": \t\r\n") {
+		return xml.Name{}, fmt.Errorf("xmlpath: invalid qualified name %q", qname)
+	}
+	space, ok := n.LookupPrefix(prefix)
+	if !ok && prefix != "" {
+		return xml.Name{}, fmt.Errorf("xmlpath: undeclared prefix %q in %q", prefix, qname)
+	}
+	return xml.Name{Space: space, Local: local}, nil
+}
+
+func (n *Node) namespaces(ns map[string]string) map[string]string {
+	if n.kind != StartNode {
+		return ns
+	}
+
+	var res map[string]string = map[string]string{
+		"xml": "http://www.w3.org/XML/1998/namespace",
+		"":    "",
+	}
+	if ns != nil {
+		for k, v := range ns {
+			res[k] = v
+		}
+	}
+
+	for i := n.pos + 1; i < n.end; i += 1 {
+		if n.doc.nodes[i].kind != AttrNode {
+			break
+		}
+		c := n.doc.nodes[i]
+		if c.name.Space == "" && c.name.Local == "xmlns" {
+			res[""] = c.attr
+		} else if c.name.Space == "xmlns" {
+			res[c.name.Local] = c.attr
+		}
+	}
+
+	return res
+}
+
+func (n *Node) Name() xml.Name {
+	return n.name
+}
+
+func appendEscaped(result []byte, data []byte) []byte {
+	for _, c := range data {
+		switch c {
+		case '<':
+			result = append(result, []byte("&lt;")...)
+			break
+		case '>':
+			result = append(result, []byte("&gt;")...)
+			break
+		case '&':
+			result = append(result, []byte("&amp;")...)
+			break
+		case '"':
+			result = append(result, []byte("&quot;")...)
+			break
+		case '\'':
+			result = append(result, []byte("&apos;")...)
+			break
+		default:
+			result = append(result, c)
+			break
+		}
+	}
+	return result
+}
+
+// String returns the string value of node.
+//
+// The string value of a node is:
+//
+//     - For element nodes, the concatenation of all text nodes within the element.
+//     - For the root node, the concatenation of all text nodes of the document.
+//     - For text nodes, the text itself.
+//     - For attribute nodes, the attribute value.
+//     - For comment nodes, the text within the comment delimiters.
+//     - For processing instruction nodes, the content of the instruction.
+//
+// The text of comments and processing instructions is not part of the string
+// value of elements, and the one of CDATA sections is. The end of an element
+// has the string value of the element.
+func (node *Node) String() string {
+	if node.kind == AttrNode {
+		return node.attr
+	}
+	return string(node.Bytes())
+}
+
+// NormalizedText returns the string value of n with white space normalized,
+// as by normalize-space(): leading and trailing white space is removed, and
+// the other sequences of white space are replaced with single spaces. It
+// gives the text of headings or links as displayed, regardless of the line
+// breaks and the indentation of the markup. No-break spaces are kept.
+func (n *Node) NormalizedText() string {
+	return normalizeSpace(n.String())
+}
+
+func CreateTextNode(text []byte) Node {
+	return *refresh([]Node{
+		Node{
+			kind: TextNode,
+			text: text,
+		},
+	})
+}
+
+func (n *Node) numattributes() int {
+	numattr := 0
+	for i := n.pos + 1; i < n.end; i += 1 {
+		if n.doc.nodes[i].kind == AttrNode {
+			numattr += 1
+		} else {
+			break
+		}
+	}
+	return numattr
+}
+
+// Set the children nodes (make a copy of them)
+// (and the node will become invalid, you should use n.Ref.Node instead)
+func (n *Node) SetChildren(nodes ...Node) {
+	switch n.kind {
+	case StartNode:
+		n.splice(n.pos+n.numattributes()+1, n.end, nodes)
+	default:
+		panic(fmt.Sprintf("Cannot set children for node type %v", n.kind))
+	}
+}
+
+// Delete current node
+func (n *Node) Remove() {
+	n.splice(n.pos, n.after(), nil)
+}
+
+func (n *Node) extract() []Node {
+	if n.kind == StartNode {
+		return n.doc.nodes[n.pos : n.end+1]
+	} else if n.doc != nil {
+		return n.doc.nodes[n.pos:n.end]
+	} else {
+		return []Node{*n}
+	}
+}
+
+// after returns the position following n and its content in its document
+func (n *Node) after() int {
+	if n.kind == StartNode {
+		return n.end + 1
+	}
+	return n.pos + 1
+}
+
+// splice replaces the nodes of the document of n from start to end with
+// copies of nodes and their content, and refreshes the document. The copies
+// get references of their own, and the nodes replaced are detached.
+func (n *Node) splice(start, end int, nodes []Node) {
+	var nodelist, nodelist2 []Node
+	nodelist = append(nodelist, n.doc.nodes[:start]...)
+	for _, nn := range nodes {
+		nodelist2 = append(nodelist2, nn.extract()...)
+	}
+	for i := range nodelist2 {
+		nodelist2[i].Ref = nil
+	}
+	nodelist = append(nodelist, nodelist2...)
+	nodelist = append(nodelist, n.doc.nodes[end:]...)
+	// The start tag of an element of which attributes change is modified
+	if start < end && n.doc.nodes[start].kind == AttrNode || len(nodes) > 0 && nodes[0].kind == AttrNode {
+		owner := start - 1
+		for nodelist[owner].kind == AttrNode {
+			owner--
+		}
+		nodelist[owner].src = nil
+	}
+	detach(n.doc.nodes[start:end])
+	refresh(nodelist)
+}
+
+// detach moves the nodes, whole subtrees removed from their document, to
+// documents of their own, one per subtree, so that their references no longer
+// refer to nodes of the document they were removed from.
+func detach(nodes []Node) {
+	for i := 0; i < len(nodes); {
+		next := i + nodes[i].after() - nodes[i].pos
+		subtree := append([]Node{}, nodes[i:next]...)
+		old := subtree[0].doc
+		subtree[0].doc = &document{html: old.html}
+		if old.names != nil {
+			subtree[0].doc.names = map[string][]int{}
+		}
+		refresh(subtree)
+		i = next
+	}
+}
+
+// modified forgets the source of n, and of its element if it is an
+// attribute, after a modification
+func (n *Node) modified() {
+	n.src = nil
+	if n.kind == AttrNode && n.up != nil {
+		n.up.src = nil
+	}
+}
+
+func (n *Node) Replace(nodes ...Node) {
+	n.splice(n.pos, n.after(), nodes)
+}
+
+func (n *Node) ReplaceInner(nodes ...Node) {
+	if n.kind != StartNode {
+		panic(fmt.Sprintf("ReplaceInside in %v", n.kind))
+	}
+	n.splice(n.pos+1, n.end, nodes)
+}
+
+func (n *Node) InsertBefore(nodes ...Node) {
+	n.splice(n.pos, n.pos, nodes)
+}
+
+func (n *Node) InsertAfter(nodes ...Node) {
+	after := n.after()
+	n.splice(after, after, nodes)
+}
+
+// Set the bytes of a node
+// On a start node, remove all child nodes and replace it by a single text node
+// (and the node will become invalid, you should use n.Ref.Node instead)
+func (n *Node) SetBytes(data []byte) {
+	switch n.kind {
+	case StartNode:
+		var nodelist []Node
+		numattr := n.numattributes()
+		nodelist = append(nodelist, n.doc.nodes[:n.pos+numattr+1]...)
+		nodelist = append(nodelist, Node{
+			kind: TextNode,
+			text: data,
+			doc:  n.doc,
+			up:   n,
+		})
+		nodelist = append(nodelist, n.doc.nodes[n.end:]...)
+		refresh(nodelist)
+		break
+	case AttrNode:
+		n.attr = string(data)
+		n.modified()
+		break
+	case TextNode, CommentNode, ProcInstNode:
+		n.text = data
+		n.modified()
+		break
+	}
+}
+
+// Set the bytes of a node
+func (n *Node) SetName(local string) {
+	n.SetNameNS("", local)
+}
+
+// Set the bytes of a node
+func (n *Node) SetNameNS(space, local string) {
+	switch n.kind {
+	case StartNode, AttrNode:
+		n.name.Space = space
+		n.name.Local = local
+		n.modified()
+		break
+	case EndNode:
+		sn := n.doc.nodes[n.end]
+		sn.name.Space = space
+		sn.name.Local = local
+		break
+	}
+}
+
+// Bytes returns the string value of node as a byte slice.
+// See Node.String for a description of what the string value of a node is.
+func (node *Node) Bytes() []byte {
+	if node.kind == AttrNode {
+		return []byte(node.attr)
+	}
+	if node.kind == EndNode {
+		node = &node.doc.nodes[node.end]
+	} else if node.kind != StartNode {
+		return node.text
+	}
+	var text []byte
+	for i := node.pos; i < node.end; i++ {
+		if node.doc.nodes[i].kind == TextNode {
+			text = append(text, node.doc.nodes[i].text...)
+		}
+	}
+	return text
+}
+
+// equals returns whether the string value of node is equal to s,
+// without allocating memory.
+func (node *Node) equals(s string) bool {
+	if node.kind == AttrNode {
+		return s == node.attr
+	}
+	if node.kind != StartNode {
+		if len(s) != len(node.text) {
+			return false
+		}
+		for i := range s {
+			if s[i] != node.text[i] {
+				return false
+			}
+		}
+		return true
+	}
+	si := 0
+	for i := node.pos; i < node.end; i++ {
+		if node.doc.nodes[i].kind == TextNode {
+			for _, c := range node.doc.nodes[i].text {
+				if si >= len(s) {
+					return false
+				}
+				if s[si] != c {
+					return false
+				}
+				si++
+			}
+		}
+	}
+	return si == len(s)
+}
+
+// ParseOption is an option of Parse, ParseHTML and ParseDecoder.
+type ParseOption int
+
+const (
+	// IndexNames indexes the elements of the document by name, kept up to
+	// date when the document is modified, so that descendant steps selecting
+	// elements by name, as in //a, look them up instead of going over the
+	// document. The index takes memory in proportion to the number of
+	// elements.
+	IndexNames ParseOption = iota + 1
+
+	// KeepSource keeps the source of the nodes, so that WriteXML and
+	// WriteHTML write the ones which were not modified as they were in the
+	// source, and the output of a modified document only differs from its
+	// source where it was modified. It is ignored by ParseDecoder, which
+	// does not know the source of the document.
+	KeepSource
+
+	// DecodeCharset converts the documents read by Parse and ParseHTML to
+	// UTF-8 with NewReader, from the encoding declared by their byte order
+	// mark, xml declaration or meta elements. The source kept with
+	// KeepSource is then the converted one.
+	DecodeCharset
+
+	// StripSpace leaves out the text nodes made only of white space, such
+	// as the ones indenting elements, so that they do not count in the
+	// positions of nodes, as in node()[2], nor in comparisons of text. The
+	// text within CDATA sections is kept.
+	StripSpace
+
+	// StripDefaultSpace is like StripSpace, but keeps the white space
+	// within the elements of which the xml:space attribute, or the one of
+	// their nearest ancestor having one, is "preserve".
+	StripDefaultSpace
+)
+
+// hasOption returns whether opt is in opts
+func hasOption(opts []ParseOption, opt ParseOption) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse reads an xml document from r, parses it, and returns its root node.
+func Parse(r io.Reader, opts ...ParseOption) (*Node, error) {
+	d, src, err := newDecoder(r, opts, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return parseDecoder(d, src, nil, nil, opts)
+}
+
+// ParseHTML reads an HTML-like document from r, parses it, and returns
+// its root node. As in web browsers, the content of the script, style,
+// textarea and title elements, among others, is text up to their end tag,
+// even if it looks like markup. Documents which are not close to being
+// well-formed may be parsed with the html5 subpackage instead.
+func ParseHTML(r io.Reader, opts ...ParseOption) (*Node, error) {
+	d, src, err := newDecoder(r, opts, "", "")
+	if err != nil {
+		return nil, err
+	}
+	htmlDecoder(d, src)
+	return parseDecoder(d, src, nil, nil, opts)
+}
+
+// htmlDecoder configures d, which reads src if not nil, to decode HTML
+func htmlDecoder(d *xml.Decoder, src *sourceReader) {
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = HTMLEntity
+	if src != nil {
+		src.raw = &rawTextScanner{}
+	}
+}
+
+// ParseFragment reads the xml content of an element from r, which may be
+// made of any number of elements and text, parses it, and returns the root
+// node of a document of which it is the content. The element is given by
+// context, as its name or as its start tag, such as
+// <svg xmlns="http://www.w3.org/2000/svg">. The namespaces declared by its
+// start tag are in scope in the fragment, declared by attributes of the root
+// node. The position and the offsets of the nodes are the ones in the
+// fragment.
+func ParseFragment(r io.Reader, context string, opts ...ParseOption) (*Node, error) {
+	return parseFragment(r, context, false, nil, nil, opts)
+}
+
+// ParseHTMLFragment is like ParseFragment, but parses HTML-like content as
+// ParseHTML does. The elements left open in the content are closed at its
+// end.
+func ParseHTMLFragment(r io.Reader, context string, opts ...ParseOption) (*Node, error) {
+	return parseFragment(r, context, true, nil, nil, opts)
+}
+
+func parseFragment(r io.Reader, context string, html bool, limits *Limits, cb *Callbacks, opts []ParseOption) (*Node, error) {
+	start := strings.TrimSpace(context)
+	if start == "" {
+		start = "fragment"
+	}
+	if !strings.HasPrefix(start, "<") {
+		start = "<" + start + ">"
+	}
+	name := strings.TrimRight(strings.Fields(start[1:] + " ")[0], "/>")
+	d, src, err := newDecoder(r, opts, start, "</"+name+">")
+	if err != nil {
+		return nil, err
+	}
+	if html {
+		htmlDecoder(d, src)
+	}
+	root, err := parseDecoder(d, src, limits.fragment(start), cb.fragment(start), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the element by its content, and its namespace declarations
+	nodes := root.doc.nodes
+	lines := int32(strings.Count(start, "\n"))
+	columns := int32(len(start) - strings.LastIndex(start, "\n") - 1)
+	attrs := 2 + nodes[1].numattributes()
+	res := []Node{nodes[0]}
+	for i := 2; i < nodes[1].end; i++ {
+		n := nodes[i]
+		if i < attrs {
+			if !isNamespaceDecl(n.name) {
+				continue
+			}
+			n.line, n.column, n.offset, n.endOffset = 0, 0, 0, 0
+		} else {
+			if n.line == lines+1 {
+				n.column -= columns
+			}
+			n.line -= lines
+			n.offset -= int32(len(start))
+			n.endOffset -= int32(len(start))
+		}
+		res = append(res, n)
+	}
+	res = append(res, nodes[len(nodes)-1])
+	return refresh(res), nil
+}
+
+// newDecoder returns a decoder reading r, enclosed by prefix and suffix,
+// and the reader of its source, which keeps all of it if opts include
+// KeepSource
+func newDecoder(r io.Reader, opts []ParseOption, prefix, suffix string) (*xml.Decoder, *sourceReader, error) {
+	charset := hasOption(opts, DecodeCharset)
+	if charset {
+		var err error
+		if r, err = NewReader(r, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+	if prefix != "" {
+		r = io.MultiReader(strings.NewReader(prefix), r, strings.NewReader(suffix))
+	}
+	src := &sourceReader{r: r, keep: hasOption(opts, KeepSource)}
+	d := xml.NewDecoder(src)
+	if charset {
+		// The encoding of the xml declaration is no longer the one of
+		// the document
+		d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) {
+			return r, nil
+		}
+	}
+	return d, src, nil
+}
+
+// sourceReader reads r, keeping what it read from the offset from on, or all
+// of it if keep, so that the markup of the tokens decoded may be looked at
+type sourceReader struct {
+	r    io.Reader
+	buf  []byte
+	from int64
+	keep bool
+
+	// Scanner of the raw text of HTML elements, if decoding HTML
+	raw *rawTextScanner
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	if s.raw != nil {
+		n, read, err := s.raw.read(s.r, p)
+		s.buf = append(s.buf, read...)
+		return n, err
+	}
+	n, err := s.r.Read(p)
+	s.buf = append(s.buf, p[:n]...)
+	return n, err
+}
+
+// at returns what was read from offset on
+func (s *sourceReader) at(offset int64) []byte {
+	return s.buf[offset-s.from:]
+}
+
+// discard forgets what was read before offset, unless keep
+func (s *sourceReader) discard(offset int64) {
+	if !s.keep {
+		s.buf = s.buf[offset-s.from:]
+		s.from = offset
+	}
+}
+
+// ParseDecoder parses the xml document being decoded by d and returns
+// its root node.
+func ParseDecoder(d *xml.Decoder, opts ...ParseOption) (*Node, error) {
+	return parseDecoder(d, nil, nil, nil, opts)
+}
+
+// parseDecoder parses the xml document being decoded by d, which reads src
+// if not nil, within limits if not nil, calling the callbacks of cb if not
+// nil.
+func parseDecoder(d *xml.Decoder, src *sourceReader, limits *Limits, cb *Callbacks, opts []ParseOption) (*Node, error) {
+	var nodes []Node
+	var text []byte
+
+	// Offset and position of the last token, and whether it is the end of
+	// an element for which the decoder read the following token
+	var start int64
+	var line, column int
+	var readAhead bool
+
+	// Whether the doctype may no longer come, after the root element or
+	// a directive
+	var started bool
+
+	// Elements and attributes use few distinct names, which are shared
+	// instead of being allocated for each of them by d.
+	names := map[string]string{}
+
+	// Whether to leave out white space, and whether the white space is
+	// preserved by xml:space within each open element
+	stripAll, stripDefault := hasOption(opts, StripSpace), hasOption(opts, StripDefaultSpace)
+	preserve := []bool{false}
+
+	var usage limitsUsage
+
+	// The root node.
+	nodes = append(nodes, Node{kind: StartNode, doc: &document{html: !d.Strict}})
+	if hasOption(opts, IndexNames) {
+		nodes[0].doc.names = map[string][]int{}
+	}
+
+	for {
+		offset := d.InputOffset()
+		l, c := d.InputPos()
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if readAhead && d.InputOffset() == offset {
+			// t was read ahead by the decoder to close the element
+			// before it, which ends where it starts
+			last := &nodes[len(nodes)-1]
+			last.endOffset = last.offset
+		} else {
+			start, line, column = offset, l, c
+		}
+		if src != nil {
+			src.discard(start)
+		}
+		_, isEnd := t.(xml.EndElement)
+		readAhead = isEnd && d.InputOffset() > offset
+		first := len(nodes)
+		switch t := t.(type) {
+		case xml.EndElement:
+			if len(preserve) > 1 {
+				preserve = preserve[:len(preserve)-1]
+			}
+			nodes = append(nodes, Node{
+				kind: EndNode,
+			})
+		case xml.StartElement:
+			started = true
+			space := preserve[len(preserve)-1]
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "space" && isXMLNamespace(attr.Name.Space) {
+					space = attr.Value == "preserve"
+				}
+			}
+			preserve = append(preserve, space)
+			nodes = append(nodes, Node{
+				kind: StartNode,
+				name: internName(names, t.Name),
+			})
+			for _, attr := range t.Attr {
+				nodes = append(nodes, Node{
+					kind: AttrNode,
+					name: internName(names, attr.Name),
+					attr: attr.Value,
+				})
+			}
+		case xml.CharData:
+			if raw, ok := src.rawText(start, d.InputOffset()); ok {
+				t = raw
+			}
+			// The decoder returns the sections as other text
+			cdata := src != nil && bytes.HasPrefix(src.at(start), []byte("<![CDATA["))
+			if (stripAll || stripDefault && !preserve[len(preserve)-1]) && !cdata && isSpace(t) {
+				break
+			}
+			texti := len(text)
+			text = append(text, t...)
+			nodes = append(nodes, Node{
+				kind:  TextNode,
+				text:  text[texti : texti+len(t)],
+				cdata: cdata,
+			})
+		case xml.Comment:
+			texti := len(text)
+			text = append(text, t...)
+			nodes = append(nodes, Node{
+				kind: CommentNode,
+				text: text[texti : texti+len(t)],
+			})
+		case xml.ProcInst:
+			texti := len(text)
+			text = append(text, t.Inst...)
+			nodes = append(nodes, Node{
+				kind: ProcInstNode,
+				name: xml.Name{Local: t.Target},
+				text: text[texti : texti+len(t.Inst)],
+			})
+		case xml.Directive:
+			if len(t) > 7 && strings.EqualFold(string(t[:7]), "DOCTYPE") && !started {
+				nodes[0].doc.doctype = ParseDoctype(string(t[7:]))
+			}
+			started = true
+		}
+		if limits != nil {
+			if err := limits.check(t, src, start, d.InputOffset(), &usage); err != nil {
+				return nil, fmt.Errorf("xmlpath: line %d: %v", line, err)
+			}
+		}
+		for i := first; i < len(nodes); i++ {
+			nodes[i].line, nodes[i].column = int32(line), int32(column)
+			nodes[i].offset, nodes[i].endOffset = int32(start), int32(d.InputOffset())
+		}
+		if cb != nil {
+			if err := cb.call(t, nodes[first:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if src != nil && src.keep {
+		var prev int32
+		for i := 1; i < len(nodes); i++ {
+			if n := &nodes[i]; n.kind != AttrNode {
+				n.src = src.buf[prev:n.endOffset:n.endOffset]
+				prev = n.endOffset
+			}
+		}
+	}
+
+	// Close the root node.
+	nodes = append(nodes, Node{kind: EndNode})
+
+	node := refresh(nodes)
+
+	if node == nil {
+		return nil, io.EOF
+	} else {
+		return node, nil
+	}
+}
+
+// isSpace returns whether text is only made of white space
+func isSpace(text []byte) bool {
+	for _, c := range text {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+// internName returns name with its parts replaced by the equal strings
+// of names, adding them there if missing
+func internName(names map[string]string, name xml.Name) xml.Name {
+	return xml.Name{Space: intern(names, name.Space), Local: intern(names, name.Local)}
+}
+
+func intern(names map[string]string, s string) string {
+	if interned, ok := names[s]; ok {
+		return interned
+	}
+	names[s] = s
+	return s
+}
+
+// isIDAttr returns whether an attribute holds the id of its element. The
+// names of the attributes of HTML documents are matched regardless of their
+// case.
+func isIDAttr(name xml.Name, html bool) bool {
+	if html && name.Space == "" {
+		return strings.EqualFold(name.Local, "id")
+	}
+	return name.Local == "id" && (name.Space == "" || isXMLNamespace(name.Space))
+}
+
+// isNamespaceDecl returns whether an attribute declares a namespace
+func isNamespaceDecl(name xml.Name) bool {
+	return name.Space == "" && name.Local == "xmlns" || name.Space == "xmlns"
+}
+
+// namespaceDecls appends to decls the attributes declaring the namespaces in
+// scope for the element n, the nearest declaration of a prefix hiding the
+// others.
+func (n *Node) namespaceDecls(decls []*Node) []*Node {
+	seen := make(map[string]bool)
+	for e := n; e != nil; e = e.up {
+		for i := e.pos + 1; i < e.end && e.doc.nodes[i].kind == AttrNode; i++ {
+			attr := &e.doc.nodes[i]
+			if !isNamespaceDecl(attr.name) {
+				continue
+			}
+			prefix := ""
+			if attr.name.Space == "xmlns" {
+				prefix = attr.name.Local
+			}
+			if !seen[prefix] {
+				seen[prefix] = true
+				// xmlns="" removes the default namespace from the scope
+				if attr.attr != "" {
+					decls = append(decls, attr)
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// isXMLNamespace returns whether space is the namespace of the xml: prefix
+func isXMLNamespace(space string) bool {
+	return space == "xml" || space == "http://www.w3.org/XML/1998/namespace"
+}
+
+// Refresh all nodes in relation to each other
+// Return the root node (or nil if there is a problem)
+func refresh(nodes []Node) *Node {
+	stack := make([]*Node, 0, len(nodes))
+	downCount := 0
+	doc := &document{nodes: nodes, downs: make([]int32, len(nodes)), ids: map[string]*Node{}}
+	if len(nodes) > 0 && nodes[0].doc != nil {
+		doc.keys = nodes[0].doc.keys
+		doc.doctype = nodes[0].doc.doctype
+		doc.html = nodes[0].doc.html
+		if nodes[0].doc.names != nil {
+			doc.names = map[string][]int{}
+		}
+	}
+	if doc.keys == nil {
+		doc.keys = map[string]*nodeKey{}
+	}
+	for _, key := range doc.keys {
+		key.mu.Lock()
+		key.index = nil
+		key.mu.Unlock()
+	}
+	ids, names := doc.ids, doc.names
+
+	for pos := range nodes {
+
+		nodes[pos].doc = doc
+		nodes[pos].ndown = 0
+		nodes[pos].pos = pos
+		nodes[pos].end = pos + 1
+		if nodes[pos].Ref == nil {
+			nodes[pos].Ref = &NodeRef{nil}
+		}
+		nodes[pos].Ref.Node = &nodes[pos]
+
+		switch nodes[pos].kind {
+
+		case StartNode, AttrNode, TextNode, CommentNode, ProcInstNode:
+			node := &nodes[pos]
+			if len(stack) > 0 {
+				node.up = stack[len(stack)-1]
+			} else {
+				node.up = nil
+			}
+			if node.kind == StartNode {
+				stack = append(stack, node)
+				if names != nil && node.up != nil {
+					names[node.name.Local] = append(names[node.name.Local], pos)
+				}
+			} else if node.kind == AttrNode && node.up != nil && isIDAttr(node.name, doc.html) {
+				if _, dup := ids[node.attr]; !dup {
+					ids[node.attr] = node.up
+				}
+			}
+			if len(stack) == 0 {
+				return node
+			}
+
+		case EndNode:
+			node := stack[len(stack)-1]
+			node.end = pos
+			nodes[pos].end = node.pos
+			stack = stack[:len(stack)-1]
+
+			// Compute downs. Doing that here is what enables the
+			// use of a contiguous pre-allocated block.
+			node.down = int32(downCount)
+			node.ndown = 0
+			for i := node.pos + 1; i < pos; i++ {
+				if nodes[i].up == node {
+					switch nodes[i].kind {
+					case StartNode, TextNode, CommentNode, ProcInstNode:
+						doc.downs[downCount] = int32(i)
+						node.ndown++
+						downCount++
+					}
+				}
+			}
+			if len(stack) == 0 {
+				return node
+			}
+		}
+	}
+	return nil
+}
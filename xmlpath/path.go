@@ -0,0 +1,1750 @@
+package xmlpath
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Path is a compiled path that can be applied to a context
+// node to obtain a matching node set.
+// A single Path can be applied concurrently to any number
+// of context nodes.
+type Path struct {
+	path       string
+	steps      []pathStep
+	namespaces map[string]string
+
+	// Set operation ("|", "intersect" or "except") combining the operands,
+	// in which case there are no steps
+	setOp    string
+	operands []*Path
+
+	// Expression evaluating to the node-set filtered by the predicates, in
+	// which case the steps are applied to the filtered nodes
+	base    expr
+	baseSrc string
+	preds   []pathPred
+
+	// Steps to evaluate in place of the steps, and whether they select
+	// nodes in document order, as planned by optimize
+	plan    []*pathStep
+	ordered bool
+}
+
+// pathPred is a predicate of a path step or filter expression
+type pathPred struct {
+	pred expr
+
+	// Whether the predicate uses the context size
+	last bool
+
+	// Whether the predicate uses the context position
+	pos bool
+
+	// Source of the predicate
+	src string
+}
+
+// Iter returns an iterator that goes over the list of nodes
+// that p matches on the given context, in document order.
+func (p *Path) Iter(context *Node) *Iter {
+	return p.iter(context, nil)
+}
+
+// IterWithVars is like Iter, but binds the $name variables of the path to the
+// values in vars. Values must be of one of the types documented for Value,
+// other integer and float types are converted to float64 and byte slices to
+// string. Unbound variables are empty node-sets.
+func (p *Path) IterWithVars(context *Node, vars map[string]Value) *Iter {
+	bound := make(map[string]Value, len(vars))
+	for name, v := range vars {
+		bound[name] = normalizeValue(v)
+	}
+	return p.iter(context, bound)
+}
+
+// iter returns an iterator over the nodes matched by p, in document order
+func (p *Path) iter(context *Node, vars map[string]Value) *Iter {
+	if p.operands != nil {
+		return p.iterSet(context, vars, nil)
+	} else if p.base != nil {
+		return p.iterFilter(context, vars)
+	}
+	iter, ordered := p.iterSteps(context, vars)
+	if !ordered {
+		return iter.sorted()
+	}
+	return iter
+}
+
+// IterContext is like Iter, but the iteration stops once ctx is done, in
+// which case Iter.Err returns the error of ctx. Set operations and filter
+// expressions are evaluated at once before the iteration, and only stop on
+// ctx between their steps.
+func (p *Path) IterContext(ctx context.Context, node *Node) *Iter {
+	if err := ctx.Err(); err != nil {
+		return &Iter{buffered: true, err: err}
+	}
+	if p.operands != nil || p.base != nil {
+		iter := p.iter(node, nil)
+		iter.ctx = ctx
+		return iter
+	}
+	iter, ordered := p.iterSteps(node, nil)
+	iter.ctx = ctx
+	if !ordered {
+		return iter.sorted()
+	}
+	return iter
+}
+
+// iterAny is like iter, but the nodes may come in any order, sparing the
+// evaluation of all of them when only some are needed
+func (p *Path) iterAny(context *Node, vars map[string]Value) *Iter {
+	if p.operands == nil && p.base == nil {
+		iter, _ := p.iterSteps(context, vars)
+		return iter
+	}
+	return p.iter(context, vars)
+}
+
+// iterSteps returns an iterator evaluating the steps of p lazily, and whether
+// it goes over the nodes in document order
+func (p *Path) iterSteps(context *Node, vars map[string]Value) (*Iter, bool) {
+	steps, ordered := p.plan, p.ordered
+	if steps == nil {
+		steps, ordered = evalSteps(p.steps)
+	}
+	iter := Iter{
+		state: make([]pathStepState, len(steps)),
+	}
+	for i, step := range steps {
+		iter.state[i].step = step
+		iter.state[i].vars = vars
+	}
+	iter.state[0].init(context)
+	return &iter, ordered
+}
+
+// evalSteps returns the steps to evaluate in place of steps, and whether
+// they select nodes in document order. The steps select nodes in document
+// order as long as the nodes they start from are in document order and do
+// not contain each other, or are a single node. Since the nodes selected by
+// descendant-or-self::node() contain each other, it is evaluated along with a
+// following child step as a descendant step when there are no predicates.
+// Not so for descendant-or-self::*, which does not select the root node.
+func evalSteps(steps []pathStep) ([]*pathStep, bool) {
+	res := make([]*pathStep, 0, len(steps))
+	ordered, single, nested := true, true, false
+	for i := 0; i < len(steps); i++ {
+		step := &steps[i]
+		if step.axis == "descendant-or-self" && step.kind == AnyNode &&
+			step.name == "*" && step.prefix == "" && step.preds == nil &&
+			i+1 < len(steps) && steps[i+1].axis == "child" && steps[i+1].preds == nil {
+			merged := steps[i+1]
+			merged.axis = "descendant"
+			merged.root = step.root
+			step = &merged
+			i++
+		}
+		res = append(res, step)
+		switch step.axis {
+		case "self":
+		case "parent":
+			ordered = ordered && !nested
+		case "child":
+			ordered = ordered && !nested
+			single = false
+		case "attribute":
+			single = false
+		case "descendant", "descendant-or-self":
+			ordered = ordered && !nested
+			single, nested = false, true
+		case "following":
+			ordered = ordered && single
+			single, nested = false, true
+		case "following-sibling":
+			ordered = ordered && single
+			single, nested = false, false
+		default:
+			// Reverse axes, and the namespace axis going from the nearest
+			// declarations to the farthest
+			ordered = false
+		}
+	}
+	return res, ordered
+}
+
+// iterSet returns an iterator over the nodes resulting from the set operation
+// on the operands, in document order, tracing the operands with t if not nil
+func (p *Path) iterSet(context *Node, vars map[string]Value, t Tracer) *Iter {
+	iter := Iter{buffered: true}
+	// Number of operands matching each node, or -1 for the nodes excluded
+	matches := map[*Node]int{}
+	for i, operand := range p.operands {
+		var operandIter *Iter
+		if t != nil {
+			operandIter = operand.IterTrace(context, t)
+		} else {
+			operandIter = operand.iter(context, vars)
+		}
+		for operandIter.Next() {
+			node := operandIter.Node()
+			if matches[node] == 0 && (i == 0 || p.setOp == "|") {
+				iter.buf = append(iter.buf, node)
+			}
+			if p.setOp == "except" && i > 0 {
+				matches[node] = -1
+			} else {
+				matches[node]++
+			}
+		}
+	}
+	nodes := iter.buf[:0]
+	for _, node := range iter.buf {
+		n := matches[node]
+		if p.setOp == "|" || (p.setOp == "intersect" && n == len(p.operands)) || (p.setOp == "except" && n > 0) {
+			nodes = append(nodes, node)
+		}
+	}
+	iter.buf = nodes
+	sort.Slice(iter.buf, func(i, j int) bool { return iter.buf[i].pos < iter.buf[j].pos })
+	return &iter
+}
+
+// iterFilter returns an iterator over the nodes of the base expression
+// selected by the predicates, or over the nodes matched by the steps from
+// them, in document order
+func (p *Path) iterFilter(context *Node, vars map[string]Value) *Iter {
+	nodes, _ := p.base.eval(&exprContext{node: context, pos: 1, size: 1, vars: vars}).([]*Node)
+	nodes = append([]*Node{}, nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].pos < nodes[j].pos })
+	nodes = filterNodes(nodes, p.preds, vars)
+
+	iter := Iter{buffered: true, buf: nodes}
+	if len(p.steps) > 0 {
+		rel := &Path{path: p.path, steps: p.steps, namespaces: p.namespaces, plan: p.plan, ordered: p.ordered}
+		seen := map[*Node]bool{}
+		iter.buf = nil
+		for _, node := range nodes {
+			relIter := rel.iter(node, vars)
+			for relIter.Next() {
+				if n := relIter.Node(); !seen[n] {
+					seen[n] = true
+					iter.buf = append(iter.buf, n)
+				}
+			}
+		}
+		sort.Slice(iter.buf, func(i, j int) bool { return iter.buf[i].pos < iter.buf[j].pos })
+	}
+	return &iter
+}
+
+// filterNodes returns the nodes selected by each predicate in turn, the
+// positions being those in the nodes selected by the previous one. The nodes
+// slice is reused.
+func filterNodes(nodes []*Node, preds []pathPred, vars map[string]Value) []*Node {
+	for _, pred := range preds {
+		size := len(nodes)
+		selected := nodes[:0]
+		for i, node := range nodes {
+			ctx := exprContext{node: node, pos: i + 1, size: size, vars: vars}
+			if evalPredicate(pred.pred, &ctx) {
+				selected = append(selected, node)
+			}
+		}
+		nodes = selected
+	}
+	return nodes
+}
+
+// setPath returns the path combining lval and rval with the set operation op
+func setPath(op, path string, lval, rval *Path) *Path {
+	res := &Path{path: path, setOp: op}
+	for i, p := range []*Path{lval, rval} {
+		// Only the left operand can be flattened for except
+		if p.setOp == op && (i == 0 || op != "except") {
+			res.operands = append(res.operands, p.operands...)
+		} else {
+			res.operands = append(res.operands, p)
+		}
+	}
+	return res
+}
+
+// Eval returns the value of p on the given context, which is a node-set
+// ([]*Node) in document order, a string, a float64 or a bool. Contrary to the
+// other methods, Eval gives access to the value of expressions that do not
+// evaluate to node-sets, such as count(//a) or string(//title).
+func (p *Path) Eval(context *Node) (Value, error) {
+	if context == nil {
+		return nil, fmt.Errorf("xmlpath: cannot evaluate %q without a context node", p.path)
+	}
+	if p.base != nil && p.preds == nil && p.steps == nil {
+		val := p.base.eval(&exprContext{node: context, pos: 1, size: 1})
+		nodes, ok := val.([]*Node)
+		if !ok {
+			return val, nil
+		}
+		nodes = append([]*Node{}, nodes...)
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].pos < nodes[j].pos })
+		return nodes, nil
+	}
+	var nodes []*Node
+	iter := p.Iter(context)
+	for iter.Next() {
+		nodes = append(nodes, iter.Node())
+	}
+	return nodes, nil
+}
+
+// Exists returns whether any nodes match p on the given context. The
+// evaluation stops at the first matching node.
+func (p *Path) Exists(context *Node) bool {
+	return p.iterAny(context, nil).Next()
+}
+
+// Count returns the number of nodes matched by p on the given context,
+// without gathering them nor sorting them when they can be counted as they
+// are found.
+func (p *Path) Count(context *Node) int {
+	return p.count(context, nil)
+}
+
+func (p *Path) count(context *Node, vars map[string]Value) int {
+	iter := p.iterAny(context, vars)
+	if iter.buffered {
+		return len(iter.buf)
+	}
+	n := 0
+	for iter.Next() {
+		n++
+	}
+	return n
+}
+
+// First returns the first node matched by p on the given context, stopping
+// the evaluation there.
+func (p *Path) First(context *Node) (node *Node, ok bool) {
+	iter := p.Iter(context)
+	if iter.Next() {
+		return iter.Node(), true
+	}
+	return nil, false
+}
+
+// String returns the string value of the first node matched
+// by p on the given context.
+//
+// See the documentation of Node.String.
+func (p *Path) String(context *Node) (s string, ok bool) {
+	iter := p.Iter(context)
+	if iter.Next() {
+		return iter.Node().String(), true
+	}
+	return "", false
+}
+
+// Bytes returns as a byte slice the string value of the first
+// node matched by p on the given context.
+//
+// See the documentation of Node.String.
+func (p *Path) Bytes(node *Node) (b []byte, ok bool) {
+	iter := p.Iter(node)
+	if iter.Next() {
+		return iter.Node().Bytes(), true
+	}
+	return nil, false
+}
+
+// Iter iterates over node sets.
+// The DOM must not be modified during the iteration
+type Iter struct {
+	state []pathStepState
+
+	// Nodes already returned, allocated on the second match so that
+	// looking for the first match does not depend on the document size
+	seen  []bool
+	first *Node
+
+	// Nodes computed in advance, for set operations
+	buf      []*Node
+	bufPos   int
+	buffered bool
+
+	// Context stopping the iteration, and the error it stopped it with
+	ctx context.Context
+	err error
+
+	// Number of nodes left to produce, if limited
+	limit   int
+	limited bool
+
+	// Tracer to report the statistics of the steps to once the iteration
+	// is over, and the path they belong to
+	tracer Tracer
+	traced string
+}
+
+// sorted returns an iterator over the remaining nodes of iter, in document
+// order
+func (iter *Iter) sorted() *Iter {
+	nodes := iter.nodes()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].pos < nodes[j].pos })
+	return &Iter{buffered: true, buf: nodes, ctx: iter.ctx, err: iter.err}
+}
+
+// done returns whether the iteration was stopped by the context of iter
+func (iter *Iter) done() bool {
+	if iter.err == nil && iter.ctx != nil {
+		iter.err = iter.ctx.Err()
+	}
+	return iter.err != nil
+}
+
+// Err returns the error that stopped the iteration, which is the error of
+// the context given to Path.IterContext once it is done, or nil.
+func (iter *Iter) Err() error {
+	return iter.err
+}
+
+// In case you plan to modify the DOM
+func (iter *Iter) Nodes() []*NodeRef {
+	var res []*NodeRef
+	for iter.Next() {
+		res = append(res, iter.Node().Ref)
+	}
+	return res
+}
+
+func (iter *Iter) nodes() []*Node {
+	var res []*Node
+	for iter.Next() {
+		res = append(res, iter.Node())
+	}
+	return res
+}
+
+// Node returns the current node.
+// Must only be called after Iter.Next returns true.
+func (iter *Iter) Node() *Node {
+	if iter.buffered {
+		if iter.bufPos == 0 {
+			panic("Iter.Node called before Iter.Next")
+		}
+		if iter.bufPos > len(iter.buf) {
+			panic("Iter.Node called after Iter.Next false")
+		}
+		return iter.buf[iter.bufPos-1]
+	}
+	state := iter.state[len(iter.state)-1]
+	if state.pos == 0 {
+		panic("Iter.Node called before Iter.Next")
+	}
+	if state.node == nil {
+		panic("Iter.Node called after Iter.Next false")
+	}
+	return state.node
+}
+
+// Limit caps to n the number of nodes iter goes over from now on, and
+// returns iter. The nodes that are not needed are not searched for, except
+// with paths whose nodes must all be known to be sorted in document order.
+func (iter *Iter) Limit(n int) *Iter {
+	iter.limit = n
+	iter.limited = true
+	return iter
+}
+
+// Clone returns a copy of iter at its current position, going over the
+// remaining nodes independently of iter. The copy does not report to the
+// tracer of iter, if any.
+func (iter *Iter) Clone() *Iter {
+	clone := *iter
+	clone.tracer = nil
+	clone.state = make([]pathStepState, len(iter.state))
+	for i, s := range iter.state {
+		s.predPos = append([]int(nil), s.predPos...)
+		s.buf = append([]*Node(nil), s.buf...)
+		s.decls = append([]*Node(nil), s.decls...)
+		clone.state[i] = s
+	}
+	clone.seen = append([]bool(nil), iter.seen...)
+	return &clone
+}
+
+// Next iterates to the next node in the set, if any, and
+// returns whether there is a node available.
+func (iter *Iter) Next() bool {
+	if iter.limited {
+		if iter.limit <= 0 {
+			iter.trace()
+			return false
+		}
+		iter.limit--
+	}
+	if !iter.next() {
+		iter.trace()
+		return false
+	}
+	return true
+}
+
+func (iter *Iter) next() bool {
+	if iter.done() {
+		return false
+	}
+	if iter.buffered {
+		if iter.bufPos <= len(iter.buf) {
+			iter.bufPos++
+		}
+		return iter.bufPos <= len(iter.buf)
+	}
+	tip := len(iter.state) - 1
+outer:
+	for {
+		if iter.done() {
+			return false
+		}
+		for !iter.state[tip].next() {
+			tip--
+			if tip == -1 {
+				return false
+			}
+		}
+		for tip < len(iter.state)-1 {
+			tip++
+			iter.state[tip].init(iter.state[tip-1].node)
+			if !iter.state[tip].next() {
+				tip--
+				continue outer
+			}
+		}
+		node := iter.state[tip].node
+		if iter.seen == nil {
+			if iter.first == nil {
+				iter.first = node
+				return true
+			}
+			iter.seen = make([]bool, len(node.doc.nodes))
+			iter.seen[iter.first.pos] = true
+		}
+		if iter.seen[node.pos] {
+			continue
+		}
+		iter.seen[node.pos] = true
+		return true
+	}
+}
+
+type pathStepState struct {
+	step *pathStep
+	node *Node
+	pos  int
+	idx  int
+	aux  int
+
+	// Variables bound for the evaluation of the predicates
+	vars map[string]Value
+
+	// Position of the current node for each predicate, among the nodes
+	// selected by the previous predicates
+	predPos []int
+
+	// Nodes selected by the step, when they must all be known before
+	// evaluating the predicates
+	buf      []*Node
+	buffered bool
+
+	// Namespace declarations in scope, for the namespace axis
+	decls []*Node
+
+	// Positions of the elements of the name of the step, when the
+	// descendant axes are evaluated with the index of the document
+	names []int
+
+	// Number of nodes tested and selected by the step, for tracing
+	visited int
+	matched int
+}
+
+// match returns whether node passes the node test of the step, counting it
+// as visited
+func (s *pathStepState) match(node *Node) bool {
+	s.visited++
+	return s.step.match(node)
+}
+
+func (s *pathStepState) init(node *Node) {
+	s.node = node
+	s.pos = 0
+	s.idx = 0
+	s.aux = 0
+	s.predPos = s.predPos[:0]
+	for range s.step.preds {
+		s.predPos = append(s.predPos, 0)
+	}
+	s.buf = s.buf[:0]
+	s.buffered = false
+}
+
+func (s *pathStepState) next() bool {
+	if s.step.last {
+		return s.nextBuffered()
+	}
+	for s._next() {
+		s.pos++
+		if s.accept() {
+			s.matched++
+			return true
+		}
+	}
+	return false
+}
+
+// accept returns whether the current node is selected by all the predicates
+func (s *pathStepState) accept() bool {
+	for i, pred := range s.step.preds {
+		s.predPos[i]++
+		ctx := exprContext{node: s.node, pos: s.predPos[i], vars: s.vars}
+		if !evalPredicate(pred.pred, &ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// nextBuffered collects all the nodes selected by the step before evaluating
+// its predicates, for predicates that need the context size.
+func (s *pathStepState) nextBuffered() bool {
+	if !s.buffered {
+		for s._next() {
+			s.buf = append(s.buf, s.node)
+		}
+		s.buf = filterNodes(s.buf, s.step.preds, s.vars)
+		s.buffered = true
+	}
+	if s.pos < len(s.buf) {
+		s.node = s.buf[s.pos]
+		s.pos++
+		s.matched++
+		return true
+	}
+	s.node = nil
+	return false
+}
+
+// _next moves to the next node selected by the step, ignoring predicates.
+// Reverse axes (ancestor, preceding, etc) select nodes from the nearest to
+// the farthest, so positions in predicates are proximity positions.
+func (s *pathStepState) _next() bool {
+	if s.node == nil {
+		return false
+	}
+	if s.step.root && s.idx == 0 {
+		for s.node.up != nil {
+			s.node = s.node.up
+		}
+	}
+
+	if s.aux >= len(s.node.doc.nodes) {
+		panic("s.aux out of range")
+	}
+
+	switch s.step.axis {
+
+	case "self":
+		if s.idx == 0 && s.match(s.node) {
+			s.idx++
+			return true
+		}
+
+	case "parent":
+		if s.idx == 0 && s.node.up != nil && s.match(s.node.up) {
+			s.idx++
+			s.node = s.node.up
+			return true
+		}
+
+	case "ancestor", "ancestor-or-self":
+		if s.idx == 0 && s.step.axis == "ancestor-or-self" {
+			s.idx++
+			if s.match(s.node) {
+				return true
+			}
+		}
+		for s.node.up != nil {
+			s.node = s.node.up
+			s.idx++
+			if s.match(s.node) {
+				return true
+			}
+		}
+
+	case "child":
+		var down []int32
+		if s.idx == 0 {
+			down = s.node.downs()
+		} else {
+			down = s.node.up.downs()
+		}
+		for s.idx < len(down) {
+			node := &s.node.doc.nodes[down[s.idx]]
+			s.idx++
+			if s.match(node) {
+				s.node = node
+				return true
+			}
+		}
+
+	case "descendant", "descendant-or-self":
+		if s.idx == 0 {
+			s.idx = s.node.pos
+			s.aux = s.node.end
+			if s.step.axis == "descendant" {
+				s.idx++
+			}
+			s.names = nil
+			if s.node.doc.names != nil && s.step.kind == StartNode && s.step.name != "*" && !s.step.fold {
+				// Go over the index instead, s.idx being a position in it
+				s.names = s.node.doc.names[s.step.name]
+				s.idx = sort.SearchInts(s.names, s.idx)
+				if s.idx == len(s.names) {
+					break
+				}
+			}
+		}
+		if s.names != nil {
+			for s.idx < len(s.names) && s.names[s.idx] < s.aux {
+				node := &s.node.doc.nodes[s.names[s.idx]]
+				s.idx++
+				if s.match(node) {
+					s.node = node
+					return true
+				}
+			}
+			break
+		}
+		for s.idx < s.aux {
+			node := &s.node.doc.nodes[s.idx]
+			s.idx++
+			if node.kind == AttrNode {
+				continue
+			}
+			if s.match(node) {
+				s.node = node
+				return true
+			}
+		}
+
+	case "following":
+		if s.idx == 0 {
+			s.idx = s.node.end
+		}
+		for s.idx < len(s.node.doc.nodes) {
+			node := &s.node.doc.nodes[s.idx]
+			s.idx++
+			if node.kind == AttrNode {
+				continue
+			}
+			if s.match(node) {
+				s.node = node
+				return true
+			}
+		}
+
+	case "following-sibling":
+		var down []int32
+		if s.node.up != nil {
+			down = s.node.up.downs()
+			if s.idx == 0 {
+				for s.idx < len(down) {
+					pos := int(down[s.idx])
+					s.idx++
+					if pos == s.node.pos {
+						break
+					}
+				}
+			}
+		}
+		for s.idx < len(down) {
+			node := &s.node.doc.nodes[down[s.idx]]
+			s.idx++
+			if s.match(node) {
+				s.node = node
+				return true
+			}
+		}
+
+	case "preceding":
+		if s.idx == 0 {
+			s.aux = s.node.pos // Detect ancestors.
+			s.idx = s.node.pos - 1
+		}
+		for s.idx >= 0 {
+			node := &s.node.doc.nodes[s.idx]
+			s.idx--
+			if node.kind == AttrNode {
+				continue
+			}
+			if node == s.node.doc.nodes[s.aux].up {
+				s.aux = s.node.doc.nodes[s.aux].up.pos
+				continue
+			}
+			if s.match(node) {
+				s.node = node
+				return true
+			}
+		}
+
+	case "preceding-sibling":
+		var down []int32
+		if s.node.up != nil {
+			down = s.node.up.downs()
+			if s.aux == 0 {
+				s.aux = 1
+				for s.idx < len(down) {
+					pos := int(down[s.idx])
+					s.idx++
+					if pos == s.node.pos {
+						s.idx -= 2
+						break
+					}
+				}
+			}
+		}
+		for s.idx >= 0 && s.idx < len(down) {
+			node := &s.node.doc.nodes[down[s.idx]]
+			s.idx--
+			if s.match(node) {
+				s.node = node
+				return true
+			}
+		}
+
+	case "namespace":
+		if s.idx == 0 {
+			s.idx++
+			s.decls = s.decls[:0]
+			if s.node.kind == StartNode {
+				s.decls = s.node.namespaceDecls(s.decls)
+			}
+		}
+		for s.aux < len(s.decls) {
+			node := s.decls[s.aux]
+			s.aux++
+			if s.step.name == "*" || node.name.Space == "xmlns" && node.name.Local == s.step.name {
+				s.node = node
+				return true
+			}
+		}
+
+	case "attribute":
+		if s.idx == 0 {
+			s.idx = s.node.pos + 1
+			s.aux = s.node.end
+		}
+		for s.idx < s.aux {
+			node := &s.node.doc.nodes[s.idx]
+			s.idx++
+			if node.kind != AttrNode {
+				break
+			}
+			if s.step.name == "*" && isNamespaceDecl(node.name) {
+				// Namespace declarations only match by name
+				continue
+			}
+			if s.match(node) {
+				s.node = node
+				return true
+			}
+		}
+
+	}
+
+	s.node = nil
+	return false
+}
+
+type pathStep struct {
+	root   bool
+	axis   string
+	prefix string
+	space  string
+	name   string
+	kind   NodeKind
+	fold   bool
+	preds  []pathPred
+
+	// Whether a predicate uses the context size
+	last bool
+}
+
+func (step *pathStep) match(node *Node) bool {
+	return node.kind != EndNode &&
+		(step.kind == AnyNode || step.kind == node.kind) &&
+		// Name tests do not match the root node, which has no name
+		(step.kind != StartNode || node.name.Local != "") &&
+		(step.name == "*" || node.name.Local == step.name || step.fold && strings.EqualFold(node.name.Local, step.name)) &&
+		(step.prefix == "*" || step.prefix == "" && step.name == "*" || node.name.Space == step.space)
+}
+
+// XHTMLNamespace is the namespace of XHTML elements, to be used as the
+// default namespace of paths applied to XHTML documents.
+const XHTMLNamespace = "http://www.w3.org/1999/xhtml"
+
+// MustCompile returns the compiled path, and panics if
+// there are any errors.
+func MustCompile(path string) *Path {
+	return MustCompileNS(path, nil)
+}
+
+func MustCompileNS(path string, ns map[string]string) *Path {
+	e, err := CompileNS(path, ns)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// Compile returns the compiled path.
+func Compile(path string) (*Path, error) {
+	return CompileNS(path, nil)
+}
+
+// CompileNS returns the compiled path, in which name prefixes are resolved
+// using ns. The namespace mapped to the empty prefix, if any, is the default
+// namespace of unprefixed element names, as in:
+//
+//	xmlpath.CompileNS("//a/@href", map[string]string{"": xmlpath.XHTMLNamespace})
+func CompileNS(path string, ns map[string]string) (*Path, error) {
+	return CompileWithOptions(path, Options{Namespaces: ns})
+}
+
+// MustCompileHTML returns the path compiled with CompileHTML, and panics if
+// there are any errors.
+func MustCompileHTML(path string) *Path {
+	e, err := CompileHTML(path)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// CompileHTML returns the compiled path, in which element and attribute
+// names match regardless of their case, as HTML names do.
+func CompileHTML(path string) (*Path, error) {
+	return CompileWithOptions(path, Options{FoldCase: true})
+}
+
+// Options are the options of CompileWithOptions. The zero value compiles
+// paths like Compile.
+type Options struct {
+	// Namespaces maps the prefixes used in the path to namespace URIs
+	Namespaces map[string]string
+
+	// DefaultNamespace is the namespace of unprefixed element names, unless
+	// Namespaces maps the empty prefix to another one
+	DefaultNamespace string
+
+	// FoldCase makes element and attribute names match regardless of their
+	// case, as HTML names do
+	FoldCase bool
+
+	// Functions are made available to the path in addition to the built-in
+	// functions and the ones registered with RegisterFunc, taking precedence
+	// over them. They are called like the functions given to RegisterFunc.
+	Functions map[string]func(args ...Value) Value
+
+	// MaxLength, MaxSteps and MaxDepth limit the length of the path in
+	// bytes, its number of location steps, including the ones of its
+	// predicates, and the nesting depth of its predicates, parenthesized
+	// expressions and function arguments, a[b[c]] being of depth 2. Zero
+	// means no limit. Limits protect programs compiling untrusted paths.
+	MaxLength int
+	MaxSteps  int
+	MaxDepth  int
+}
+
+// CompileWithOptions returns the path compiled with the given options.
+func CompileWithOptions(path string, opts Options) (*Path, error) {
+	ns := map[string]string{"": opts.DefaultNamespace}
+	for prefix, space := range opts.Namespaces {
+		ns[prefix] = space
+	}
+	c := pathCompiler{
+		path:     path,
+		foldCase: opts.FoldCase,
+		funcs:    opts.Functions,
+		maxSteps: opts.MaxSteps,
+		maxDepth: opts.MaxDepth,
+	}
+	if opts.MaxLength > 0 && len(path) > opts.MaxLength {
+		c.i = opts.MaxLength
+		return nil, c.errorf("path longer than %d bytes", opts.MaxLength)
+	}
+	return c.compile(ns)
+}
+
+// MustCompileWithOptions returns the path compiled with CompileWithOptions,
+// and panics if there are any errors.
+func MustCompileWithOptions(path string, opts Options) *Path {
+	e, err := CompileWithOptions(path, opts)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func (c *pathCompiler) compile(ns map[string]string) (*Path, error) {
+	if c.path == "" {
+		return nil, c.errorf("empty path")
+	}
+	if ns == nil {
+		ns = map[string]string{}
+	}
+	if _, ok := ns[""]; !ok {
+		ns[""] = ""
+	}
+	ns["xml"] = "http://www.w3.org/XML/1998/namespace"
+	ns["xmlns"] = "http://www.w3.org/2000/xmlns/"
+	val, err := c.parseExpr(ns)
+	if err != nil {
+		return nil, err
+	}
+	if c.i < len(c.path) {
+		return nil, c.errorf("unexpected %q", c.path[c.i])
+	}
+	if p, ok := val.(*exprPath); ok {
+		return p.path, nil
+	}
+	// Other expressions are evaluated by Eval, and are iterated over only
+	// when they evaluate to a node-set.
+	return &Path{path: c.path, base: val, baseSrc: strings.TrimSpace(c.path), namespaces: ns}, nil
+}
+
+type pathCompiler struct {
+	path string
+	i    int
+
+	// Whether names match regardless of their case
+	foldCase bool
+
+	// Functions available in addition to the registered ones
+	funcs map[string]func(args ...Value) Value
+
+	// Limits on the number of steps and on the nesting of expressions, if
+	// not zero, and the steps and nesting so far
+	maxSteps int
+	maxDepth int
+	steps    int
+	depth    int
+
+	// Whether the predicate being compiled uses last() or position()
+	last     bool
+	position bool
+}
+
+// CompileError is the error returned when a path fails to compile.
+type CompileError struct {
+	Path     string // Path being compiled
+	Offset   int    // Byte offset in Path at which the error was detected
+	Token    string // Token at Offset, empty at the end of Path
+	Expected string // Token or construct expected at Offset, if known
+	Msg      string // Description of the error
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("compiling xml path %q:%d: %s", e.Path, e.Offset, e.Msg)
+}
+
+func (c *pathCompiler) errorf(format string, args ...interface{}) error {
+	return c.errorExpected("", format, args...)
+}
+
+// errorExpected returns an error for the path at the current position, where
+// expected was expected
+func (c *pathCompiler) errorExpected(expected, format string, args ...interface{}) error {
+	return &CompileError{
+		Path:     c.path,
+		Offset:   c.i,
+		Token:    c.token(),
+		Expected: expected,
+		Msg:      fmt.Sprintf(format, args...),
+	}
+}
+
+// token returns the name or the character at the current position
+func (c *pathCompiler) token() string {
+	mark := c.i
+	defer func() { c.i = mark }()
+	if c.i >= len(c.path) {
+		return ""
+	} else if c.skipName() {
+		return c.path[mark:c.i]
+	}
+	_, size := utf8.DecodeRuneInString(c.path[mark:])
+	return c.path[mark : mark+size]
+}
+
+func (c *pathCompiler) parsePath(ns map[string]string) (path *Path, err error) {
+	var steps []pathStep
+	var start = c.i
+	for {
+		step := pathStep{axis: "child", prefix: "", fold: c.foldCase}
+
+		if c.i == start && c.skipByte('/') {
+			step.root = true
+			if c.i == len(c.path) || !isPathStart(c.path[c.i]) {
+				// A lone slash selects the root node itself.
+				step.axis = "self"
+				step.name = "*"
+				path := &Path{steps: []pathStep{step}, path: c.path[start:c.i], namespaces: ns}
+				path.optimize()
+				return path, nil
+			}
+		}
+		if c.peekByte('/') {
+			step.axis = "descendant-or-self"
+			step.name = "*"
+		} else if c.skipByte('@') {
+			mark := c.i
+			if !c.skipName() {
+				return nil, c.errorExpected("name", "missing name after @")
+			}
+			step.axis = "attribute"
+			step.prefix, step.name = extractPrefix(c.path[mark:c.i])
+			step.kind = AttrNode
+			if step.prefix == "" && step.name == "node" && c.skipByte('(') {
+				if !c.skipByte(')') {
+					return nil, c.errorExpected(")", "missing )")
+				}
+				step.name = "*"
+			}
+		} else {
+			mark := c.i
+			if c.skipName() {
+				step.name = c.path[mark:c.i]
+			}
+			if step.name == "" {
+				return nil, c.errorExpected("name", "missing name")
+			} else if step.name == "*" {
+				step.kind = StartNode
+			} else if step.name == "." {
+				step.axis = "self"
+				step.name = "*"
+			} else if step.name == ".." {
+				step.axis = "parent"
+				step.name = "*"
+			} else {
+				if c.skipByte(':') {
+					if !c.skipByte(':') {
+						return nil, c.errorExpected(":", "missing ':'")
+					}
+					switch step.name {
+					case "attribute", "namespace":
+						step.kind = AttrNode
+					case "self", "child", "parent":
+					case "descendant", "descendant-or-self":
+					case "ancestor", "ancestor-or-self":
+					case "following", "following-sibling":
+					case "preceding", "preceding-sibling":
+					default:
+						return nil, c.errorf("unsupported axis: %q", step.name)
+					}
+					step.axis = step.name
+
+					mark = c.i
+					if !c.skipName() {
+						return nil, c.errorExpected("name", "missing name")
+					}
+					step.name = c.path[mark:c.i]
+				}
+				if c.skipByte('(') {
+					conflict := step.kind != AnyNode
+					switch step.name {
+					case "node":
+						// any node on the axis
+						conflict = false
+					case "text":
+						step.kind = TextNode
+					case "comment":
+						step.kind = CommentNode
+					case "processing-instruction":
+						step.kind = ProcInstNode
+					default:
+						return nil, c.errorf("unsupported expression: %s()", step.name)
+					}
+					if conflict {
+						return nil, c.errorf("%s() cannot succeed on axis %q", step.name, step.axis)
+					}
+
+					literal, err := c.parseLiteral()
+					if err == errNoLiteral {
+						step.name = "*"
+					} else if err != nil {
+						return nil, err
+					} else if step.kind == ProcInstNode {
+						step.name = literal
+					} else {
+						return nil, c.errorf("%s() has no arguments", step.name)
+					}
+					if !c.skipByte(')') {
+						return nil, c.errorExpected(")", "missing )")
+					}
+				} else if step.name == "*" && step.kind == AnyNode {
+					step.kind = StartNode
+				}
+			}
+			if step.kind != ProcInstNode {
+				step.prefix, step.name = extractPrefix(step.name)
+			}
+			if step.kind == AnyNode && (step.prefix != "" || step.name != "*") {
+				// Name tests only match elements
+				step.kind = StartNode
+			}
+		}
+		if step.prefix != "" && step.prefix != "*" {
+			space, ok := ns[step.prefix]
+			if !ok {
+				return nil, c.errorf("undeclared prefix %q", step.prefix)
+			}
+			step.space = space
+		} else if step.kind == AnyNode || step.kind == StartNode {
+			// The default namespace only applies to element names
+			step.space = ns[step.prefix]
+		}
+		for c.skipByte('[') {
+			pred, err := c.parsePredicate(ns)
+			if err != nil {
+				return nil, err
+			}
+			step.preds = append(step.preds, pred)
+			step.last = step.last || pred.last
+		}
+		steps = append(steps, step)
+		c.steps++
+		if c.maxSteps > 0 && c.steps > c.maxSteps {
+			return nil, c.errorf("path has more than %d steps", c.maxSteps)
+		}
+		//fmt.Printf("step: %#v\n", step)
+		if !c.skipByte('/') {
+			if start == c.i && c.i < len(c.path) {
+				return nil, c.errorf("unexpected %q", c.path[c.i])
+			}
+			path := &Path{steps: steps, path: c.path[start:c.i], namespaces: ns}
+			path.optimize()
+			return path, nil
+		}
+	}
+}
+
+// parsePredicate parses a predicate after its opening bracket
+func (c *pathCompiler) parsePredicate(ns map[string]string) (pathPred, error) {
+	outerLast, outerPosition := c.last, c.position
+	c.last, c.position = false, false
+	start := c.i
+	pred, err := c.parseExpr(ns)
+	if err != nil {
+		return pathPred{}, err
+	}
+	if n, ok := pred.(*exprNumber); ok && n.val == 0 {
+		return pathPred{}, c.errorf("positions start at 1")
+	} else if ok && n.val < 0 {
+		return pathPred{}, c.errorf("positions must be positive")
+	}
+	last, position := c.last, c.position
+	c.last, c.position = outerLast, outerPosition
+	src := strings.TrimSpace(c.path[start:c.i])
+	if !c.skipByte(']') {
+		return pathPred{}, c.errorExpected("]", "expected ']'")
+	}
+	return pathPred{pred: pred, last: last, pos: position, src: src}, nil
+}
+
+func (c *pathCompiler) parseExpr(ns map[string]string) (pred expr, err error) {
+	if c.maxDepth > 0 && c.depth > c.maxDepth {
+		return nil, c.errorf("expressions nested deeper than %d levels", c.maxDepth)
+	}
+	c.depth++
+	defer func() { c.depth-- }()
+	return c.parseOrExpr(ns)
+}
+
+func (c *pathCompiler) parseOrExpr(ns map[string]string) (pred expr, err error) {
+	c.skipSpaces()
+	lval, err := c.parseAndExpr(ns)
+	if err != nil {
+		return nil, err
+	}
+	expr := &exprOpOr{vals: []expr{lval}}
+	pred = expr
+
+	for {
+		c.skipSpaces()
+		if !c.skipKeyword("or") {
+			if len(expr.vals) == 1 {
+				return lval, nil
+			} else {
+				return pred, nil
+			}
+		}
+
+		rval, err := c.parseAndExpr(ns)
+		if err != nil {
+			return nil, err
+		}
+		expr.vals = append(expr.vals, rval)
+	}
+}
+
+func (c *pathCompiler) parseAndExpr(ns map[string]string) (pred expr, err error) {
+	c.skipSpaces()
+	lval, err := c.parseEqualityExpr(ns)
+	if err != nil {
+		return nil, err
+	}
+	expr := &exprOpAnd{vals: []expr{lval}}
+	pred = expr
+
+	for {
+		c.skipSpaces()
+		if !c.skipKeyword("and") {
+			if len(expr.vals) == 1 {
+				return lval, nil
+			} else {
+				return pred, nil
+			}
+		}
+
+		rval, err := c.parseEqualityExpr(ns)
+		if err != nil {
+			return nil, err
+		}
+		expr.vals = append(expr.vals, rval)
+	}
+}
+
+func (c *pathCompiler) parseEqualityExpr(ns map[string]string) (expr, error) {
+	return c.parseBinaryExpr(ns, []string{"!=", "="}, c.parseRelationalExpr, newCompare)
+}
+
+func (c *pathCompiler) parseRelationalExpr(ns map[string]string) (expr, error) {
+	return c.parseBinaryExpr(ns, []string{"<=", ">=", "<", ">"}, c.parseAdditiveExpr, newCompare)
+}
+
+func (c *pathCompiler) parseAdditiveExpr(ns map[string]string) (expr, error) {
+	return c.parseBinaryExpr(ns, []string{"+", "-"}, c.parseMultiplicativeExpr, newArith)
+}
+
+func (c *pathCompiler) parseMultiplicativeExpr(ns map[string]string) (expr, error) {
+	return c.parseBinaryExpr(ns, []string{"*", "div", "mod"}, c.parseUnaryExpr, newArith)
+}
+
+// parseBinaryExpr parses a sequence of left associative binary operations
+// using one of ops. The operands are parsed with next.
+func (c *pathCompiler) parseBinaryExpr(ns map[string]string, ops []string, next func(ns map[string]string) (expr, error), build func(op string, lval, rval expr) expr) (expr, error) {
+	lval, err := next(ns)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		c.skipSpaces()
+		op := c.parseOperator(ops)
+		if op == "" {
+			return lval, nil
+		}
+		c.skipSpaces()
+		rval, err := next(ns)
+		if err != nil {
+			return nil, err
+		}
+		lval = build(op, lval, rval)
+	}
+}
+
+func newCompare(op string, lval, rval expr) expr {
+	if path, ok := lval.(*exprPath); ok && op == "=" {
+		if sval, ok := rval.(*exprString); ok {
+			return &exprOpEq{path.path, sval.val}
+		}
+	}
+	return &exprCompare{op, lval, rval}
+}
+
+func newArith(op string, lval, rval expr) expr {
+	return &exprArith{op, lval, rval}
+}
+
+func (c *pathCompiler) parseUnaryExpr(ns map[string]string) (expr, error) {
+	c.skipSpaces()
+	if !c.skipByte('-') {
+		return c.parseUnionExpr(ns)
+	}
+	val, err := c.parseUnaryExpr(ns)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := val.(*exprNumber); ok {
+		return &exprNumber{-n.val}, nil
+	}
+	return &exprNegate{val}, nil
+}
+
+func (c *pathCompiler) parseUnionExpr(ns map[string]string) (expr, error) {
+	return c.parseSetExpr(ns, []string{"|"}, c.parseIntersectExceptExpr)
+}
+
+func (c *pathCompiler) parseIntersectExceptExpr(ns map[string]string) (expr, error) {
+	return c.parseSetExpr(ns, []string{"intersect", "except"}, c.parsePathExpr)
+}
+
+// parseSetExpr parses a sequence of set operations using one of ops on paths
+// parsed with next
+func (c *pathCompiler) parseSetExpr(ns map[string]string, ops []string, next func(ns map[string]string) (expr, error)) (expr, error) {
+	start := c.i
+	val, err := next(ns)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		c.skipSpaces()
+		op := c.parseOperator(ops)
+		if op == "" {
+			return val, nil
+		}
+		c.skipSpaces()
+		rval, err := next(ns)
+		if err != nil {
+			return nil, err
+		}
+		lpath, lok := val.(*exprPath)
+		rpath, rok := rval.(*exprPath)
+		if !lok || !rok {
+			if op == "|" {
+				op = "union"
+			}
+			return nil, c.errorf("%s operands must be paths", op)
+		}
+		val = &exprPath{setPath(op, c.path[start:c.i], lpath.path, rpath.path)}
+	}
+}
+
+func (c *pathCompiler) parsePathExpr(ns map[string]string) (expr, error) {
+	start := c.i
+	val, err := c.parseOperand(ns)
+	if err != nil {
+		return nil, err
+	} else if val != nil {
+		return c.parseFilterExpr(ns, start, val)
+	}
+	if c.i >= len(c.path) || !isPathStart(c.path[c.i]) {
+		return nil, c.errorExpected("expression", "expected an expression")
+	}
+	path, err := c.parsePath(ns)
+	if err != nil {
+		return nil, err
+	}
+	return &exprPath{path}, nil
+}
+
+// isPathStart returns whether a location path may start with byte b
+func isPathStart(b byte) bool {
+	return strings.IndexByte("/@.*", b) >= 0 || b >= utf8.RuneSelf || isNameByte(b)
+}
+
+// parseFilterExpr parses the predicates and the relative path that may follow
+// the operand val starting at start
+func (c *pathCompiler) parseFilterExpr(ns map[string]string, start int, val expr) (expr, error) {
+	if !c.peekByte('[') && !c.peekByte('/') {
+		return val, nil
+	}
+	switch val.(type) {
+	case *exprString, *exprNumber, *exprBool, *exprArith, *exprNegate, *exprCompare, *exprOpEq, *exprOpOr, *exprOpAnd:
+		return nil, c.errorf("predicates and paths only apply to node-sets")
+	}
+	filter := &Path{base: val, baseSrc: strings.TrimSpace(c.path[start:c.i]), namespaces: ns}
+	for c.skipByte('[') {
+		pred, err := c.parsePredicate(ns)
+		if err != nil {
+			return nil, err
+		}
+		filter.preds = append(filter.preds, pred)
+	}
+	if c.skipByte('/') {
+		if c.peekByte('/') {
+			// The path parser only handles // between steps
+			c.i++
+			filter.steps = append(filter.steps, pathStep{axis: "descendant-or-self", name: "*"})
+		}
+		rel, err := c.parsePath(ns)
+		if err != nil {
+			return nil, err
+		}
+		filter.steps = append(filter.steps, rel.steps...)
+	}
+	filter.path = c.path[start:c.i]
+	filter.optimize()
+	return &exprPath{filter}, nil
+}
+
+// parseOperand parses a number, a literal, a variable, a function call or a
+// parenthesized expression, or returns nil
+// if there is none
+func (c *pathCompiler) parseOperand(ns map[string]string) (expr, error) {
+	if nval, ok := c.parseNumber(); ok {
+		return &exprNumber{nval}, nil
+	}
+	if sval, err := c.parseLiteral(); err != errNoLiteral {
+		if err != nil {
+			return nil, err
+		}
+		return &exprString{sval}, nil
+	}
+	if c.skipByte('(') {
+		val, err := c.parseExpr(ns)
+		if err != nil {
+			return nil, err
+		}
+		c.skipSpaces()
+		if !c.skipByte(')') {
+			return nil, c.errorExpected(")", "missing )")
+		}
+		return val, nil
+	}
+	if c.skipByte('$') {
+		mark := c.i
+		if c.peekN(1) == '*' || !c.skipName() {
+			return nil, c.errorExpected("name", "expected a variable name")
+		}
+		return &exprVar{c.path[mark:c.i]}, nil
+	}
+	return c.parseFunction(ns)
+}
+
+// parseFunction parses a function call, or returns nil if there is none
+func (c *pathCompiler) parseFunction(ns map[string]string) (fn expr, err error) {
+	mark := c.i
+	if !c.skipName() {
+		return nil, nil
+	}
+	name := c.path[mark:c.i]
+	c.skipSpaces()
+	f, ok := c.lookupFunction(name)
+	if !ok || !c.skipByte('(') {
+		// Node tests are parsed with the path
+		c.i = mark
+		return nil, nil
+	}
+
+	var args []expr
+	c.skipSpaces()
+	if !c.skipByte(')') {
+		for {
+			arg, err := c.parseExpr(ns)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			c.skipSpaces()
+			if c.skipByte(')') {
+				break
+			} else if !c.skipByte(',') {
+				return nil, c.errorExpected(")", "missing )")
+			}
+		}
+	}
+
+	if f.max == 0 && len(args) > 0 {
+		return nil, c.errorf("%s() has no arguments", name)
+	} else if len(args) < f.min || (f.max >= 0 && len(args) > f.max) {
+		return nil, c.errorf("wrong number of arguments for %s()", name)
+	}
+	return f.build(c, args)
+}
+
+// parseOperator parses one of ops. Operators made of letters must not be
+// followed by a name character.
+func (c *pathCompiler) parseOperator(ops []string) string {
+	for _, op := range ops {
+		if op[0] >= 'a' && op[0] <= 'z' {
+			if c.skipKeyword(op) {
+				return op
+			}
+		} else if c.skipString(op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func (c *pathCompiler) skipKeyword(kw string) bool {
+	mark := c.i
+	if !c.skipString(kw) {
+		return false
+	}
+	if c.i < len(c.path) && (c.path[c.i] >= utf8.RuneSelf || isNameByte(c.path[c.i])) {
+		c.i = mark
+		return false
+	}
+	return true
+}
+
+func extractPrefix(fullname string) (string, string) {
+	i := strings.Index(fullname, ":")
+	if i == -1 || i == len(fullname)-1 {
+		return "", fullname
+	} else {
+		return fullname[:i], fullname[i+1:]
+	}
+}
+
+var errNoLiteral = fmt.Errorf("expected a literal string")
+
+func (c *pathCompiler) parseLiteral() (string, error) {
+	if c.skipByte('"') {
+		return c.parseQuoted('"', `'"'`)
+	}
+	if c.skipByte('\'') {
+		return c.parseQuoted('\'', `"'"`)
+	}
+	return "", errNoLiteral
+}
+
+// parseQuoted parses the rest of a literal opened with quote, in which a
+// doubled quote stands for the quote itself.
+func (c *pathCompiler) parseQuoted(quote byte, quoted string) (string, error) {
+	var buf []byte
+	mark := c.i
+	for {
+		if !c.skipByteFind(quote) {
+			return "", c.errorExpected(string(quote), "missing %s", quoted)
+		}
+		if !c.peekByte(quote) {
+			break
+		}
+		buf = append(buf, c.path[mark:c.i]...)
+		c.i++
+		mark = c.i
+	}
+	if buf == nil {
+		return c.path[mark : c.i-1], nil
+	}
+	return string(append(buf, c.path[mark:c.i-1]...)), nil
+}
+
+func (c *pathCompiler) parseInt() (v int, ok bool) {
+	mark := c.i
+	for c.i < len(c.path) && c.path[c.i] >= '0' && c.path[c.i] <= '9' {
+		v *= 10
+		v += int(c.path[c.i]) - '0'
+		c.i++
+	}
+	if c.i == mark {
+		return 0, false
+	}
+	return v, true
+}
+
+// parseNumber parses a number with optional decimals, such as 2, 1.5 or .5
+func (c *pathCompiler) parseNumber() (v float64, ok bool) {
+	mark := c.i
+	c.parseInt()
+	if c.i+1 < len(c.path) && c.path[c.i] == '.' && c.path[c.i+1] >= '0' && c.path[c.i+1] <= '9' {
+		c.i++
+		c.parseInt()
+	} else if c.i < len(c.path) && c.path[c.i] == '.' && c.i > mark {
+		c.i++
+	}
+	if c.i == mark {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(c.path[mark:c.i], 64)
+	if err != nil {
+		c.i = mark
+		return 0, false
+	}
+	return v, true
+}
+
+func (c *pathCompiler) skipSpaces() bool {
+	res := false
+	for c.i < len(c.path) && strings.ContainsAny(string(c.path[c.i]), " \t\n\v") {
+		c.i++
+		res = true
+	}
+	return res
+}
+
+func (c *pathCompiler) skipString(str string) bool {
+	if c.i+len(str)-1 < len(c.path) && c.path[c.i:c.i+len(str)] == str {
+		c.i += len(str)
+		return true
+	}
+	return false
+}
+
+func (c *pathCompiler) skipByte(b byte) bool {
+	if c.i < len(c.path) && c.path[c.i] == b {
+		c.i++
+		return true
+	}
+	return false
+}
+
+func (c *pathCompiler) skipByteFind(b byte) bool {
+	for i := c.i; i < len(c.path); i++ {
+		if c.path[i] == b {
+			c.i = i + 1
+			return true
+		}
+	}
+	return false
+}
+
+func (c *pathCompiler) peekByte(b byte) bool {
+	return c.i < len(c.path) && c.path[c.i] == b
+}
+
+// Peek the Nth byte or return '\0'
+// N=1 means the next byte
+func (c *pathCompiler) peekN(offset int) byte {
+	i := c.i + offset - 1
+	if i >= len(c.path) {
+		return 0
+	} else {
+		return c.path[i]
+	}
+}
+
+func (c *pathCompiler) skipName() bool {
+	if c.i >= len(c.path) {
+		return false
+	}
+	start := c.i
+	if c.path[c.i] == '*' {
+		c.i++
+	} else {
+		for c.i < len(c.path) && (c.path[c.i] >= utf8.RuneSelf || isNameByte(c.path[c.i])) {
+			c.i++
+		}
+	}
+	// Allow namespace separator once, with a wildcard on either side
+	if c.peekN(1) == ':' && (c.peekN(2) >= utf8.RuneSelf || isNameByte(c.peekN(2))) {
+		c.i++
+		for c.i < len(c.path) && (c.path[c.i] >= utf8.RuneSelf || isNameByte(c.path[c.i])) {
+			c.i++
+		}
+	} else if c.peekN(1) == ':' && c.peekN(2) == '*' && c.i > start {
+		c.i += 2
+	}
+	return c.i > start
+}
+
+func isNameByte(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' || c == '.' || c == '-'
+}
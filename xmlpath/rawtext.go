@@ -0,0 +1,211 @@
+package xmlpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+)
+
+// escapableRawText are the HTML elements of which the content is text up to
+// their end tag, as the one of the elements of htmlRawText, but with its
+// character references decoded
+var escapableRawText = map[string]bool{
+	"textarea": true, "title": true,
+}
+
+// rawTextScanner finds the raw text of the HTML elements in the source read
+// by a sourceReader, and hides its markup from the decoder, which would
+// otherwise take the < and & of scripts as markup, or ]]> as an error. The
+// text is then taken from the source by sourceReader.rawText.
+type rawTextScanner struct {
+	// What was read from the source and not yet given to the decoder, of
+	// which the first scanned bytes were scanned, and the error which
+	// ended the source
+	next    []byte
+	scanned int
+	err     error
+
+	// Offset in the source of the start of next
+	offset int64
+
+	// Where the scanner is: in text, a tag, a comment, a CDATA section or
+	// raw text. In tags, the name of the element if its content is raw
+	// text, the quote of the attribute value being scanned, whether an
+	// attribute value may start, and whether the tag ends with /.
+	state int
+	elem  string
+	quote byte
+	value bool
+	slash bool
+
+	// Start of the raw text found and not yet decoded
+	raws []rawText
+}
+
+// rawText is where the content of a raw text element starts
+type rawText struct {
+	offset int64
+	elem   string
+}
+
+const (
+	scanText = iota
+	scanTag
+	scanComment
+	scanCDATA
+	scanRawText
+)
+
+// rawTextPlaceholder replaces the <, > and & of raw text for the decoder
+const rawTextPlaceholder = '_'
+
+// read reads from r into p what the decoder is given of the source
+func (rs *rawTextScanner) read(r io.Reader, p []byte) (int, []byte, error) {
+	var read []byte
+	for rs.scanned == 0 && rs.err == nil {
+		chunk := make([]byte, len(p))
+		n, err := r.Read(chunk)
+		read = append(read, chunk[:n]...)
+		rs.next = append(rs.next, chunk[:n]...)
+		rs.err = err
+		rs.scan()
+	}
+	if rs.scanned == 0 {
+		return 0, read, rs.err
+	}
+	n := copy(p, rs.next[:rs.scanned])
+	rs.next = append(rs.next[:0], rs.next[n:]...)
+	rs.scanned -= n
+	rs.offset += int64(n)
+	return n, read, nil
+}
+
+// scan scans next from scanned on, as far as it can tell where the bytes
+// are, replacing the <, > and & of raw text
+func (rs *rawTextScanner) scan() {
+	b, eof := rs.next, rs.err != nil
+	i := rs.scanned
+	defer func() { rs.scanned = i }()
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch rs.state {
+		case scanText:
+			if c != '<' {
+				continue
+			}
+			rest := b[i+1:]
+			if len(rest) < len("![CDATA[") && !eof {
+				return
+			}
+			switch {
+			case bytes.HasPrefix(rest, []byte("!--")):
+				rs.state = scanComment
+				i += 3
+			case bytes.HasPrefix(rest, []byte("![CDATA[")):
+				rs.state = scanCDATA
+				i += 8
+			default:
+				rs.state, rs.elem, rs.quote, rs.value, rs.slash = scanTag, "", 0, false, false
+				name := rest
+				for j := range rest {
+					if !isTagNameByte(rest[j]) {
+						name = rest[:j]
+						break
+					}
+				}
+				if lower := string(bytes.ToLower(name)); htmlRawText[lower] || escapableRawText[lower] {
+					rs.elem = lower
+				}
+			}
+		case scanTag:
+			switch {
+			case rs.quote != 0:
+				if c == rs.quote {
+					rs.quote = 0
+				}
+			case c == '>':
+				rs.state = scanText
+				if rs.elem != "" && !rs.slash {
+					rs.state = scanRawText
+					rs.raws = append(rs.raws, rawText{rs.offset + int64(i) + 1, rs.elem})
+				}
+			case (c == '"' || c == '\'') && rs.value:
+				rs.quote = c
+			}
+			if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+				rs.value, rs.slash = c == '=', c == '/'
+			}
+		case scanComment, scanCDATA:
+			end := "-->"
+			if rs.state == scanCDATA {
+				end = "]]>"
+			}
+			if c != end[0] {
+				continue
+			}
+			if len(b)-i < len(end) && !eof {
+				return
+			}
+			if bytes.HasPrefix(b[i:], []byte(end)) {
+				rs.state = scanText
+				i += len(end) - 1
+			}
+		case scanRawText:
+			if c == '&' || c == '>' {
+				b[i] = rawTextPlaceholder
+			}
+			if c != '<' {
+				continue
+			}
+			if len(b)-i < len(rs.elem)+3 && !eof {
+				return
+			}
+			if end := b[i+1:]; len(end) > len(rs.elem) && end[0] == '/' && bytes.EqualFold(end[1:1+len(rs.elem)], []byte(rs.elem)) {
+				if len(end) == 1+len(rs.elem) || !isTagNameByte(end[1+len(rs.elem)]) {
+					// The end tag is scanned as text
+					rs.state = scanText
+					i--
+					continue
+				}
+			}
+			b[i] = rawTextPlaceholder
+		}
+	}
+}
+
+// isTagNameByte returns whether c may be part of the name of an HTML tag
+func isTagNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == ':' || c == '.' || c >= 0x80
+}
+
+// rawText returns the text of the raw text element of which the content
+// goes from start to end in the source, with its line breaks normalized, and
+// false if it is not the content of a raw text element
+func (s *sourceReader) rawText(start, end int64) (xml.CharData, bool) {
+	if s == nil || s.raw == nil {
+		return nil, false
+	}
+	rs := s.raw
+	for len(rs.raws) > 0 && rs.raws[0].offset < start {
+		rs.raws = rs.raws[1:]
+	}
+	if len(rs.raws) == 0 || rs.raws[0].offset != start {
+		return nil, false
+	}
+	elem := rs.raws[0].elem
+	rs.raws = rs.raws[1:]
+	text := bytes.Replace(s.at(start)[:end-start], []byte("\r\n"), []byte("\n"), -1)
+	text = bytes.Replace(text, []byte("\r"), []byte("\n"), -1)
+	if escapableRawText[elem] {
+		escaped := bytes.Replace(text, []byte("<"), []byte("&lt;"), -1)
+		escaped = bytes.Replace(escaped, []byte(">"), []byte("&gt;"), -1)
+		d := xml.NewDecoder(bytes.NewReader(escaped))
+		htmlDecoder(d, nil)
+		if t, err := d.Token(); err == nil {
+			if t, ok := t.(xml.CharData); ok {
+				return t.Copy(), true
+			}
+		}
+	}
+	return text, true
+}
@@ -0,0 +1,508 @@
+package xmlpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// WriteXML writes n and its content to w as XML. The namespaces declared by
+// the ancestors of n are declared again on n, and the namespaces of the
+// elements and attributes are declared where missing, so that the output is a
+// well-formed document or fragment on its own. The nodes of documents parsed
+// with KeepSource are written as they were in the source, except for the ones
+// modified since.
+func (n *Node) WriteXML(w io.Writer) error {
+	_, err := w.Write(n.XML())
+	return err
+}
+
+// WriteXMLIndent is like WriteXML, but starts each line with prefix and puts
+// each element with no text content of its own on a line of its own, indented
+// by copies of indent according to its depth. The text of elements with text
+// content is written unchanged, without any indentation. The source of the
+// nodes parsed with KeepSource is not used.
+func (n *Node) WriteXMLIndent(w io.Writer, prefix, indent string) error {
+	xw := &xmlWriter{prefix: prefix, indent: indent}
+	xw.line(0)
+	xw.write(n, indent != "" || prefix != "")
+	_, err := w.Write(xw.buf)
+	return err
+}
+
+// WriteHTML writes n and its content to w as HTML. Unlike WriteXML, it
+// writes void elements such as <br> and <img> without end tags, boolean
+// attributes such as checked without values, and the text of raw text
+// elements such as <script> and <style> without escaping it. Namespaces are
+// never declared. As with WriteXML, the nodes of documents parsed with
+// KeepSource are written as they were in the source unless modified.
+func (n *Node) WriteHTML(w io.Writer) error {
+	_, err := w.Write(n.HTML())
+	return err
+}
+
+// HTML returns n and its content formatted as HTML, as written by WriteHTML.
+func (n *Node) HTML() []byte {
+	xw := &xmlWriter{html: true}
+	xw.write(n, false)
+	return xw.buf
+}
+
+// XML returns n and its content formatted as XML, as written by WriteXML.
+func (n *Node) XML() []byte {
+	xw := &xmlWriter{}
+	xw.write(n, false)
+	return xw.buf
+}
+
+// OuterHTML returns n and its content formatted as HTML, as HTML does.
+func (n *Node) OuterHTML() string {
+	return string(n.HTML())
+}
+
+// InnerHTML returns the content of the element n formatted as HTML, without
+// its start and end tags. It is empty for nodes other than elements and root
+// nodes.
+func (n *Node) InnerHTML() string {
+	xw := &xmlWriter{html: true}
+	xw.inner(n)
+	return string(xw.buf)
+}
+
+// OuterXML returns n and its content formatted as XML, as XML does.
+func (n *Node) OuterXML() string {
+	return string(n.XML())
+}
+
+// InnerXML returns the content of the element n formatted as XML, without
+// its start and end tags, the namespaces in scope on n being declared again
+// on the elements of the content. It is empty for nodes other than elements
+// and root nodes.
+func (n *Node) InnerXML() string {
+	xw := &xmlWriter{}
+	xw.inner(n)
+	return string(xw.buf)
+}
+
+// xmlWriter formats nodes to XML
+type xmlWriter struct {
+	buf     []byte
+	prefix  string
+	indent  string
+	started bool
+	html    bool
+
+	// Declarations of the ancestors to write on the next start tag, and
+	// whether its source, which relies on them, may not be written instead
+	decls     []*Node
+	inherited bool
+}
+
+// write writes n as a document or a fragment on its own
+func (xw *xmlWriter) write(n *Node, indent bool) {
+	ns := (&Node{kind: StartNode}).namespaces(nil)
+	if n.up != nil {
+		if n.kind == StartNode {
+			xw.decls = n.up.namespaceDecls(nil)
+			xw.inherited = len(xw.decls) > 0
+		} else {
+			ns = n.up.FindNamespaces()
+		}
+	}
+	xw.node(n, ns, 0, indent)
+}
+
+// inner writes the content of n as a fragment on its own
+func (xw *xmlWriter) inner(n *Node) {
+	if n.kind != StartNode {
+		return
+	} else if n.name.Local == "" {
+		xw.write(n, false)
+		return
+	}
+	ns := (&Node{kind: StartNode}).namespaces(nil)
+	decls := n.namespaceDecls(nil)
+	for _, c := range n.content() {
+		if c.kind == StartNode {
+			xw.decls, xw.inherited = decls, len(decls) > 0
+		}
+		xw.node(c, ns, 0, false)
+	}
+}
+
+// line starts a new line for a node at depth, when indenting
+func (xw *xmlWriter) line(depth int) {
+	if xw.prefix == "" && xw.indent == "" {
+		return
+	}
+	if xw.started {
+		xw.buf = append(xw.buf, '\n')
+	}
+	xw.started = true
+	xw.buf = append(xw.buf, xw.prefix...)
+	for i := 0; i < depth; i++ {
+		xw.buf = append(xw.buf, xw.indent...)
+	}
+}
+
+// node writes n, within the namespaces ns, indenting its content if indent
+func (xw *xmlWriter) node(n *Node, ns map[string]string, depth int, indent bool) {
+	switch n.kind {
+	case StartNode:
+		named := n.name.Local != ""
+		children := n.content()
+		verbatim := false
+		if named {
+			verbatim = xw.verbatim(n) && !(len(children) > 0 && bytes.HasSuffix(n.src, []byte("/>")))
+			if verbatim {
+				xw.buf = append(xw.buf, n.src...)
+				ns = n.namespaces(ns)
+				xw.decls, xw.inherited = nil, false
+			} else {
+				ns = xw.startTag(n, ns)
+			}
+			if xw.html && htmlVoid[strings.ToLower(n.name.Local)] {
+				return
+			}
+			depth++
+		}
+		// The namespaces declared on the root node of fragments are
+		// declared again on their elements
+		var decls []*Node
+		if !named {
+			decls = n.namespaceDecls(nil)
+		}
+		if indent && !textContent(children) {
+			count := 0
+			for _, c := range children {
+				if c.kind != TextNode {
+					if named || count > 0 {
+						xw.line(depth)
+					}
+					xw.decls = decls
+					xw.node(c, ns, depth, true)
+					count++
+				}
+			}
+			if named && count > 0 {
+				xw.line(depth - 1)
+			}
+		} else {
+			for _, c := range children {
+				xw.decls = decls
+				xw.node(c, ns, depth, false)
+			}
+		}
+		if end := &n.doc.nodes[n.end]; verbatim && end.src != nil {
+			xw.buf = append(xw.buf, end.src...)
+		} else if named {
+			xw.endTag(n, ns)
+		}
+	case EndNode:
+		start := &n.doc.nodes[n.end]
+		if start.name.Local != "" {
+			xw.endTag(start, start.namespaces(ns))
+		}
+	case AttrNode:
+		xw.attr(n, ns)
+	case TextNode, CommentNode, ProcInstNode:
+		if xw.verbatim(n) {
+			xw.buf = append(xw.buf, n.src...)
+		} else {
+			xw.markup(n)
+		}
+	}
+}
+
+// markup writes the text, comment or processing instruction n
+func (xw *xmlWriter) markup(n *Node) {
+	switch n.kind {
+	case TextNode:
+		if n.cdata && !xw.html {
+			xw.buf = appendCDATA(xw.buf, n.text)
+		} else if !xw.html {
+			xw.buf = appendEscaped(xw.buf, n.text)
+		} else if n.up != nil && htmlRawText[strings.ToLower(n.up.name.Local)] {
+			xw.buf = append(xw.buf, n.text...)
+		} else {
+			xw.buf = appendHTMLEscaped(xw.buf, n.text, false)
+		}
+	case CommentNode:
+		xw.buf = append(xw.buf, "<!--"...)
+		xw.buf = append(xw.buf, n.text...)
+		xw.buf = append(xw.buf, "-->"...)
+	case ProcInstNode:
+		xw.buf = append(xw.buf, "<?"...)
+		xw.buf = append(xw.buf, n.name.Local...)
+		if len(n.text) > 0 {
+			xw.buf = append(xw.buf, ' ')
+			xw.buf = append(xw.buf, n.text...)
+		}
+		if xw.html {
+			xw.buf = append(xw.buf, '>')
+		} else {
+			xw.buf = append(xw.buf, "?>"...)
+		}
+	}
+}
+
+// appendCDATA appends text to buf as a CDATA section, split where text
+// contains the end of one
+func appendCDATA(buf, text []byte) []byte {
+	buf = append(buf, "<![CDATA["...)
+	for {
+		i := bytes.Index(text, []byte("]]>"))
+		if i < 0 {
+			break
+		}
+		buf = append(buf, text[:i+2]...)
+		buf = append(buf, "]]><![CDATA["...)
+		text = text[i+2:]
+	}
+	buf = append(buf, text...)
+	return append(buf, "]]>"...)
+}
+
+// verbatim returns whether n may be written as in its source, which is not
+// when indenting, nor when declaring the namespaces of the ancestors of n in
+// XML
+func (xw *xmlWriter) verbatim(n *Node) bool {
+	return n.src != nil && xw.prefix == "" && xw.indent == "" && (xw.html || !xw.inherited)
+}
+
+// content returns the children of the element n other than its attributes
+func (n *Node) content() []*Node {
+	var res []*Node
+	for _, pos := range n.downs() {
+		if c := &n.doc.nodes[pos]; c.kind != AttrNode {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// textContent returns whether nodes include text other than white space
+func textContent(nodes []*Node) bool {
+	for _, c := range nodes {
+		if c.kind == TextNode && strings.TrimSpace(string(c.text)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// startTag writes the start tag of the element n, declaring the namespaces
+// missing from ns, and returns the namespaces in scope for its content
+func (xw *xmlWriter) startTag(n *Node, ns map[string]string) map[string]string {
+	ns = n.namespaces(ns)
+	if xw.html {
+		xw.buf = append(xw.buf, '<')
+		xw.buf = append(xw.buf, htmlName(n.name, ns)...)
+		for i := n.pos + 1; i < n.end && n.doc.nodes[i].kind == AttrNode; i++ {
+			xw.buf = append(xw.buf, ' ')
+			xw.attr(&n.doc.nodes[i], ns)
+		}
+		xw.buf = append(xw.buf, '>')
+		xw.decls, xw.inherited = nil, false
+		return ns
+	}
+	var decls []string
+	for _, decl := range xw.decls {
+		if n.attrNS(decl.name.Space, decl.name.Local) == nil {
+			name, prefix := "xmlns", ""
+			if decl.name.Space == "xmlns" {
+				name, prefix = "xmlns:"+decl.name.Local, decl.name.Local
+			}
+			decls = append(decls, name, decl.attr)
+			ns[prefix] = decl.attr
+		}
+	}
+	xw.decls, xw.inherited = nil, false
+	name, ok := elemName(n, ns)
+	if !ok {
+		if n.name.Space == "" {
+			decls = append(decls, "xmlns", "")
+			ns[""] = ""
+		} else {
+			prefix := declPrefix(ns, n.name.Space)
+			decls = append(decls, "xmlns:"+prefix, n.name.Space)
+			ns[prefix] = n.name.Space
+			name = prefix + ":" + n.name.Local
+		}
+	}
+	xw.buf = append(xw.buf, '<')
+	xw.buf = append(xw.buf, name...)
+	for i := n.pos + 1; i < n.end && n.doc.nodes[i].kind == AttrNode; i++ {
+		attr := &n.doc.nodes[i]
+		name, ok := attrName(attr, ns)
+		if !ok {
+			prefix := declPrefix(ns, attr.name.Space)
+			decls = append(decls, "xmlns:"+prefix, attr.name.Space)
+			ns[prefix] = attr.name.Space
+			name = prefix + ":" + attr.name.Local
+		}
+		xw.buf = append(xw.buf, ' ')
+		xw.attrValue(name, attr.attr)
+	}
+	for i := 0; i < len(decls); i += 2 {
+		xw.buf = append(xw.buf, ' ')
+		xw.attrValue(decls[i], decls[i+1])
+	}
+	xw.buf = append(xw.buf, '>')
+	return ns
+}
+
+// endTag writes the end tag of the element n, of which the content has the
+// namespaces ns in scope
+func (xw *xmlWriter) endTag(n *Node, ns map[string]string) {
+	name, _ := elemName(n, ns)
+	if xw.html {
+		name = htmlName(n.name, ns)
+	}
+	xw.buf = append(xw.buf, "</"...)
+	xw.buf = append(xw.buf, name...)
+	xw.buf = append(xw.buf, '>')
+}
+
+// attr writes the attribute n within the namespaces ns
+func (xw *xmlWriter) attr(n *Node, ns map[string]string) {
+	if !xw.html {
+		name, _ := attrName(n, ns)
+		xw.attrValue(name, n.attr)
+		return
+	}
+	name := htmlName(n.name, ns)
+	if n.name.Space == "" && htmlBoolean[strings.ToLower(n.name.Local)] && (n.attr == "" || strings.EqualFold(n.attr, n.name.Local)) {
+		xw.buf = append(xw.buf, name...)
+		return
+	}
+	xw.attrValue(name, n.attr)
+}
+
+// attrValue writes an attribute of the given name and value
+func (xw *xmlWriter) attrValue(name, value string) {
+	xw.buf = append(xw.buf, name...)
+	xw.buf = append(xw.buf, '=', '"')
+	if xw.html {
+		xw.buf = appendHTMLEscaped(xw.buf, []byte(value), true)
+	} else {
+		xw.buf = appendEscaped(xw.buf, []byte(value))
+	}
+	xw.buf = append(xw.buf, '"')
+}
+
+// elemName returns the qualified name of the element n within the namespaces
+// ns, and false when its namespace is not in scope
+func elemName(n *Node, ns map[string]string) (string, bool) {
+	if ns[""] == n.name.Space {
+		return n.name.Local, true
+	} else if prefix := attrPrefix(ns, n.name.Space); prefix != "" && n.name.Space != "" {
+		return prefix + ":" + n.name.Local, true
+	}
+	return n.name.Local, false
+}
+
+// attrName returns the qualified name of the attribute n within the
+// namespaces ns, and false when its namespace is not in scope
+func attrName(n *Node, ns map[string]string) (string, bool) {
+	switch {
+	case n.name.Space == "":
+		return n.name.Local, true
+	case n.name.Space == "xmlns":
+		return "xmlns:" + n.name.Local, true
+	case isXMLNamespace(n.name.Space):
+		return "xml:" + n.name.Local, true
+	}
+	if prefix := attrPrefix(ns, n.name.Space); prefix != "" {
+		return prefix + ":" + n.name.Local, true
+	}
+	return n.name.Local, false
+}
+
+// declPrefix returns the prefix to declare for space, which is space itself
+// when it is a prefix left undeclared by a lenient parser
+func declPrefix(ns map[string]string, space string) string {
+	if _, ok := ns[space]; !ok && isNCName(space) && !strings.HasPrefix(strings.ToLower(space), "xml") {
+		return space
+	}
+	return newPrefix(ns)
+}
+
+// htmlName returns the name of an element or an attribute in HTML, in which
+// namespaces are not declared. The names in the namespaces of HTML, SVG and
+// MathML are written without prefix, and the other ones with the prefix in
+// scope for their namespace, if any, or with the prefix left undeclared by
+// the lenient parser of ParseHTML.
+func htmlName(name xml.Name, ns map[string]string) string {
+	switch {
+	case name.Space == "" || ns[""] == name.Space || htmlNamespaces[name.Space]:
+		return name.Local
+	case name.Space == "xmlns":
+		return "xmlns:" + name.Local
+	case isXMLNamespace(name.Space):
+		return "xml:" + name.Local
+	case name.Space == "http://www.w3.org/1999/xlink":
+		return "xlink:" + name.Local
+	}
+	if prefix := attrPrefix(ns, name.Space); prefix != "" {
+		return prefix + ":" + name.Local
+	} else if isNCName(name.Space) {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// htmlNamespaces are the namespaces of the elements of HTML documents
+var htmlNamespaces = map[string]bool{
+	"http://www.w3.org/1999/xhtml":       true,
+	"http://www.w3.org/2000/svg":         true,
+	"http://www.w3.org/1998/Math/MathML": true,
+}
+
+// htmlVoid are the HTML elements that have no content nor end tag
+var htmlVoid = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "keygen": true, "link": true,
+	"meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlRawText are the HTML elements of which the text is not escaped
+var htmlRawText = map[string]bool{
+	"script": true, "style": true, "xmp": true, "iframe": true,
+	"noembed": true, "noframes": true, "plaintext": true,
+}
+
+// htmlBoolean are the HTML attributes which are true when present
+var htmlBoolean = map[string]bool{
+	"allowfullscreen": true, "async": true, "autofocus": true,
+	"autoplay": true, "checked": true, "controls": true, "default": true,
+	"defer": true, "disabled": true, "formnovalidate": true, "hidden": true,
+	"inert": true, "ismap": true, "itemscope": true, "loop": true,
+	"multiple": true, "muted": true, "nomodule": true, "novalidate": true,
+	"open": true, "playsinline": true, "readonly": true, "required": true,
+	"reversed": true, "selected": true,
+}
+
+// appendHTMLEscaped appends data to result, escaped as the text of an HTML
+// element, or as the value of an attribute if attr
+func appendHTMLEscaped(result []byte, data []byte, attr bool) []byte {
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '&':
+			result = append(result, "&amp;"...)
+		case c == '"' && attr:
+			result = append(result, "&quot;"...)
+		case c == '<' && !attr:
+			result = append(result, "&lt;"...)
+		case c == '>' && !attr:
+			result = append(result, "&gt;"...)
+		case c == 0xc2 && i+1 < len(data) && data[i+1] == 0xa0:
+			result = append(result, "&nbsp;"...)
+			i++
+		default:
+			result = append(result, c)
+		}
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package xmlpath
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Store caches parsed documents by name, such as their file name, and by
+// the hash of their content, so that parsing a tree of files again only
+// parses the files which changed since. It is safe for concurrent use.
+//
+// The documents returned are shared by the calls parsing the same content,
+// and must not be modified; modify a Copy of them instead.
+type Store struct {
+	mu   sync.Mutex
+	docs map[string]*storeEntry
+}
+
+type storeEntry struct {
+	hash [sha256.Size]byte
+	html bool
+	opts []ParseOption
+	root *NodeRef
+}
+
+// NewStore returns an empty store.
+func NewStore() *Store {
+	return &Store{docs: make(map[string]*storeEntry)}
+}
+
+// Parse is like the Parse function, but returns the document parsed before
+// under the given name if its content and the options are the same.
+func (s *Store) Parse(name string, r io.Reader, opts ...ParseOption) (*Node, error) {
+	return s.parse(name, r, false, opts)
+}
+
+// ParseHTML is like the ParseHTML function, but returns the document parsed
+// before under the given name if its content and the options are the same.
+func (s *Store) ParseHTML(name string, r io.Reader, opts ...ParseOption) (*Node, error) {
+	return s.parse(name, r, true, opts)
+}
+
+// ParseFile parses the named file as Parse does, or as ParseHTML does if
+// html is true, under its name.
+func (s *Store) ParseFile(fname string, html bool, opts ...ParseOption) (*Node, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	return s.parse(fname, bytes.NewReader(data), html, opts)
+}
+
+func (s *Store) parse(name string, r io.Reader, html bool, opts []ParseOption) (*Node, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+
+	s.mu.Lock()
+	e, ok := s.docs[name]
+	s.mu.Unlock()
+	if ok && e.hash == hash && e.html == html && sameOptions(e.opts, opts) {
+		return e.root.Node, nil
+	}
+
+	var root *Node
+	if html {
+		root, err = ParseHTML(bytes.NewReader(data), opts...)
+	} else {
+		root, err = Parse(bytes.NewReader(data), opts...)
+	}
+	if err != nil {
+		s.Forget(name)
+		return nil, err
+	}
+	e = &storeEntry{hash, html, append([]ParseOption(nil), opts...), root.Ref}
+	s.mu.Lock()
+	s.docs[name] = e
+	s.mu.Unlock()
+	return root, nil
+}
+
+// sameOptions returns whether the options a and b parse documents the same
+func sameOptions(a, b []ParseOption) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Forget removes the document parsed under the given name from s, such as
+// a file which was deleted.
+func (s *Store) Forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.docs, name)
+}
+
+// Len returns the number of documents in s.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.docs)
+}
@@ -0,0 +1,284 @@
+package xmlpath
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Stream applies p to the document being decoded by d without building it
+// in memory, calling fn with each matching node as soon as it is complete.
+// Stream returns the first error returned by fn or d, other than io.EOF.
+//
+// Only paths made of child, descendant, descendant-or-self and self steps,
+// optionally followed by an attribute step, can be streamed, and their steps
+// must not have predicates. The path is applied to the root of the document,
+// which it must not select.
+//
+// The nodes given to fn are copies of the matching nodes, along with their
+// content, within documents of their own: their ancestors and siblings are not
+// available, but the namespaces in scope are declared by attributes of the
+// root node of their document, as with Clone. Only the nodes matching within another matching node are kept
+// in memory until they are given to fn.
+func (p *Path) Stream(d *xml.Decoder, fn func(node *Node) error) error {
+	if err := p.checkStreamable(); err != nil {
+		return err
+	}
+	s := streamState{path: p, fn: fn}
+	steps := s.closure([]int{0}, &Node{kind: StartNode})
+	if containsInt(steps, len(p.steps)) {
+		return fmt.Errorf("xmlpath: cannot stream %q: the root node is selected", p.path)
+	}
+	s.push(nil, steps)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := s.token(t); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Path) checkStreamable() error {
+	if p.operands != nil || p.base != nil {
+		return fmt.Errorf("xmlpath: cannot stream %q: not a location path", p.path)
+	}
+	for i := range p.steps {
+		step := &p.steps[i]
+		switch step.axis {
+		case "child", "descendant", "descendant-or-self", "self":
+		case "attribute":
+			if i < len(p.steps)-1 {
+				return fmt.Errorf("xmlpath: cannot stream %q: attribute step is not the last one", p.path)
+			}
+		default:
+			return fmt.Errorf("xmlpath: cannot stream %q: unsupported axis %q", p.path, step.axis)
+		}
+		if step.preds != nil {
+			return fmt.Errorf("xmlpath: cannot stream %q: predicates are not supported", p.path)
+		}
+	}
+	return nil
+}
+
+// streamState is the state of Path.Stream
+type streamState struct {
+	path *Path
+	fn   func(node *Node) error
+
+	// For each open element, the steps to apply to its children and to its
+	// descendants
+	stack []streamLevel
+
+	// Nodes of the matching element being read, and the indexes of the
+	// matching nodes within
+	nodes    []Node
+	depth    int
+	matches  []int
+	captured bool
+}
+
+type streamLevel struct {
+	child []int
+	desc  []int
+
+	// Namespace declarations in scope
+	ns []Node
+}
+
+// closure returns the steps to apply from node, given the steps it was
+// selected by, following the self and descendant-or-self steps that select it.
+// len(path.steps) is included in the result if node matches the path.
+func (s *streamState) closure(steps []int, node *Node) []int {
+	for i := 0; i < len(steps); i++ {
+		k := steps[i]
+		if k == len(s.path.steps) {
+			continue
+		}
+		step := &s.path.steps[k]
+		if (step.axis == "self" || step.axis == "descendant-or-self") && step.match(node) && !containsInt(steps, k+1) {
+			steps = append(steps, k+1)
+		}
+	}
+	return steps
+}
+
+// selected returns the steps to apply from node, a child of the top of the
+// stack
+func (s *streamState) selected(node *Node) []int {
+	var steps []int
+	top := &s.stack[len(s.stack)-1]
+	for _, k := range top.child {
+		if s.path.steps[k].match(node) && !containsInt(steps, k+1) {
+			steps = append(steps, k+1)
+		}
+	}
+	for _, k := range top.desc {
+		if s.path.steps[k].match(node) && !containsInt(steps, k+1) {
+			steps = append(steps, k+1)
+		}
+	}
+	return s.closure(steps, node)
+}
+
+// push opens a level for the children of the element selected by steps
+func (s *streamState) push(parent *streamLevel, steps []int) {
+	var level streamLevel
+	if parent != nil {
+		level.desc = parent.desc
+		level.ns = parent.ns
+	}
+	for _, k := range steps {
+		if k == len(s.path.steps) {
+			continue
+		}
+		switch s.path.steps[k].axis {
+		case "child":
+			level.child = append(level.child, k)
+		case "descendant", "descendant-or-self":
+			if !containsInt(level.desc, k) {
+				level.desc = append(level.desc[:len(level.desc):len(level.desc)], k)
+			}
+		}
+	}
+	s.stack = append(s.stack, level)
+}
+
+func (s *streamState) token(t xml.Token) error {
+	var node Node
+	switch t := t.(type) {
+	case xml.StartElement:
+		node = Node{kind: StartNode, name: t.Name}
+	case xml.EndElement:
+		s.stack = s.stack[:len(s.stack)-1]
+		if s.captured {
+			s.nodes = append(s.nodes, Node{kind: EndNode})
+			s.depth--
+			if s.depth == 0 {
+				return s.flush()
+			}
+		}
+		return nil
+	case xml.CharData:
+		node = Node{kind: TextNode, text: append([]byte(nil), t...)}
+	case xml.Comment:
+		node = Node{kind: CommentNode, text: append([]byte(nil), t...)}
+	case xml.ProcInst:
+		node = Node{kind: ProcInstNode, name: xml.Name{Local: t.Target}, text: append([]byte(nil), t.Inst...)}
+	default:
+		return nil
+	}
+
+	steps := s.selected(&node)
+	match := containsInt(steps, len(s.path.steps))
+	if match && !s.captured {
+		s.capture()
+	}
+	if s.captured {
+		if match {
+			s.matches = append(s.matches, len(s.nodes))
+		}
+		s.nodes = append(s.nodes, node)
+	}
+
+	if node.kind != StartNode {
+		if s.captured && s.depth == 0 {
+			return s.flush()
+		}
+		return nil
+	}
+
+	start := StartNode
+	if s.captured {
+		s.depth++
+	}
+	var attrs []Node
+	for _, attr := range t.(xml.StartElement).Attr {
+		attrs = append(attrs, Node{kind: AttrNode, name: attr.Name, attr: attr.Value})
+	}
+	last := len(s.path.steps) - 1
+	attrMatch := containsInt(steps, last) && s.path.steps[last].axis == "attribute"
+	if attrMatch && !s.captured {
+		// Capture the element without its content
+		s.capture()
+		s.nodes = append(s.nodes, node)
+		start = EndNode
+	}
+	for _, attr := range attrs {
+		if attrMatch && s.path.steps[last].match(&attr) && !isNamespaceDecl(attr.name) {
+			s.matches = append(s.matches, len(s.nodes))
+		}
+		if s.captured {
+			s.nodes = append(s.nodes, attr)
+		}
+	}
+	if start == EndNode {
+		s.nodes = append(s.nodes, Node{kind: EndNode})
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	s.push(&s.stack[len(s.stack)-1], steps)
+	s.declare(attrs)
+	return nil
+}
+
+// capture starts capturing nodes, in a document of which the root node
+// declares the namespaces in scope
+func (s *streamState) capture() {
+	s.captured = true
+	s.nodes = append(s.nodes[:0], Node{kind: StartNode})
+	s.nodes = append(s.nodes, s.stack[len(s.stack)-1].ns...)
+}
+
+// declare adds the namespace declarations among attrs to the ones in scope
+// for the children of the top of the stack, the nearest ones first
+func (s *streamState) declare(attrs []Node) {
+	var ns []Node
+	declared := map[xml.Name]bool{}
+	for _, attr := range attrs {
+		if isNamespaceDecl(attr.name) {
+			ns = append(ns, attr)
+			declared[attr.name] = true
+		}
+	}
+	if ns == nil {
+		return
+	}
+	level := &s.stack[len(s.stack)-1]
+	for _, decl := range level.ns {
+		if !declared[decl.name] {
+			ns = append(ns, decl)
+		}
+	}
+	level.ns = ns
+}
+
+// flush gives the captured matching nodes to fn
+func (s *streamState) flush() error {
+	nodes := append(s.nodes, Node{kind: EndNode})
+	matches := s.matches
+	s.nodes = nil
+	s.matches = nil
+	s.captured = false
+	refresh(nodes)
+	for _, i := range matches {
+		if err := s.fn(&nodes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
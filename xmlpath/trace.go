@@ -0,0 +1,67 @@
+package xmlpath
+
+// Tracer receives statistics on the evaluation of paths, to understand why a
+// path is slow. See Path.IterTrace.
+type Tracer interface {
+	// TraceStep is called for each step of a location path once the
+	// iteration over the nodes it matches is over.
+	TraceStep(stats StepStats)
+}
+
+// StepStats describes the work done by a step of a location path.
+type StepStats struct {
+	Path    string // Location path of the step
+	Index   int    // Position of the step in the evaluated steps, from 0
+	Step    Step   // Step evaluated, as planned for the evaluation
+	Visited int    // Number of nodes tested by the step, from all of its context nodes
+	Matched int    // Number of nodes selected by the step, after its predicates
+}
+
+// TracerFunc is a function implementing Tracer.
+type TracerFunc func(stats StepStats)
+
+// TraceStep calls f(stats).
+func (f TracerFunc) TraceStep(stats StepStats) {
+	f(stats)
+}
+
+// IterTrace is like Iter, but reports the statistics of the steps of p to t
+// once the iteration is over, that is when Iter.Next returns false, or before
+// the first node for paths whose nodes must all be found to be sorted in
+// document order, such as //a/b. The steps
+// are the ones evaluated, in which descendant-or-self::node()/child::x is a
+// single descendant::x step. The operands of set operations are traced in
+// turn, while the expressions filtered by predicates, as in (//a)[1], are not.
+func (p *Path) IterTrace(context *Node, t Tracer) *Iter {
+	if p.operands != nil {
+		return p.iterSet(context, nil, t)
+	} else if p.base != nil {
+		return p.iterFilter(context, nil)
+	}
+	iter, ordered := p.iterSteps(context, nil)
+	iter.tracer = t
+	iter.traced = p.path
+	if !ordered {
+		return iter.sorted()
+	}
+	return iter
+}
+
+// trace reports the statistics of the steps of iter to its tracer, once
+func (iter *Iter) trace() {
+	if iter.tracer == nil {
+		return
+	}
+	t := iter.tracer
+	iter.tracer = nil
+	for i := range iter.state {
+		s := &iter.state[i]
+		t.TraceStep(StepStats{
+			Path:    iter.traced,
+			Index:   i,
+			Step:    s.step.describe(),
+			Visited: s.visited,
+			Matched: s.matched,
+		})
+	}
+}
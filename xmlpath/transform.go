@@ -0,0 +1,66 @@
+package xmlpath
+
+import (
+	"io"
+)
+
+// Transform applies templates to the nodes of a document in document order,
+// each template producing the output of the nodes matched by the path of its
+// rule, as XSLT templates do. For instance, to list the links of a document:
+//
+//	var t xmlpath.Transform
+//	t.Rule(xmlpath.MustCompile("//a/@href"), func(node *xmlpath.Node) string {
+//		return node.String() + "\n"
+//	})
+//	err := t.Execute(os.Stdout, root)
+//
+// The paths of the rules are evaluated in a single pass over the document
+// when they can be, as with CompileAll.
+type Transform struct {
+	paths     []*Path
+	templates []func(node *Node) string
+}
+
+// TransformResult is the output of a template for a node.
+type TransformResult struct {
+	Node   *Node
+	Output string
+}
+
+// Rule adds to t a rule applying template to the nodes matched by path. A
+// node matched by the paths of several rules is given to the template of the
+// rule added first only.
+func (t *Transform) Rule(path *Path, template func(node *Node) string) {
+	t.paths = append(t.paths, path)
+	t.templates = append(t.templates, template)
+}
+
+// Apply returns the outputs of the templates of t for the nodes matched on
+// context by the paths of their rules, in document order. The templates
+// must not modify the document; the results may be used to modify it
+// afterwards.
+func (t *Transform) Apply(context *Node) []TransformResult {
+	var results []TransformResult
+	var last *Node
+	iter := newMultiPath(t.paths).Iter(context)
+	for iter.Next() {
+		node := iter.Node()
+		if node == last {
+			continue
+		}
+		last = node
+		results = append(results, TransformResult{node, t.templates[iter.Path()](node)})
+	}
+	return results
+}
+
+// Execute writes to w the outputs of the templates of t, as returned by
+// Apply.
+func (t *Transform) Execute(w io.Writer, context *Node) error {
+	for _, result := range t.Apply(context) {
+		if _, err := io.WriteString(w, result.Output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package xmlpath
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var nodeType = reflect.TypeOf(Node{})
+
+// Unmarshal populates the struct pointed to by v from the nodes matched on
+// context by the paths in the xpath struct tags of its fields:
+//
+//	var book struct {
+//		ISBN       string   `xpath:"isbn"`
+//		Title      string   `xpath:"title"`
+//		Available  bool     `xpath:"@available"`
+//		Characters []string `xpath:"character/name"`
+//		Author     struct {
+//			Name string `xpath:"name"`
+//		} `xpath:"author"`
+//	}
+//	err := xmlpath.Unmarshal(bookNode, &book)
+//
+// Paths are evaluated relative to context. Fields are set according to their
+// type:
+//
+//   - Strings, byte slices, numbers and booleans are set from the string
+//     value of the first matching node.
+//   - Structs are unmarshaled recursively with the first matching node
+//     as context.
+//   - *Node fields point to the first matching node.
+//   - Slices get one element per matching node.
+//   - Pointers are allocated when there is a matching node.
+//
+// Fields without an xpath tag, or tagged with "-", are left untouched, as are
+// fields whose path matches no node.
+func Unmarshal(context *Node, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("xmlpath: Unmarshal expects a non nil pointer to a struct, got %T", v)
+	}
+	return unmarshalStruct(context, rv.Elem())
+}
+
+func unmarshalStruct(context *Node, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("xpath")
+		if tag == "" || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		path, err := Compile(tag)
+		if err != nil {
+			return err
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			fv.Set(reflect.MakeSlice(fv.Type(), 0, 0))
+			iter := path.Iter(context)
+			for iter.Next() {
+				elem := reflect.New(fv.Type().Elem()).Elem()
+				err = unmarshalValue(iter.Node(), elem)
+				if err != nil {
+					return fmt.Errorf("xmlpath: field %s: %v", field.Name, err)
+				}
+				fv.Set(reflect.Append(fv, elem))
+			}
+			continue
+		}
+
+		iter := path.Iter(context)
+		if !iter.Next() {
+			continue
+		}
+		err = unmarshalValue(iter.Node(), fv)
+		if err != nil {
+			return fmt.Errorf("xmlpath: field %s: %v", field.Name, err)
+		}
+	}
+	return nil
+}
+
+func unmarshalValue(node *Node, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.Type().Elem() == nodeType {
+			v.Set(reflect.ValueOf(node))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshalValue(node, v.Elem())
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return unmarshalStruct(node, v)
+	case reflect.String:
+		v.SetString(node.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("cannot unmarshal into nested %s", v.Type())
+		}
+		v.SetBytes(append([]byte{}, node.Bytes()...))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(node.String()))
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(node.String()), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(node.String()), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(node.String()), v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("cannot unmarshal into %s", v.Type())
+	}
+	return nil
+}
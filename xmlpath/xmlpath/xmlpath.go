@@ -3,10 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/mildred/htmltools/xmlpath"
 	"io"
-	"launchpad.net/xmlpath"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode/utf8"
 )
 
 func main() {
@@ -40,6 +42,11 @@ func main() {
 	err := handleTags(dir, f1, os.Stdout, *html, path, *delim)
 	if err != nil && err != io.EOF {
 		fmt.Fprintln(os.Stderr, err.Error())
+		if cerr, ok := err.(*xmlpath.CompileError); ok {
+			// Point at the error in the path
+			indent := utf8.RuneCountInString(cerr.Path[:cerr.Offset])
+			fmt.Fprintf(os.Stderr, "  %s\n  %s^\n", cerr.Path, strings.Repeat(" ", indent))
+		}
 		os.Exit(1)
 	}
 
@@ -0,0 +1,257 @@
+package xmlpath
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Pointer is a compiled XPointer, identifying nodes of a document as the
+// fragment of a URI does, such as the fragment of an XInclude href.
+type Pointer struct {
+	ptr string
+
+	// Paths of the supported pointer parts, in order
+	paths []*Path
+}
+
+// CompileXPointer returns the compiled XPointer, which is either:
+//
+//   - a shorthand pointer, the id of an element, as in intro
+//   - a sequence of pointer parts, as in xmlns(x=urn:x) xpointer(//x:a),
+//     each one being tried in turn until one selects nodes
+//
+// The following schemes are supported, pointer parts of other schemes being
+// ignored:
+//
+//   - element(), selecting an element by its id and the positions of its
+//     ancestors among the child elements of their parents, as in
+//     element(intro/2/1) or element(/1/3)
+//   - xpointer() and xpath1(), selecting the nodes of an expression
+//   - xmlns(), binding a prefix for the expressions of the pointer parts
+//     that follow it
+//
+// In scheme data, ^ escapes a parenthesis or a circumflex, as in
+// xpointer(//a[.='^(1^)']).
+func CompileXPointer(ptr string) (*Pointer, error) {
+	c := pointerCompiler{ptr: ptr, ns: map[string]string{}}
+	return c.compile()
+}
+
+// MustCompileXPointer returns the compiled XPointer, and panics if there are
+// any errors.
+func MustCompileXPointer(ptr string) *Pointer {
+	p, err := CompileXPointer(ptr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the XPointer p was compiled from.
+func (p *Pointer) String() string {
+	return p.ptr
+}
+
+// Iter returns an iterator over the nodes selected by the first pointer part
+// of p selecting any node in the document of node, in document order. Each
+// part is evaluated at once, until one selects nodes.
+func (p *Pointer) Iter(node *Node) *Iter {
+	for node.up != nil {
+		node = node.up
+	}
+	for _, path := range p.paths {
+		if nodes := path.Iter(node).nodes(); len(nodes) > 0 {
+			return &Iter{buffered: true, buf: nodes}
+		}
+	}
+	return &Iter{buffered: true}
+}
+
+// First returns the first node selected by p in the document of node.
+func (p *Pointer) First(node *Node) (*Node, bool) {
+	iter := p.Iter(node)
+	if iter.Next() {
+		return iter.Node(), true
+	}
+	return nil, false
+}
+
+type pointerCompiler struct {
+	ptr string
+	i   int
+
+	// Namespaces bound by the xmlns() parts so far
+	ns map[string]string
+}
+
+func (c *pointerCompiler) compile() (*Pointer, error) {
+	p := &Pointer{ptr: c.ptr}
+	if c.ptr == "" {
+		return nil, c.errorf("empty pointer")
+	}
+	if !strings.ContainsAny(c.ptr, "( \t\r\n") {
+		// Shorthand pointer
+		if !isNCName(c.ptr) {
+			return nil, c.errorf("invalid id %q", c.ptr)
+		}
+		path, err := Compile("id('" + c.ptr + "')")
+		if err != nil {
+			return nil, err
+		}
+		p.paths = append(p.paths, path)
+		return p, nil
+	}
+
+	for {
+		c.skipSpaces()
+		if c.i == len(c.ptr) {
+			break
+		}
+		mark := c.i
+		for c.i < len(c.ptr) && c.ptr[c.i] != '(' && !strings.ContainsRune(" \t\r\n)^", rune(c.ptr[c.i])) {
+			c.i++
+		}
+		scheme := c.ptr[mark:c.i]
+		if scheme == "" {
+			return nil, c.errorf("expected a scheme name")
+		} else if c.i == len(c.ptr) || c.ptr[c.i] != '(' {
+			return nil, c.errorf("expected '(' after %s", scheme)
+		}
+		c.i++
+		start := c.i
+		data, err := c.parseData()
+		if err != nil {
+			return nil, err
+		}
+		path, err := c.compilePart(scheme, data, start)
+		if err != nil {
+			return nil, err
+		} else if path != nil {
+			p.paths = append(p.paths, path)
+		}
+	}
+	if p.paths == nil {
+		c.i = 0
+		return nil, c.errorf("no supported pointer part")
+	}
+	return p, nil
+}
+
+// parseData parses the data of a pointer part after its opening parenthesis,
+// up to its closing parenthesis, and returns it unescaped
+func (c *pointerCompiler) parseData() (string, error) {
+	var data []byte
+	depth := 0
+	for ; c.i < len(c.ptr); c.i++ {
+		switch b := c.ptr[c.i]; b {
+		case '^':
+			if c.i+1 == len(c.ptr) || strings.IndexByte("()^", c.ptr[c.i+1]) < 0 {
+				return "", c.errorf("invalid escape in scheme data")
+			}
+			c.i++
+			data = append(data, c.ptr[c.i])
+		case '(':
+			depth++
+			data = append(data, b)
+		case ')':
+			if depth == 0 {
+				c.i++
+				return string(data), nil
+			}
+			depth--
+			data = append(data, b)
+		default:
+			data = append(data, b)
+		}
+	}
+	return "", c.errorf("missing )")
+}
+
+// compilePart returns the path of the pointer part of the given scheme and
+// data, starting at start, or nil for the parts selecting no nodes
+func (c *pointerCompiler) compilePart(scheme, data string, start int) (*Path, error) {
+	switch scheme {
+	case "xmlns":
+		eq := strings.IndexByte(data, '=')
+		prefix := ""
+		if eq >= 0 {
+			prefix = strings.TrimSpace(data[:eq])
+		}
+		if !isNCName(prefix) {
+			c.i = start
+			return nil, c.errorf("invalid namespace binding %q", data)
+		}
+		c.ns[prefix] = strings.TrimSpace(data[eq+1:])
+	case "element":
+		return c.compileElement(data, start)
+	case "xpointer", "xpath1":
+		ns := make(map[string]string, len(c.ns))
+		for prefix, space := range c.ns {
+			ns[prefix] = space
+		}
+		path, err := CompileNS(data, ns)
+		if err, ok := err.(*CompileError); ok {
+			pc := pathCompiler{path: c.ptr, i: start + err.Offset}
+			err.Path, err.Offset, err.Token = c.ptr, pc.i, pc.token()
+			return nil, err
+		} else if err != nil {
+			return nil, err
+		}
+		return path, nil
+	}
+	return nil, nil
+}
+
+// compileElement returns the path of the child sequence of the element()
+// scheme, as in intro/2/1 or /1/3
+func (c *pointerCompiler) compileElement(data string, start int) (*Path, error) {
+	parts := strings.Split(data, "/")
+	expr := "/"
+	if parts[0] != "" {
+		if !isNCName(parts[0]) {
+			c.i = start
+			return nil, c.errorf("invalid id %q", parts[0])
+		}
+		expr = "id('" + parts[0] + "')/"
+	} else if len(parts) == 1 {
+		c.i = start
+		return nil, c.errorf("empty child sequence")
+	}
+	for i, part := range parts[1:] {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || part[0] == '+' {
+			c.i = start
+			return nil, c.errorf("invalid child position %q", part)
+		}
+		if i > 0 {
+			expr += "/"
+		}
+		expr += "*[" + part + "]"
+	}
+	expr = strings.TrimSuffix(expr, "/")
+	return Compile(expr)
+}
+
+func (c *pointerCompiler) skipSpaces() {
+	for c.i < len(c.ptr) && strings.IndexByte(" \t\r\n", c.ptr[c.i]) >= 0 {
+		c.i++
+	}
+}
+
+func (c *pointerCompiler) errorf(format string, args ...interface{}) error {
+	pc := pathCompiler{path: c.ptr, i: c.i}
+	return pc.errorf(format, args...)
+}
+
+// isNCName returns whether s is a name without a colon, such as an id
+func isNCName(s string) bool {
+	if s == "" || s[0] == '-' || s[0] == '.' || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x80 && !isNameByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}